@@ -0,0 +1,111 @@
+// Package bridge embeds mesos-consul in other programs, e.g. custom
+// controllers, syncing a Mesos cluster into a registry without the
+// flags, signals and servers of the mesos-consul binary.
+package bridge
+
+import (
+	"context"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/consul"
+	"github.com/CiscoCloud/mesos-consul/mesos"
+	"github.com/CiscoCloud/mesos-consul/registry"
+)
+
+// A MesosClient provides the Mesos state to sync, see
+// mesos.Client. Without one the state is read from the leading master
+// found in Zookeeper.
+type MesosClient = mesos.Client
+
+// A Registry is where the state is synced to, see registry.Registry.
+// Without one services are registered with Consul.
+type Registry = registry.Registry
+
+// The stages of the syncs replacing the default ones, e.g. a chain
+// of Transformers rewriting the task services, see mesos.Stages
+type Stages = mesos.Stages
+
+// A Bridge syncs one Mesos cluster into a registry
+type Bridge struct {
+	// Told about every sync Run fails, when not nil
+	OnError func(error)
+
+	config *config.Config
+	mesos  *mesos.Mesos
+}
+
+// New builds a Bridge syncing the cluster of c.Zk into Consul at
+// c.ConsulAddr. c typically starts from config.DefaultConfig().
+func New(c *config.Config) (*Bridge, error) {
+	return NewWith(c, nil, nil)
+}
+
+// NewWith builds a Bridge syncing the state of client into r. A nil
+// client replays c.FromFile when set and reads the cluster of c.Zk
+// otherwise. A nil r registers with Consul.
+func NewWith(c *config.Config, r Registry, client MesosClient) (*Bridge, error) {
+	if r == nil {
+		r = consul.NewConsul(c)
+	}
+
+	if client == nil && c.FromFile != "" {
+		var err error
+		if client, err = mesos.NewFileClient(c.FromFile); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := mesos.NewWithClient(c, r, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bridge{config: c, mesos: m}, nil
+}
+
+// SyncOnce syncs the state once, failing when reading the state or
+// any registry call failed. The calls left are abandoned once ctx is
+// done.
+func (b *Bridge) SyncOnce(ctx context.Context) error {
+	if err := b.mesos.RefreshContext(ctx); err != nil {
+		return err
+	}
+
+	return b.mesos.ConsulErr()
+}
+
+// Run syncs the state every c.Refresh until ctx is done, returning
+// its error. Failed syncs are reported to OnError and retried on the
+// next refresh. A sync in progress when ctx is done is cut short.
+func (b *Bridge) Run(ctx context.Context) error {
+	ticker := time.NewTicker(b.config.Refresh)
+	defer ticker.Stop()
+
+	for {
+		if err := b.SyncOnce(ctx); err != nil && b.OnError != nil {
+			b.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetStages replaces the stages of the following syncs
+func (b *Bridge) SetStages(s Stages) {
+	b.mesos.SetStages(s)
+}
+
+// CachedServices lists the services the bridge registered
+func (b *Bridge) CachedServices() []mesos.CachedService {
+	return b.mesos.CachedServices()
+}
+
+// DeregisterAll deregisters every service the bridge registered
+func (b *Bridge) DeregisterAll() {
+	b.mesos.DeregisterAll()
+}
@@ -0,0 +1,182 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/mesos"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+type staticClient struct {
+	state mesos.StateJSON
+	err   error
+}
+
+func (c *staticClient) State(context.Context) (mesos.StateJSON, error) {
+	return c.state, c.err
+}
+
+// A Client hanging until its request is given up
+type hungClient struct{}
+
+func (hungClient) State(ctx context.Context) (mesos.StateJSON, error) {
+	<-ctx.Done()
+	return mesos.StateJSON{}, ctx.Err()
+}
+
+// A Registry keeping the registered services in memory
+type memory struct {
+	Registry
+
+	sync.Mutex
+	services map[string]*consulapi.AgentServiceRegistration
+}
+
+func (r *memory) Register(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	r.Lock()
+	defer r.Unlock()
+	r.services[s.ID] = s
+	return nil
+}
+
+func (r *memory) Deregister(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.services, s.ID)
+	return nil
+}
+
+func (r *memory) Services(context.Context, string) ([]*registry.Service, error) {
+	return nil, nil
+}
+func (r *memory) Txn(context.Context, map[string][]byte, []string) error { return nil }
+func (r *memory) List(context.Context, string, uint64, time.Duration) (map[string][]byte, uint64, error) {
+	return nil, 0, nil
+}
+func (r *memory) Get(context.Context, string, uint64, time.Duration) ([]byte, uint64, error) {
+	return nil, 0, nil
+}
+
+func state() mesos.StateJSON {
+	return mesos.StateJSON{
+		Leader:    "master@10.0.0.1:5050",
+		Followers: mesos.Followers{{Id: "1", Hostname: "10.0.0.2", Pid: "slave(1)@10.0.0.2:5051"}},
+		Frameworks: mesos.Frameworks{{
+			Name:  "marathon",
+			Tasks: mesos.Tasks{{Id: "web.1", Name: "web", FollowerId: "1", State: "TASK_RUNNING"}},
+		}},
+	}
+}
+
+func TestSyncOnce(t *testing.T) {
+	r := &memory{services: map[string]*consulapi.AgentServiceRegistration{}}
+	client := &staticClient{state: state()}
+
+	b, err := NewWith(config.DefaultConfig(), r, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.SyncOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.services["mesos-consul:10.0.0.2-web"]; !ok || len(r.services) != 2 {
+		t.Errorf("expected the follower and the task to be registered, got %v", r.services)
+	}
+	if n := len(b.CachedServices()); n != 2 {
+		t.Errorf("expected 2 cached services, got %d", n)
+	}
+
+	client.err = errors.New("unreachable")
+	if err := b.SyncOnce(context.Background()); err == nil {
+		t.Error("expected the failed state read to fail the sync")
+	}
+}
+
+func TestSyncOnceFrozen(t *testing.T) {
+	r := &memory{services: map[string]*consulapi.AgentServiceRegistration{}}
+	c := config.DefaultConfig()
+	c.FreezeOnFailure = true
+	c.MesosRetries = 0
+	client := &staticClient{state: state()}
+
+	b, err := NewWith(c, r, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.SyncOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.err = errors.New("unreachable")
+	for i := 0; i < 3; i++ {
+		if err := b.SyncOnce(context.Background()); err == nil {
+			t.Error("expected the failed state read to fail the sync")
+		}
+	}
+
+	if len(r.services) != 2 {
+		t.Errorf("expected the services of the last good state to stay, got %v", r.services)
+	}
+	if n := len(b.CachedServices()); n != 2 {
+		t.Errorf("expected the frozen services to be kept, got %d", n)
+	}
+}
+
+func TestSyncOnceTimeout(t *testing.T) {
+	r := &memory{services: map[string]*consulapi.AgentServiceRegistration{}}
+	c := config.DefaultConfig()
+	c.MesosRetries = 0
+	c.MesosTimeout = 10 * time.Millisecond
+
+	b, err := NewWith(c, r, hungClient{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.SyncOnce(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("expected the state read to time out, got %v", err)
+	}
+
+	c.MesosTimeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.SyncOnce(ctx); err != context.Canceled {
+		t.Errorf("expected the state read to stop with the context, got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	r := &memory{services: map[string]*consulapi.AgentServiceRegistration{}}
+	c := config.DefaultConfig()
+	c.Refresh = time.Millisecond
+	c.MesosRetries = 0
+
+	b, err := NewWith(c, r, &staticClient{err: errors.New("unreachable")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	failures := 0
+	b.OnError = func(error) {
+		failures++
+		if failures == 3 {
+			cancel()
+		}
+	}
+
+	if err := b.Run(ctx); err != context.Canceled {
+		t.Errorf("expected Run to stop with the context, got %v", err)
+	}
+	if failures != 3 {
+		t.Errorf("expected 3 failed syncs, got %d", failures)
+	}
+}
@@ -14,30 +14,396 @@ type SSL struct {
 	Enabled		bool
 	Verify		bool
 	Cert		string
+	Key		string
 	CaCert		string
 }
 
+// A Mesos cluster synced next to others by the same instance, see
+// --cluster
+type Cluster struct {
+	Name		string
+	Zk		string
+	ConsulAddr	string
+	Datacenter	string
+	ServicePrefix	string
+}
+
+// What happens to new services once the cache is full, see
+// --cache-eviction
+const (
+	CacheEvictionDrop	= "drop"
+	CacheEvictionLRU	= "lru"
+)
+
+// Orderings for the register and deregister passes of a sync
+const (
+	SyncRegisterFirst	= "register-first"
+	SyncDeregisterFirst	= "deregister-first"
+)
+
+// Sources of a task's address, see --address-priority
+const (
+	AddressContainer	= "container"
+	AddressDocker		= "docker"
+	AddressLabel		= "label"
+	AddressSlave		= "slave"
+	AddressHostname		= "hostname"
+)
+
+// Addresses services are registered under, see --consul-address-mode
+const (
+	AddressModeAuto		= "auto"
+	AddressModeHostname	= "hostname"
+	AddressModeIP		= "ip"
+)
+
+// Shorthands of --address-priority, see --task-ip-source
+var TaskIPSources = map[string][]string{
+	"host":		{ AddressHostname },
+	"netinfo":	{ AddressContainer, AddressHostname },
+	"docker":	{ AddressDocker, AddressHostname },
+	"auto":		{ AddressContainer, AddressDocker, AddressHostname },
+}
+
+// Handling of task services claiming the same address and port
+const (
+	CollisionAll	= "all"
+	CollisionFirst	= "first"
+	CollisionSkip	= "skip"
+)
+
+// Handling of task services whose names normalize to the same one,
+// see --name-collision-policy
+const (
+	NameCollisionMerge	= "merge"
+	NameCollisionSuffix	= "suffix"
+)
+
+// Naming strategies of the tasks of a framework, see --naming-strategy
+const (
+	NamingCassandra	= "cassandra"
+	NamingChronos	= "chronos"
+	NamingGeneric	= "generic"
+	NamingKafka	= "kafka"
+	NamingMarathon	= "marathon"
+)
+
+// Task resources service weights are taken from, see --service-weights
+const (
+	WeightsCpus	= "cpus"
+	WeightsMem	= "mem"
+	WeightsNone	= "none"
+)
+
+// How changes in Mesos are picked up
+const (
+	MesosAPIPoll	= "poll"
+	MesosAPIEvents	= "events"
+)
+
+// Endpoints the Mesos state is read from, see --mesos-state-api
+const (
+	MesosStateAuto	= "auto"
+	MesosStateJSON	= "state.json"
+	MesosStateHTTP	= "state"
+	MesosStateV1	= "v1"
+)
+
+// Who checks the health of task services
+const (
+	CheckModeAgent	= "agent"
+	CheckModeMesos	= "mesos"
+	CheckModeTTL	= "ttl"
+)
+
+// Checks of the task services without check labels, see
+// --default-check
+const (
+	DefaultCheckHTTP	= "http"
+	DefaultCheckNone	= "none"
+	DefaultCheckTCP		= "tcp"
+)
+
+// Formats of the metrics sent to --statsd-addr
+const (
+	StatsdFormatDogStatsd	= "dogstatsd"
+	StatsdFormatStatsd	= "statsd"
+)
+
+// Handling of the registrations over Consul's limits on tags and meta,
+// see --limit-policy
+const (
+	LimitPolicyFail		= "fail"
+	LimitPolicyKV		= "kv"
+	LimitPolicyTruncate	= "truncate"
+)
+
+// How the IDs of task services are made, see --service-id-scheme
+const (
+	IDSchemeHash	= "hash"
+	IDSchemeHost	= "host"
+)
+
+// Output formats of the log
+const (
+	LogFormatText	= "text"
+	LogFormatJSON	= "json"
+)
+
+// Consul agent task services are registered with
+const (
+	TaskAgentAddress	= "address"
+	TaskAgentFollower	= "follower"
+)
+
+// Consul API services are registered through
+const (
+	RegistrationAgent	= "agent"
+	RegistrationCatalog	= "catalog"
+	RegistrationFiles	= "files"
+)
+
 type Config struct {
+	AddressLabel	string
+	AddressMapFile	string
+	AddressTranslator	string
+	AdminAddr	string
+	AdminToken	string
+	AdminUI		bool
+	AddressPriority	[]string
+	AdaptiveCheckInterval	bool
+	AgentConcurrency	int
+	AgentContainers	bool
+	AgentState	bool
+	AgentTimeout	time.Duration
+	AggregateHealth	bool
+	AuditLog	string
+	BlueGreen	bool
+	BlueGreenLive	bool
+	CacheEviction	string
+	CacheWatch	bool
+	ChangeLog	string
+	ChangeLogKV	int
+	CheckContext	bool
+	CheckIntervalInstances	int
+	CheckIntervalMax	time.Duration
+	CheckIntervalMin	time.Duration
+	CheckMode	string
+	CheckOverrides	bool
+	CleanupOrphans	bool
+	Cluster		*Cluster
+	Clusters	[]Cluster
+	ConfirmDeregister	bool
+	ConsulAddr	string
+	ConsulAddressMode	string
+	ConsulAgentAttribute	string
+	ConsulAllowStale	bool
+	ConsulNamespace	string
+	ConsulPartition	string
+	ConsulTimeout	time.Duration
+	DebugAddr	string
+	DefaultCheck	string
+	DNSSRV		bool
+	DeregisterCriticalAfter	time.Duration
+	DeregisterDelay	int
+	DeregisterOnShutdown	bool
+	DryRun		bool
+	EmitEvents	bool
+	EnableTagOverride	bool
+	EventName	string
+	ExportServices	bool
+	ExportState	bool
+	FlapBackoff	time.Duration
+	FlapThreshold	int
+	FlapWindow	time.Duration
+	FollowerAttributes	[]string
+	FollowerCapabilities	bool
+	FollowerResources	[]string
+	FollowerRoles	[]string
+	FwBlacklist	string
+	FwWhitelist	string
+	FollowerTags	[]string
+	FrameworkUISuffix	string
+	FreezeOnFailure	bool
+	FromFile	string
+	HealthAddr	string
+	HealthStaleness	time.Duration
+	HeartbeatService	string
+	HeartbeatTTL	time.Duration
+	HookExec	string
+	HookSlack	string
+	HookWebhook	string
+	InstanceID	string
+	InstanceTags	bool
+	KVPrefix	string
+	LimitPolicy	string
+	Lock		string
+	LostTaskGrace	time.Duration
+	ReconcileInterval	time.Duration
 	Refresh		time.Duration
+	RegisterFrameworkUIs	bool
+	RegisterMesosEndpoints	bool
+	RegisterZookeeper	bool
+	RegistrationAPI	string
 	RegistryAuth	*Auth
+	RegistryConcurrency	int
 	RegistryPort	string
+	RegistryRate	float64
+	RegistryRetries	int
 	RegistrySSL	*SSL
 	RegistryToken	string
+	RegistryTokenDir	string
+	RequireHealthy	bool
+	RoleBlacklist	string
+	RoleWhitelist	string
+	SandboxURL	string
 	Zk		string
+	LogFormat	string
 	LogLevel	string
+	MasterTags	[]string
+	MaxCacheEntries	int
+	MaxDeregisterPercent	int
+	MesosAPI	string
+	MesosCheckDeregisterCriticalAfter	time.Duration
+	MesosCheckInterval	time.Duration
+	MesosCheckTimeout	time.Duration
+	MesosCredentialFile	string
+	MesosRetries	int
+	MesosSSL	*SSL
+	MesosStateAPI	string
+	MesosStateBackoff	bool
+	MesosStateJitter	time.Duration
+	MesosStateMaxAge	time.Duration
+	MesosStateReplicas	[]string
+	MesosTimeout	time.Duration
+	MesosPassword	string
+	MesosUser	string
+	MetricsAddr	string
+	MinHealthyBeforeDrain	int
+	MirrorDatacenter	string
+	MirrorServices	string
+	MirrorTags	[]string
+	NameCollisionPolicy	string
+	NamespaceDepth	int
+	NamingStrategies	map[string]string
+	NoCheckServices	string
+	NoDefaultTags	bool
+	Once		bool
+	OTLPEndpoint	string
+	OTLPHeaders	map[string]string
+	PidParseStrict	bool
+	PortCollisionPolicy	string
+	PreparedQueries	bool
+	PreserveTags	string
+	QueryFailoverDatacenters	[]string
+	QueryFailoverNearest	int
+	ServiceControls	bool
+	ServiceFilesDir	string
+	ServiceFilesReload	string
+	ServiceIDScheme	string
+	ServiceIDTemplate	string
+	ServiceNameSeparator	string
+	ServicePrefix	string
+	ServiceSuffix	string
+	ServiceTagsTemplate	string
+	ServiceWeights	string
+	SkipUnchanged	bool
+	StateRefresh	time.Duration
+	StatsdAddr	string
+	StatsdFormat	string
+	StatsdInterval	time.Duration
+	StatsdTags	[]string
+	StripMarathonGroups	bool
+	SyncMaintenance	bool
+	SyncOrder	string
+	TagLabelPrefix	string
+	TaskAgent	string
+	TaskBlacklist	string
+	TaskWhitelist	string
+	TransformPlugin	string
+	TransformPluginTimeout	time.Duration
+	VaultAddr	string
+	VaultMesosCredential	string
+	VaultMesosSSL	string
+	VaultRefresh	time.Duration
+	VaultRegistrySSL	string
+	VaultRegistryToken	string
+	VaultTokenFile	string
+	WanAddressMap	map[string]string
+	WatchServices	bool
 }
 
 func DefaultConfig() *Config {
 	return &Config{
+		AddressLabel:	"address",
+		CacheEviction:	CacheEvictionDrop,
+		AgentConcurrency:	16,
+		AgentTimeout:	5 * time.Second,
+		CheckIntervalMax:	time.Minute,
+		CheckIntervalMin:	5 * time.Second,
+		CheckMode:	CheckModeAgent,
+		AddressPriority:	[]string{ AddressHostname },
+		ConsulAddr:	"127.0.0.1:8500",
+		ConsulAddressMode:	AddressModeAuto,
+		ConsulTimeout:	10 * time.Second,
+		DefaultCheck:	DefaultCheckNone,
+		DeregisterDelay:	1,
+		EventName:	"mesos-consul",
+		FlapBackoff:	5 * time.Minute,
+		FlapWindow:	10 * time.Minute,
+		FrameworkUISuffix:	"-ui",
+		KVPrefix:	"mesos-consul",
+		LimitPolicy:	LimitPolicyFail,
 		Refresh:	time.Minute,
+		RegistrationAPI:	RegistrationAgent,
 		RegistryAuth:	&Auth{
 			Enabled: false,
 		},
+		RegistryConcurrency:	1,
+		RegistryRetries:	2,
 		RegistrySSL:	&SSL{
 			Enabled: false,
 			Verify: true,
 		},
 		RegistryToken:	"",
+		ServiceFilesReload:	"consul reload",
+		ServiceIDScheme:	IDSchemeHost,
+		ServiceWeights:	WeightsNone,
+		StatsdFormat:	StatsdFormatStatsd,
+		StatsdInterval:	10 * time.Second,
 		Zk:		"zk://127.0.0.1:2181/mesos",
+		LogFormat:	LogFormatText,
+		MesosAPI:	MesosAPIPoll,
+		MesosCheckInterval:	10 * time.Second,
+		MesosRetries:	2,
+		MesosSSL:	&SSL{
+			Enabled: false,
+			Verify: true,
+		},
+		MesosStateAPI:	MesosStateAuto,
+		MesosTimeout:	30 * time.Second,
+		NameCollisionPolicy:	NameCollisionMerge,
+		PortCollisionPolicy:	CollisionAll,
+		SyncOrder:	SyncRegisterFirst,
+		TaskAgent:	TaskAgentAddress,
+		TransformPluginTimeout:	10 * time.Second,
+		VaultRefresh:	5 * time.Minute,
+	}
+}
+
+// The configuration of one of the --cluster clusters, syncing it
+// under its own KV prefix
+func (c *Config) ForCluster(cl Cluster) *Config {
+	cc := *c
+	cc.Cluster = &cl
+	cc.Clusters = nil
+	cc.Zk = cl.Zk
+	cc.KVPrefix = c.KVPrefix + "/" + cl.Name
+	cc.InstanceID = c.InstanceID + "/" + cl.Name
+
+	if cl.ConsulAddr != "" {
+		cc.ConsulAddr = cl.ConsulAddr
 	}
+
+	return &cc
 }
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadCredential reads a Mesos credential file, either in the JSON
+// {"principal": ..., "secret": ...} form or as a plain line holding
+// the principal and secret separated by whitespace.
+func ReadCredential(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	var cred struct {
+		Principal string `json:"principal"`
+		Secret    string `json:"secret"`
+	}
+	if err := json.Unmarshal(data, &cred); err == nil {
+		if cred.Principal == "" {
+			return "", "", fmt.Errorf("%s: no principal", path)
+		}
+		return cred.Principal, cred.Secret, nil
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("%s: expected a principal and a secret", path)
+	}
+
+	return fields[0], fields[1], nil
+}
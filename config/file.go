@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v2"
+)
+
+// A configuration file setting flags by name, see ReadFile
+type Setting struct {
+	Name   string
+	Values []string
+}
+
+// ReadFile reads a configuration file, in YAML for the .yaml and .yml
+// extensions and in HCL, or its JSON flavor, otherwise. Its keys are
+// the long names of the flags and its values are set as the flags
+// would be: lists set a repeatable flag once per element and maps set
+// a key=value flag once per pair. The settings are sorted by name.
+func ReadFile(path string) ([]Setting, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		err = hcl.Decode(&raw, string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var settings []Setting
+	for name, value := range raw {
+		values, err := flagValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, name, err)
+		}
+		settings = append(settings, Setting{name, values})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+
+	return settings, nil
+}
+
+// Turn a decoded value into the flag values setting it
+func flagValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case bool, int, int64, float64:
+		return []string{fmt.Sprint(v)}, nil
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			vs, err := flagValues(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, vs...)
+		}
+		return values, nil
+	// HCL decodes an object into a list of maps
+	case []map[string]interface{}:
+		var values []string
+		for _, m := range v {
+			vs, err := flagValues(m)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, vs...)
+		}
+		return values, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+
+	var pairs []string
+	for _, k := range rv.MapKeys() {
+		vs, err := flagValues(rv.MapIndex(k).Interface())
+		if err != nil || len(vs) != 1 {
+			return nil, fmt.Errorf("expected a key=value map, got %v", value)
+		}
+		pairs = append(pairs, fmt.Sprintf("%v=%s", k.Interface(), vs[0]))
+	}
+	sort.Strings(pairs)
+
+	return pairs, nil
+}
+
+// The settings only read at startup, by flag name and Config field.
+// Reloading the configuration cannot change them.
+var restartSettings = []struct{ flag, field string }{
+	{"address-map-file", "AddressMapFile"},
+	{"admin-addr", "AdminAddr"},
+	{"admin-ui", "AdminUI"},
+	{"audit-log", "AuditLog"},
+	{"cache-watch", "CacheWatch"},
+	{"change-log", "ChangeLog"},
+	{"cluster", "Clusters"},
+	{"consul-addr", "ConsulAddr"},
+	{"consul-namespace", "ConsulNamespace"},
+	{"consul-partition", "ConsulPartition"},
+	{"debug-addr", "DebugAddr"},
+	{"dry-run", "DryRun"},
+	{"from-file", "FromFile"},
+	{"health-addr", "HealthAddr"},
+	{"hook-exec", "HookExec"},
+	{"hook-slack", "HookSlack"},
+	{"hook-webhook", "HookWebhook"},
+	{"instance-id", "InstanceID"},
+	{"kv-prefix", "KVPrefix"},
+	{"lock", "Lock"},
+	{"mesos-api", "MesosAPI"},
+	{"mesos-ssl", "MesosSSL"},
+	{"metrics-addr", "MetricsAddr"},
+	{"once", "Once"},
+	{"otlp-endpoint", "OTLPEndpoint"},
+	{"otlp-headers", "OTLPHeaders"},
+	{"registration-api", "RegistrationAPI"},
+	{"registry-auth", "RegistryAuth"},
+	{"registry-concurrency", "RegistryConcurrency"},
+	{"registry-port", "RegistryPort"},
+	{"registry-rate", "RegistryRate"},
+	{"registry-retries", "RegistryRetries"},
+	{"registry-ssl", "RegistrySSL"},
+	{"registry-token", "RegistryToken"},
+	{"registry-token-dir", "RegistryTokenDir"},
+	{"service-files-dir", "ServiceFilesDir"},
+	{"service-files-reload", "ServiceFilesReload"},
+	{"service-prefix", "ServicePrefix"},
+	{"service-suffix", "ServiceSuffix"},
+	{"statsd-addr", "StatsdAddr"},
+	{"statsd-format", "StatsdFormat"},
+	{"statsd-interval", "StatsdInterval"},
+	{"statsd-tags", "StatsdTags"},
+	{"vault-addr", "VaultAddr"},
+	{"vault-refresh", "VaultRefresh"},
+	{"vault-token-file", "VaultTokenFile"},
+	{"watch-services", "WatchServices"},
+	{"zk", "Zk"},
+}
+
+// KeepRestartSettings copies the settings only read at startup from
+// old, returning the flags whose value c changed.
+func (c *Config) KeepRestartSettings(old *Config) []string {
+	var changed []string
+
+	cv := reflect.ValueOf(c).Elem()
+	ov := reflect.ValueOf(old).Elem()
+	for _, s := range restartSettings {
+		f := cv.FieldByName(s.field)
+		o := ov.FieldByName(s.field)
+		if !reflect.DeepEqual(f.Interface(), o.Interface()) {
+			changed = append(changed, s.flag)
+		}
+		f.Set(o)
+	}
+
+	return changed
+}
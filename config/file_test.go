@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	files := map[string]string{
+		"mesos-consul.hcl": `
+zk = "zk://zk1:2181,zk2:2181/mesos"
+refresh = "30s"
+dry-run = true
+max-cache-entries = 100
+follower-tags = ["a", "b"]
+wan-address-map = {
+  "10.0.0.1" = "1.2.3.4"
+}
+`,
+		"mesos-consul.yaml": `
+zk: zk://zk1:2181,zk2:2181/mesos
+refresh: 30s
+dry-run: true
+max-cache-entries: 100
+follower-tags: [a, b]
+wan-address-map:
+  10.0.0.1: 1.2.3.4
+`,
+	}
+
+	want := []Setting{
+		{"dry-run", []string{"true"}},
+		{"follower-tags", []string{"a", "b"}},
+		{"max-cache-entries", []string{"100"}},
+		{"refresh", []string{"30s"}},
+		{"wan-address-map", []string{"10.0.0.1=1.2.3.4"}},
+		{"zk", []string{"zk://zk1:2181,zk2:2181/mesos"}},
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		settings, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(settings, want) {
+			t.Errorf("%s: got %v, want %v", name, settings, want)
+		}
+	}
+}
+
+func TestKeepRestartSettings(t *testing.T) {
+	old := DefaultConfig()
+	c := DefaultConfig()
+	c.Zk = "zk://other:2181/mesos"
+	c.TaskWhitelist = "^web"
+
+	changed := c.KeepRestartSettings(old)
+	if !reflect.DeepEqual(changed, []string{"zk"}) {
+		t.Errorf("expected only zk to need a restart, got %v", changed)
+	}
+	if c.Zk != old.Zk || c.TaskWhitelist != "^web" {
+		t.Errorf("expected zk kept and task-whitelist reloaded, got %q and %q", c.Zk, c.TaskWhitelist)
+	}
+}
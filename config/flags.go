@@ -30,3 +30,105 @@ func (a *AuthVar) String() string {
 
 	return fmt.Sprintf("%s:%s", a.Username, a.Password)
 }
+
+// StringsVar implements the Flag.Value interface and allows the user to
+// specify a list of values either by repeating the flag or in the
+// comma-separated value[,value...] form.
+type StringsVar []string
+
+func (s *StringsVar) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+
+	return nil
+}
+
+func (s *StringsVar) String() string {
+	return strings.Join(*s, ",")
+}
+
+// MapVar implements the Flag.Value interface and allows the user to
+// specify a mapping in the key=value[,key=value...] form.
+type MapVar map[string]string
+
+func (m *MapVar) Set(value string) error {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+
+	for _, kv := range strings.Split(value, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return fmt.Errorf("expected key=value, got %q", kv)
+		}
+		(*m)[split[0]] = split[1]
+	}
+
+	return nil
+}
+
+func (m *MapVar) String() string {
+	var kvs []string
+	for k, v := range *m {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return strings.Join(kvs, ",")
+}
+
+// ClusterVar implements the Flag.Value interface and allows the user to
+// add a cluster per flag in the name;key=value[;key=value...] form, the
+// keys being zk, consul, datacenter and service-prefix.
+type ClusterVar []Cluster
+
+func (cs *ClusterVar) Set(value string) error {
+	fields := strings.Split(value, ";")
+	cl := Cluster{Name: strings.TrimSpace(fields[0])}
+
+	for _, kv := range fields[1:] {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("expected key=value, got %q", kv)
+		}
+
+		switch split[0] {
+		case "zk":
+			cl.Zk = split[1]
+		case "consul":
+			cl.ConsulAddr = split[1]
+		case "datacenter":
+			cl.Datacenter = split[1]
+		case "service-prefix":
+			cl.ServicePrefix = split[1]
+		default:
+			return fmt.Errorf("unknown cluster option %q", split[0])
+		}
+	}
+
+	if cl.Zk == "" {
+		return fmt.Errorf("cluster %q: missing zk", cl.Name)
+	}
+
+	*cs = append(*cs, cl)
+	return nil
+}
+
+func (cs *ClusterVar) String() string {
+	var names []string
+	for _, cl := range *cs {
+		names = append(names, cl.Name)
+	}
+
+	return strings.Join(names, ",")
+}
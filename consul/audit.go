@@ -0,0 +1,65 @@
+package consul
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// An entry of the --audit-log
+type auditRecord struct {
+	Time    string `json:"time"`
+	Op      string `json:"op"`
+	Target  string `json:"target"`
+	Agent   string `json:"agent"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+type auditLog struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+// SetAuditLog()
+//
+//	Record every Consul call to w, one JSON object per line
+func (r *Consul) SetAuditLog(w io.Writer) {
+	r.auditLog = &auditLog{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// audit()
+//
+//	Record the outcome of op on target against agent and pass the
+//	error through
+func (r *Consul) audit(op string, target string, agent string, err error) error {
+	if r.auditLog == nil {
+		return err
+	}
+
+	rec := &auditRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Op:      op,
+		Target:  target,
+		Agent:   agent,
+		Outcome: "ok",
+	}
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Error = err.Error()
+	}
+
+	r.auditLog.Lock()
+	defer r.auditLog.Unlock()
+
+	if werr := r.auditLog.enc.Encode(rec); werr != nil {
+		hclog.L().Error("Unable to write audit log", "error", werr)
+	}
+
+	return err
+}
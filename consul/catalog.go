@@ -0,0 +1,159 @@
+package consul
+
+import (
+	"context"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The node meta marking the nodes mesos-consul registers in the
+// catalog as external, i.e. without a Consul agent
+var externalNodeMeta = map[string]string{
+	"external-node": "true",
+}
+
+// catalogRegister()
+//
+//	Register service in the catalog of datacenter dc, or of the
+//	--consul-addr agent's one when empty, under the external node
+//	named after address. As no agent runs on the node, the service is
+//	registered without checks, but for one of a static status, see
+//	staticCheck().
+func (r *Consul) catalogRegister(ctx context.Context, dc string, address string, service *consulapi.AgentServiceRegistration) error {
+	token, err := r.serviceToken(service)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	var weights consulapi.AgentWeights
+	if service.Weights != nil {
+		weights = *service.Weights
+	}
+
+	var check *consulapi.AgentCheck
+	if c := service.Check; staticCheck(c) {
+		check = &consulapi.AgentCheck{
+			Node:        address,
+			CheckID:     "service:" + service.ID,
+			Name:        c.Name,
+			Status:      c.Status,
+			Notes:       c.Notes,
+			ServiceID:   service.ID,
+			ServiceName: service.Name,
+			Namespace:   service.Namespace,
+			Partition:   service.Partition,
+		}
+	}
+
+	r.externalNodes.RLock()
+	defer r.externalNodes.RUnlock()
+
+	_, err = r.Endpoint().Catalog().Register(&consulapi.CatalogRegistration{
+		Datacenter: dc,
+		Node:       address,
+		Address:    address,
+		NodeMeta:   externalNodeMeta,
+		Partition:  service.Partition,
+		Service: &consulapi.AgentService{
+			ID:              service.ID,
+			Service:         service.Name,
+			Tags:            service.Tags,
+			Meta:            service.Meta,
+			Port:            service.Port,
+			Address:         service.Address,
+			TaggedAddresses: service.TaggedAddresses,
+			Namespace:       service.Namespace,
+			Partition:       service.Partition,
+			Weights:         weights,
+
+			EnableTagOverride: service.EnableTagOverride,
+		},
+		Check: check,
+	}, (&consulapi.WriteOptions{Token: token}).WithContext(ctx))
+
+	return r.audit("catalog-register", service.ID, address, err)
+}
+
+// staticCheck()
+//
+//	Whether c only carries a status, which the catalog keeps as it
+//	is, e.g. the health of a mirrored task. Checks the agents run
+//	have no agent on an external node.
+func staticCheck(c *consulapi.AgentServiceCheck) bool {
+	return c != nil && c.Status != "" && c.TTL == "" && c.HTTP == "" && c.TCP == "" &&
+		c.UDP == "" && c.GRPC == "" && c.H2PING == "" && len(c.Args) == 0
+}
+
+// catalogDeregister()
+//
+//	Remove service from the external node named after address in
+//	datacenter dc, or in the --consul-addr agent's one when empty,
+//	and the node once it has no service left
+func (r *Consul) catalogDeregister(ctx context.Context, dc string, address string, service *consulapi.AgentServiceRegistration) error {
+	token, err := r.serviceToken(service)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	_, err = r.Endpoint().Catalog().Deregister(&consulapi.CatalogDeregistration{
+		Datacenter: dc,
+		Node:       address,
+		ServiceID:  service.ID,
+		Namespace:  service.Namespace,
+		Partition:  service.Partition,
+	}, (&consulapi.WriteOptions{Token: token}).WithContext(ctx))
+	if err = r.audit("catalog-deregister", service.ID, address, err); err != nil {
+		return err
+	}
+
+	r.removeEmptyNode(ctx, dc, address, service, token)
+	return nil
+}
+
+// removeEmptyNode()
+//
+//	Remove the external node named after address once the last
+//	service of service's partition on it is gone, every namespace
+//	of it included. A failure is only logged, the node being tried
+//	again with the next service deregistered from it.
+func (r *Consul) removeEmptyNode(ctx context.Context, dc string, address string, service *consulapi.AgentServiceRegistration, token string) {
+	r.externalNodes.Lock()
+	defer r.externalNodes.Unlock()
+
+	// Namespaces are only named with Consul Enterprise
+	namespace := ""
+	if service.Namespace != "" {
+		namespace = "*"
+	}
+	node, _, err := r.Endpoint().Catalog().NodeServiceList(address, (&consulapi.QueryOptions{
+		Datacenter: dc,
+		Namespace:  namespace,
+		Partition:  service.Partition,
+		Token:      token,
+	}).WithContext(ctx))
+	if err != nil || node == nil || node.Node == nil {
+		if err != nil {
+			hclog.L().Warn("Unable to read the external node", "node", address, "error", err)
+		}
+		return
+	}
+	if node.Node.Meta["external-node"] != "true" || len(node.Services) > 0 {
+		return
+	}
+
+	_, err = r.Endpoint().Catalog().Deregister(&consulapi.CatalogDeregistration{
+		Datacenter: dc,
+		Node:       address,
+		Partition:  service.Partition,
+	}, (&consulapi.WriteOptions{Token: token}).WithContext(ctx))
+	if err = r.audit("catalog-deregister", "node", address, err); err != nil {
+		hclog.L().Warn("Unable to remove the empty external node", "node", address, "error", err)
+	}
+}
@@ -1,25 +1,58 @@
 package consul
 
 import (
-	"crypto/tls"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
 	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
 )
 
 type Consul struct {
 	agents		map[string]*consulapi.Client
 	config		*config.Config
+	endpoint	*consulapi.Client
+	auditLog	*auditLog
+
+	// The agents of a --consul-addr list, nil with a single one
+	pool		*agentPool
+
+	// The services registered on a fallback agent of the pool, by ID,
+	// see registeredOn()
+	fallbacks	map[string]fallbackService
+
+	// Guards agents and endpoint against concurrent registry writes,
+	// see --registry-concurrency
+	clients		sync.Mutex
+
+	// Held to register services on external nodes, and exclusively
+	// to remove the nodes left empty, see catalogDeregister()
+	externalNodes	sync.RWMutex
+
+	// The leader lock, see --lock
+	lock		*consulapi.Lock
+
+	// The ACL token of the clients, see SetToken()
+	token		string
 }
 
+var _ registry.Registry = (*Consul)(nil)
+
 //
 func NewConsul(c *config.Config) *Consul {
 	return &Consul{
 		agents:		make(map[string]*consulapi.Client),
 		config:		c,
+		token:		c.RegistryToken,
 	}
 }
 
@@ -27,10 +60,13 @@ func NewConsul(c *config.Config) *Consul {
 //   Return a consul client at the specified address
 func (c *Consul) Client(address string) *consulapi.Client {
 	if address == "" {
-		log.Print("[WARN] No address to Consul.Agent")
+		hclog.L().Warn("No address to Consul.Agent")
 		return nil
 	}
 
+	c.clients.Lock()
+	defer c.clients.Unlock()
+
         if _, ok := c.agents[address]; !ok {
                 // Agent connection not saved. Connect.
                 c.agents[address] = c.newAgent(address)
@@ -40,70 +76,658 @@ func (c *Consul) Client(address string) *consulapi.Client {
 }
 
 	
+// SetToken()
+//   Use token for the calls from now on, e.g. once renewed. The
+//   clients are connected again with it, while the calls in progress
+//   finish with the previous one
+func (c *Consul) SetToken(token string) {
+	c.clients.Lock()
+	defer c.clients.Unlock()
+
+	c.token = token
+	c.reset()
+}
+
+// Reconnect()
+//   Connect the clients again, e.g. to present a --registry-ssl-cert
+//   renewed by --vault-registry-ssl, while the calls in progress
+//   finish with the previous ones
+func (c *Consul) Reconnect() {
+	c.clients.Lock()
+	defer c.clients.Unlock()
+
+	c.reset()
+}
+
+// reset()
+//   Drop the clients, connected again when next used. The new ones
+//   start on the preferred --consul-addr agent, so the services
+//   registered on a fallback move back to it. Called with c.clients
+//   held.
+func (c *Consul) reset() {
+	c.agents = make(map[string]*consulapi.Client)
+	c.endpoint = nil
+	c.pool = nil
+
+	if len(c.fallbacks) > 0 {
+		go c.failBack()
+	}
+}
+
+// Endpoint()
+//   Return a consul client for the agent mesos-consul itself
+//   talks to, as set by --consul-addr, failing over to the next
+//   agent of a list when it cannot be reached
+func (c *Consul) Endpoint() *consulapi.Client {
+	c.clients.Lock()
+	defer c.clients.Unlock()
+
+	if c.endpoint == nil {
+		c.endpoint = c.newEndpoint(agentAddresses(c.config.ConsulAddr))
+	}
+
+	return c.endpoint
+}
+
+// endpointAddr()
+//   The address of the --consul-addr agent in use
+func (c *Consul) endpointAddr() string {
+	c.clients.Lock()
+	pool := c.pool
+	c.clients.Unlock()
+
+	if pool == nil {
+		return c.config.ConsulAddr
+	}
+
+	return pool.current()
+}
 
 // newAgent()
-//   Connect to a new agent specified by address
+//   Connect to a new agent specified by address, on --registry-port
+//   unless address has a scheme or port of its own, e.g. from
+//   --consul-agent-attribute
 //
 func (c *Consul) newAgent(address string) *consulapi.Client {
 	if address == "" {
-		log.Printf("[WARN] No address to Consul.NewAgent")
+		hclog.L().Warn("No address to Consul.NewAgent")
 		return nil
 	}
 
+	if strings.Contains(address, "://") {
+		return c.newClient(address)
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return c.newClient(address)
+	}
+
+	return c.newClient(net.JoinHostPort(address, c.config.RegistryPort))
+}
+
+// newClient()
+//   Connect to the agent at address, in the [scheme://]host:port form
+//
+func (c *Consul) newClient(address string) *consulapi.Client {
+	return connect(c.clientConfig(address))
+}
+
+// clientConfig()
+//   The configuration of a client of the agent at address
+//
+func (c *Consul) clientConfig(address string) *consulapi.Config {
 	config := consulapi.DefaultConfig()
 
-	config.Address = fmt.Sprintf("%s:%s", address, c.config.RegistryPort)
+	config.Address = address
 
-	if c.config.RegistryToken != "" {
-		log.Printf("[DEBUG] setting token to %s", c.config.RegistryToken)
-		config.Token = c.config.RegistryToken
+	// The KV, catalog and event calls of a --cluster go to its
+	// datacenter
+	if c.config.Cluster != nil && c.config.Cluster.Datacenter != "" {
+		config.Datacenter = c.config.Cluster.Datacenter
+	}
+
+	// The calls that take no partition, e.g. those of the --lock
+	// session, go to the --consul-partition
+	config.Partition = c.config.ConsulPartition
+
+	if c.token != "" {
+		hclog.L().Debug("setting token")
+		config.Token = c.token
 	}
 
 	if c.config.RegistrySSL.Enabled {
-		log.Printf("[DEBUG] enabling SSL")
+		hclog.L().Debug("enabling SSL")
 		config.Scheme = "https"
 	}
 
+	if i := strings.Index(address, "://"); i != -1 {
+		config.Scheme = address[:i]
+		config.Address = address[i+3:]
+	}
+
+	// Unset files and verification fall back to the CONSUL_CACERT,
+	// CONSUL_CLIENT_CERT, CONSUL_CLIENT_KEY and CONSUL_HTTP_SSL_VERIFY
+	// environment variables
+	if c.config.RegistrySSL.CaCert != "" {
+		config.TLSConfig.CAFile = c.config.RegistrySSL.CaCert
+	}
+	if c.config.RegistrySSL.Cert != "" {
+		config.TLSConfig.CertFile = c.config.RegistrySSL.Cert
+	}
+	if c.config.RegistrySSL.Key != "" {
+		config.TLSConfig.KeyFile = c.config.RegistrySSL.Key
+	}
+
 	if !c.config.RegistrySSL.Verify {
-		log.Printf("[DEBUG] disabled SSL verification")
-		config.HttpClient.Transport = &http.Transport {
-			TLSClientConfig: &tls.Config {
-				InsecureSkipVerify: true,
-			},
-		}
+		hclog.L().Debug("disabled SSL verification")
+		config.TLSConfig.InsecureSkipVerify = true
 	}
 
 	if c.config.RegistryAuth.Enabled {
-		log.Printf("[DEBUG] setting basic auth")
+		hclog.L().Debug("setting basic auth")
 		config.HttpAuth = &consulapi.HttpBasicAuth{
 			Username: c.config.RegistryAuth.Username,
 			Password: c.config.RegistryAuth.Password,
 		}
 	}
 
+	return config
+}
+
+func connect(config *consulapi.Config) *consulapi.Client {
 	client, err := consulapi.NewClient(config)
 	if err != nil {
-		log.Fatal("consul: ", address)
+		hclog.L().Error("Unable to create the Consul client", "addr", config.Address, "error", err)
+		os.Exit(1)
 	}
 	return client
 }
 
-func (r *Consul) Register(service *consulapi.AgentServiceRegistration) error {
-	if _, ok := r.agents[service.Address]; !ok {
-		// Agent connection not saved. Connect.
-		r.agents[service.Address] = r.newAgent(service.Address)
+// timeout()
+//   Bound a call to Consul by --consul-timeout, plus extra for
+//   blocking queries
+func (r *Consul) timeout(ctx context.Context, extra time.Duration) (context.Context, context.CancelFunc) {
+	if r.config.ConsulTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, r.config.ConsulTimeout+extra)
+}
+
+// queryOptions()
+//   Options of a read of mesos-consul's own data, e.g. its KV keys,
+//   in the --consul-namespace and --consul-partition. Reads of a ctx
+//   of registry.AllowStale may be answered by any server.
+func (r *Consul) queryOptions(ctx context.Context) *consulapi.QueryOptions {
+	return (&consulapi.QueryOptions{
+		Namespace:	r.config.ConsulNamespace,
+		Partition:	r.config.ConsulPartition,
+		AllowStale:	registry.Stale(ctx),
+	}).WithContext(ctx)
+}
+
+// writeOptions()
+//   Options of a write of mesos-consul's own data, see queryOptions()
+func (r *Consul) writeOptions(ctx context.Context) *consulapi.WriteOptions {
+	return (&consulapi.WriteOptions{
+		Namespace:	r.config.ConsulNamespace,
+		Partition:	r.config.ConsulPartition,
+	}).WithContext(ctx)
+}
+
+// blocking()
+//   The longest a blocking query waits for a change past waitIndex,
+//   5 minutes unless wait is set. Consul adds up to 1/16th of it as
+//   jitter.
+func blocking(waitIndex uint64, wait time.Duration) time.Duration {
+	if waitIndex == 0 {
+		return 0
+	}
+	if wait == 0 {
+		wait = 5 * time.Minute
+	}
+
+	return wait + wait/16
+}
+
+// Register()
+//   Register service with the agent at address agent, or
+//   the --consul-addr agent when agent is empty. With
+//   --registration-api=catalog, or in the datacenter of a ctx of
+//   registry.InDatacenter, agent names an external node of the
+//   catalog instead
+func (r *Consul) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	if dc := registry.Datacenter(ctx); dc != "" {
+		return r.catalogRegister(ctx, dc, agent, service)
+	}
+	if agent != "" && r.config.RegistrationAPI == config.RegistrationCatalog {
+		return r.catalogRegister(ctx, "", agent, service)
+	}
+
+	token, err := r.serviceToken(service)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+	opts := consulapi.ServiceRegisterOpts{Token: token}.WithContext(ctx)
+
+	if agent == "" {
+		err := r.Endpoint().Agent().ServiceRegisterOpts(service, opts)
+		if err == nil {
+			r.registeredOn(ctx, service)
+		}
+		return r.audit("register", service.ID, r.endpointAddr(), err)
+	}
+
+	return r.audit("register", service.ID, agent, r.Client(agent).Agent().ServiceRegisterOpts(service, opts))
+}
+
+// Deregister()
+//   Remove service from the agent it was registered with
+func (r *Consul) Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	if dc := registry.Datacenter(ctx); dc != "" {
+		return r.catalogDeregister(ctx, dc, agent, service)
+	}
+	if agent != "" && r.config.RegistrationAPI == config.RegistrationCatalog {
+		return r.catalogDeregister(ctx, "", agent, service)
+	}
+
+	token, err := r.serviceToken(service)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	// Services registered into a namespace or partition must be
+	// removed from it
+	opts := (&consulapi.QueryOptions{
+		Namespace:	service.Namespace,
+		Partition:	service.Partition,
+		Token:		token,
+	}).WithContext(ctx)
+
+	if agent == "" {
+		err := r.Endpoint().Agent().ServiceDeregisterOpts(service.ID, opts)
+		if err == nil {
+			r.deregisterFallback(ctx, service.ID)
+		}
+		return r.audit("deregister", service.ID, r.endpointAddr(), err)
+	}
+
+	r.clients.Lock()
+	_, ok := r.agents[agent]
+	r.clients.Unlock()
+	if !ok {
+		hclog.L().Warn("Deregistering a service without an agent connection?!", "service_id", service.ID, "agent", agent)
+	}
+
+	return r.audit("deregister", service.ID, agent, r.Client(agent).Agent().ServiceDeregisterOpts(service.ID, opts))
+}
+
+// UpdateTTL()
+//   Mark a TTL check as passing or failing on the agent its service
+//   was registered with, the --consul-addr agent for an empty agent
+func (r *Consul) UpdateTTL(ctx context.Context, agent string, checkID string, passing bool, note string) error {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+	opts := r.queryOptions(ctx)
+
+	client, addr := r.Endpoint(), r.endpointAddr()
+	if agent != "" {
+		client, addr = r.Client(agent), agent
+	}
+
+	if passing {
+		return r.audit("pass-ttl", checkID, addr, client.Agent().UpdateTTLOpts(checkID, note, consulapi.HealthPassing, opts))
+	}
+
+	return r.audit("fail-ttl", checkID, addr, client.Agent().UpdateTTLOpts(checkID, note, consulapi.HealthCritical, opts))
+}
+
+// Maintenance()
+//   Put the node of the agent at address agent into maintenance
+//   mode, or take it out of it. The Consul API takes no context for
+//   these, so a timed out call is left running in the background
+func (r *Consul) Maintenance(ctx context.Context, agent string, enable bool, reason string) error {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	op, call := "disable-maintenance", r.Client(agent).Agent().DisableNodeMaintenance
+	if enable {
+		op, call = "enable-maintenance", func() error {
+			return r.Client(agent).Agent().EnableNodeMaintenance(reason)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return r.audit(op, "node", agent, err)
+	case <-ctx.Done():
+		return r.audit(op, "node", agent, ctx.Err())
+	}
+}
+
+// Services()
+//   List the services of the --consul-addr agent's catalog whose ID
+//   starts with prefix, in the --consul-namespace and
+//   --consul-partition and the scopes of registry.InScopes. Each
+//   call, of the names and of every service, gets its own
+//   --consul-timeout
+func (r *Consul) Services(ctx context.Context, prefix string) ([]*registry.Service, error) {
+	var services []*registry.Service
+	for _, scope := range r.scopes(ctx) {
+		scoped, err := r.scopeServices(ctx, scope, prefix)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, scoped...)
+	}
+
+	return services, nil
+}
+
+// scopes()
+//   The scopes Services() lists, the --consul-namespace and
+//   --consul-partition first, each once
+func (r *Consul) scopes(ctx context.Context) []registry.Scope {
+	orDefault := func(name string) string {
+		if name == "" {
+			return "default"
+		}
+		return name
+	}
+
+	own := registry.Scope{Namespace: r.config.ConsulNamespace, Partition: r.config.ConsulPartition}
+	scopes := []registry.Scope{own}
+	seen := map[registry.Scope]bool{{Namespace: orDefault(own.Namespace), Partition: orDefault(own.Partition)}: true}
+	for _, s := range registry.Scopes(ctx) {
+		key := registry.Scope{Namespace: orDefault(s.Namespace), Partition: orDefault(s.Partition)}
+		if !seen[key] {
+			seen[key] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+// scopeServices()
+//   The services of Services() in scope
+func (r *Consul) scopeServices(ctx context.Context, scope registry.Scope, prefix string) ([]*registry.Service, error) {
+	catalog := r.Endpoint().Catalog()
+	opts := func(ctx context.Context) *consulapi.QueryOptions {
+		opts := r.queryOptions(ctx)
+		opts.Namespace = scope.Namespace
+		opts.Partition = scope.Partition
+		return opts
+	}
+
+	listCtx, cancel := r.timeout(ctx, 0)
+	serviceList, _, err := catalog.Services(opts(listCtx))
+	cancel()
+	if err != nil {
+		return nil, r.audit("list", "services", r.endpointAddr(), err)
+	}
+
+	var services []*registry.Service
+	for service, _ := range serviceList {
+		serviceCtx, cancel := r.timeout(ctx, 0)
+		catalogServices, _, err := catalog.Service(service, "", opts(serviceCtx))
+		cancel()
+		if err != nil {
+			return nil, r.audit("list", service, r.endpointAddr(), err)
+		}
+
+		for _, s := range catalogServices {
+			if strings.HasPrefix(s.ServiceID, prefix) {
+				services = append(services, &registry.Service{
+					AgentServiceRegistration:	&consulapi.AgentServiceRegistration{
+						ID:		s.ServiceID,
+						Name:		s.ServiceName,
+						Port:		s.ServicePort,
+						Address:	s.ServiceAddress,
+						Tags:		s.ServiceTags,
+						Meta:		s.ServiceMeta,
+						Namespace:	s.Namespace,
+						Partition:	s.Partition,
+					},
+					Agent:	s.Address,
+				})
+			}
+		}
+	}
+
+	return services, nil
+}
+
+// WaitServices()
+//   Block on the service names of the --consul-addr agent's catalog,
+//   whose index Consul moves with every registration, deregistration
+//   or change of a service, like List() blocks on a prefix
+func (r *Consul) WaitServices(ctx context.Context, waitIndex uint64, wait time.Duration) (uint64, error) {
+	ctx, cancel := r.timeout(ctx, blocking(waitIndex, wait))
+	defer cancel()
+
+	opts := r.queryOptions(ctx)
+	opts.WaitIndex = waitIndex
+	opts.WaitTime = wait
+
+	_, meta, err := r.Endpoint().Catalog().Services(opts)
+	if err != nil {
+		return 0, r.audit("list", "services", r.endpointAddr(), err)
+	}
+
+	return meta.LastIndex, nil
+}
+
+// Checks()
+//   List the checks, in any state, of the services whose ID starts
+//   with prefix on every node of the datacenter, as the services are
+//   registered with the agents of the Mesos agents
+func (r *Consul) Checks(ctx context.Context, prefix string) ([]*consulapi.HealthCheck, error) {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	all, _, err := r.Endpoint().Health().State(consulapi.HealthAny, r.queryOptions(ctx))
+	if err != nil {
+		return nil, r.audit("list", "checks", r.endpointAddr(), err)
+	}
+
+	var checks []*consulapi.HealthCheck
+	for _, c := range all {
+		if c.ServiceID != "" && strings.HasPrefix(c.ServiceID, prefix) {
+			checks = append(checks, c)
+		}
+	}
+
+	return checks, nil
+}
+
+// CheckRegistration()
+//   Verify the registry token can register services by registering
+//   and deregistering a throwaway service on the --consul-addr agent
+func (r *Consul) CheckRegistration(ctx context.Context) error {
+	service := &consulapi.AgentServiceRegistration{
+		ID:	"mesos-consul:preflight",
+		Name:	"mesos-consul-preflight",
+
+		Namespace:	r.config.ConsulNamespace,
+		Partition:	r.config.ConsulPartition,
+	}
+
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	agent := r.Endpoint().Agent()
+
+	if err := r.audit("register", service.ID, r.endpointAddr(), agent.ServiceRegisterOpts(service, consulapi.ServiceRegisterOpts{}.WithContext(ctx))); err != nil {
+		return fmt.Errorf("registry token cannot register services (check its ACL permissions): %v", err)
+	}
+
+	if err := r.audit("deregister", service.ID, r.endpointAddr(), agent.ServiceDeregisterOpts(service.ID, r.queryOptions(ctx))); err != nil {
+		return fmt.Errorf("registry token cannot deregister services (check its ACL permissions): %v", err)
+	}
+
+	return nil
+}
+
+// The payload of the user events fired by FireEvent()
+type Event struct {
+	Action	string	`json:"action"`
+	ID	string	`json:"id"`
+	Name	string	`json:"name"`
+	Address	string	`json:"address"`
+	Port	int	`json:"port"`
+}
+
+// FireEvent()
+//   Fire a Consul user event named name on the --consul-addr agent
+//   describing a change to service
+func (r *Consul) FireEvent(ctx context.Context, name string, action string, service *consulapi.AgentServiceRegistration) error {
+	payload, err := json.Marshal(&Event{
+		Action:		action,
+		ID:		service.ID,
+		Name:		service.Name,
+		Address:	service.Address,
+		Port:		service.Port,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	_, _, err = r.Endpoint().Event().Fire(&consulapi.UserEvent{
+		Name:		name,
+		Payload:	payload,
+	}, r.writeOptions(ctx))
+
+	return r.audit("fire-event", name, r.endpointAddr(), err)
+}
+
+// Get()
+//   Read key from the --consul-addr agent. A missing key returns a
+//   nil value. With a non-zero waitIndex the read is a blocking query
+//   returning once the key changes past waitIndex or wait elapses.
+func (r *Consul) Get(ctx context.Context, key string, waitIndex uint64, wait time.Duration) ([]byte, uint64, error) {
+	ctx, cancel := r.timeout(ctx, blocking(waitIndex, wait))
+	defer cancel()
+
+	opts := r.queryOptions(ctx)
+	opts.WaitIndex = waitIndex
+	opts.WaitTime = wait
+
+	pair, meta, err := r.Endpoint().KV().Get(key, opts)
+	r.audit("get", key, r.endpointAddr(), err)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if pair == nil {
+		return nil, meta.LastIndex, nil
+	}
+
+	return pair.Value, meta.LastIndex, nil
+}
+
+// Put()
+//   Write key on the --consul-addr agent
+func (r *Consul) Put(ctx context.Context, key string, value []byte) error {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	_, err := r.Endpoint().KV().Put(&consulapi.KVPair{
+		Key:	key,
+		Value:	value,
+	}, r.writeOptions(ctx))
+
+	return r.audit("put", key, r.endpointAddr(), err)
+}
+
+// List()
+//   Read the keys starting with prefix from the --consul-addr agent,
+//   blocking like Get() with a non-zero waitIndex
+func (r *Consul) List(ctx context.Context, prefix string, waitIndex uint64, wait time.Duration) (map[string][]byte, uint64, error) {
+	ctx, cancel := r.timeout(ctx, blocking(waitIndex, wait))
+	defer cancel()
+
+	opts := r.queryOptions(ctx)
+	opts.WaitIndex = waitIndex
+	opts.WaitTime = wait
+
+	pairs, meta, err := r.Endpoint().KV().List(prefix, opts)
+	r.audit("list", prefix, r.endpointAddr(), err)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return r.agents[service.Address].Agent().ServiceRegister(service)
+	values := make(map[string][]byte, len(pairs))
+	for _, pair := range pairs {
+		values[pair.Key] = pair.Value
+	}
+
+	return values, meta.LastIndex, nil
 }
 
-func (r *Consul) Deregister(service *consulapi.AgentServiceRegistration) error {
-	if _, ok := r.agents[service.Address]; !ok {
-		log.Print("[WARN] Deregistering a service without an agent connection?!")
+// Operations of a Consul transaction
+const txnMaxOps = 64
+
+// Txn()
+//   Write puts and remove deletes on the --consul-addr agent in
+//   transactions of up to txnMaxOps operations
+func (r *Consul) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	keys := make([]string, 0, len(puts))
+	for key := range puts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ops consulapi.KVTxnOps
+	for _, key := range keys {
+		ops = append(ops, &consulapi.KVTxnOp{
+			Verb:	consulapi.KVSet,
+			Key:	key,
+			Value:	puts[key],
+		})
+	}
+	for _, key := range deletes {
+		ops = append(ops, &consulapi.KVTxnOp{
+			Verb:	consulapi.KVDelete,
+			Key:	key,
+		})
+	}
+
+	// Each chunk gets its own --consul-timeout
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > txnMaxOps {
+			n = txnMaxOps
+		}
+
+		chunkCtx, cancel := r.timeout(ctx, 0)
+		ok, resp, _, err := r.Endpoint().KV().Txn(ops[:n], r.queryOptions(chunkCtx))
+		cancel()
+		if err == nil && !ok {
+			err = fmt.Errorf("transaction rolled back")
+			if resp != nil && len(resp.Errors) > 0 {
+				err = fmt.Errorf("transaction rolled back: %s", resp.Errors[0].What)
+			}
+		}
+		if err := r.audit("txn", ops[0].Key, r.endpointAddr(), err); err != nil {
+			return err
+		}
 
-		// Agent connection not saved. Connect.
-		r.agents[service.Address] = r.newAgent(service.Address)
+		ops = ops[n:]
 	}
 
-	return r.agents[service.Address].Agent().ServiceDeregister(service.ID)
+	return nil
 }
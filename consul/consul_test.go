@@ -0,0 +1,41 @@
+package consul
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+)
+
+func TestClientConfigTLS(t *testing.T) {
+	t.Setenv("CONSUL_CACERT", "/env/ca.pem")
+	t.Setenv("CONSUL_CLIENT_CERT", "/env/cert.pem")
+	t.Setenv("CONSUL_CLIENT_KEY", "/env/key.pem")
+
+	c := config.DefaultConfig()
+	c.RegistrySSL.Cert = "/flag/cert.pem"
+	r := NewConsul(c)
+
+	tls := r.clientConfig("10.0.0.1:8500").TLSConfig
+	if tls.CAFile != "/env/ca.pem" || tls.CertFile != "/flag/cert.pem" || tls.KeyFile != "/env/key.pem" {
+		t.Errorf("expected the flag to override only the certificate of the environment, got %+v", tls)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	c := config.DefaultConfig()
+	c.ConsulNamespace = "team-a"
+	r := NewConsul(c)
+
+	ctx := registry.InScopes(context.Background(), []registry.Scope{
+		{Namespace: "team-a", Partition: "default"},
+		{Namespace: "team-b"},
+		{Namespace: "team-b", Partition: "default"},
+	})
+	want := []registry.Scope{{Namespace: "team-a"}, {Namespace: "team-b"}}
+	if scopes := r.scopes(ctx); !reflect.DeepEqual(scopes, want) {
+		t.Errorf("expected %v, got %v", want, scopes)
+	}
+}
@@ -0,0 +1,321 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+const (
+	// How long a failed over endpoint waits before probing the
+	// preferred agent, the first of --consul-addr, again
+	agentProbeInterval = 30 * time.Second
+
+	// Connection timeout of the --consul-addr agents, short enough
+	// for a call to fail over to the next agent within --consul-timeout
+	agentDialTimeout = 3 * time.Second
+)
+
+// Split --consul-addr into its comma-separated agents
+func agentAddresses(addr string) []string {
+	var addrs []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+
+	return addrs
+}
+
+// newEndpoint()
+//
+//	Connect to the --consul-addr agents, through an agentPool when
+//	there are several. Called with c.clients held.
+func (c *Consul) newEndpoint(addrs []string) *consulapi.Client {
+	if len(addrs) < 2 {
+		return c.newClient(c.config.ConsulAddr)
+	}
+
+	config := c.clientConfig(addrs[0])
+	config.Transport.DialContext = (&net.Dialer{
+		Timeout:   agentDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	client := connect(config)
+
+	scheme := "http"
+	if c.config.RegistrySSL.Enabled {
+		scheme = "https"
+	}
+
+	// The agents share the TLS configuration and the connections of
+	// the client
+	c.pool = newAgentPool(addrs, scheme, config.HttpClient.Transport)
+	c.pool.failedBack = func() { go c.failBack() }
+	config.HttpClient.Transport = c.pool
+
+	return client
+}
+
+// An agent of --consul-addr, as the URL of its requests
+type poolAgent struct {
+	address string
+	scheme  string
+	host    string
+}
+
+// agentPool()
+//
+//	Send the requests of the endpoint to the first of the agents
+//	that can be connected to. A request that cannot connect to the
+//	agent in use is sent to the next one, which is used from then on.
+//	While on a fallback, the preferred agent is probed every
+//	agentProbeInterval and used again once it answers.
+type agentPool struct {
+	next   http.RoundTripper
+	agents []poolAgent
+
+	// Called once the preferred agent is used again
+	failedBack func()
+
+	sync.Mutex
+	active  int
+	probed  time.Time
+	probing bool
+}
+
+func newAgentPool(addrs []string, scheme string, next http.RoundTripper) *agentPool {
+	p := &agentPool{next: next}
+	for _, addr := range addrs {
+		a := poolAgent{address: addr, scheme: scheme, host: addr}
+		if i := strings.Index(addr, "://"); i != -1 {
+			a.scheme, a.host = addr[:i], addr[i+3:]
+		}
+		p.agents = append(p.agents, a)
+	}
+
+	return p
+}
+
+// The address of the agent in use
+func (p *agentPool) current() string {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.agents[p.active].address
+}
+
+func (p *agentPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.Lock()
+	start := p.active
+	if start != 0 && !p.probing && time.Since(p.probed) >= agentProbeInterval {
+		p.probing = true
+		go p.probe(req.Header)
+	}
+	p.Unlock()
+
+	for i := 0; ; i++ {
+		n := (start + i) % len(p.agents)
+		r, err := p.agents[n].request(req, i > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := p.next.RoundTrip(r)
+		if err == nil || !unreachable(err) || i == len(p.agents)-1 || req.Context().Err() != nil {
+			if err == nil {
+				p.use(n)
+			}
+			return resp, err
+		}
+
+		// The agent did not get the request: it can be sent again
+		hclog.L().Warn("Consul agent unreachable. Failing over", "agent", p.agents[n].address,
+			"fallback", p.agents[(n+1)%len(p.agents)].address, "error", err)
+	}
+}
+
+// Use the agent n from now on
+func (p *agentPool) use(n int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.active == n {
+		return
+	}
+	if n == 0 {
+		hclog.L().Info("Consul agent reachable again. Failing back", "agent", p.agents[0].address)
+		if p.failedBack != nil {
+			defer p.failedBack()
+		}
+	}
+	if p.active == 0 {
+		p.probed = time.Now()
+	}
+	p.active = n
+}
+
+// Check whether the preferred agent answers again, with the headers,
+// e.g. the ACL token, of the request that triggered the probe
+func (p *agentPool) probe(header http.Header) {
+	ctx, cancel := context.WithTimeout(context.Background(), agentDialTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://preferred/v1/status/leader", nil)
+	if err == nil {
+		req.Header = header.Clone()
+		req, err = p.agents[0].request(req, false)
+	}
+
+	var resp *http.Response
+	if err == nil {
+		resp, err = p.next.RoundTrip(req)
+	}
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = errors.New(resp.Status)
+		}
+	}
+
+	p.Lock()
+	p.probing = false
+	p.probed = time.Now()
+	p.Unlock()
+
+	if err != nil {
+		hclog.L().Debug("Consul agent still unreachable", "agent", p.agents[0].address, "error", err)
+		return
+	}
+
+	p.use(0)
+}
+
+// A copy of req for the agent, with a new body when it is resent
+func (a poolAgent) request(req *http.Request, resend bool) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = a.scheme
+	r.URL.Host = a.host
+	r.Host = a.host
+
+	if resend && req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("unable to resend the request body to " + a.address)
+		}
+
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	return r, nil
+}
+
+// Whether err tells the agent could not be connected to, so the
+// request never reached it
+func unreachable(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// A service registered on a fallback agent of the pool
+type fallbackService struct {
+	agent   string
+	service *consulapi.AgentServiceRegistration
+}
+
+// registeredOn()
+//
+//	Record the agent of the pool service was just registered on, so
+//	a copy on a fallback is removed with it, or once the preferred
+//	agent is used again, see failBack(). A copy left on another
+//	fallback is removed at once.
+func (c *Consul) registeredOn(ctx context.Context, service *consulapi.AgentServiceRegistration) {
+	c.clients.Lock()
+	pool := c.pool
+	c.clients.Unlock()
+	if pool == nil {
+		return
+	}
+
+	agent := pool.current()
+	s := *service
+
+	c.clients.Lock()
+	previous, ok := c.fallbacks[service.ID]
+	if agent != pool.agents[0].address {
+		if c.fallbacks == nil {
+			c.fallbacks = make(map[string]fallbackService)
+		}
+		c.fallbacks[service.ID] = fallbackService{agent, &s}
+	} else {
+		delete(c.fallbacks, service.ID)
+	}
+	c.clients.Unlock()
+
+	if ok && previous.agent != agent {
+		c.removeFallback(ctx, previous)
+	}
+}
+
+// deregisterFallback()
+//
+//	Remove the copy of the service with id a fallback agent holds,
+//	once it was deregistered from the agent in use
+func (c *Consul) deregisterFallback(ctx context.Context, id string) {
+	c.clients.Lock()
+	f, ok := c.fallbacks[id]
+	delete(c.fallbacks, id)
+	c.clients.Unlock()
+
+	if ok && f.agent != c.endpointAddr() {
+		c.removeFallback(ctx, f)
+	}
+}
+
+// failBack()
+//
+//	Move the services registered on the fallback agents to the
+//	preferred one, now in use again
+func (c *Consul) failBack() {
+	c.clients.Lock()
+	fallbacks := c.fallbacks
+	c.fallbacks = nil
+	c.clients.Unlock()
+
+	for _, f := range fallbacks {
+		ctx, cancel := c.timeout(context.Background(), 0)
+		if err := c.Register(ctx, "", f.service); err != nil {
+			hclog.L().Warn("Unable to move the service back from the fallback agent", "service_id", f.service.ID, "agent", f.agent, "error", err)
+		} else {
+			c.removeFallback(ctx, f)
+		}
+		cancel()
+	}
+}
+
+// Deregister the service of f from its fallback agent
+func (c *Consul) removeFallback(ctx context.Context, f fallbackService) {
+	token, err := c.serviceToken(f.service)
+	if err == nil {
+		opts := (&consulapi.QueryOptions{
+			Namespace: f.service.Namespace,
+			Partition: f.service.Partition,
+			Token:     token,
+		}).WithContext(ctx)
+		err = c.Client(f.agent).Agent().ServiceDeregisterOpts(f.service.ID, opts)
+	}
+	if err = c.audit("deregister", f.service.ID, f.agent, err); err != nil {
+		hclog.L().Warn("Unable to deregister the service from the fallback agent", "service_id", f.service.ID, "agent", f.agent, "error", err)
+	}
+}
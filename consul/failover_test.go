@@ -0,0 +1,166 @@
+package consul
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A transport answering for the agents that are not down
+type agentsTransport struct {
+	sync.Mutex
+	down     map[string]bool
+	bodies   map[string]string
+	requests []string
+}
+
+func (t *agentsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.down[req.URL.Host] {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: io.ErrUnexpectedEOF}
+	}
+
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		t.bodies[req.URL.Host] = string(body)
+	}
+	t.requests = append(t.requests, req.Method+" "+req.URL.Host+req.URL.Path)
+
+	return &http.Response{StatusCode: 200, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+}
+
+func (t *agentsTransport) setDown(host string, down bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.down[host] = down
+}
+
+func TestAgentAddresses(t *testing.T) {
+	addrs := agentAddresses(" 10.0.0.1:8500, https://10.0.0.2:8501,")
+	if len(addrs) != 2 || addrs[0] != "10.0.0.1:8500" || addrs[1] != "https://10.0.0.2:8501" {
+		t.Errorf("unexpected addresses %q", addrs)
+	}
+}
+
+func TestAgentPoolFailover(t *testing.T) {
+	next := &agentsTransport{down: map[string]bool{"a:8500": true}, bodies: map[string]string{}}
+	p := newAgentPool([]string{"a:8500", "https://b:8501"}, "http", next)
+
+	req, _ := http.NewRequest("PUT", "http://a:8500/v1/kv/key", strings.NewReader("value"))
+	resp, err := p.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Request.URL.Scheme != "https" || resp.Request.URL.Host != "b:8501" {
+		t.Errorf("expected the request to fail over to https://b:8501, got %s", resp.Request.URL)
+	}
+	if next.bodies["b:8501"] != "value" {
+		t.Errorf("expected the body to be resent, got %q", next.bodies["b:8501"])
+	}
+	if p.current() != "https://b:8501" {
+		t.Errorf("expected the fallback to be used, got %s", p.current())
+	}
+
+	// All agents down: the error of the last one is returned
+	next.setDown("b:8501", true)
+	req, _ = http.NewRequest("GET", "http://a:8500/v1/status/leader", nil)
+	if _, err := p.RoundTrip(req); !unreachable(err) {
+		t.Errorf("expected the agents to be unreachable, got %v", err)
+	}
+
+	// The preferred agent is back once the probe finds it answering
+	next.setDown("b:8501", false)
+	next.setDown("a:8500", false)
+	p.Lock()
+	p.probed = time.Time{}
+	p.Unlock()
+
+	if _, err := p.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); p.current() != "a:8500"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a failback to a:8500, still on %s", p.current())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpdateTTLAgent(t *testing.T) {
+	r := NewConsul(config.DefaultConfig())
+
+	next := &agentsTransport{down: map[string]bool{}, bodies: map[string]string{}}
+	agent, err := consulapi.NewClient(&consulapi.Config{Address: "10.0.0.2:8500", HttpClient: &http.Client{Transport: next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.agents["10.0.0.2:8500"] = agent
+
+	if err := r.UpdateTTL(context.Background(), "10.0.0.2:8500", "service:mesos-consul:web", true, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"PUT 10.0.0.2:8500/v1/agent/check/update/service:mesos-consul:web"}
+	if !reflect.DeepEqual(next.requests, want) {
+		t.Errorf("expected %v, got %v", want, next.requests)
+	}
+}
+
+func TestFallbackServices(t *testing.T) {
+	c := config.DefaultConfig()
+	c.ConsulAddr = "a:8500,b:8500"
+	r := NewConsul(c)
+
+	next := &agentsTransport{down: map[string]bool{"a:8500": true}, bodies: map[string]string{}}
+	r.Endpoint()
+	r.pool.next = next
+	fallback, err := consulapi.NewClient(&consulapi.Config{Address: "b:8500", HttpClient: &http.Client{Transport: next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.agents["b:8500"] = fallback
+
+	s := &consulapi.AgentServiceRegistration{ID: "mesos-consul:health", Name: "health"}
+	if err := r.Register(context.Background(), "", s); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := r.fallbacks[s.ID]; !ok || f.agent != "b:8500" {
+		t.Fatalf("expected the service to be recorded on the fallback, got %v", r.fallbacks)
+	}
+
+	// Failing back moves it to the preferred agent
+	next.setDown("a:8500", false)
+	r.pool.use(0)
+	want := []string{
+		"PUT b:8500/v1/agent/service/register",
+		"PUT a:8500/v1/agent/service/register",
+		"PUT b:8500/v1/agent/service/deregister/mesos-consul:health",
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		next.Lock()
+		requests := append([]string(nil), next.requests...)
+		next.Unlock()
+		if reflect.DeepEqual(requests, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v, got %v", want, requests)
+		}
+	}
+
+	r.clients.Lock()
+	defer r.clients.Unlock()
+	if len(r.fallbacks) != 0 {
+		t.Errorf("expected no service left on a fallback, got %v", r.fallbacks)
+	}
+}
@@ -0,0 +1,55 @@
+package consul
+
+import (
+	"os"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// AcquireLock()
+//
+//	Block until this instance holds the session lock on key of the
+//	--consul-addr agent and return a channel closed once the lock is
+//	lost, e.g. when the agent or its session goes away
+func (r *Consul) AcquireLock(key string) <-chan struct{} {
+	for {
+		if r.lock == nil {
+			lock, err := r.Endpoint().LockOpts(&consulapi.LockOptions{
+				Key:         key,
+				SessionName: "mesos-consul",
+				Namespace:   r.config.ConsulNamespace,
+			})
+			if err != nil {
+				hclog.L().Error("Unable to create the lock", "lock", key, "error", err)
+				os.Exit(1)
+			}
+			r.lock = lock
+		}
+
+		// A lost lock is still marked held until released
+		r.lock.Unlock()
+
+		lost, err := r.lock.Lock(nil)
+		r.audit("lock", key, r.endpointAddr(), err)
+		if err == nil && lost != nil {
+			return lost
+		}
+
+		hclog.L().Warn("Unable to acquire the lock. Retrying", "lock", key, "error", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// ReleaseLock()
+//
+//	Give up the lock acquired by AcquireLock(), if any, so a standby
+//	can take over right away
+func (r *Consul) ReleaseLock() error {
+	if r.lock == nil {
+		return nil
+	}
+
+	return r.lock.Unlock()
+}
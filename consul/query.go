@@ -0,0 +1,52 @@
+package consul
+
+import (
+	"context"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Queries()
+//
+//	List the prepared queries of the --consul-addr agent's datacenter
+func (r *Consul) Queries(ctx context.Context) ([]*consulapi.PreparedQueryDefinition, error) {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	queries, _, err := r.Endpoint().PreparedQuery().List((&consulapi.QueryOptions{}).WithContext(ctx))
+
+	return queries, r.audit("list", "prepared-queries", r.endpointAddr(), err)
+}
+
+// SetQuery()
+//
+//	Create query, or update it when it has an ID
+func (r *Consul) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	opts := (&consulapi.WriteOptions{}).WithContext(ctx)
+	if query.ID != "" {
+		_, err := r.Endpoint().PreparedQuery().Update(query, opts)
+		return r.audit("update-query", query.Name, r.endpointAddr(), err)
+	}
+
+	id, _, err := r.Endpoint().PreparedQuery().Create(query, opts)
+	if err == nil {
+		query.ID = id
+	}
+
+	return r.audit("create-query", query.Name, r.endpointAddr(), err)
+}
+
+// DeleteQuery()
+//
+//	Delete the prepared query with id
+func (r *Consul) DeleteQuery(ctx context.Context, id string) error {
+	ctx, cancel := r.timeout(ctx, 0)
+	defer cancel()
+
+	_, err := r.Endpoint().PreparedQuery().Delete(id, (&consulapi.WriteOptions{}).WithContext(ctx))
+
+	return r.audit("delete-query", id, r.endpointAddr(), err)
+}
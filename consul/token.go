@@ -0,0 +1,41 @@
+package consul
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// serviceToken()
+//
+//	The ACL token to register and deregister service with: the
+//	content of the file under --registry-token-dir named by the
+//	consul_token_path label of its task, or empty for the
+//	--registry-token. The file is read on every call, so rotated
+//	tokens are picked up without a restart.
+func (r *Consul) serviceToken(service *consulapi.AgentServiceRegistration) (string, error) {
+	name := service.Meta[registry.TokenPathMeta]
+	if name == "" || r.config.RegistryTokenDir == "" {
+		return "", nil
+	}
+
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("token of %s: %q is outside of --registry-token-dir", service.ID, name)
+	}
+
+	b, err := os.ReadFile(filepath.Join(r.config.RegistryTokenDir, name))
+	if err != nil {
+		return "", fmt.Errorf("token of %s: %w", service.ID, err)
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token of %s: %s is empty", service.ID, name)
+	}
+
+	return token, nil
+}
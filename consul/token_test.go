@@ -0,0 +1,45 @@
+package consul
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestServiceToken(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "shop"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.DefaultConfig()
+	c.RegistryTokenDir = dir
+	r := &Consul{config: c}
+
+	service := func(path string) *consulapi.AgentServiceRegistration {
+		return &consulapi.AgentServiceRegistration{ID: "web", Meta: map[string]string{registry.TokenPathMeta: path}}
+	}
+
+	if token, err := r.serviceToken(service("shop")); err != nil || token != "s3cr3t" {
+		t.Errorf("expected the token of the file, got %q, %v", token, err)
+	}
+	if token, err := r.serviceToken(&consulapi.AgentServiceRegistration{ID: "web"}); err != nil || token != "" {
+		t.Errorf("expected the --registry-token without a label, got %q, %v", token, err)
+	}
+	if _, err := r.serviceToken(service("../shop")); err == nil {
+		t.Error("expected a path outside of the directory to fail")
+	}
+	if _, err := r.serviceToken(service("missing")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+
+	// Without --registry-token-dir, the label is ignored
+	c.RegistryTokenDir = ""
+	if token, err := r.serviceToken(service("shop")); err != nil || token != "" {
+		t.Errorf("expected no token without a directory, got %q, %v", token, err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package hook notifies external systems of the services mesos-consul
+// registers and deregisters, e.g. to alert on heavy churn or on
+// critical services disappearing.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// A registration change
+type Event struct {
+	Action  string `json:"action"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+
+	// How the task of a deregistered task service ended, e.g.
+	// TASK_FAILED with REASON_CONTAINER_LIMITATION_MEMORY, when known
+	TaskState   string `json:"task_state,omitempty"`
+	TaskReason  string `json:"task_reason,omitempty"`
+	TaskMessage string `json:"task_message,omitempty"`
+}
+
+// A Hook is notified of every registration and deregistration
+type Hook interface {
+	Fire(e Event) error
+}
+
+// Webhook POSTs every event as JSON to url
+func Webhook(url string) Hook {
+	return &webhook{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhook) Fire(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return post(w.client, w.url, body)
+}
+
+// Slack posts a message describing every event to the Slack incoming
+// webhook at url
+func Slack(url string) Hook {
+	return &slack{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type slack struct {
+	url    string
+	client *http.Client
+}
+
+func (s *slack) Fire(e Event) error {
+	text := fmt.Sprintf("mesos-consul: %s %s (%s at %s:%d)", e.Action, e.Name, e.ID, e.Address, e.Port)
+	if e.TaskState != "" {
+		text += ", task " + e.TaskState
+		if e.TaskReason != "" {
+			text += " " + e.TaskReason
+		}
+		if e.TaskMessage != "" {
+			text += ": " + e.TaskMessage
+		}
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	return post(s.client, s.url, body)
+}
+
+func post(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// Exec runs command with /bin/sh for every event, passing the event
+// as JSON on its standard input and in the MESOS_CONSUL_ACTION,
+// MESOS_CONSUL_SERVICE_ID, MESOS_CONSUL_SERVICE_NAME,
+// MESOS_CONSUL_SERVICE_ADDRESS and MESOS_CONSUL_SERVICE_PORT
+// environment variables, and how the task of a deregistered service
+// ended in MESOS_CONSUL_TASK_STATE, MESOS_CONSUL_TASK_REASON and
+// MESOS_CONSUL_TASK_MESSAGE
+func Exec(command string) Hook {
+	return &execHook{command}
+}
+
+// Shell returns the command running command with the shell of the
+// platform, /bin/sh or, on Windows, cmd.exe
+func Shell(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd.exe", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+type execHook struct {
+	command string
+}
+
+func (x *execHook) Fire(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := Shell(context.Background(), x.command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"MESOS_CONSUL_ACTION="+e.Action,
+		"MESOS_CONSUL_SERVICE_ID="+e.ID,
+		"MESOS_CONSUL_SERVICE_NAME="+e.Name,
+		"MESOS_CONSUL_SERVICE_ADDRESS="+e.Address,
+		"MESOS_CONSUL_SERVICE_PORT="+strconv.Itoa(e.Port),
+		"MESOS_CONSUL_TASK_STATE="+e.TaskState,
+		"MESOS_CONSUL_TASK_REASON="+e.TaskReason,
+		"MESOS_CONSUL_TASK_MESSAGE="+e.TaskMessage,
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", x.command, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Queue delivers the events to hooks in order from a goroutine, so
+// slow hooks do not hold up the syncs. Once size events are waiting,
+// new ones are dropped with a warning. Failed deliveries are logged.
+func Queue(size int, hooks ...Hook) Hook {
+	q := &queue{events: make(chan Event, size), hooks: hooks}
+	go q.run()
+
+	return q
+}
+
+type queue struct {
+	events chan Event
+	hooks  []Hook
+}
+
+func (q *queue) Fire(e Event) error {
+	select {
+	case q.events <- e:
+	default:
+		hclog.L().Warn("Hook queue full. Dropping event", "service_id", e.ID, "action", e.Action)
+	}
+
+	return nil
+}
+
+func (q *queue) run() {
+	for e := range q.events {
+		for _, h := range q.hooks {
+			if err := h.Fire(e); err != nil {
+				hclog.L().Error("Hook failed", "service_id", e.ID, "action", e.Action, "error", err)
+			}
+		}
+	}
+}
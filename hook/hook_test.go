@@ -0,0 +1,102 @@
+package hook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var event = Event{Action: "register", ID: "mesos-consul:10.0.0.1:web:31000", Name: "web", Address: "10.0.0.1", Port: 31000}
+
+func TestWebhook(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	if err := Webhook(srv.URL).Fire(event); err != nil {
+		t.Fatal(err)
+	}
+	if got != event {
+		t.Errorf("expected %v, got %v", event, got)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	if err := Slack(srv.URL).Fire(event); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got["text"], "register web") {
+		t.Errorf("unexpected message: %q", got["text"])
+	}
+
+	ended := Event{Action: "deregister", ID: "web.1", Name: "web", TaskState: "TASK_FAILED", TaskReason: "REASON_CONTAINER_LIMITATION_MEMORY"}
+	if err := Slack(srv.URL).Fire(ended); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(got["text"], "task TASK_FAILED REASON_CONTAINER_LIMITATION_MEMORY") {
+		t.Errorf("expected the message to tell how the task ended, got %q", got["text"])
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer failing.Close()
+
+	if err := Slack(failing.URL).Fire(event); err == nil {
+		t.Error("expected the rejected post to fail")
+	}
+}
+
+func TestExec(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	if err := Exec(`echo "$MESOS_CONSUL_ACTION $MESOS_CONSUL_SERVICE_PORT" > ` + out).Fire(event); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "register 31000\n" {
+		t.Errorf("unexpected output: %q", b)
+	}
+
+	if err := Exec("exit 1").Fire(event); err == nil {
+		t.Error("expected the failing command to fail")
+	}
+}
+
+type recorder chan Event
+
+func (r recorder) Fire(e Event) error {
+	r <- e
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	r := make(recorder, 1)
+	q := Queue(10, r)
+
+	q.Fire(event)
+	select {
+	case e := <-r:
+		if e != event {
+			t.Errorf("expected %v, got %v", event, e)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the event to be delivered")
+	}
+}
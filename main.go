@@ -1,41 +1,564 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"text/template"
 	"time"
 
 	"github.com/CiscoCloud/mesos-consul/config"
 	"github.com/CiscoCloud/mesos-consul/consul"
 	"github.com/CiscoCloud/mesos-consul/mesos"
+	"github.com/CiscoCloud/mesos-consul/metrics"
+	"github.com/CiscoCloud/mesos-consul/tracing"
 
-	"github.com/hashicorp/consul-template/logging"
+	hclog "github.com/hashicorp/go-hclog"
 	flag "github.com/ogier/pflag"
 )
 
 const Name = "mesos-consul"
 const Version = "0.2"
 
+// Names of the --cluster clusters, used in KV keys and URL paths
+var clusterName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Characters --service-name-separator may be made of
+var nameSeparator = regexp.MustCompile(`^[a-z0-9_.-]*$`)
+
+// Characters --service-prefix and --service-suffix may be made of,
+// keeping the names valid in DNS
+var nameAffix = regexp.MustCompile(`^[a-z0-9-]*$`)
+
+// The commands of the binary. Arguments starting with a flag run the
+// daemon, as they did before there were commands.
+const (
+	commandRun	= "run"
+	commandSync	= "sync"
+	commandValidate	= "validate"
+	commandCleanup	= "cleanup"
+)
+
 func main() {
-	c, err := parseFlags(os.Args[1:])
+	runMain(dispatch)
+}
+
+// Run the command of the arguments
+func dispatch() {
+	command, args := splitCommand(os.Args[1:])
+
+	switch command {
+	case commandRun:
+		run(args, false)
+	case commandSync:
+		run(args, true)
+	case commandValidate:
+		validate(args)
+	case commandCleanup:
+		cleanup(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fatal("Unknown command, see mesos-consul help", "command", command)
+	}
+}
+
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commandRun, args
+	}
+
+	return args[0], args[1:]
+}
+
+// Sync every refresh until told to stop, or only once with the sync
+// command or --once
+func run(args []string, once bool) {
+	c, err := parseFlags(args)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Invalid options", "error", err)
+	}
+	c.Once = c.Once || once
+
+	registry, audit := newRegistry(c)
+	if audit != nil {
+		defer audit.Close()
 	}
 
-	log.Print("[INFO] Using registry port: ", c.RegistryPort)
-	log.Print("[INFO] Using zookeeper: ", c.Zk)
-	leader := mesos.New(c, consul.NewConsul(c))
+	var changeLog *os.File
+	if c.ChangeLog != "" {
+		changeLog = openLog(c.ChangeLog)
+		defer changeLog.Close()
+
+		hclog.L().Info("Writing change log", "file", c.ChangeLog)
+	}
+
+	clusters := newClusters(c, registry, audit)
+
+	// Fail fast instead of logging an error on every sync. Each
+	// cluster checks through its own registry, so that only the
+	// files are tried with --registration-api=files
+	if c.RegistryToken != "" && !c.DryRun {
+		for _, cl := range clusters {
+			if err := cl.leader.CheckRegistration(context.Background()); err != nil {
+				fatal("Unable to register", "cluster", cl.name, "error", err)
+			}
+		}
+	}
+
+	if changeLog != nil {
+		for _, cl := range clusters {
+			cl.leader.SetChangeLog(changeLog)
+		}
+	}
+
+	if c.HealthAddr != "" {
+		hclog.L().Info("Serving health", "addr", c.HealthAddr)
+		handler := clusterHandler(clusters, (*mesos.Mesos).HealthHandler)
+		go func() {
+			fatal("Unable to serve health", "error", http.ListenAndServe(c.HealthAddr, handler))
+		}()
+	}
+
+	// POST /v1/sync on the admin API syncs without waiting for the
+	// next refresh
+	resync := make(chan struct{}, 1)
+	if c.AdminAddr != "" {
+		hclog.L().Info("Serving the admin API", "addr", c.AdminAddr)
+		handler := clusterHandler(clusters, func(leader *mesos.Mesos) http.Handler {
+			return leader.AdminHandler(func() {
+				select {
+				case resync <- struct{}{}:
+				default:
+				}
+			})
+		})
+		go func() {
+			fatal("Unable to serve the admin API", "error", http.ListenAndServe(c.AdminAddr, handler))
+		}()
+	}
+
+	if c.MetricsAddr != "" {
+		hclog.L().Info("Serving metrics", "addr", c.MetricsAddr)
+		handler := clusterHandler(clusters, (*mesos.Mesos).MetricsHandler)
+		go func() {
+			fatal("Unable to serve metrics", "error", http.ListenAndServe(c.MetricsAddr, handler))
+		}()
+	}
+
+	if c.StatsdAddr != "" {
+		sink, err := metrics.StatsD(c.StatsdAddr, c.StatsdFormat == config.StatsdFormatDogStatsd, c.StatsdTags)
+		if err != nil {
+			fatal("Unable to send metrics", "addr", c.StatsdAddr, "error", err)
+		}
+
+		hclog.L().Info("Sending metrics", "addr", c.StatsdAddr)
+		go emitMetrics(sink, c.StatsdInterval, clusters)
+	}
+
+	var tracer *tracing.Tracer
+	if c.OTLPEndpoint != "" {
+		hclog.L().Info("Exporting traces", "endpoint", c.OTLPEndpoint)
+		tracer = tracing.New(c.OTLPEndpoint, "mesos-consul", c.OTLPHeaders)
+		for _, cl := range clusters {
+			cl.leader.SetTracer(tracer)
+		}
+	}
+
+	if c.DebugAddr != "" {
+		hclog.L().Info("Serving debug endpoints", "addr", c.DebugAddr)
+		go func() {
+			fatal("Unable to serve debug endpoints", "error", http.ListenAndServe(c.DebugAddr, debugHandler()))
+		}()
+	}
+
+	// With the event stream, sync as soon as Mesos reports a change
+	// and keep polling as a fallback
+	changes := make(chan *mesos.Mesos)
+	if c.MesosAPI == config.MesosAPIEvents {
+		for _, cl := range clusters {
+			go func(leader *mesos.Mesos) {
+				for range leader.WatchEvents() {
+					changes <- leader
+				}
+			}(cl.leader)
+		}
+	}
+
+	// With --lock, only the instance holding the lock syncs. The
+	// others wait to take over from the cache it persisted.
+	var lost <-chan struct{}
+	acquire := func() {
+		if c.Lock == "" {
+			return
+		}
+
+		for _, cl := range clusters {
+			cl.leader.WatchCache()
+		}
+		hclog.L().Info("Waiting for lock", "lock", c.Lock)
+		lost = registry.AcquireLock(c.Lock)
+		hclog.L().Info("Acquired lock", "lock", c.Lock)
+		for _, cl := range clusters {
+			cl.leader.ResetCache()
+		}
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	hup := make(chan os.Signal, 1)
+	notifySignals(shutdown, hup)
+
+	// Renewed Vault secrets are applied like a reload
+	vaultChanged := make(chan struct{}, 1)
+	if usesVault(c) && !c.Once {
+		go watchVault(c, vaultChanged)
+	}
+
+	acquire()
+	err = refresh(clusters)
+
+	// With --once, exit after the first sync, failing when any of it
+	// failed
+	if c.Once {
+		registry.ReleaseLock()
+		if err := tracer.Flush(); err != nil {
+			hclog.L().Warn("Unable to export the traces", "error", err)
+		}
+		for _, cl := range clusters {
+			if err == nil {
+				err = cl.leader.ConsulErr()
+			}
+		}
+		if err != nil {
+			fatal("Sync failed", "error", err)
+		}
+		return
+	}
 
 	ticker := time.NewTicker(c.Refresh)
-        leader.Refresh()
-	for _ = range ticker.C {
-	        leader.Refresh()
+	for {
+		select {
+		case <-ticker.C:
+		case leader := <-changes:
+			leader.ExpireState()
+		case <-resync:
+			hclog.L().Info("Resync requested")
+			for _, cl := range clusters {
+				cl.leader.ExpireState()
+			}
+		case <-lost:
+			hclog.L().Warn("Lost lock", "lock", c.Lock)
+			for _, cl := range clusters {
+				cl.leader.StopWatching()
+			}
+			acquire()
+		case <-hup:
+			if n := reload(args, c, registry, clusters); n != nil {
+				c = n
+				ticker.Reset(c.Refresh)
+			}
+		case <-vaultChanged:
+			if n := reload(args, c, registry, clusters); n != nil {
+				c = n
+				ticker.Reset(c.Refresh)
+			}
+		case sig := <-shutdown:
+			hclog.L().Info("Shutting down", "signal", sig)
+			for _, cl := range clusters {
+				if c.DeregisterOnShutdown {
+					cl.leader.DeregisterAll()
+				}
+				cl.leader.DeregisterHeartbeat()
+			}
+			registry.ReleaseLock()
+			tracer.Flush()
+			return
+		}
+
+		refresh(clusters)
+	}
+}
+
+// Check the flags and configuration file, then sync once as a dry run
+// and list the services the filters and naming let through, without
+// writing to Consul
+func validate(args []string) {
+	c, err := parseFlags(args)
+	if err != nil {
+		fatal("Invalid options", "error", err)
+	}
+	c.DryRun = true
+	c.Lock = ""
+
+	clusters := newClusters(c, consul.NewConsul(c), nil)
+	if err := refresh(clusters); err != nil {
+		fatal("Sync failed", "error", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tSERVICE\tID\tADDRESS")
+	for _, cl := range clusters {
+		for _, s := range cl.leader.CachedServices() {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s:%d\n", cl.name, s.Service.Name, s.Service.ID, s.Service.Address, s.Service.Port)
+		}
+	}
+	w.Flush()
+
+	// The services DNS consumers could not look up, e.g. before
+	// enabling --dns-srv
+	problems := 0
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, cl := range clusters {
+		for _, p := range cl.leader.DNSReport() {
+			if problems == 0 {
+				fmt.Fprintln(w, "\nCLUSTER\tSERVICE\tID\tDNS PROBLEM")
+			}
+			problems++
+
+			problem := p.Problem
+			if p.Detail != "" {
+				problem += ": " + p.Detail
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cl.name, p.Name, p.ID, problem)
+		}
+	}
+	w.Flush()
+}
+
+// Remove every service and prepared query this instance owns from
+// Consul, e.g. when decommissioning it. Instances still syncing the
+// same --kv-prefix register the services again, so stop them first.
+func cleanup(args []string) {
+	c, err := parseFlags(args)
+	if err != nil {
+		fatal("Invalid options", "error", err)
+	}
+
+	registry, audit := newRegistry(c)
+	if audit != nil {
+		defer audit.Close()
+	}
+
+	failed := false
+	for _, cl := range newClusters(c, registry, audit) {
+		if err := cl.leader.Cleanup(context.Background()); err != nil {
+			hclog.L().Error("Cleanup failed", "cluster", cl.name, "error", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
 }
 
+// The registry of the instance, appending every Consul call to the
+// --audit-log file, which is returned too, when set
+func newRegistry(c *config.Config) (*consul.Consul, *os.File) {
+	hclog.L().Info("Using consul agent", "addr", c.ConsulAddr)
+	hclog.L().Info("Using registry port", "port", c.RegistryPort)
+	registry := consul.NewConsul(c)
+
+	if c.AuditLog == "" {
+		return registry, nil
+	}
+
+	audit := openLog(c.AuditLog)
+	hclog.L().Info("Writing audit log", "file", c.AuditLog)
+	registry.SetAuditLog(audit)
+
+	return registry, audit
+}
+
+// The clusters the instance syncs. With --cluster, every cluster syncs
+// through its own registry, cache and KV prefix.
+func newClusters(c *config.Config, registry *consul.Consul, audit *os.File) []cluster {
+	var clusters []cluster
+	if len(c.Clusters) == 0 {
+		hclog.L().Info("Using zookeeper", "zk", c.Zk)
+		clusters = append(clusters, cluster{"", mesos.New(c, registry), registry})
+	}
+	for _, cl := range c.Clusters {
+		cc := c.ForCluster(cl)
+		hclog.L().Info("Using zookeeper", "zk", cc.Zk, "cluster", cl.Name)
+
+		r := consul.NewConsul(cc)
+		if audit != nil {
+			r.SetAuditLog(audit)
+		}
+		clusters = append(clusters, cluster{cl.Name, mesos.New(cc, r), r})
+	}
+
+	return clusters
+}
+
+// The net/http/pprof profiles under /debug/pprof/ and the expvar
+// variables, the memory statistics and the number of goroutines among
+// them, under /debug/vars, see --debug-addr
+func debugHandler() http.Handler {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
+}
+
+// Open a log file for appending, exiting when it cannot be
+func openLog(path string) *os.File {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		fatal("Unable to open the log", "file", path, "error", err)
+	}
+
+	return f
+}
+
+// Log msg and its fields as an error and exit
+func fatal(msg string, args ...interface{}) {
+	hclog.L().Error(msg, args...)
+	os.Exit(1)
+}
+
+// Re-read the flags and configuration file, e.g. on SIGHUP, and apply
+// them to every cluster. The settings only read at startup keep their
+// value, but for a --vault-registry-token renewed in Vault. An invalid
+// configuration is logged and ignored.
+func reload(args []string, c *config.Config, registry *consul.Consul, clusters []cluster) *config.Config {
+	hclog.L().Info("Reloading the configuration")
+
+	n, err := parseFlags(args)
+	if err != nil {
+		hclog.L().Error("Not reloading", "error", err)
+		return nil
+	}
+
+	token := n.RegistryToken
+	if c.VaultRegistryToken != "" {
+		n.RegistryToken = c.RegistryToken
+	}
+
+	if changed := n.KeepRestartSettings(c); len(changed) > 0 {
+		hclog.L().Warn("Ignoring changes that need a restart", "options", changed)
+	}
+
+	if c.VaultRegistryToken != "" && token != c.RegistryToken {
+		hclog.L().Info("Using the registry token renewed in Vault")
+		registry.SetToken(token)
+		for _, cl := range clusters {
+			cl.registry.SetToken(token)
+		}
+		n.RegistryToken = token
+	}
+
+	// The certificate files keep their names, so the clients would
+	// keep presenting the one they were connected with
+	if c.VaultRegistrySSL != "" {
+		registry.Reconnect()
+		for _, cl := range clusters {
+			cl.registry.Reconnect()
+		}
+	}
+
+	for i, cl := range clusters {
+		if cl.name == "" {
+			cl.leader.Reload(n)
+		} else {
+			cl.leader.Reload(n.ForCluster(n.Clusters[i]))
+		}
+	}
+
+	return n
+}
+
+// A Mesos cluster synced by this instance, see --cluster. The name is
+// empty without --cluster.
+type cluster struct {
+	name     string
+	leader   *mesos.Mesos
+	registry *consul.Consul
+}
+
+// Send the metrics of the clusters to sink every interval, tagged with
+// their cluster with --cluster
+func emitMetrics(sink metrics.Sink, interval time.Duration, clusters []cluster) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var samples []metrics.Sample
+		for _, cl := range clusters {
+			for _, s := range cl.leader.Metrics() {
+				if cl.name != "" {
+					if s.Labels == nil {
+						s.Labels = make(map[string]string)
+					}
+					s.Labels["cluster"] = cl.name
+				}
+				samples = append(samples, s)
+			}
+		}
+
+		if err := sink.Emit(samples); err != nil {
+			hclog.L().Warn("Unable to send the metrics", "error", err)
+		}
+	}
+}
+
+// Sync the clusters concurrently and return the first error
+func refresh(clusters []cluster) error {
+	errs := make(chan error, len(clusters))
+	for _, cl := range clusters {
+		go func(leader *mesos.Mesos) {
+			errs <- leader.Refresh()
+		}(cl.leader)
+	}
+
+	var err error
+	for range clusters {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Serve the handler of each cluster, under /<name>/ with --cluster
+func clusterHandler(clusters []cluster, handler func(*mesos.Mesos) http.Handler) http.Handler {
+	if len(clusters) == 1 && clusters[0].name == "" {
+		return handler(clusters[0].leader)
+	}
+
+	mux := http.NewServeMux()
+	for _, cl := range clusters {
+		prefix := "/" + cl.name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, handler(cl.leader)))
+	}
+
+	return mux
+}
+
 func parseFlags(args []string) (*config.Config, error) {
 	var doHelp bool
+	var configFile string
+	var addressPriority []string
+	var taskIPSource string
 	var c = config.DefaultConfig()
 
 	flags := flag.NewFlagSet("mesos-consul", flag.ContinueOnError)
@@ -43,16 +566,182 @@ func parseFlags(args []string) (*config.Config, error) {
 		fmt.Print(usage)
 	}
 
+	flags.StringVar(&c.DebugAddr,		"debug-addr", "", "")
+	flags.StringVar(&c.HealthAddr,		"health-addr", "", "")
+	flags.DurationVar(&c.HealthStaleness,	"health-staleness", 0, "")
+	flags.StringVar(&c.HeartbeatService,	"heartbeat-service", "", "")
+	flags.DurationVar(&c.HeartbeatTTL,	"heartbeat-ttl", 0, "")
+	flags.StringVar(&c.HookExec,		"hook-exec", "", "")
+	flags.StringVar(&c.HookSlack,		"hook-slack", "", "")
+	flags.StringVar(&c.HookWebhook,		"hook-webhook", "", "")
 	flags.BoolVar(&doHelp,			"help", false, "")
+	flags.StringVar(&c.AddressLabel,		"address-label", c.AddressLabel, "")
+	flags.StringVar(&c.AddressMapFile,	"address-map-file", "", "")
+	flags.StringVar(&c.AddressTranslator,	"address-translator", "", "")
+	flags.StringVar(&c.AdminAddr,		"admin-addr", "", "")
+	flags.StringVar(&c.AdminToken,		"admin-token", "", "")
+	flags.BoolVar(&c.AdminUI,		"admin-ui", false, "")
+	flags.Var((*config.StringsVar)(&addressPriority),	"address-priority", "")
+	flags.BoolVar(&c.AdaptiveCheckInterval,	"adaptive-check-interval", false, "")
+	flags.IntVar(&c.AgentConcurrency,	"agent-concurrency", c.AgentConcurrency, "")
+	flags.BoolVar(&c.AgentContainers,	"agent-containers", false, "")
+	flags.BoolVar(&c.AgentState,		"agent-state", false, "")
+	flags.DurationVar(&c.AgentTimeout,	"agent-timeout", c.AgentTimeout, "")
+	flags.BoolVar(&c.AggregateHealth,	"aggregate-health", false, "")
+	flags.StringVar(&c.AuditLog,		"audit-log", "", "")
+	flags.BoolVar(&c.BlueGreen,		"blue-green", false, "")
+	flags.BoolVar(&c.BlueGreenLive,		"blue-green-live", false, "")
+	flags.BoolVar(&c.CacheWatch,		"cache-watch", false, "")
+	flags.StringVar(&c.ChangeLog,		"change-log", "", "")
+	flags.IntVar(&c.ChangeLogKV,		"change-log-kv", 0, "")
+	flags.BoolVar(&c.CleanupOrphans,	"cleanup-orphans", false, "")
+	flags.Var((*config.ClusterVar)(&c.Clusters),	"cluster", "")
+	flags.BoolVar(&c.CheckContext,		"check-context", false, "")
+	flags.IntVar(&c.CheckIntervalInstances,	"check-interval-instances", 0, "")
+	flags.DurationVar(&c.CheckIntervalMax,	"check-interval-max", c.CheckIntervalMax, "")
+	flags.DurationVar(&c.CheckIntervalMin,	"check-interval-min", c.CheckIntervalMin, "")
+	flags.StringVar(&c.CheckMode,		"check-mode", c.CheckMode, "")
+	flags.BoolVar(&c.CheckOverrides,	"check-overrides", false, "")
+	flags.StringVar(&configFile,		"config-file", "", "")
+	flags.BoolVar(&c.ConfirmDeregister,	"confirm-deregister", false, "")
+	flags.StringVar(&c.ConsulAddr,		"consul-addr", c.ConsulAddr, "")
+	flags.StringVar(&c.ConsulAddressMode,	"consul-address-mode", c.ConsulAddressMode, "")
+	flags.StringVar(&c.ConsulAgentAttribute,	"consul-agent-attribute", "", "")
+	flags.BoolVar(&c.ConsulAllowStale,	"consul-allow-stale", false, "")
+	flags.StringVar(&c.ConsulNamespace,	"consul-namespace", "", "")
+	flags.StringVar(&c.ConsulPartition,	"consul-partition", "", "")
+	flags.DurationVar(&c.ConsulTimeout,	"consul-timeout", c.ConsulTimeout, "")
+	flags.DurationVar(&c.DeregisterCriticalAfter,	"deregister-critical-after", 0, "")
+	flags.StringVar(&c.DefaultCheck,	"default-check", c.DefaultCheck, "")
+	flags.IntVar(&c.DeregisterDelay,	"deregister-delay", c.DeregisterDelay, "")
+	flags.BoolVar(&c.DeregisterOnShutdown,	"deregister-on-shutdown", false, "")
+	flags.BoolVar(&c.DNSSRV,		"dns-srv", false, "")
+	flags.BoolVar(&c.DryRun,		"dry-run", false, "")
+	flags.StringVar(&c.EventName,		"consul-event-name", c.EventName, "")
+	flags.BoolVar(&c.EmitEvents,		"emit-consul-events", false, "")
+	flags.BoolVar(&c.EnableTagOverride,	"enable-tag-override", false, "")
+	flags.BoolVar(&c.ExportServices,	"export-services", false, "")
+	flags.BoolVar(&c.ExportState,		"export-state", false, "")
+	flags.DurationVar(&c.FlapBackoff,	"flap-backoff", c.FlapBackoff, "")
+	flags.IntVar(&c.FlapThreshold,	"flap-threshold", 0, "")
+	flags.DurationVar(&c.FlapWindow,	"flap-window", c.FlapWindow, "")
+	flags.Var((*config.StringsVar)(&c.FollowerAttributes),	"follower-attributes", "")
+	flags.BoolVar(&c.FollowerCapabilities,	"follower-capabilities", false, "")
+	flags.Var((*config.StringsVar)(&c.FollowerResources),	"follower-resources", "")
+	flags.Var((*config.StringsVar)(&c.FollowerRoles),	"follower-role-filter", "")
+	flags.Var((*config.StringsVar)(&c.FollowerTags),	"follower-tags", "")
+	flags.StringVar(&c.InstanceID,		"instance-id", "", "")
+	flags.BoolVar(&c.InstanceTags,		"instance-tags", false, "")
+	flags.StringVar(&c.KVPrefix,		"kv-prefix", c.KVPrefix, "")
+	flags.StringVar(&c.LimitPolicy,		"limit-policy", c.LimitPolicy, "")
+	flags.StringVar(&c.Lock,		"lock", "", "")
+	flags.DurationVar(&c.LostTaskGrace,	"lost-task-grace", 0, "")
+	flags.StringVar(&c.FrameworkUISuffix,	"framework-ui-suffix", c.FrameworkUISuffix, "")
+	flags.BoolVar(&c.FreezeOnFailure,	"freeze-on-failure", false, "")
+	flags.StringVar(&c.FromFile,		"from-file", "", "")
+	flags.StringVar(&c.FwBlacklist,		"fw-blacklist", "", "")
+	flags.StringVar(&c.FwWhitelist,		"fw-whitelist", "", "")
+	flags.StringVar(&c.LogFormat,		"log-format", c.LogFormat, "")
 	flags.StringVar(&c.LogLevel,		"log-level", "WARN", "")
+	flags.StringVar(&c.MesosAPI,		"mesos-api", c.MesosAPI, "")
+	flags.DurationVar(&c.MesosCheckDeregisterCriticalAfter,	"mesos-check-deregister-critical-after", 0, "")
+	flags.DurationVar(&c.MesosCheckInterval,	"mesos-check-interval", c.MesosCheckInterval, "")
+	flags.DurationVar(&c.MesosCheckTimeout,	"mesos-check-timeout", 0, "")
+	flags.StringVar(&c.MesosCredentialFile,	"mesos-credential-file", "", "")
+	flags.IntVar(&c.MesosRetries,		"mesos-retries", c.MesosRetries, "")
+	flags.BoolVar(&c.MesosSSL.Enabled,	"mesos-ssl", c.MesosSSL.Enabled, "")
+	flags.BoolVar(&c.MesosSSL.Verify,	"mesos-ssl-verify", c.MesosSSL.Verify, "")
+	flags.StringVar(&c.MesosSSL.Cert,	"mesos-ssl-cert", c.MesosSSL.Cert, "")
+	flags.StringVar(&c.MesosSSL.Key,	"mesos-ssl-key", c.MesosSSL.Key, "")
+	flags.StringVar(&c.MesosSSL.CaCert,	"mesos-ssl-cacert", c.MesosSSL.CaCert, "")
+	flags.StringVar(&c.MesosStateAPI,	"mesos-state-api", c.MesosStateAPI, "")
+	flags.BoolVar(&c.MesosStateBackoff,	"mesos-state-backoff", false, "")
+	flags.DurationVar(&c.MesosStateJitter,	"mesos-state-jitter", 0, "")
+	flags.DurationVar(&c.MesosStateMaxAge,	"mesos-state-max-age", 0, "")
+	flags.Var((*config.StringsVar)(&c.MesosStateReplicas),	"mesos-state-replica", "")
+	flags.DurationVar(&c.MesosTimeout,	"mesos-timeout", c.MesosTimeout, "")
+	flags.StringVar(&c.MesosPassword,	"mesos-password", "", "")
+	flags.StringVar(&c.MesosUser,		"mesos-user", "", "")
+	flags.StringVar(&c.MetricsAddr,		"metrics-addr", "", "")
+	flags.Var((*config.StringsVar)(&c.MasterTags),	"master-tags", "")
+	flags.IntVar(&c.MaxCacheEntries,	"max-cache-entries", 0, "")
+	flags.StringVar(&c.CacheEviction,	"cache-eviction", c.CacheEviction, "")
+	flags.IntVar(&c.MaxDeregisterPercent,	"max-deregister-percent", 0, "")
+	flags.IntVar(&c.MinHealthyBeforeDrain,	"min-healthy-before-drain", 0, "")
+	flags.StringVar(&c.MirrorDatacenter,	"mirror-datacenter", "", "")
+	flags.StringVar(&c.MirrorServices,	"mirror-services", "", "")
+	flags.Var((*config.StringsVar)(&c.MirrorTags),	"mirror-tags", "")
+	flags.StringVar(&c.NameCollisionPolicy,	"name-collision-policy", c.NameCollisionPolicy, "")
+	flags.Var((*config.MapVar)(&c.NamingStrategies),	"naming-strategy", "")
+	flags.IntVar(&c.NamespaceDepth,		"namespace-from-group", 0, "")
+	flags.StringVar(&c.NoCheckServices,	"no-check-services", "", "")
+	flags.BoolVar(&c.NoDefaultTags,		"no-default-tags", false, "")
+	flags.BoolVar(&c.Once,			"once", false, "")
+	flags.StringVar(&c.OTLPEndpoint,	"otlp-endpoint", "", "")
+	flags.Var((*config.MapVar)(&c.OTLPHeaders),	"otlp-headers", "")
+	flags.BoolVar(&c.PidParseStrict,	"pid-parse-strict", false, "")
+	flags.StringVar(&c.PortCollisionPolicy,	"port-collision-policy", c.PortCollisionPolicy, "")
+	flags.BoolVar(&c.PreparedQueries,	"prepared-queries", false, "")
+	flags.StringVar(&c.PreserveTags,	"preserve-tags", "", "")
+	flags.Var((*config.StringsVar)(&c.QueryFailoverDatacenters),	"query-failover-datacenters", "")
+	flags.IntVar(&c.QueryFailoverNearest,	"query-failover-nearest", 0, "")
+	flags.DurationVar(&c.ReconcileInterval,	"reconcile-interval", 0, "")
 	flags.DurationVar(&c.Refresh,		"refresh", time.Minute, "")
+	flags.BoolVar(&c.RegisterFrameworkUIs,	"register-framework-uis", false, "")
+	flags.BoolVar(&c.RegisterMesosEndpoints,	"register-mesos-endpoints", false, "")
+	flags.BoolVar(&c.RegisterZookeeper,	"register-zookeeper", false, "")
+	flags.StringVar(&c.RegistrationAPI,	"registration-api", c.RegistrationAPI, "")
 	flags.StringVar(&c.RegistryPort,	"registry-port", "8500", "")
 	flags.Var((*config.AuthVar)(c.RegistryAuth),	"registry-auth", "")
+	flags.IntVar(&c.RegistryConcurrency,	"registry-concurrency", c.RegistryConcurrency, "")
+	flags.Float64Var(&c.RegistryRate,	"registry-rate", 0, "")
+	flags.IntVar(&c.RegistryRetries,	"registry-retries", c.RegistryRetries, "")
 	flags.BoolVar(&c.RegistrySSL.Enabled,	"registry-ssl", c.RegistrySSL.Enabled, "")
 	flags.BoolVar(&c.RegistrySSL.Verify,	"registry-ssl-verify", c.RegistrySSL.Verify, "")
 	flags.StringVar(&c.RegistrySSL.Cert,	"registry-ssl-cert", c.RegistrySSL.Cert, "")
+	flags.StringVar(&c.RegistrySSL.Key,	"registry-ssl-key", c.RegistrySSL.Key, "")
 	flags.StringVar(&c.RegistrySSL.CaCert,	"registry-ssl-cacert", c.RegistrySSL.CaCert, "")
 	flags.StringVar(&c.RegistryToken,		"registry-token", c.RegistryToken, "")
+	flags.StringVar(&c.RegistryTokenDir,	"registry-token-dir", "", "")
+	flags.BoolVar(&c.RequireHealthy,	"require-healthy", false, "")
+	flags.StringVar(&c.RoleBlacklist,	"role-blacklist", "", "")
+	flags.StringVar(&c.RoleWhitelist,	"role-whitelist", "", "")
+	flags.StringVar(&c.SandboxURL,		"sandbox-url", "", "")
+	flags.BoolVar(&c.ServiceControls,	"service-controls", false, "")
+	flags.StringVar(&c.ServiceFilesDir,	"service-files-dir", "", "")
+	flags.StringVar(&c.ServiceFilesReload,	"service-files-reload", c.ServiceFilesReload, "")
+	flags.StringVar(&c.ServiceIDScheme,	"service-id-scheme", c.ServiceIDScheme, "")
+	flags.StringVar(&c.ServiceIDTemplate,	"service-id-template", "", "")
+	flags.StringVar(&c.ServiceNameSeparator,	"service-name-separator", "", "")
+	flags.StringVar(&c.ServicePrefix,	"service-prefix", "", "")
+	flags.StringVar(&c.ServiceSuffix,	"service-suffix", "", "")
+	flags.StringVar(&c.ServiceTagsTemplate,	"service-tags-template", "", "")
+	flags.StringVar(&c.ServiceWeights,	"service-weights", c.ServiceWeights, "")
+	flags.BoolVar(&c.SkipUnchanged,	"skip-unchanged", false, "")
+	flags.DurationVar(&c.StateRefresh,	"state-refresh", 0, "")
+	flags.StringVar(&c.StatsdAddr,		"statsd-addr", "", "")
+	flags.StringVar(&c.StatsdFormat,	"statsd-format", c.StatsdFormat, "")
+	flags.DurationVar(&c.StatsdInterval,	"statsd-interval", c.StatsdInterval, "")
+	flags.Var((*config.StringsVar)(&c.StatsdTags),	"statsd-tags", "")
+	flags.BoolVar(&c.StripMarathonGroups,	"strip-marathon-groups", false, "")
+	flags.BoolVar(&c.SyncMaintenance,	"sync-maintenance", false, "")
+	flags.StringVar(&c.SyncOrder,		"sync-order", c.SyncOrder, "")
+	flags.StringVar(&c.TagLabelPrefix,	"tag-label-prefix", "", "")
+	flags.StringVar(&c.TaskAgent,		"task-agent", c.TaskAgent, "")
+	flags.StringVar(&taskIPSource,		"task-ip-source", "", "")
+	flags.StringVar(&c.TaskBlacklist,	"task-blacklist", "", "")
+	flags.StringVar(&c.TaskWhitelist,	"task-whitelist", "", "")
+	flags.StringVar(&c.TransformPlugin,	"transform-plugin", "", "")
+	flags.DurationVar(&c.TransformPluginTimeout,	"transform-plugin-timeout", c.TransformPluginTimeout, "")
+	flags.StringVar(&c.VaultAddr,		"vault-addr", os.Getenv("VAULT_ADDR"), "")
+	flags.StringVar(&c.VaultMesosCredential,	"vault-mesos-credential", "", "")
+	flags.StringVar(&c.VaultMesosSSL,	"vault-mesos-ssl", "", "")
+	flags.DurationVar(&c.VaultRefresh,	"vault-refresh", c.VaultRefresh, "")
+	flags.StringVar(&c.VaultRegistrySSL,	"vault-registry-ssl", "", "")
+	flags.StringVar(&c.VaultRegistryToken,	"vault-registry-token", "", "")
+	flags.StringVar(&c.VaultTokenFile,	"vault-token-file", "", "")
+	flags.Var((*config.MapVar)(&c.WanAddressMap),	"wan-address-map", "")
+	flags.BoolVar(&c.WatchServices,		"watch-services", false, "")
 	flags.StringVar(&c.Zk,			"zk", "zk://127.0.0.1:2181/mesos", "")
 
 	if err := flags.Parse(args); err != nil {
@@ -69,34 +758,911 @@ func parseFlags(args []string) (*config.Config, error) {
 		os.Exit(0)
 	}
 
-	logging.Setup(&logging.Config{
-		Name:		"mesos-consul",
-		Level:		c.LogLevel,
-		Writer:		os.Stderr,
-		})
+	// The command line takes precedence over the configuration file
+	if configFile != "" {
+		settings, err := config.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config-file: %s", err)
+		}
+
+		set := make(map[string]bool)
+		flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		for _, setting := range settings {
+			if set[setting.Name] || setting.Name == "config-file" {
+				continue
+			}
+			for _, value := range setting.Values {
+				if err := flags.Set(setting.Name, value); err != nil {
+					return nil, fmt.Errorf("invalid %s in config-file: %s", setting.Name, err)
+				}
+			}
+		}
+	}
+
+	// Allow the scheduler to inject the agent address, e.g. $HOST:8500
+	c.ConsulAddr = os.ExpandEnv(c.ConsulAddr)
+
+	if c.VaultMesosCredential != "" && (c.MesosCredentialFile != "" || c.MesosUser != "") {
+		return nil, fmt.Errorf("vault-mesos-credential cannot be combined with mesos-credential-file or mesos-user")
+	}
+
+	if c.MesosCredentialFile != "" && c.MesosUser == "" {
+		user, password, err := config.ReadCredential(c.MesosCredentialFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mesos-credential-file: %s", err)
+		}
+		c.MesosUser, c.MesosPassword = user, password
+	}
+
+	if c.VaultRegistryToken != "" && c.RegistryToken != "" {
+		return nil, fmt.Errorf("vault-registry-token cannot be combined with registry-token")
+	}
+
+	if c.VaultRegistrySSL != "" && (c.RegistrySSL.Cert != "" || c.RegistrySSL.Key != "") {
+		return nil, fmt.Errorf("vault-registry-ssl cannot be combined with registry-ssl-cert or registry-ssl-key")
+	}
+
+	if c.VaultMesosSSL != "" && (c.MesosSSL.Cert != "" || c.MesosSSL.Key != "") {
+		return nil, fmt.Errorf("vault-mesos-ssl cannot be combined with mesos-ssl-cert or mesos-ssl-key")
+	}
+
+	if c.VaultRefresh <= 0 {
+		return nil, fmt.Errorf("invalid vault-refresh: %s", c.VaultRefresh)
+	}
+
+	if err := readVault(c); err != nil {
+		return nil, fmt.Errorf("invalid vault secrets: %s", err)
+	}
+
+	if taskIPSource != "" {
+		if len(addressPriority) > 0 {
+			return nil, fmt.Errorf("task-ip-source cannot be combined with address-priority")
+		}
+
+		sources, ok := config.TaskIPSources[taskIPSource]
+		if !ok {
+			return nil, fmt.Errorf("invalid task-ip-source: %q", taskIPSource)
+		}
+		addressPriority = sources
+	}
+
+	if len(addressPriority) > 0 {
+		c.AddressPriority = addressPriority
+	}
+
+	for _, source := range c.AddressPriority {
+		switch source {
+		case config.AddressContainer, config.AddressDocker, config.AddressLabel, config.AddressSlave, config.AddressHostname:
+		default:
+			return nil, fmt.Errorf("invalid address-priority source: %q", source)
+		}
+	}
+
+	if _, err := regexp.Compile(c.NoCheckServices); err != nil {
+		return nil, fmt.Errorf("invalid no-check-services: %s", err)
+	}
+
+	if _, err := regexp.Compile(c.MirrorServices); err != nil {
+		return nil, fmt.Errorf("invalid mirror-services: %s", err)
+	}
+	if c.MirrorDatacenter == "" && (c.MirrorServices != "" || len(c.MirrorTags) > 0) {
+		return nil, fmt.Errorf("mirror-services and mirror-tags need mirror-datacenter")
+	}
+
+	switch c.StatsdFormat {
+	case config.StatsdFormatDogStatsd, config.StatsdFormatStatsd:
+	default:
+		return nil, fmt.Errorf("invalid statsd-format: %q", c.StatsdFormat)
+	}
+	if c.StatsdInterval <= 0 {
+		return nil, fmt.Errorf("invalid statsd-interval: %s", c.StatsdInterval)
+	}
+	if len(c.StatsdTags) > 0 && c.StatsdFormat != config.StatsdFormatDogStatsd {
+		return nil, fmt.Errorf("statsd-tags needs statsd-format=dogstatsd")
+	}
+
+	for name, expr := range map[string]string{
+		"fw-blacklist":		c.FwBlacklist,
+		"fw-whitelist":		c.FwWhitelist,
+		"task-blacklist":	c.TaskBlacklist,
+		"task-whitelist":	c.TaskWhitelist,
+		"role-blacklist":	c.RoleBlacklist,
+		"role-whitelist":	c.RoleWhitelist,
+	} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, err)
+		}
+	}
+
+	if _, err := template.New("service-tags").Parse(c.ServiceTagsTemplate); err != nil {
+		return nil, fmt.Errorf("invalid service-tags-template: %s", err)
+	}
+
+	switch c.ServiceIDScheme {
+	case config.IDSchemeHost:
+	case config.IDSchemeHash:
+		if c.ServiceIDTemplate != "" {
+			return nil, fmt.Errorf("service-id-scheme=hash cannot be combined with service-id-template")
+		}
+		c.ServiceIDTemplate = mesos.HashIDTemplate
+	default:
+		return nil, fmt.Errorf("invalid service-id-scheme: %q", c.ServiceIDScheme)
+	}
+
+	if _, err := template.New("service-id").Funcs(mesos.ServiceIDFuncs).Parse(c.ServiceIDTemplate); err != nil {
+		return nil, fmt.Errorf("invalid service-id-template: %s", err)
+	}
+
+	for _, addr := range strings.Split(c.ConsulAddr, ",") {
+		if strings.TrimSpace(addr) == "" {
+			return nil, fmt.Errorf("invalid consul-addr: %q", c.ConsulAddr)
+		}
+	}
+
+	switch c.ConsulAddressMode {
+	case config.AddressModeAuto, config.AddressModeHostname, config.AddressModeIP:
+	default:
+		return nil, fmt.Errorf("invalid consul-address-mode: %q", c.ConsulAddressMode)
+	}
+
+	switch c.NameCollisionPolicy {
+	case config.NameCollisionMerge, config.NameCollisionSuffix:
+	default:
+		return nil, fmt.Errorf("invalid name-collision-policy: %q", c.NameCollisionPolicy)
+	}
+
+	for framework, strategy := range c.NamingStrategies {
+		switch strategy {
+		case config.NamingCassandra, config.NamingChronos, config.NamingGeneric, config.NamingKafka, config.NamingMarathon:
+		default:
+			return nil, fmt.Errorf("invalid naming-strategy of %s: %q", framework, strategy)
+		}
+	}
+
+	if !nameSeparator.MatchString(c.ServiceNameSeparator) {
+		return nil, fmt.Errorf("invalid service-name-separator: %q", c.ServiceNameSeparator)
+	}
+
+	if !nameAffix.MatchString(c.ServicePrefix) {
+		return nil, fmt.Errorf("invalid service-prefix: %q", c.ServicePrefix)
+	}
+	if !nameAffix.MatchString(c.ServiceSuffix) {
+		return nil, fmt.Errorf("invalid service-suffix: %q", c.ServiceSuffix)
+	}
+
+	switch c.PortCollisionPolicy {
+	case config.CollisionAll, config.CollisionFirst, config.CollisionSkip:
+	default:
+		return nil, fmt.Errorf("invalid port-collision-policy: %q", c.PortCollisionPolicy)
+	}
+
+	if c.AgentConcurrency < 1 {
+		return nil, fmt.Errorf("invalid agent-concurrency: %d", c.AgentConcurrency)
+	}
+
+	if c.ChangeLogKV < 0 {
+		return nil, fmt.Errorf("invalid change-log-kv: %d", c.ChangeLogKV)
+	}
+
+	if c.CheckIntervalInstances < 0 {
+		return nil, fmt.Errorf("invalid check-interval-instances: %d", c.CheckIntervalInstances)
+	}
+
+	if c.CheckIntervalMin <= 0 || c.CheckIntervalMax < c.CheckIntervalMin {
+		return nil, fmt.Errorf("invalid check intervals: min %s, max %s", c.CheckIntervalMin, c.CheckIntervalMax)
+	}
+
+	switch c.CheckMode {
+	case config.CheckModeAgent, config.CheckModeMesos, config.CheckModeTTL:
+	default:
+		return nil, fmt.Errorf("invalid check-mode: %q", c.CheckMode)
+	}
+
+	switch c.DefaultCheck {
+	case config.DefaultCheckHTTP, config.DefaultCheckNone, config.DefaultCheckTCP:
+	default:
+		return nil, fmt.Errorf("invalid default-check: %q", c.DefaultCheck)
+	}
+
+	switch c.MesosAPI {
+	case config.MesosAPIPoll, config.MesosAPIEvents:
+	default:
+		return nil, fmt.Errorf("invalid mesos-api: %q", c.MesosAPI)
+	}
+
+	switch c.CacheEviction {
+	case config.CacheEvictionDrop, config.CacheEvictionLRU:
+	default:
+		return nil, fmt.Errorf("invalid cache-eviction: %q", c.CacheEviction)
+	}
+
+	if c.AdminUI && c.AdminAddr == "" {
+		return nil, fmt.Errorf("invalid admin-ui: requires --admin-addr")
+	}
+
+	if c.AdminToken != "" && c.AdminAddr == "" {
+		return nil, fmt.Errorf("invalid admin-token: requires --admin-addr")
+	}
+
+	if c.BlueGreenLive && !c.BlueGreen {
+		return nil, fmt.Errorf("invalid blue-green-live: requires --blue-green")
+	}
+
+	if c.FromFile != "" && (len(c.Clusters) > 0 || c.MesosAPI == config.MesosAPIEvents) {
+		return nil, fmt.Errorf("invalid from-file: not supported with --cluster or --mesos-api=events")
+	}
+
+	switch c.MesosStateAPI {
+	case config.MesosStateAuto, config.MesosStateJSON, config.MesosStateHTTP, config.MesosStateV1:
+	default:
+		return nil, fmt.Errorf("invalid mesos-state-api: %q", c.MesosStateAPI)
+	}
+
+	switch c.ServiceWeights {
+	case config.WeightsNone, config.WeightsCpus, config.WeightsMem:
+	default:
+		return nil, fmt.Errorf("invalid service-weights: %q", c.ServiceWeights)
+	}
+
+	switch c.SyncOrder {
+	case config.SyncRegisterFirst, config.SyncDeregisterFirst:
+	default:
+		return nil, fmt.Errorf("invalid sync-order: %q", c.SyncOrder)
+	}
+
+	switch c.LimitPolicy {
+	case config.LimitPolicyFail, config.LimitPolicyKV, config.LimitPolicyTruncate:
+	default:
+		return nil, fmt.Errorf("invalid limit-policy: %q", c.LimitPolicy)
+	}
+
+	switch c.LogFormat {
+	case config.LogFormatText, config.LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid log-format: %q", c.LogFormat)
+	}
+
+	level := hclog.LevelFromString(c.LogLevel)
+	if level == hclog.NoLevel {
+		return nil, fmt.Errorf("invalid log-level: %q", c.LogLevel)
+	}
+
+	switch c.RegistrationAPI {
+	case config.RegistrationAgent, config.RegistrationCatalog, config.RegistrationFiles:
+	default:
+		return nil, fmt.Errorf("invalid registration-api: %q", c.RegistrationAPI)
+	}
+
+	// TTL checks live on an agent, external nodes have none. Without
+	// the agent API, only the service files are written.
+	if c.RegistrationAPI != config.RegistrationAgent && c.CheckMode != config.CheckModeAgent {
+		return nil, fmt.Errorf("check-mode=%s needs registration-api=agent", c.CheckMode)
+	}
+	if c.RegistrationAPI != config.RegistrationAgent && c.SyncMaintenance {
+		return nil, fmt.Errorf("sync-maintenance needs registration-api=agent")
+	}
+	if c.RegistrationAPI == config.RegistrationFiles {
+		switch {
+		case c.ServiceFilesDir == "":
+			return nil, fmt.Errorf("registration-api=files needs service-files-dir")
+		case c.HeartbeatService != "":
+			return nil, fmt.Errorf("heartbeat-service cannot be combined with registration-api=files")
+		case c.AggregateHealth:
+			return nil, fmt.Errorf("aggregate-health cannot be combined with registration-api=files")
+		}
+	}
+	if c.RegistrationAPI == config.RegistrationCatalog && c.ServiceFilesDir != "" {
+		return nil, fmt.Errorf("service-files-dir needs registration-api=agent or files")
+	}
+	if c.RegistrationAPI == config.RegistrationCatalog && c.ConsulAgentAttribute != "" {
+		return nil, fmt.Errorf("consul-agent-attribute needs registration-api=agent")
+	}
+
+	switch c.TaskAgent {
+	case config.TaskAgentAddress, config.TaskAgentFollower:
+	default:
+		return nil, fmt.Errorf("invalid task-agent: %q", c.TaskAgent)
+	}
+
+	if c.HealthStaleness < 0 {
+		return nil, fmt.Errorf("invalid health-staleness: %s", c.HealthStaleness)
+	}
+
+	if c.HeartbeatTTL < 0 {
+		return nil, fmt.Errorf("invalid heartbeat-ttl: %s", c.HeartbeatTTL)
+	}
+	if c.HeartbeatTTL > 0 && c.HeartbeatService == "" {
+		return nil, fmt.Errorf("heartbeat-ttl needs heartbeat-service")
+	}
+
+	if c.QueryFailoverNearest < 0 {
+		return nil, fmt.Errorf("invalid query-failover-nearest: %d", c.QueryFailoverNearest)
+	}
+
+	if c.MaxDeregisterPercent < 0 || c.MaxDeregisterPercent > 100 {
+		return nil, fmt.Errorf("invalid max-deregister-percent: %d", c.MaxDeregisterPercent)
+	}
+
+	for flag, u := range map[string]string{"hook-slack": c.HookSlack, "hook-webhook": c.HookWebhook, "otlp-endpoint": c.OTLPEndpoint, "sandbox-url": c.SandboxURL} {
+		if u == "" {
+			continue
+		}
+		if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") {
+			return nil, fmt.Errorf("invalid %s: %q", flag, u)
+		}
+	}
+	for _, u := range c.MesosStateReplicas {
+		if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") {
+			return nil, fmt.Errorf("invalid mesos-state-replica: %q", u)
+		}
+	}
+
+	if c.MesosStateJitter < 0 {
+		return nil, fmt.Errorf("invalid mesos-state-jitter: %s", c.MesosStateJitter)
+	}
+	if c.MesosStateMaxAge < 0 {
+		return nil, fmt.Errorf("invalid mesos-state-max-age: %s", c.MesosStateMaxAge)
+	}
+
+	if c.DeregisterCriticalAfter < 0 {
+		return nil, fmt.Errorf("invalid deregister-critical-after: %s", c.DeregisterCriticalAfter)
+	}
+
+	if c.DeregisterDelay < 1 {
+		return nil, fmt.Errorf("invalid deregister-delay: %d", c.DeregisterDelay)
+	}
+
+	c.KVPrefix = strings.Trim(c.KVPrefix, "/")
+	if c.KVPrefix == "" {
+		return nil, fmt.Errorf("invalid kv-prefix: must not be empty")
+	}
+
+	// Instances sharing a cache own the same services
+	if c.InstanceID == "" {
+		c.InstanceID = c.KVPrefix
+	}
+
+	// A dry run holding the lock would stop the instance syncing
+	if c.DryRun && c.Lock != "" {
+		return nil, fmt.Errorf("dry-run cannot be combined with lock")
+	}
+
+	names := make(map[string]bool)
+	for _, cl := range c.Clusters {
+		if !clusterName.MatchString(cl.Name) || names[cl.Name] {
+			return nil, fmt.Errorf("invalid cluster name: %q", cl.Name)
+		}
+		names[cl.Name] = true
+	}
+
+	if c.RegistryConcurrency < 1 {
+		return nil, fmt.Errorf("invalid registry-concurrency: %d", c.RegistryConcurrency)
+	}
+
+	if c.LostTaskGrace < 0 {
+		return nil, fmt.Errorf("invalid lost-task-grace: %s", c.LostTaskGrace)
+	}
+
+	if c.ReconcileInterval < 0 {
+		return nil, fmt.Errorf("invalid reconcile-interval: %s", c.ReconcileInterval)
+	}
+
+	if c.TransformPluginTimeout <= 0 {
+		return nil, fmt.Errorf("invalid transform-plugin-timeout: %s", c.TransformPluginTimeout)
+	}
+
+	if c.FlapThreshold < 0 || c.FlapWindow <= 0 || c.FlapBackoff < 0 {
+		return nil, fmt.Errorf("invalid flap options: threshold %d, window %s, backoff %s", c.FlapThreshold, c.FlapWindow, c.FlapBackoff)
+	}
+
+	if c.MesosCheckInterval <= 0 || c.MesosCheckTimeout < 0 || c.MesosCheckDeregisterCriticalAfter < 0 {
+		return nil, fmt.Errorf("invalid mesos-check options: interval %s, timeout %s, deregister-critical-after %s",
+			c.MesosCheckInterval, c.MesosCheckTimeout, c.MesosCheckDeregisterCriticalAfter)
+	}
+
+	if c.MesosRetries < 0 {
+		return nil, fmt.Errorf("invalid mesos-retries: %d", c.MesosRetries)
+	}
+
+	if c.ConsulTimeout < 0 || c.MesosTimeout < 0 {
+		return nil, fmt.Errorf("invalid consul-timeout or mesos-timeout: %s, %s", c.ConsulTimeout, c.MesosTimeout)
+	}
+
+	if c.RegistryRate < 0 || c.RegistryRetries < 0 {
+		return nil, fmt.Errorf("invalid registry-rate or registry-retries: %g, %d", c.RegistryRate, c.RegistryRetries)
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:		Name,
+		Level:		level,
+		Output:		logOutput(),
+		JSONFormat:	c.LogFormat == config.LogFormatJSON,
+	})
+	hclog.SetDefault(logger)
+
+	// Route the log package through the logger too, taking the level
+	// from the [LEVEL] prefix of each line
+	log.SetFlags(0)
+	log.SetOutput(logger.StandardWriter(&hclog.StandardLoggerOptions{
+		InferLevels:	true,
+	}))
 
 	return c, nil
 }
 
 const usage = `
-Usage: mesos-consul [options]
+Usage: mesos-consul [command] [options]
+
+Commands:
+
+  run				Sync every --refresh (default)
+  sync				Sync once and exit, like run --once
+  validate			Check the options, then sync once as a dry run
+				and list the services that would be registered
+  cleanup			Deregister every service and delete every
+				prepared query this instance owns
+  help				Print this help
 
 Options:
 
+  --address-label=<key>		Task label holding the address used by
+				the "label" address source (default address)
+  --address-map-file=<path>	File of "<address> <registered address>"
+				lines, e.g. private agent IPs and their
+				elastic IPs, services are registered under
+  --address-translator=<command>
+				Command run with /bin/sh printing the address
+				to register in place of $MESOS_CONSUL_ADDRESS
+  --address-priority=<source[,source]>
+				Sources tried in order for a task's address,
+				from [ "container", "docker", "label",
+				"slave", "hostname" ] (default hostname)
+  --adaptive-check-interval	Start task checks at --check-interval-min and
+				back off towards --check-interval-max as the
+				task's uptime grows
+  --agent-concurrency=<n>	Agents --agent-state and --agent-containers
+				read at a time (default 16)
+  --agent-containers		Register the labelled ports of Docker
+				bridge-mode tasks as the host ports mapped to
+				them, read from the agents' /containers
+  --agent-state			Complete running tasks the masters report
+				without a container IP or executor from the
+				state of their agents
+  --agent-timeout=<time>	Timeout of reading an agent's state
+				(default 5s)
+  --admin-addr=<[host]:port>	Serve the admin API, /v1/services, /v1/cache,
+				/v1/health, /v1/checks and POST /v1/sync, on
+				this address (default disabled)
+  --admin-token=<token>		Require POST /v1/sync of the admin API to
+				send the token as "Authorization: Bearer
+				<token>". Reloaded on SIGHUP
+  --admin-ui			Serve a dashboard of the services, their
+				Consul checks and the last sync on /ui/ of
+				--admin-addr
+  --aggregate-health		Register a <name>-aggregate service per task
+				service whose TTL check passes while at least
+				one instance is running
+  --audit-log=<file>		Append a record of every Consul call to file
+  --blue-green			Register both colours of marathon-lb blue/green
+				deployments under their deployment group,
+				tagged with their colour
+  --blue-green-live		Only register the colour of a --blue-green
+				deployment named under <kv-prefix>/bluegreen/
+  --cache-eviction=<policy>	What happens to new services once the cache
+				holds --max-cache-entries, one of [ "drop",
+				"lru" ] (default drop)
+  --cache-watch			Watch the cache persisted in Consul KV and
+				merge in changes made by other instances
+  --change-log=<path>		Append the summary and changes of every sync
+				that changed Consul to this file
+  --change-log-kv=<n>		Keep the last n change records under
+				<kv-prefix>/changes/ (default disabled)
+  --check-context		Add the task ID, agent and latest Mesos
+				status of their task to the notes of the
+				checks of task services and to the output of
+				their TTL updates
+  --check-interval-instances=<n>	Double the check interval of a task service
+				for every doubling of its instances past n
+				(default disabled)
+  --check-interval-max=<time>	Longest adaptive or scaled check interval
+				(default 1m)
+  --check-interval-min=<time>	Shortest adaptive or scaled check interval
+				(default 5s)
+  --check-mode=<mode>		Who checks task services, one of [ "agent",
+				"mesos", "ttl" ]. With ttl, mesos-consul
+				reports the Mesos health of the tasks, with
+				mesos of those Mesos health checks only
+				(default agent)
+  --check-overrides		Replace the checks of services with the
+				overrides under <kv-prefix>/overrides/
+  --cleanup-orphans		On the first sync, and every --reconcile-interval,
+				deregister the services of this instance no
+				Mesos task backs, even with a cold cache
+  --cluster=<name;zk=<address>[;option=value...]>
+				Sync another Mesos cluster, repeatable. The
+				options are zk, consul (agent address),
+				datacenter and service-prefix (default the
+				--zk cluster only)
+  --config-file=<file>		Read flags from this HCL, JSON or YAML file,
+				keyed by their names. Flags on the command
+				line take precedence. Reloaded on SIGHUP
+  --confirm-deregister		Re-fetch the Mesos state and only deregister
+				services still missing from it
+  --consul-addr=<[scheme://]host:port[,...]>
+				Consul agent used by mesos-consul itself, or
+				a comma-separated list of agents failed over
+				in order. Environment variables are expanded
+				(default 127.0.0.1:8500)
+  --consul-address-mode=<mode>	Addresses services are registered under, one
+				of [ "auto", "ip", "hostname" ] (default auto)
+  --consul-agent-attribute=<name>
+				Register task services with the Consul agent
+				named by this attribute of their Mesos agent,
+				e.g. consul_agent=10.1.2.3:8501
+  --consul-allow-stale		Let any Consul server answer the reads loading
+				the cache and reconciling, not only the leader
+  --consul-event-name=<name>	Name of the events fired by --emit-consul-events
+				(default mesos-consul)
+  --consul-namespace=<name>	Consul Enterprise namespace of the services and
+				KV keys (default the token's)
+  --consul-partition=<name>	Consul Enterprise admin partition of the
+				services and KV keys (default the token's)
+  --consul-timeout=<duration>	Give up on a Consul call after this long, plus
+				the wait of blocking queries (default 10s, 0
+				never)
+  --debug-addr=<[host]:port>	Serve the Go profiles on /debug/pprof/ and
+				the runtime variables on /debug/vars on this
+				address (default disabled)
+  --default-check=<kind>	Check of the task services without check
+				labels, one of [ "tcp", "http", "none" ]
+				(default none)
+  --deregister-critical-after=<duration>
+				Have Consul deregister task services whose
+				check stayed critical this long (default 0,
+				never)
+  --deregister-delay=<n>	Consecutive syncs a service must be missing
+				from before it is deregistered (default 1)
+  --deregister-on-shutdown	Deregister every service this instance
+				registered when receiving SIGTERM or SIGINT
+  --dns-srv			Register the names and tags of services as DNS
+				labels, for consumers of Consul DNS and its
+				SRV records
+  --dry-run			Sync as usual but only log the registrations,
+				deregistrations and other Consul writes
+				instead of making them
+  --emit-consul-events		Fire a Consul user event whenever a service is
+				registered or deregistered
+  --enable-tag-override		Let other tooling change the tags of task
+				services without the Consul agents reverting
+				them
+  --export-services		Write a JSON document per task service under
+				<kv-prefix>/services/<name>/<id>
+  --export-state		Write the masters, agents and frameworks of
+				the cluster under <kv-prefix>/state/
+  --flap-backoff=<time>		Hold off the new registrations of a flapping
+				app this long after its last flap
+				(default 5m)
+  --flap-threshold=<n>		Tag the task services of an app flapping, and
+				hold off its new registrations, once its
+				services were deregistered or relaunched this
+				many times within --flap-window of their
+				registration (default 0, disabled)
+  --flap-window=<time>		A task service ending this soon after its
+				registration flaps (default 10m)
+  --follower-attributes=<attribute[,attribute]>
+				Attributes of the Mesos agents, e.g. rack or
+				zone, to tag and describe the task services
+				running on them with
+  --follower-capabilities	Tag the follower services with the
+				capabilities of their agent
+  --follower-resources=<resource[,resource]>
+				Resources of the Mesos agents, e.g. gpus, or
+				* for all, to tag and describe the follower
+				services with
+  --follower-role-filter=<role[,role]>
+				Only register followers whose "role" attribute
+				matches one of the roles (default all followers)
+  --follower-tags=<tag[,tag]>	Extra tags of the follower services
+  --framework-ui-suffix=<suffix>
+				Suffix of the --register-framework-uis service
+				names, empty to name them after the framework
+				(default -ui)
+  --freeze-on-failure		When the Mesos state cannot be read, register
+				the services of the last good one again and
+				deregister none
+  --from-file=<path>		Sync the Mesos state recorded in this file,
+				or replay the *.json snapshots of this
+				directory in order, one per sync, instead of
+				reading the masters found in --zk
+  --fw-blacklist=<regexp>	Do not sync the frameworks whose name matches
+  --fw-whitelist=<regexp>	Only sync the frameworks whose name matches
+				(default all frameworks)
+  --health-addr=<[host]:port>	Serve /health, /health/mesos and /health/consul
+				on this address (default disabled)
+  --health-staleness=<time>	How long /health stays OK after a successful
+				sync (default 3 times --refresh)
+  --heartbeat-service=<name>	Register mesos-consul itself under this service
+				name, with a TTL check every sync reports to
+				(default disabled)
+  --heartbeat-ttl=<time>	TTL of the check of --heartbeat-service
+				(default 3 times --refresh)
+  --hook-exec=<command>		Run command with /bin/sh on every registration
+				and deregistration
+  --hook-slack=<url>		Post a message to this Slack incoming webhook
+				on every registration and deregistration
+  --hook-webhook=<url>		POST every registration and deregistration as
+				JSON to url
+  --instance-id=<id>		Owner the services are marked with, see
+				--cleanup-orphans (default --kv-prefix)
+  --instance-tags		Number the tasks of every service, tagging
+				their services instance-<n>
+  --kv-prefix=<prefix>		KV prefix of the keys mesos-consul writes,
+				e.g. the service cache under <prefix>/cache/
+				(default mesos-consul)
+  --limit-policy=<policy>	Handling of the registrations over Consul's
+				limits on tags and meta, one of
+				[ "fail", "truncate", "kv" ] (default fail)
+  --lock=<key>			Only sync while holding a Consul session lock
+				on key, e.g. mesos-consul/leader, so several
+				instances can run as standbys (default
+				disabled)
+  --lost-task-grace=<time>	Keep the services of lost or unreachable tasks
+				registered with a critical check for this
+				long, in case their agent comes back
+				(default disabled)
+  --log-format=<format>		Format of the log, one of [ "text", "json" ]
+				(default text)
   --log-level=<log_level>	Set the Logging level to one of [ "DEBUG", "INFO", "WARN", "ERROR" ]
 				(default "WARN")
+  --master-tags=<tag[,tag]>	Extra tags of the master services
+  --max-cache-entries=<n>	Stop registering new services once n are
+				cached (default 0, unlimited)
+  --max-deregister-percent=<percent>
+				Skip the deregistrations of a sync that
+				would remove more than this share of the
+				cached services (default 0, no limit)
+  --mesos-api=<api>		How to follow Mesos, one of [ "poll",
+				"events" ] (default poll)
+  --mesos-check-deregister-critical-after=<duration>
+				Have Consul deregister masters and followers
+				whose check stayed critical this long
+				(default 0, never)
+  --mesos-check-interval=<duration>
+				Interval of the master and follower checks
+				(default 10s)
+  --mesos-check-timeout=<duration>
+				Timeout of the master and follower checks
+				(default 0, Consul's default)
+  --mesos-credential-file=<file>
+				Mesos credential file holding the user and
+				password, as JSON or "principal secret"
+  --mesos-password=<password>	Password of --mesos-user
+  --mesos-retries=<n>		Retries of a state fetch failing with a
+				transient error (default 2)
+  --mesos-ssl			Use HTTPS when connecting to the Mesos masters
+				and checking the followers' health
+  --mesos-ssl-verify		Verify the certificates of the Mesos masters and
+				followers (default true)
+  --mesos-ssl-cert		SSL certificate to send to the Mesos masters
+  --mesos-ssl-key		Private key of --mesos-ssl-cert
+  --mesos-ssl-cacert		Validate the Mesos certificates against this CA
+  --mesos-state-api=<api>	Endpoint the Mesos state is read from, one of
+				[ "auto", "state.json", "state", "v1" ]
+				(default auto, following the masters' version)
+  --mesos-state-backoff		Sync the last good state while the leader
+				answers 429, 503 or times out
+  --mesos-state-jitter=<time>	Wait a random time up to this long before
+				every fetch of the Mesos state (default 0)
+  --mesos-state-max-age=<time>	Oldest state synced from a replica or while
+				backing off from an overloaded leader
+				(default no limit)
+  --mesos-state-replica=<url[,url]>
+				Read the Mesos state from these copies of
+				/master/state before the masters
+  --mesos-timeout=<duration>	Give up on a request to the Mesos masters after
+				this long (default 30s, 0 never)
+  --mesos-user=<user>		Authenticate to the Mesos masters with HTTP
+				basic authentication as this user
+  --metrics-addr=<[host]:port>	Serve Prometheus metrics on /metrics on this
+				address (default disabled)
+  --min-healthy-before-drain=<n>
+				Keep the instances of a task service that went
+				away registered until n instances are running
+				(default 0)
+  --mirror-datacenter=<dc>	Also register the task services in the catalog
+				of this datacenter, tagged external-dc
+  --mirror-services=<regexp>	Only mirror the task services whose name
+				matches (default all)
+  --mirror-tags=<tag[,tag]>	Only mirror the task services with one of
+				these tags (default all)
+  --name-collision-policy=<policy>
+				What to do with task services of different
+				apps whose names normalize to the same one,
+				one of [ "merge", "suffix" ] (default merge)
+  --namespace-from-group=<depth>
+				Register Marathon tasks into the Consul
+				Enterprise namespace named after the first
+				<depth> levels of their app group (default 0,
+				disabled)
+  --naming-strategy=<framework=strategy[,framework=strategy]>
+				Name the tasks of a framework with one of the
+				strategies [ "marathon", "chronos",
+				"cassandra", "kafka", "generic" ] (default
+				the strategy named after the framework, else
+				generic)
+  --no-check-services=<regexp>	Register the task services whose name matches
+				without any check
+  --no-default-tags		Do not add the built-in leader, master and
+				follower tags to the mesos services
+  --once			Sync once and exit, non-zero when any of the
+				sync failed
+  --otlp-endpoint=<url>		Export a trace of every sync to the OTLP/HTTP
+				collector at url, e.g. http://127.0.0.1:4318
+  --otlp-headers=<name=value[,name=value]>
+				Headers of the exports to --otlp-endpoint,
+				e.g. the API key of a tracing vendor
+  --pid-parse-strict		Skip followers whose PID is not in the plain
+				type@host:port form instead of stripping
+				schemes and trailing paths
+  --port-collision-policy=<policy>
+				What to register when task services share an
+				address and port, one of [ "all", "first",
+				"skip" ] (default all)
+  --prepared-queries		Keep a prepared query failing over to other
+				datacenters for every service
+  --preserve-tags=<prefix>	Keep the tags starting with prefix other
+				tooling added when registering a service again
+  --query-failover-datacenters=<dc[,dc]>
+				Datacenters --prepared-queries fail over to,
+				in order
+  --query-failover-nearest=<n>	Number of the nearest datacenters
+				--prepared-queries fail over to (default 0)
+  --reconcile-interval=<time>	Compare the services in Consul with the cache
+				this often, registering the lost ones again
+				and removing orphans (default disabled)
   --refresh=<time>		Set the Mesos refresh rate
 				(default 1m)
+  --register-framework-uis	Register the webui_url of every framework as
+				a <framework><suffix> service, see
+				--framework-ui-suffix
+  --register-mesos-endpoints	Register the UI and metrics endpoints of the
+				masters and agents as mesos-master-ui,
+				mesos-master-metrics, mesos-agent-ui and
+				mesos-agent-metrics services
+  --register-zookeeper		Register the members of the --zk ensemble as
+				zookeeper services with TCP checks
+  --registration-api=<api>	Consul API services are registered through,
+				one of [ "agent", "catalog", "files" ]. With
+				catalog, hosts without a Consul agent are
+				registered as external nodes. With files,
+				services are only written to
+				--service-files-dir (default agent)
   --registry-auth=<user[:pass]>	Set the basic authentication username
 				(and password)
+  --registry-concurrency=<n>	Registry writes of a sync in flight at once
+				(default 1)
   --registry-port=<port>	Port to connect to consul agents
 				(default 8500)
+  --registry-rate=<rate>	Most registry writes per second, 0 for no
+				limit (default 0)
+  --registry-retries=<n>	Retries of a registry write failing with a
+				transient error, e.g. a timeout or a 5xx,
+				with a jittered exponential backoff
+				(default 2)
   --registry-ssl		Use SSL when connecting to the registry
   --registry-ssl-verify		Verify certificates when connecting via SSL
   --registry-ssl-cert		SSL certificates to send to registry
+  --registry-ssl-key		Private key of --registry-ssl-cert
   --registry-ssl-cacert		Validate server certificate against this CA
 				certificate file list
   --registry-token=<token>	Set registry ACL token
+  --registry-token-dir=<dir>	Directory of the token files task services
+				name with their consul_token_path label
+  --require-healthy		Only register the tasks Mesos health checks
+				once their latest check passed
+  --role-blacklist=<regexp>	Do not sync the tasks whose Mesos role matches
+  --role-whitelist=<regexp>	Only sync the tasks whose Mesos role matches
+				(default all roles)
+  --sandbox-url=<url>		Add the link to the sandbox of their task in the
+				Mesos UI at this URL to the meta of task
+				services as sandbox_url
+  --service-controls		Pull the task services of a name from Consul
+				while <kv-prefix>/control/<name>/enabled is
+				false
+  --service-files-dir=<dir>	Also write every service as a Consul service
+				definition file, to dir for the --consul-addr
+				agent and to dir/<agent> for the others
+  --service-files-reload=<command>
+				Run command with /bin/sh after the service
+				files of an agent changed, naming it in
+				MESOS_CONSUL_AGENT and its directory in
+				MESOS_CONSUL_SERVICE_DIR, and its address
+				in CONSUL_HTTP_ADDR but for the
+				--consul-addr agent (default "consul reload")
+  --service-id-scheme=<scheme>	IDs of the task services, one of [ "host",
+				"hash" ]. hash IDs stay the same when a task
+				moves hosts or ports (default host)
+  --service-id-template=<template>
+				Go template rendering the IDs of the task
+				services after mesos-consul:, e.g.
+				'{{.FrameworkName}}:{{.TaskId}}:{{.Port}}'
+  --service-name-separator=<sep>
+				Replace the characters of task names that are
+				invalid in DNS with sep instead of dropping
+				them, e.g. "-" (default disabled)
+  --service-prefix=<prefix>	Prepend prefix to the names of every service
+				and prepared query, e.g. mesos-prod-
+  --service-suffix=<suffix>	Append suffix to the names of every service
+				and prepared query
+  --service-tags-template=<template>
+				Go template rendering comma-separated extra
+				tags of the task services, e.g.
+				'{{.FrameworkName}},{{.Label "env"}}'
+  --service-weights=<resource>	Task resource the Consul weights of task
+				services follow, one of [ "none", "cpus",
+				"mem" ] (default none)
+  --skip-unchanged		Skip the registration pass of syncs finding
+				the same Mesos state as the last one
+  --state-refresh=<time>	Fetch the Mesos state at most this often and
+				re-affirm registrations from the last good
+				state in between (default every refresh)
+  --statsd-addr=<host:port>	Also send the metrics to the StatsD server on
+				this UDP address, e.g. 127.0.0.1:8125
+				(default disabled)
+  --statsd-format=<format>	Format of the metrics sent to --statsd-addr,
+				one of [ "statsd", "dogstatsd" ]
+				(default statsd)
+  --statsd-interval=<time>	Send the metrics to --statsd-addr this often
+				(default 10s)
+  --statsd-tags=<tag[,tag]>	Extra tags of the metrics sent in the
+				dogstatsd format, e.g. env:prod
+  --strip-marathon-groups	Name the services of Marathon tasks after their
+				app, without its groups
+  --sync-maintenance		Put the Consul agents of the followers under
+				Mesos maintenance into maintenance mode
+  --sync-order=<order>		Order of the register and deregister passes
+				of a sync, one of [ "register-first",
+				"deregister-first" ] (default register-first)
+  --tag-label-prefix=<prefix>	Tag task services with the labels starting
+				with prefix, e.g. consul.tag.
+  --task-agent=<agent>		Consul agent task services are registered
+				with, one of [ "address", "follower" ]
+				(default address)
+  --task-blacklist=<regexp>	Do not sync the tasks whose name matches
+  --task-ip-source=<source>	Shorthand of --address-priority, one of
+				[ "host", "netinfo", "docker", "auto" ]
+  --task-whitelist=<regexp>	Only sync the tasks whose name matches
+				(default all tasks)
+  --transform-plugin=<command>	Command run with /bin/sh passed the task
+				services as JSON, printing the ones to
+				register
+  --transform-plugin-timeout=<time>
+				Timeout of a --transform-plugin run
+				(default 10s)
+  --vault-addr=<url>		Vault server the --vault-* secrets are read
+				from (default $VAULT_ADDR)
+  --vault-mesos-credential=<path>
+				Vault secret holding the principal and secret
+				of the Mesos credential
+  --vault-mesos-ssl=<path>	Vault secret holding the certificate,
+				private_key and issuing_ca of the Mesos TLS
+				client certificate
+  --vault-refresh=<time>	Interval at which the Vault token is renewed
+				and the secrets read again (default 5m)
+  --vault-registry-ssl=<path>	Vault secret holding the certificate,
+				private_key and issuing_ca of the registry TLS
+				client certificate
+  --vault-registry-token=<path>	Vault secret holding the registry ACL token
+				in its token field
+  --vault-token-file=<file>	File of the Vault token (default
+				$VAULT_TOKEN)
+  --wan-address-map=<lan=wan[,lan=wan]>
+				Public address of task addresses, registered
+				as the services' wan tagged address
+  --watch-services		Watch the Consul catalog with blocking queries
+				and register again at once the services
+				deregistered or modified behind mesos-consul
   --zk=<address>		Zookeeper path to Mesos
 				(default zk://127.0.0.1:2181/mesos)
 `
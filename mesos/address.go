@@ -0,0 +1,156 @@
+package mesos
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Task labels overriding the address and ports of a task's services
+const (
+	consulPortLabel = "consul-port"
+	checkPortLabel  = "check-port"
+
+	lanAddressLabel = "tagged-address-lan"
+	wanAddressLabel = "tagged-address-wan"
+)
+
+// Pick the address to register a task under by walking the
+// --address-priority chain and returning the first source that
+// yields a usable address.
+func (m *Mesos) taskAddress(task *Task, f *follower) string {
+	for _, source := range m.config.AddressPriority {
+		var address string
+
+		switch source {
+		case config.AddressContainer:
+			address = m.taskContainerIP(task)
+		case config.AddressDocker:
+			address = dockerIP(task)
+		case config.AddressLabel:
+			if v := task.label(m.config.AddressLabel); v != "" {
+				address = m.address(v)
+			}
+		case config.AddressSlave:
+			if h, _, err := parsePID(f.Pid, m.config.PidParseStrict); err == nil && net.ParseIP(h) != nil {
+				address = h
+			}
+		case config.AddressHostname:
+			address = m.address(f.Hostname)
+		}
+
+		if address != "" {
+			hclog.L().Debug("Using task address", append(task.logFields(), "source", source, "address", address)...)
+			return address
+		}
+	}
+
+	return m.address(f.Hostname)
+}
+
+// Build the lan/wan tagged addresses of a task service registered
+// under address. The lan address is the tagged-address-lan label, the
+// container IP or address. The wan address is the tagged-address-wan
+// label or the --wan-address-map entry of the lan address or address.
+// Without a wan address there is nothing to split and nil is returned.
+func (m *Mesos) taggedAddresses(task *Task, address string, port int) map[string]consulapi.ServiceAddress {
+	lan := task.label(lanAddressLabel)
+	if lan == "" {
+		lan = m.taskContainerIP(task)
+	}
+	if lan == "" {
+		lan = address
+	}
+
+	wan := task.label(wanAddressLabel)
+	if wan == "" {
+		wan = m.config.WanAddressMap[lan]
+	}
+	if wan == "" {
+		wan = m.config.WanAddressMap[address]
+	}
+	if wan == "" {
+		return nil
+	}
+
+	return map[string]consulapi.ServiceAddress{
+		"lan": {Address: lan, Port: port},
+		"wan": {Address: wan, Port: port},
+	}
+}
+
+// Look up a task label holding a port number
+func (t *Task) labelPort(key string) (int, bool) {
+	v := t.label(key)
+	if v == "" {
+		return 0, false
+	}
+
+	port, err := strconv.Atoi(v)
+	if err != nil || port <= 0 || port > 65535 {
+		hclog.L().Warn("Ignoring invalid label", append(t.logFields(), "label", key, "value", v)...)
+		return 0, false
+	}
+
+	return port, true
+}
+
+// Look up the value of a task label
+func (t *Task) label(key string) string {
+	for _, l := range t.Labels {
+		if l.Key == key {
+			return l.Value
+		}
+	}
+
+	return ""
+}
+
+// The status label the Docker executor reports the container IP in
+const dockerIPLabel = "Docker.NetworkSettings.IPAddress"
+
+// Return the Docker container IP of the most recent running status
+// reporting one, e.g. on a Docker bridge or user network
+func dockerIP(task *Task) string {
+	for i := len(task.Statuses) - 1; i >= 0; i-- {
+		status := task.Statuses[i]
+		if status.State != "TASK_RUNNING" {
+			continue
+		}
+
+		for _, l := range status.Labels {
+			if l.Key == dockerIPLabel && net.ParseIP(l.Value) != nil {
+				return l.Value
+			}
+		}
+	}
+
+	return ""
+}
+
+// Return the container network IP of the most recent running status
+func containerIP(task *Task) string {
+	for i := len(task.Statuses) - 1; i >= 0; i-- {
+		status := task.Statuses[i]
+		if status.State != "TASK_RUNNING" {
+			continue
+		}
+
+		for _, ni := range status.ContainerStatus.NetworkInfos {
+			if net.ParseIP(ni.IPAddress) != nil {
+				return ni.IPAddress
+			}
+
+			for _, ip := range ni.IPAddresses {
+				if net.ParseIP(ip.IPAddress) != nil {
+					return ip.IPAddress
+				}
+			}
+		}
+	}
+
+	return ""
+}
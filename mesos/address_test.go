@@ -0,0 +1,76 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestTaskAddress(t *testing.T) {
+	f := &follower{Id: "1", Hostname: "10.0.0.1", Pid: "slave(1)@10.0.0.2:5051"}
+
+	task := &Task{
+		Id:     "web.1",
+		Labels: []Label{{Key: "address", Value: "10.0.0.3"}},
+		Statuses: []Status{
+			{State: "TASK_RUNNING", Labels: []Label{{Key: "Docker.NetworkSettings.IPAddress", Value: "172.18.0.5"}}, ContainerStatus: ContainerStatus{
+				NetworkInfos: []NetworkInfo{{IPAddresses: []IPAddress{{IPAddress: "172.17.0.4"}}}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		priority []string
+		address  string
+	}{
+		{[]string{config.AddressContainer, config.AddressHostname}, "172.17.0.4"},
+		{[]string{config.AddressDocker, config.AddressHostname}, "172.18.0.5"},
+		{[]string{config.AddressLabel, config.AddressHostname}, "10.0.0.3"},
+		{[]string{config.AddressSlave, config.AddressHostname}, "10.0.0.2"},
+		{[]string{config.AddressHostname}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		c := config.DefaultConfig()
+		c.AddressPriority = tt.priority
+
+		m := &Mesos{config: c}
+		if address := m.taskAddress(task, f); address != tt.address {
+			t.Errorf("%v: got %s, want %s", tt.priority, address, tt.address)
+		}
+	}
+
+	// Fall through sources that are not present
+	c := config.DefaultConfig()
+	c.AddressPriority = []string{config.AddressContainer, config.AddressDocker, config.AddressLabel, config.AddressSlave}
+
+	m := &Mesos{config: c}
+	if address := m.taskAddress(&Task{}, &follower{Hostname: "10.0.0.1"}); address != "10.0.0.1" {
+		t.Errorf("expected the hostname as last resort, got %s", address)
+	}
+}
+
+func TestTaggedAddresses(t *testing.T) {
+	c := config.DefaultConfig()
+	c.WanAddressMap = map[string]string{"10.0.0.1": "203.0.113.1"}
+
+	m := &Mesos{config: c}
+
+	ta := m.taggedAddresses(&Task{}, "10.0.0.1", 31000)
+	if ta["lan"].Address != "10.0.0.1" || ta["wan"].Address != "203.0.113.1" || ta["wan"].Port != 31000 {
+		t.Errorf("unexpected mapped tagged addresses: %v", ta)
+	}
+
+	task := &Task{Labels: []Label{
+		{Key: "tagged-address-lan", Value: "172.17.0.4"},
+		{Key: "tagged-address-wan", Value: "198.51.100.7"},
+	}}
+	ta = m.taggedAddresses(task, "10.0.0.1", 31000)
+	if ta["lan"].Address != "172.17.0.4" || ta["wan"].Address != "198.51.100.7" {
+		t.Errorf("unexpected labelled tagged addresses: %v", ta)
+	}
+
+	if ta := m.taggedAddresses(&Task{}, "10.0.0.2", 31000); ta != nil {
+		t.Errorf("expected no tagged addresses without a wan address, got %v", ta)
+	}
+}
@@ -0,0 +1,171 @@
+package mesos
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// The state of mesos-consul served on /v1/health
+type adminHealth struct {
+	LastSync        *time.Time `json:"last_sync,omitempty"`
+	SyncDuration    string     `json:"sync_duration"`
+	SyncError       string     `json:"sync_error,omitempty"`
+	MesosError      string     `json:"mesos_error,omitempty"`
+	ConsulErrors    int        `json:"consul_errors"`
+	Syncs           int        `json:"syncs"`
+	Registrations   int        `json:"registrations"`
+	Deregistrations int        `json:"deregistrations"`
+	MesosErrors     int        `json:"mesos_errors_total"`
+	ConsulErrorsAll int        `json:"consul_errors_total"`
+	CacheEntries    int        `json:"cache_entries"`
+}
+
+// AdminHandler serves the state of mesos-consul for operators:
+//
+//	GET  /v1/services  the registrations of the cached services
+//	GET  /v1/cache     the cache with the datacenter and agent of every service
+//	GET  /v1/health    the outcome of the last sync and the running totals
+//	GET  /v1/checks    the checks of the cached services, read from Consul
+//	GET  /v1/dns       the cached services DNS consumers cannot look up
+//	POST /v1/sync      call resync to sync right away, with the
+//	                   --admin-token when one is set
+//	GET  /ui/          with --admin-ui, a dashboard of the above
+func (m *Mesos) AdminHandler(resync func()) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/services", func(w http.ResponseWriter, r *http.Request) {
+		cached := m.CachedServices()
+
+		services := make([]*consulapi.AgentServiceRegistration, len(cached))
+		for i, e := range cached {
+			services[i] = e.Service
+		}
+
+		writeJSON(w, services)
+	})
+
+	mux.HandleFunc("/v1/cache", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, m.CachedServices())
+	})
+
+	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, m.adminHealth())
+	})
+
+	mux.HandleFunc("/v1/checks", func(w http.ResponseWriter, r *http.Request) {
+		checks, err := m.cachedChecks(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		writeJSON(w, checks)
+	})
+
+	mux.HandleFunc("/v1/dns", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, m.DNSReport())
+	})
+
+	mux.HandleFunc("/v1/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !m.adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		resync()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	if m.currentConfig().AdminUI {
+		mux.HandleFunc("/ui/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, dashboardHTML)
+		})
+	}
+
+	return mux
+}
+
+// Whether r carries the --admin-token as its bearer token, or none is
+// set
+func (m *Mesos) adminAuthorized(r *http.Request) bool {
+	token := m.currentConfig().AdminToken
+	if token == "" {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// The checks Consul holds for the services in the cache, whatever
+// their state
+func (m *Mesos) cachedChecks(ctx context.Context) ([]*consulapi.HealthCheck, error) {
+	services := m.CachedServices()
+	cached := make(map[string]bool, len(services))
+	for _, e := range services {
+		cached[e.Service.ID] = true
+	}
+
+	all, err := m.Registry.Checks(ctx, "mesos-consul:")
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []*consulapi.HealthCheck{}
+	for _, c := range all {
+		if cached[c.ServiceID] {
+			checks = append(checks, c)
+		}
+	}
+
+	return checks, nil
+}
+
+func (m *Mesos) adminHealth() *adminHealth {
+	cached := m.cachedCount()
+
+	m.health.Lock()
+	defer m.health.Unlock()
+
+	h := &adminHealth{
+		SyncDuration:    m.health.syncDuration.String(),
+		ConsulErrors:    m.health.consulErrors,
+		Syncs:           m.health.syncs,
+		Registrations:   m.health.registrations,
+		Deregistrations: m.health.deregistrations,
+		MesosErrors:     m.health.mesosErrorsTotal,
+		ConsulErrorsAll: m.health.consulErrorsTotal,
+		CacheEntries:    cached,
+	}
+	if !m.health.lastSync.IsZero() {
+		last := m.health.lastSync
+		h.LastSync = &last
+	}
+	if m.health.syncErr != nil {
+		h.SyncError = m.health.syncErr.Error()
+	}
+	if m.health.mesosErr != nil {
+		h.MesosError = m.health.mesosErr.Error()
+	}
+
+	return h
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,147 @@
+package mesos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestAdminHandler(t *testing.T) {
+	m := &Mesos{
+		config: config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}, agent: "10.0.0.1"},
+		},
+	}
+	m.health.registered(false)
+
+	resyncs := 0
+	h := m.AdminHandler(func() { resyncs++ })
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/cache", nil))
+	var cache []CachedService
+	if err := json.Unmarshal(w.Body.Bytes(), &cache); err != nil || len(cache) != 1 || cache[0].Agent != "10.0.0.1" {
+		t.Errorf("unexpected /v1/cache: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/services", nil))
+	var services []*consulapi.AgentServiceRegistration
+	if err := json.Unmarshal(w.Body.Bytes(), &services); err != nil || len(services) != 1 || services[0].ID != "mesos-consul:a" {
+		t.Errorf("unexpected /v1/services: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/health", nil))
+	var health adminHealth
+	if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil || health.Registrations != 1 || health.CacheEntries != 1 || health.LastSync != nil {
+		t.Errorf("unexpected /v1/health: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/sync", nil))
+	if w.Code != http.StatusMethodNotAllowed || resyncs != 0 {
+		t.Errorf("expected GET /v1/sync to be refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/v1/sync", nil))
+	if w.Code != http.StatusAccepted || resyncs != 1 {
+		t.Errorf("expected POST /v1/sync to resync, got %d", w.Code)
+	}
+
+	m.config.AdminToken = "secret"
+	for _, auth := range []string{"", "Bearer other"} {
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/sync", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || resyncs != 1 {
+			t.Errorf("expected POST /v1/sync with %q to be refused, got %d", auth, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/sync", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted || resyncs != 2 {
+		t.Errorf("expected POST /v1/sync with the token to resync, got %d", w.Code)
+	}
+}
+
+func TestAdminDuringSync(t *testing.T) {
+	m := &Mesos{
+		config: config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}, agent: "10.0.0.1"},
+		},
+	}
+	m.cacheLock.Lock()
+	m.unlockCache()
+
+	// A sync holds the cache
+	m.cacheLock.Lock()
+	defer m.cacheLock.Unlock()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		m.AdminHandler(func() {}).ServeHTTP(w, httptest.NewRequest("GET", "/v1/cache", nil))
+		done <- w
+	}()
+
+	select {
+	case w := <-done:
+		var cache []CachedService
+		if err := json.Unmarshal(w.Body.Bytes(), &cache); err != nil || len(cache) != 1 {
+			t.Errorf("expected the cache as of before the sync, got %s", w.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("/v1/cache waited for the sync")
+	}
+}
+
+func TestAdminUI(t *testing.T) {
+	r := newFakeRegistry()
+	r.checks = []*consulapi.HealthCheck{
+		{CheckID: "service:mesos-consul:a", ServiceID: "mesos-consul:a", Status: consulapi.HealthCritical},
+		{CheckID: "service:mesos-consul:b", ServiceID: "mesos-consul:b", Status: consulapi.HealthPassing},
+	}
+	m := &Mesos{
+		Registry: r,
+		config:   config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	m.AdminHandler(func() {}).ServeHTTP(w, httptest.NewRequest("GET", "/v1/checks", nil))
+	var checks []*consulapi.HealthCheck
+	if err := json.Unmarshal(w.Body.Bytes(), &checks); err != nil || len(checks) != 1 || checks[0].Status != consulapi.HealthCritical {
+		t.Errorf("expected the check of the cached service, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	m.AdminHandler(func() {}).ServeHTTP(w, httptest.NewRequest("GET", "/ui/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected no dashboard without --admin-ui, got %d", w.Code)
+	}
+
+	m.config.AdminUI = true
+	w = httptest.NewRecorder()
+	m.AdminHandler(func() {}).ServeHTTP(w, httptest.NewRequest("GET", "/ui/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "../v1/checks") {
+		t.Errorf("expected the dashboard, got %d", w.Code)
+	}
+}
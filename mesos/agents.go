@@ -0,0 +1,175 @@
+package mesos
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The parts of an agent's /slave(1)/state tasks are enriched with
+type agentState struct {
+	Frameworks []struct {
+		Executors []struct {
+			Id    string `json:"id"`
+			Tasks []struct {
+				Id       string   `json:"id"`
+				Statuses []Status `json:"statuses"`
+			} `json:"tasks"`
+		} `json:"executors"`
+	} `json:"frameworks"`
+}
+
+// What an agent reports of one of its tasks
+type agentTask struct {
+	executorId string
+	statuses   []Status
+}
+
+// With --agent-state, complete the running tasks the masters report
+// without a container IP or executor from the state of their agents.
+// The agents are read --agent-concurrency at a time, each within
+// --agent-timeout. An agent that cannot be read leaves its tasks as
+// they are.
+func (m *Mesos) enrichTasks(sj StateJSON) {
+	if !m.config.AgentState {
+		return
+	}
+
+	var agents []*follower
+	for _, id := range incompleteAgents(sj) {
+		if f, err := sj.Followers.byId(id); err == nil {
+			agents = append(agents, f)
+		}
+	}
+	if len(agents) == 0 {
+		return
+	}
+
+	start := time.Now()
+	tasks := make(map[string]map[string]agentTask, len(agents))
+	var lock sync.Mutex
+	m.readAgents(agents, func(f *follower) {
+		found, err := m.loadAgentTasks(f)
+		if err != nil {
+			hclog.L().Warn("Unable to read the agent state", "agent", f.Id, "hostname", f.Hostname, "error", err)
+			return
+		}
+
+		lock.Lock()
+		tasks[f.Id] = found
+		lock.Unlock()
+	})
+
+	hclog.L().Debug("Read the agent states", "agents", len(agents), "read", len(tasks), "duration", time.Since(start))
+
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			if t, ok := tasks[task.FollowerId][task.Id]; ok && incomplete(task) {
+				t.enrich(task)
+			}
+		}
+	}
+}
+
+// Call read with every agent, --agent-concurrency at a time
+func (m *Mesos) readAgents(agents []*follower, read func(f *follower)) {
+	var wg sync.WaitGroup
+	n := m.config.AgentConcurrency
+	if n < 1 {
+		n = 1
+	}
+	slots := make(chan struct{}, n)
+	for _, f := range agents {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(f *follower) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			read(f)
+		}(f)
+	}
+	wg.Wait()
+}
+
+// The agents running a task enrichTasks() completes
+func incompleteAgents(sj StateJSON) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			if incomplete(task) && !seen[task.FollowerId] {
+				seen[task.FollowerId] = true
+				ids = append(ids, task.FollowerId)
+			}
+		}
+	}
+
+	return ids
+}
+
+// Tell whether a running task lacks a container IP or executor
+func incomplete(task *Task) bool {
+	if task.State != "TASK_RUNNING" {
+		return false
+	}
+
+	return task.ExecutorId == "" || (containerIP(task) == "" && dockerIP(task) == "")
+}
+
+// Read the tasks of an agent by ID
+func (m *Mesos) loadAgentTasks(f *follower) (map[string]agentTask, error) {
+	host, port, err := parsePID(f.Pid, m.config.PidParseStrict)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.agentTimeout()
+	defer cancel()
+
+	var state agentState
+	if err := m.requestJSONContext(ctx, "GET", m.mesosURL(hostPort(host, port), "/slave(1)/state"), "", &state); err != nil {
+		return nil, err
+	}
+
+	tasks := make(map[string]agentTask)
+	for _, fw := range state.Frameworks {
+		for _, e := range fw.Executors {
+			for _, t := range e.Tasks {
+				tasks[t.Id] = agentTask{executorId: e.Id, statuses: t.Statuses}
+			}
+		}
+	}
+
+	return tasks, nil
+}
+
+// The context of a request to an agent, see --agent-timeout
+func (m *Mesos) agentTimeout() (context.Context, context.CancelFunc) {
+	if m.config.AgentTimeout <= 0 {
+		return context.WithCancel(m.syncCtx())
+	}
+	return context.WithTimeout(m.syncCtx(), m.config.AgentTimeout)
+}
+
+// Fill in what the master left out of task
+func (t agentTask) enrich(task *Task) {
+	if task.ExecutorId == "" {
+		task.ExecutorId = t.executorId
+	}
+
+	if containerIP(task) != "" || dockerIP(task) != "" {
+		return
+	}
+
+	agent := Task{State: task.State, Statuses: t.statuses}
+	if containerIP(&agent) != "" || dockerIP(&agent) != "" {
+		task.Statuses = t.statuses
+	}
+}
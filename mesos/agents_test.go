@@ -0,0 +1,73 @@
+package mesos
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestEnrichTasks(t *testing.T) {
+	var running, peak int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slave(1)/state" {
+			http.NotFound(w, r)
+			return
+		}
+
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		fmt.Fprint(w, `{"frameworks":[{"executors":[{"id":"web.1","tasks":[{"id":"web.1","statuses":[
+			{"state":"TASK_RUNNING","container_status":{"network_infos":[{"ip_addresses":[{"ip_address":"172.17.0.2"}]}]}}]}]}]}]}`)
+	}))
+	defer agent.Close()
+
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer hung.Close()
+
+	pid := func(s *httptest.Server) string { return "slave(1)@" + s.Listener.Addr().String() }
+	sj := StateJSON{
+		Followers: Followers{
+			{Id: "s-1", Pid: pid(agent)},
+			{Id: "s-2", Pid: pid(agent)},
+			{Id: "s-3", Pid: pid(hung)},
+		},
+		Frameworks: Frameworks{{Tasks: Tasks{
+			{Id: "web.1", FollowerId: "s-1", State: "TASK_RUNNING"},
+			{Id: "web.1", FollowerId: "s-2", State: "TASK_RUNNING", ExecutorId: "custom"},
+			{Id: "web.1", FollowerId: "s-3", State: "TASK_RUNNING"},
+		}}},
+	}
+
+	c := config.DefaultConfig()
+	c.AgentState = true
+	c.AgentConcurrency = 1
+	c.AgentTimeout = 100 * time.Millisecond
+	m := &Mesos{config: c}
+	m.enrichTasks(sj)
+
+	tasks := sj.Frameworks[0].Tasks
+	if tasks[0].ExecutorId != "web.1" || containerIP(&tasks[0]) != "172.17.0.2" {
+		t.Errorf("expected the agent to complete the task, got %+v", tasks[0])
+	}
+	if tasks[1].ExecutorId != "custom" || containerIP(&tasks[1]) != "172.17.0.2" {
+		t.Errorf("expected the master's executor to be kept, got %+v", tasks[1])
+	}
+	if tasks[2].ExecutorId != "" || tasks[2].Statuses != nil {
+		t.Errorf("expected the hung agent to leave its task as it is, got %+v", tasks[2])
+	}
+	if peak := atomic.LoadInt32(&peak); peak != 1 {
+		t.Errorf("expected one agent to be read at a time, got %d", peak)
+	}
+}
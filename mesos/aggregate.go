@@ -0,0 +1,97 @@
+package mesos
+
+import (
+	"fmt"
+	"sort"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// An aggregate is a meta-service rolling up every instance of a
+// task service into a single TTL check.
+type aggregate struct {
+	service *consulapi.AgentServiceRegistration
+	running int
+	total   int
+}
+
+func (a *aggregate) checkID() string {
+	return "service:" + a.service.ID
+}
+
+// Group the tasks in the state by the names their services are
+// registered under, one per port, see drainNames(), and build one
+// aggregate per name. The aggregate is healthy when at least one
+// instance is running and not failing its Mesos health check.
+func (m *Mesos) aggregateServices(sj StateJSON) []*aggregate {
+	if !m.config.AggregateHealth {
+		return nil
+	}
+
+	ttl := fmt.Sprintf("%ds", int(3*m.config.Refresh.Seconds()))
+
+	taskNames, _ := m.serviceNames(sj)
+	byName := make(map[string]*aggregate)
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+
+			_, err := sj.Followers.hostById(task.FollowerId)
+			healthy, ok := taskHealthy(task)
+			running := err == nil && task.State == "TASK_RUNNING" && (healthy || !ok)
+
+			for _, name := range m.drainNames(taskNames[task], task) {
+				a, ok := byName[name]
+				if !ok {
+					a = &aggregate{
+						service: &consulapi.AgentServiceRegistration{
+							ID:   fmt.Sprintf("mesos-consul:aggregate:%s", name),
+							Name: fmt.Sprintf("%s-aggregate", name),
+							Check: &consulapi.AgentServiceCheck{
+								TTL: ttl,
+							},
+						},
+					}
+					byName[name] = a
+				}
+
+				a.total++
+				if running {
+					a.running++
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	aggregates := make([]*aggregate, len(names))
+	for i, name := range names {
+		aggregates[i] = byName[name]
+	}
+
+	return aggregates
+}
+
+// Register the aggregates and report their health
+func (m *Mesos) registerAggregates(sj StateJSON) {
+	aggregates := m.aggregateServices(sj)
+	for _, a := range aggregates {
+		m.register(localDatacenter, a.service)
+	}
+
+	m.flush()
+	for _, a := range aggregates {
+		note := fmt.Sprintf("%d of %d instances running", a.running, a.total)
+		err := m.Registry.UpdateTTL(m.syncCtx(), a.service.Address, a.checkID(), a.running > 0, note)
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Error("Unable to update the aggregate check", "service_id", a.service.ID, "error", err)
+		}
+	}
+}
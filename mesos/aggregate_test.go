@@ -0,0 +1,61 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestAggregateServices(t *testing.T) {
+	c := config.DefaultConfig()
+	c.AggregateHealth = true
+
+	m := &Mesos{config: c}
+	unhealthy := false
+
+	sj := StateJSON{
+		Followers: Followers{
+			{Id: "1", Hostname: "10.0.0.1"},
+		},
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{Name: "web", FollowerId: "1", State: "TASK_RUNNING"},
+				{Name: "web", FollowerId: "1", State: "TASK_STAGING"},
+				{Name: "web", FollowerId: "1", State: "TASK_RUNNING", Statuses: []Status{{Healthy: &unhealthy}}},
+				{Name: "db", FollowerId: "1", State: "TASK_STAGING"},
+				{
+					Name:       "cache",
+					FollowerId: "1",
+					State:      "TASK_RUNNING",
+					Labels:     []Label{{Key: "consul_port_1_name", Value: "admin"}},
+					Resources:  Resources{Ports: "[31000-31001]"},
+				},
+			}},
+		},
+	}
+
+	aggregates := m.aggregateServices(sj)
+	if len(aggregates) != 4 {
+		t.Fatalf("expected 4 aggregates, got %d", len(aggregates))
+	}
+
+	// Named after the port services, as they are registered
+	admin, cache, db, web := aggregates[0], aggregates[1], aggregates[2], aggregates[3]
+	if admin.service.Name != "admin-aggregate" || admin.running != 1 || cache.service.Name != "cache-aggregate" || cache.running != 1 {
+		t.Errorf("unexpected port aggregates: %s %d, %s %d", admin.service.Name, admin.running, cache.service.Name, cache.running)
+	}
+	if db.service.Name != "db-aggregate" || db.running != 0 || db.total != 1 {
+		t.Errorf("unexpected db aggregate: %s %d/%d", db.service.Name, db.running, db.total)
+	}
+	if web.service.Name != "web-aggregate" || web.running != 1 || web.total != 3 {
+		t.Errorf("unexpected web aggregate: %s %d/%d", web.service.Name, web.running, web.total)
+	}
+	if web.checkID() != "service:mesos-consul:aggregate:web" {
+		t.Errorf("unexpected check ID: %s", web.checkID())
+	}
+
+	c.AggregateHealth = false
+	if aggregates := m.aggregateServices(sj); len(aggregates) != 0 {
+		t.Errorf("expected no aggregates when disabled, got %d", len(aggregates))
+	}
+}
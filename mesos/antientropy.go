@@ -0,0 +1,177 @@
+package mesos
+
+import (
+	"context"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The least time between two repairs of the --watch-services watcher,
+// so a burst of catalog changes is repaired at once
+const watchRepairInterval = 10 * time.Second
+
+// How long after a sync the catalog changes are taken for its own
+// registrations and left alone by the --watch-services watcher
+const watchSettle = 2 * time.Second
+
+// With --watch-services, block on the services of the catalog and
+// repair the cached services deregistered or modified behind
+// mesos-consul's back as soon as Consul reports the change, instead of
+// at the next --reconcile-interval, until ctx is done. The changes
+// made by the syncs are skipped, see repairServices().
+func (m *Mesos) watchServices(ctx context.Context) {
+	var index uint64
+	var repaired time.Time
+
+	for ctx.Err() == nil {
+		last, err := m.Registry.WaitServices(m.allowStale(ctx), index, 5*time.Minute)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			hclog.L().Warn("Unable to watch the Consul services", "error", err)
+			sleep(ctx, m.config.Refresh)
+			continue
+		}
+
+		// Consul resets its index, e.g. after a snapshot restore
+		if last < index {
+			index = 0
+			continue
+		}
+		if last == index {
+			continue
+		}
+
+		// The first answer returns at once with the current index
+		first := index == 0
+		index = last
+		if first {
+			continue
+		}
+
+		sleep(ctx, time.Until(repaired.Add(watchRepairInterval)))
+		repaired = time.Now()
+		m.repairServices(ctx)
+	}
+}
+
+// Sleep for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// StopWatching stops the --watch-services watcher, e.g. once another
+// instance took over the --lock, so the cache it no longer owns is not
+// repaired into the catalog. The next sync starts it again.
+func (m *Mesos) StopWatching() {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	if m.stopWatching != nil {
+		m.stopWatching()
+		m.stopWatching = nil
+	}
+}
+
+// Register again the cached services the catalog lost or holds with
+// another address, port, name, or without tags or meta mesos-consul
+// registered them with. Tags and meta other tooling added are left
+// alone, see --preserve-tags. Catalog changes during a sync or within
+// watchSettle of its end are its own registrations and are skipped, as
+// is the repair once ctx is done.
+func (m *Mesos) repairServices(ctx context.Context) {
+	if !m.syncLock.TryLock() {
+		return
+	}
+	defer m.syncLock.Unlock()
+
+	if ctx.Err() != nil || time.Since(m.syncEnded) < watchSettle {
+		return
+	}
+
+	services, err := m.Registry.Services(m.inScopes(m.allowStale(ctx)), "mesos-consul:")
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to list the Consul services", "error", err)
+		return
+	}
+
+	registered := make(map[string]*consulapi.AgentServiceRegistration, len(services))
+	for _, s := range services {
+		registered[s.ID] = s.AgentServiceRegistration
+	}
+
+	m.cacheLock.Lock()
+	defer m.unlockCache()
+
+	type repair struct {
+		entry  CacheEntry
+		reason string
+	}
+	var repairs []repair
+	for key, b := range m.ServiceCache {
+		// Services waiting out --deregister-delay are left alone
+		if key.Datacenter != localDatacenter || b.missed != 0 {
+			continue
+		}
+
+		s, ok := registered[key.ID]
+		switch {
+		case !ok:
+			repairs = append(repairs, repair{*b, reasonLost})
+		case modified(b.service, s):
+			repairs = append(repairs, repair{*b, reasonChanged})
+		}
+	}
+	if len(repairs) == 0 {
+		return
+	}
+
+	m.beginSummary()
+	for _, r := range repairs {
+		b, reason := r.entry, r.reason
+		hclog.L().Warn("Service changed in Consul. Registering again", "service_id", b.service.ID, "reason", reason)
+		reg := m.withExternalTags(b.service)
+		m.write(func(ctx context.Context) error {
+			return m.applier().Register(ctx, Registration{localDatacenter, b.agent, reg})
+		}, func() {
+			m.health.drifted()
+			m.applied(eventRegister, reason, b.agent, b.service)
+		})
+	}
+	m.flush()
+	m.endSummary(nil, 0)
+}
+
+// Whether the catalog's copy of a cached service lost anything
+// mesos-consul registered it with
+func modified(cached, s *consulapi.AgentServiceRegistration) bool {
+	if cached.Name != s.Name || cached.Address != s.Address || cached.Port != s.Port {
+		return true
+	}
+
+	for _, tag := range cached.Tags {
+		if !contains(s.Tags, tag) {
+			return true
+		}
+	}
+	for k, v := range cached.Meta {
+		if other, ok := s.Meta[k]; !ok || other != v {
+			return true
+		}
+	}
+
+	return false
+}
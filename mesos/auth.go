@@ -0,0 +1,15 @@
+package mesos
+
+import (
+	"net/http"
+)
+
+// Authenticate a request to a Mesos master with --mesos-user and
+// --mesos-password, e.g. for masters started with
+// --authenticate_http_readonly
+func (m *Mesos) authenticate(req *http.Request) {
+	c := m.currentConfig()
+	if c.MesosUser != "" {
+		req.SetBasicAuth(c.MesosUser, c.MesosPassword)
+	}
+}
@@ -0,0 +1,76 @@
+package mesos
+
+import (
+	"net/url"
+	"strings"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The labels marathon-lb's blue/green deployments set on the apps of
+// both colours, see --blue-green
+const (
+	deploymentGroupLabel  = "HAPROXY_DEPLOYMENT_GROUP"
+	deploymentColourLabel = "HAPROXY_DEPLOYMENT_COLOUR"
+)
+
+// With --blue-green, the deployment group of a task that has one, its
+// services being named after the group rather than the app
+func (m *Mesos) deploymentGroup(task *Task) string {
+	if !m.config.BlueGreen {
+		return ""
+	}
+
+	return task.label(deploymentGroupLabel)
+}
+
+// The colour of a task of a blue/green deployment, tagging its
+// services, e.g. blue
+func (m *Mesos) deploymentColour(task *Task) string {
+	if m.deploymentGroup(task) == "" {
+		return ""
+	}
+
+	return cleanName(task.label(deploymentColourLabel))
+}
+
+// With --blue-green-live, read the live colour of every deployment
+// operators keep under <kv-prefix>/bluegreen/<service name>, e.g.
+// green. A failed read keeps the colours of the last one.
+func (m *Mesos) loadLiveColours() {
+	if !m.config.BlueGreenLive {
+		m.liveColours = nil
+		return
+	}
+
+	prefix := m.config.KVPrefix + "/bluegreen/"
+	values, _, err := m.Registry.List(m.syncCtx(), prefix, 0, 0)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to read the live colours", "key", prefix, "error", err)
+		return
+	}
+
+	m.liveColours = make(map[string]string)
+	for key, value := range values {
+		name, err := url.PathUnescape(strings.TrimPrefix(key, prefix))
+		if err != nil || strings.Contains(name, "/") {
+			continue
+		}
+		if colour := cleanName(strings.TrimSpace(string(value))); colour != "" {
+			m.liveColours[name] = colour
+		}
+	}
+}
+
+// Tell whether the services of a task are registered under name: with
+// --blue-green-live, those of a deployment with a live colour only
+// when the task has that colour
+func (m *Mesos) liveTask(name string, task *Task) bool {
+	live, ok := m.liveColours[name]
+	if !ok || m.deploymentGroup(task) == "" {
+		return true
+	}
+
+	return m.deploymentColour(task) == live
+}
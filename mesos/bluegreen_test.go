@@ -0,0 +1,64 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestBlueGreen(t *testing.T) {
+	c := config.DefaultConfig()
+	c.BlueGreen = true
+	r := &changesRegistry{keys: map[string][]byte{}}
+	m := &Mesos{Registry: r, config: c}
+
+	task := func(id, app, colour string) Task {
+		return Task{
+			Id:         id,
+			Name:       app,
+			FollowerId: "1",
+			State:      "TASK_RUNNING",
+			Labels: []Label{
+				{Key: deploymentGroupLabel, Value: "web"},
+				{Key: deploymentColourLabel, Value: colour},
+			},
+		}
+	}
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{{Name: "marathon", Tasks: Tasks{
+			task("web-blue.1", "web-blue", "Blue"),
+			task("web-green.1", "web-green", "green"),
+		}}},
+	}
+
+	names, collisions := m.serviceNames(sj)
+	if len(collisions) != 0 {
+		t.Errorf("expected the colours not to collide, got %v", collisions)
+	}
+
+	services, _ := m.taskServices(sj)
+	if len(services) != 2 {
+		t.Fatalf("expected both colours, got %d services", len(services))
+	}
+	for _, s := range services {
+		if s.Name != "web" || !contains(s.Tags, "blue") && !contains(s.Tags, "green") {
+			t.Errorf("expected a coloured web service, got %s %v", s.Name, s.Tags)
+		}
+	}
+
+	c.BlueGreenLive = true
+	r.keys["mesos-consul/bluegreen/web"] = []byte("green\n")
+	m.loadLiveColours()
+	services, _ = m.taskServices(sj)
+	if len(services) != 1 || !contains(services[0].Tags, "green") {
+		t.Errorf("expected the live colour only, got %v", services)
+	}
+
+	c.BlueGreen, c.BlueGreenLive = false, false
+	m.loadLiveColours()
+	names, _ = m.serviceNames(sj)
+	if names[&sj.Frameworks[0].Tasks[0]] != "web-blue" {
+		t.Errorf("expected the app names without --blue-green, got %v", names)
+	}
+}
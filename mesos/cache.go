@@ -0,0 +1,492 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The schema of the persisted cache entries. Entries without a
+// version were written before it was recorded and are version 1, the
+// single value of older releases version 0.
+const cacheVersion = 2
+
+// The upgrades of an entry of version i+1 to i+2, see decodeEntry()
+var cacheMigrations = []func(e *CachedService){
+	// 2 records the agent. Version 1 registered every service with
+	// the agent on its address.
+	func(e *CachedService) {
+		if e.Agent == "" {
+			e.Agent = e.Service.Address
+		}
+	},
+}
+
+// An entry written by a release with a newer cache schema
+var errNewerCache = errors.New("written by a newer release")
+
+// Populate the cache from the entries persisted in the KV store, or
+// from a cache persisted by an older release as a single value.
+// Returns false when there is neither so the caller can fall back to
+// the catalog. When entries cannot be read, the services missing from
+// them are also looked up in the catalog so they are not registered
+// again or leaked.
+func (m *Mesos) loadKVCache() (bool, error) {
+	values, _, err := m.Registry.List(m.loadCtx(), m.cacheKey+"/", 0, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if len(values) > 0 {
+		hclog.L().Debug("Populating cache", "key", m.cacheKey+"/")
+		entries, skipped := decodeEntries(values)
+		m.mergeEntries(entries)
+
+		// Leave the entries of a newer release alone, e.g. while
+		// rolling back, and rewrite the others
+		m.savedCache = make(map[string][]byte, len(values))
+		for key, value := range values {
+			if !errors.Is(skipped[key], errNewerCache) {
+				m.savedCache[key] = value
+			}
+		}
+
+		if len(skipped) > 0 {
+			hclog.L().Warn("Cache entries unreadable. Completing the cache from the catalog", "key", m.cacheKey+"/", "entries", len(skipped))
+			if err := m.LoadCache(); err != nil {
+				hclog.L().Warn("Unable to read the catalog", "error", err)
+			}
+		}
+
+		return true, nil
+	}
+
+	value, _, err := m.Registry.Get(m.loadCtx(), m.cacheKey, 0, 0)
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	// The next save writes the entries and deletes the single value
+	hclog.L().Info("Migrating the persisted cache", "key", m.cacheKey)
+	m.mergeCache(value)
+	m.legacyCache = true
+
+	return true, nil
+}
+
+// A service in the cache, as persisted in the KV store and returned
+// by CachedServices()
+type CachedService struct {
+	Version    int                                 `json:"version,omitempty"`
+	Datacenter string                              `json:"datacenter,omitempty"`
+	Agent      string                              `json:"agent,omitempty"`
+	Service    *consulapi.AgentServiceRegistration `json:"service"`
+}
+
+// The KV key of a cached service, <kv-prefix>/cache/<service-id> for
+// the local datacenter and <kv-prefix>/cache/<datacenter>/<service-id>
+// for the others
+func (m *Mesos) entryKey(key ServiceKey) string {
+	if key.Datacenter == localDatacenter {
+		return m.cacheKey + "/" + url.PathEscape(key.ID)
+	}
+
+	return m.cacheKey + "/" + url.PathEscape(key.Datacenter) + "/" + url.PathEscape(key.ID)
+}
+
+// Decode persisted entries, returning the keys of the unreadable
+// ones with their error
+func decodeEntries(values map[string][]byte) ([]CachedService, map[string]error) {
+	entries := make([]CachedService, 0, len(values))
+	skipped := make(map[string]error)
+	for key, value := range values {
+		e, err := decodeEntry(value)
+		if err != nil {
+			hclog.L().Warn("Ignoring unreadable cache entry", "key", key, "error", err)
+			skipped[key] = err
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, skipped
+}
+
+// Decode a persisted entry and migrate it to cacheVersion
+func decodeEntry(value []byte) (CachedService, error) {
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return CachedService{}, err
+	}
+	if v.Version > cacheVersion {
+		return CachedService{}, fmt.Errorf("version %d: %w", v.Version, errNewerCache)
+	}
+
+	var e CachedService
+	if err := json.Unmarshal(value, &e); err != nil {
+		return CachedService{}, err
+	}
+	if e.Service == nil {
+		return CachedService{}, errors.New("no service")
+	}
+
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	for ; e.Version < cacheVersion; e.Version++ {
+		cacheMigrations[e.Version-1](&e)
+	}
+
+	return e, nil
+}
+
+// Add the services of a cache persisted as a single value by an older
+// release, see mergeEntries()
+func (m *Mesos) mergeCache(value []byte) {
+	var values []json.RawMessage
+	if err := json.Unmarshal(value, &values); err != nil {
+		hclog.L().Warn("Ignoring unreadable cache", "key", m.cacheKey, "error", err)
+		return
+	}
+
+	entries := make([]CachedService, 0, len(values))
+	for _, v := range values {
+		e, err := decodeEntry(v)
+		if err != nil {
+			hclog.L().Warn("Ignoring unreadable cache entry", "key", m.cacheKey, "error", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	m.mergeEntries(entries)
+}
+
+// Add the persisted services that are missing from the in-memory
+// cache. They are not marked as registered, so the next sync
+// deregisters the ones no longer running in Mesos.
+func (m *Mesos) mergeEntries(entries []CachedService) {
+	m.syncedHash = ""
+	if m.ServiceCache == nil {
+		m.ServiceCache = make(map[ServiceKey]*CacheEntry)
+	}
+
+	for _, e := range entries {
+		key := ServiceKey{e.Service.ID, e.Datacenter}
+		if _, ok := m.ServiceCache[key]; !ok {
+			m.ServiceCache[key] = &CacheEntry{
+				service:      e.Service,
+				isRegistered: false,
+				agent:        e.Agent,
+			}
+		}
+	}
+}
+
+// CachedServices returns the services mesos-consul tracks as
+// registered, sorted by datacenter and service ID. It is safe to call
+// while a sync runs, e.g. from programs embedding the package, and
+// returns the services as of before the sync rather than waiting for
+// it.
+func (m *Mesos) CachedServices() []CachedService {
+	if cached := m.cached.Load(); cached != nil {
+		return append([]CachedService(nil), *cached...)
+	}
+
+	// Nothing is cached before the first sync ends
+	if !m.cacheLock.TryLock() {
+		return []CachedService{}
+	}
+	defer m.cacheLock.Unlock()
+
+	return m.cachedServices()
+}
+
+// The number of services CachedServices() returns
+func (m *Mesos) cachedCount() int {
+	if cached := m.cached.Load(); cached != nil {
+		return len(*cached)
+	}
+
+	if !m.cacheLock.TryLock() {
+		return 0
+	}
+	defer m.cacheLock.Unlock()
+
+	return len(m.ServiceCache)
+}
+
+// Release cacheLock after changing the cache, publishing its services
+// to CachedServices()
+func (m *Mesos) unlockCache() {
+	cached := m.cachedServices()
+	m.cached.Store(&cached)
+	m.cacheLock.Unlock()
+}
+
+func (m *Mesos) cachedServices() []CachedService {
+	entries := make([]CachedService, 0, len(m.ServiceCache))
+	for key, b := range m.ServiceCache {
+		entries = append(entries, CachedService{
+			Version:    cacheVersion,
+			Datacenter: key.Datacenter,
+			Agent:      b.agent,
+			Service:    b.service,
+		})
+	}
+	sort.Sort(byKey(entries))
+
+	return entries
+}
+
+// Persist the entries of the cache that changed since the last write
+// to the KV store, in transactions
+func (m *Mesos) saveCache() {
+	values := make(map[string][]byte, len(m.ServiceCache))
+	for _, e := range m.cachedServices() {
+		value, err := json.Marshal(e)
+		if err != nil {
+			hclog.L().Error("Unable to encode the cache entry", "service_id", e.Service.ID, "error", err)
+			return
+		}
+		values[m.entryKey(ServiceKey{e.Service.ID, e.Datacenter})] = value
+	}
+
+	puts, deletes := changedEntries(values, m.savedCache)
+	if m.legacyCache {
+		deletes = append(deletes, m.cacheKey)
+	}
+
+	if len(puts) == 0 && len(deletes) == 0 {
+		return
+	}
+
+	err := m.Registry.Txn(m.syncCtx(), puts, deletes)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to save the cache", "key", m.cacheKey+"/", "error", err)
+		return
+	}
+
+	m.savedCache = values
+	m.legacyCache = false
+}
+
+// The keys to write and delete to turn the saved entries into values
+func changedEntries(values, saved map[string][]byte) (map[string][]byte, []string) {
+	puts := make(map[string][]byte)
+	for key, value := range values {
+		if !bytes.Equal(value, saved[key]) {
+			puts[key] = value
+		}
+	}
+
+	var deletes []string
+	for key := range saved {
+		if _, ok := values[key]; !ok {
+			deletes = append(deletes, key)
+		}
+	}
+
+	return puts, deletes
+}
+
+// Tell whether two sets of persisted entries are the same
+func sameEntries(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Watch the persisted cache with blocking queries and merge in changes
+// made by other mesos-consul instances or operators
+func (m *Mesos) watchCache() {
+	var index uint64
+
+	for {
+		m.cacheLock.Lock()
+		cacheKey := m.cacheKey
+		m.cacheLock.Unlock()
+
+		values, last, err := m.Registry.List(m.allowStale(context.Background()), cacheKey+"/", index, 5*time.Minute)
+		if err != nil {
+			hclog.L().Warn("Unable to watch the cache", "key", cacheKey+"/", "error", err)
+			time.Sleep(m.currentConfig().Refresh)
+			continue
+		}
+
+		if last == index || len(values) == 0 {
+			index = last
+			continue
+		}
+		index = last
+
+		m.cacheLock.Lock()
+		switch {
+		case cacheKey != m.cacheKey:
+		case m.ServiceCache == nil || m.warm:
+			m.warmCache(values)
+		case !sameEntries(values, m.savedCache):
+			hclog.L().Info("Cache changed externally. Merging", "key", m.cacheKey+"/")
+			entries, _ := decodeEntries(values)
+			m.mergeEntries(entries)
+		}
+		m.unlockCache()
+	}
+}
+
+// WatchCache starts the --cache-watch watcher, once. With --lock, it
+// is started before waiting for the lock, so a standby keeps its cache
+// warm from the entries the holder persists and takes over without
+// reading them again.
+func (m *Mesos) WatchCache() {
+	if m.config.CacheWatch {
+		m.watchOnce.Do(func() { go m.watchCache() })
+	}
+}
+
+// Replace the cache with the persisted entries values, before any
+// sync used it. Unreadable entries leave the cache to be loaded by the
+// first sync, which completes it from the catalog.
+func (m *Mesos) warmCache(values map[string][]byte) {
+	entries, skipped := decodeEntries(values)
+	if len(skipped) > 0 {
+		return
+	}
+
+	hclog.L().Debug("Warming cache", "key", m.cacheKey+"/")
+	m.ServiceCache = nil
+	m.mergeEntries(entries)
+	m.forgetUnwritten()
+	m.savedCache = values
+	m.legacyCache = false
+	m.warm = true
+}
+
+// The KV key of the cache of the Mesos cluster named cluster, after
+// the --cluster flag of its masters: <kv-prefix>/clusters/<cluster>/cache,
+// so an instance pointed at another cluster by mistake cannot take
+// over the cache of this one. Unnamed clusters keep <kv-prefix>/cache.
+func (m *Mesos) clusterCacheKey(cluster string) string {
+	if cluster == "" {
+		return m.config.KVPrefix + "/cache"
+	}
+
+	return m.config.KVPrefix + "/clusters/" + url.PathEscape(cluster) + "/cache"
+}
+
+// CheckRegistration verifies that the registry accepts registrations
+// and the writes of the service cache, e.g. that the --registry-token
+// has write access to the key prefix of the cache, by writing and
+// deleting a key next to it. Before the first sync the Mesos cluster
+// is not known yet, so the cache of the unnamed one is tried, see
+// clusterCacheKey().
+func (m *Mesos) CheckRegistration(ctx context.Context) error {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	if err := m.Registry.CheckRegistration(ctx); err != nil {
+		return err
+	}
+
+	key := m.clusterCacheKey(m.mesosCluster) + "-preflight"
+	if err := m.Registry.Put(ctx, key, []byte("mesos-consul")); err != nil {
+		return fmt.Errorf("registry token cannot write %s (check its ACL permissions): %v", key, err)
+	}
+	if err := m.Registry.Txn(ctx, nil, []string{key}); err != nil {
+		return fmt.Errorf("registry token cannot delete %s (check its ACL permissions): %v", key, err)
+	}
+
+	return nil
+}
+
+// Pick the cache of the Mesos cluster of the state before it is
+// loaded, and refuse the states of any other cluster once it is, so
+// its services are not deregistered as gone
+func (m *Mesos) selectCluster(cluster string) error {
+	// A cache warmed from another cluster's key is of no use
+	if m.warm && cluster != m.mesosCluster {
+		m.ServiceCache = nil
+		m.savedCache = nil
+		m.warm = false
+	}
+
+	if m.ServiceCache == nil {
+		m.mesosCluster = cluster
+		m.cacheKey = m.clusterCacheKey(cluster)
+		return nil
+	}
+
+	if cluster != m.mesosCluster {
+		return fmt.Errorf("the state is of Mesos cluster %q, not %q: refusing to sync it into %s/", cluster, m.mesosCluster, m.cacheKey)
+	}
+
+	return nil
+}
+
+// Sort persisted entries by datacenter and service ID
+type byKey []CachedService
+
+func (s byKey) Len() int      { return len(s) }
+func (s byKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byKey) Less(i, j int) bool {
+	if s[i].Datacenter != s[j].Datacenter {
+		return s[i].Datacenter < s[j].Datacenter
+	}
+	return s[i].Service.ID < s[j].Service.ID
+}
+
+// Drop the cache so the next Refresh loads it again, e.g. after taking
+// over from another instance that kept the persisted cache up to date.
+// A cache warmed by the --cache-watch watcher is kept, see warmCache().
+func (m *Mesos) ResetCache() {
+	m.cacheLock.Lock()
+	defer m.unlockCache()
+
+	// The watcher kept a warm cache up to date with the holder's
+	if m.warm {
+		return
+	}
+
+	m.ServiceCache = nil
+	m.savedCache = nil
+	m.legacyCache = false
+}
+
+// With --service-files-dir, forget the cached services of the local
+// datacenter without a definition file, e.g. cached before the files
+// were written, so the sync registers them again and writes it
+func (m *Mesos) forgetUnwritten() {
+	if m.serviceFiles == nil {
+		return
+	}
+
+	for key, b := range m.ServiceCache {
+		if key.Datacenter != "" {
+			continue
+		}
+		if _, err := os.Stat(m.serviceFiles.Path(b.agent, key.ID)); os.IsNotExist(err) {
+			hclog.L().Debug("No service file", "service_id", key.ID)
+			delete(m.ServiceCache, key)
+		}
+	}
+}
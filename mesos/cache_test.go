@@ -0,0 +1,322 @@
+package mesos
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestMergeCache(t *testing.T) {
+	a := ServiceKey{"mesos-consul:a", localDatacenter}
+
+	m := &Mesos{
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			a: {
+				service:      &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Port: 1},
+				isRegistered: true,
+			},
+		},
+	}
+
+	m.mergeCache([]byte(`[{"service":{"ID":"mesos-consul:a","Port":2}},{"service":{"ID":"mesos-consul:b","Name":"b"}},{"datacenter":"dc2","service":{"ID":"mesos-consul:a"}}]`))
+
+	if e := m.ServiceCache[a]; e.service.Port != 1 || !e.isRegistered {
+		t.Errorf("expected existing entry to be kept, got %+v", e.service)
+	}
+
+	b, ok := m.ServiceCache[ServiceKey{"mesos-consul:b", localDatacenter}]
+	if !ok {
+		t.Fatal("expected missing entry to be merged")
+	}
+	if b.service.Name != "b" || b.isRegistered {
+		t.Errorf("unexpected merged entry: %+v registered=%v", b.service, b.isRegistered)
+	}
+
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:a", "dc2"}]; !ok {
+		t.Error("expected the same ID in another datacenter to be merged separately")
+	}
+
+	// Unreadable caches are ignored
+	m.mergeCache([]byte(`{`))
+	if len(m.ServiceCache) != 3 {
+		t.Errorf("expected 3 entries, got %d", len(m.ServiceCache))
+	}
+}
+
+func TestCachedServices(t *testing.T) {
+	m := &Mesos{
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:b", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:b"}},
+			{"mesos-consul:a", "dc2"}:           {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}},
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}, agent: "10.0.0.1"},
+		},
+	}
+
+	services := m.CachedServices()
+	if len(services) != 3 {
+		t.Fatalf("expected 3 services, got %d", len(services))
+	}
+
+	got := []string{services[0].Service.ID + "@" + services[0].Datacenter, services[1].Service.ID + "@" + services[1].Datacenter, services[2].Service.ID + "@" + services[2].Datacenter}
+	want := []string{"mesos-consul:a@", "mesos-consul:b@", "mesos-consul:a@dc2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+			break
+		}
+	}
+
+	if services[0].Agent != "10.0.0.1" {
+		t.Errorf("expected the agent to be returned, got %q", services[0].Agent)
+	}
+}
+
+// A registry keeping its KV store in memory
+type kvRegistry struct {
+	*fakeRegistry
+	kv map[string][]byte
+}
+
+func (r *kvRegistry) Get(ctx context.Context, key string, _ uint64, _ time.Duration) ([]byte, uint64, error) {
+	return r.kv[key], 0, nil
+}
+
+func (r *kvRegistry) Put(ctx context.Context, key string, value []byte) error {
+	r.kv[key] = value
+	return nil
+}
+
+func (r *kvRegistry) List(ctx context.Context, prefix string, _ uint64, _ time.Duration) (map[string][]byte, uint64, error) {
+	values := make(map[string][]byte)
+	for key, value := range r.kv {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, 0, nil
+}
+
+func (r *kvRegistry) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	for key, value := range puts {
+		r.kv[key] = value
+	}
+	for _, key := range deletes {
+		delete(r.kv, key)
+	}
+	return nil
+}
+
+func TestSaveCacheEntries(t *testing.T) {
+	r := &kvRegistry{newFakeRegistry(), map[string][]byte{}}
+	a := ServiceKey{"mesos-consul:a", localDatacenter}
+	b := ServiceKey{"mesos-consul:b/1", "dc2"}
+
+	m := &Mesos{
+		Registry: r,
+		cacheKey: "mesos-consul/cache",
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			a: {service: &consulapi.AgentServiceRegistration{ID: a.ID}, agent: "10.0.0.1"},
+			b: {service: &consulapi.AgentServiceRegistration{ID: b.ID}},
+		},
+	}
+
+	m.saveCache()
+	for _, key := range []string{"mesos-consul/cache/mesos-consul:a", "mesos-consul/cache/dc2/mesos-consul:b%2F1"} {
+		if _, ok := r.kv[key]; !ok {
+			t.Errorf("expected %s to be written, got %v", key, r.kv)
+		}
+	}
+
+	delete(m.ServiceCache, b)
+	m.saveCache()
+	if len(r.kv) != 1 {
+		t.Errorf("expected the removed entry to be deleted, got %v", r.kv)
+	}
+
+	loaded := &Mesos{Registry: r, config: config.DefaultConfig(), cacheKey: m.cacheKey, ServiceCache: map[ServiceKey]*CacheEntry{}}
+	if ok, err := loaded.loadKVCache(); !ok || err != nil {
+		t.Fatalf("expected the cache to load, got %v, %v", ok, err)
+	}
+	if e, ok := loaded.ServiceCache[a]; !ok || e.agent != "10.0.0.1" {
+		t.Errorf("expected the saved entry to load, got %v", loaded.ServiceCache)
+	}
+}
+
+func TestLoadLegacyCache(t *testing.T) {
+	r := &kvRegistry{newFakeRegistry(), map[string][]byte{
+		"mesos-consul/cache": []byte(`[{"service":{"ID":"mesos-consul:a"}}]`),
+	}}
+
+	m := &Mesos{Registry: r, config: config.DefaultConfig(), cacheKey: "mesos-consul/cache", ServiceCache: map[ServiceKey]*CacheEntry{}}
+	if ok, err := m.loadKVCache(); !ok || err != nil {
+		t.Fatalf("expected the single value to load, got %v, %v", ok, err)
+	}
+
+	m.saveCache()
+	if _, ok := r.kv["mesos-consul/cache"]; ok {
+		t.Error("expected the single value to be deleted")
+	}
+	if _, ok := r.kv["mesos-consul/cache/mesos-consul:a"]; !ok {
+		t.Errorf("expected the entry to be migrated, got %v", r.kv)
+	}
+}
+
+func TestLoadCacheVersions(t *testing.T) {
+	r := &kvRegistry{newFakeRegistry(), map[string][]byte{
+		"mesos-consul/cache/mesos-consul:a": []byte(`{"service":{"ID":"mesos-consul:a","Address":"10.0.0.1"}}`),
+		"mesos-consul/cache/mesos-consul:b": []byte(`{"version":3,"service":"mesos-consul:b"}`),
+		"mesos-consul/cache/mesos-consul:c": []byte(`{"service":`),
+	}}
+	r.services = []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:a", Address: "10.0.0.9"},
+		{ID: "mesos-consul:c", Address: "10.0.0.3"},
+	}
+
+	m := &Mesos{Registry: r, config: config.DefaultConfig(), cacheKey: "mesos-consul/cache", ServiceCache: map[ServiceKey]*CacheEntry{}}
+	if ok, err := m.loadKVCache(); !ok || err != nil {
+		t.Fatalf("expected the cache to load, got %v, %v", ok, err)
+	}
+
+	if e := m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}]; e == nil || e.agent != "10.0.0.1" {
+		t.Errorf("expected the version 1 entry to be migrated, got %+v", e)
+	}
+	if e := m.ServiceCache[ServiceKey{"mesos-consul:c", localDatacenter}]; e == nil || e.agent != "10.0.0.3" {
+		t.Errorf("expected the unreadable entry to be completed from the catalog, got %+v", e)
+	}
+
+	m.saveCache()
+	if !strings.Contains(string(r.kv["mesos-consul/cache/mesos-consul:a"]), `"version":2`) {
+		t.Errorf("expected the migrated entry to be rewritten, got %s", r.kv["mesos-consul/cache/mesos-consul:a"])
+	}
+	if string(r.kv["mesos-consul/cache/mesos-consul:b"]) != `{"version":3,"service":"mesos-consul:b"}` {
+		t.Errorf("expected the entry of a newer release to be kept, got %s", r.kv["mesos-consul/cache/mesos-consul:b"])
+	}
+}
+
+// A kvRegistry recording whether its lists may be stale
+type staleRegistry struct {
+	*kvRegistry
+	stale []bool
+}
+
+func (r *staleRegistry) List(ctx context.Context, prefix string, index uint64, wait time.Duration) (map[string][]byte, uint64, error) {
+	r.stale = append(r.stale, registry.Stale(ctx))
+	return r.kvRegistry.List(ctx, prefix, index, wait)
+}
+
+func TestStaleCacheLoad(t *testing.T) {
+	for _, tt := range []struct {
+		allowStale bool
+		lock       string
+		want       bool
+	}{
+		{false, "", false},
+		{true, "", true},
+		{true, "mesos-consul/lock", false},
+	} {
+		r := &staleRegistry{kvRegistry: &kvRegistry{newFakeRegistry(), map[string][]byte{
+			"mesos-consul/cache/mesos-consul:a": []byte(`{"service":{"ID":"mesos-consul:a"}}`),
+		}}}
+		c := config.DefaultConfig()
+		c.ConsulAllowStale = tt.allowStale
+		c.Lock = tt.lock
+
+		m := &Mesos{Registry: r, config: c, cacheKey: "mesos-consul/cache", ServiceCache: map[ServiceKey]*CacheEntry{}}
+		if ok, err := m.loadKVCache(); !ok || err != nil {
+			t.Fatalf("expected the cache to load, got %v, %v", ok, err)
+		}
+		if len(r.stale) != 1 || r.stale[0] != tt.want {
+			t.Errorf("allow-stale %v, lock %q: expected a stale read %v, got %v", tt.allowStale, tt.lock, tt.want, r.stale)
+		}
+	}
+}
+
+func TestSelectCluster(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	if err := m.selectCluster(""); err != nil || m.cacheKey != "mesos-consul/cache" {
+		t.Errorf("expected unnamed clusters to keep the cache key, got %q, %v", m.cacheKey, err)
+	}
+
+	if err := m.selectCluster("prod/eu"); err != nil || m.cacheKey != "mesos-consul/clusters/prod%2Feu/cache" {
+		t.Errorf("expected the cache key of the cluster, got %q, %v", m.cacheKey, err)
+	}
+
+	m.ServiceCache = map[ServiceKey]*CacheEntry{}
+	if err := m.selectCluster("prod/eu"); err != nil {
+		t.Errorf("expected the states of the cluster to be synced, got %v", err)
+	}
+	for _, other := range []string{"staging", ""} {
+		if err := m.selectCluster(other); err == nil {
+			t.Errorf("expected the state of cluster %q to be refused", other)
+		}
+	}
+	if m.cacheKey != "mesos-consul/clusters/prod%2Feu/cache" {
+		t.Errorf("expected the cache key to stay, got %q", m.cacheKey)
+	}
+}
+
+// A registry whose token may only write the keys under prefix
+type aclRegistry struct {
+	*kvRegistry
+	prefix string
+}
+
+func (r *aclRegistry) Put(ctx context.Context, key string, value []byte) error {
+	if !strings.HasPrefix(key, r.prefix) {
+		return errors.New("Unexpected response code: 403 (Permission denied)")
+	}
+	return r.kvRegistry.Put(ctx, key, value)
+}
+
+func TestCheckRegistrationCache(t *testing.T) {
+	r := &aclRegistry{kvRegistry: &kvRegistry{fakeRegistry: newFakeRegistry(), kv: map[string][]byte{}}, prefix: "mesos-consul/cache"}
+	m := &Mesos{Registry: r, config: config.DefaultConfig()}
+
+	if err := m.CheckRegistration(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.kv) != 0 {
+		t.Errorf("expected the preflight key to be deleted, got %v", r.kv)
+	}
+
+	r.prefix = "mesos-consul/maintenance"
+	if err := m.CheckRegistration(context.Background()); err == nil || !strings.Contains(err.Error(), "mesos-consul/cache-preflight") {
+		t.Errorf("expected a token unable to write the cache to fail, got %v", err)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	a := ServiceKey{"mesos-consul:a", localDatacenter}
+	values := map[string][]byte{
+		"mesos-consul/cache/mesos-consul:a": []byte(`{"version":2,"service":{"ID":"mesos-consul:a"},"agent":"10.0.0.1"}`),
+	}
+
+	// The watcher merging after a reset
+	m := &Mesos{config: config.DefaultConfig(), cacheKey: "mesos-consul/cache"}
+	m.ResetCache()
+	m.mergeEntries([]CachedService{{Service: &consulapi.AgentServiceRegistration{ID: a.ID}}})
+	if _, ok := m.ServiceCache[a]; !ok {
+		t.Errorf("expected the entry to be merged into an empty cache, got %v", m.ServiceCache)
+	}
+
+	standby := &Mesos{config: config.DefaultConfig(), cacheKey: "mesos-consul/cache"}
+	standby.warmCache(values)
+	standby.ResetCache()
+	if e, ok := standby.ServiceCache[a]; !ok || e.agent != "10.0.0.1" {
+		t.Fatalf("expected the warm cache to be kept on takeover, got %v", standby.ServiceCache)
+	}
+	if err := standby.selectCluster(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := standby.selectCluster("other"); err != nil || standby.ServiceCache != nil {
+		t.Errorf("expected the cache warmed from another cluster to be dropped, got %v, %v", err, standby.ServiceCache)
+	}
+}
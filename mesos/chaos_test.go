@@ -0,0 +1,164 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A step of a fault scenario: the faults injected before a sync, and
+// the task services Consul holds after it
+type chaosStep struct {
+	name    string
+	fault   func(h *harness)
+	wantErr bool
+	want    []string
+}
+
+func TestChaos(t *testing.T) {
+	healthy := []string{"db@10.0.0.1:31001", "web@10.0.0.1:31000"}
+
+	tests := []struct {
+		name    string
+		masters int
+		steps   []chaosStep
+	}{
+		{
+			name:    "master failover",
+			masters: 3,
+			steps: []chaosStep{
+				{name: "healthy", want: healthy},
+				{
+					name: "leader gone, another elected",
+					fault: func(h *harness) {
+						h.masters[0].set(func(fm *fakeMaster) { fm.down = true })
+						h.elect(1)
+					},
+					want: healthy,
+				},
+				{
+					name: "leader gone before Zookeeper notices",
+					fault: func(h *harness) {
+						h.masters[1].set(func(fm *fakeMaster) { fm.down = true })
+					},
+					want: healthy,
+				},
+				{
+					name: "every master gone",
+					fault: func(h *harness) {
+						h.masters[2].set(func(fm *fakeMaster) { fm.down = true })
+						h.kill("db")
+					},
+					wantErr: true,
+					want:    healthy,
+				},
+				{
+					name: "masters back",
+					fault: func(h *harness) {
+						h.masters[2].set(func(fm *fakeMaster) { fm.down = false })
+						h.elect(2)
+					},
+					want: []string{"web@10.0.0.1:31000"},
+				},
+			},
+		},
+		{
+			name:    "partial state",
+			masters: 1,
+			steps: []chaosStep{
+				{name: "healthy", want: healthy},
+				{
+					name: "state cut off",
+					fault: func(h *harness) {
+						h.masters[0].set(func(fm *fakeMaster) { fm.truncated = true })
+						h.kill("web")
+					},
+					wantErr: true,
+					want:    healthy,
+				},
+				{
+					name: "state whole again",
+					fault: func(h *harness) {
+						h.masters[0].set(func(fm *fakeMaster) { fm.truncated = false })
+					},
+					want: []string{"db@10.0.0.1:31001"},
+				},
+			},
+		},
+		{
+			name:    "consul outage",
+			masters: 1,
+			steps: []chaosStep{
+				{name: "healthy", want: healthy},
+				{
+					name: "no Consul leader",
+					fault: func(h *harness) {
+						h.registry.setDown(true)
+						h.run("api", 31002)
+						h.kill("db")
+					},
+					wantErr: true,
+					want:    healthy,
+				},
+				{
+					// The deregistrations that failed are left to the
+					// reconciliation
+					name: "Consul back",
+					fault: func(h *harness) {
+						h.registry.setDown(false)
+						h.mesos.config.ReconcileInterval = time.Nanosecond
+					},
+					want: []string{"api@10.0.0.1:31002", "web@10.0.0.1:31000"},
+				},
+			},
+		},
+		{
+			name:    "cache corruption",
+			masters: 1,
+			steps: []chaosStep{
+				{name: "healthy", want: healthy},
+				{
+					name: "restart with a corrupt cache after a task ended",
+					fault: func(h *harness) {
+						h.kill("db")
+						h.registry.do(func() {
+							for key := range h.registry.kv {
+								h.registry.kv[key] = []byte("{garbage")
+							}
+						})
+						h.restart()
+					},
+					want: []string{"web@10.0.0.1:31000"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, tt.masters)
+			h.run("web", 31000)
+			h.run("db", 31001)
+
+			for _, step := range tt.steps {
+				if step.fault != nil {
+					step.fault(h)
+				}
+
+				// A service of a task gone is kept for a sync, see
+				// --deregister-delay
+				err := h.sync()
+				if err == nil {
+					err = h.sync()
+				}
+				if (err != nil) != step.wantErr {
+					t.Fatalf("%s: expected an error %v, got %v", step.name, step.wantErr, err)
+				}
+
+				if got := h.taskServices(); !reflect.DeepEqual(got, step.want) {
+					t.Fatalf("%s: expected %v in Consul, got %v", step.name, step.want, got)
+				}
+			}
+		})
+	}
+}
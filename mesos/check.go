@@ -0,0 +1,433 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Task labels configuring checks
+const (
+	// A command to run inside the task's Docker container
+	dockerExecLabel = "check-docker-exec"
+
+	// A command for the Consul agent to run with /bin/sh
+	scriptLabel = "consul_check_script"
+
+	// Register the task without any check when "true", even with
+	// --default-check
+	noCheckLabel   = "no-check"
+	checkSkipLabel = "consul_check_skip"
+
+	// An HTTP path to check
+	httpLabel = "consul_check_http"
+
+	// Content the consul_check_http response must contain
+	expectBodyLabel = "check-expect-body"
+
+	// A TCP check of the task's port when "true"
+	tcpLabel = "consul_check_tcp"
+
+	// A gRPC health check of the task's port when "true", over TLS
+	// when grpcTLSLabel is "true" too
+	grpcLabel    = "consul_check_grpc"
+	grpcTLSLabel = "consul_check_grpc_tls"
+
+	// The interval of the task's check, e.g. 5s
+	intervalLabel = "consul_check_interval"
+
+	// How long the task's check may stay critical before Consul
+	// deregisters the service, e.g. 10m
+	deregisterCriticalLabel = "check-deregister-critical-after"
+)
+
+// The names the check labels had before following the consul_check_
+// scheme, still read when the new name is not set
+var checkLabelAliases = map[string]string{
+	httpLabel:     "check-http",
+	tcpLabel:      "check-tcp",
+	intervalLabel: "check-interval",
+}
+
+// The value of the check label key, or else of its older alias
+func (t *Task) checkLabel(key string) string {
+	if v := t.label(key); v != "" {
+		return v
+	}
+
+	return t.label(checkLabelAliases[key])
+}
+
+// Build the Consul check for a task service from the task's labels,
+// or nil when the task asks for none. Checks against the network
+// target address and port.
+func (m *Mesos) taskCheck(name string, task *Task, address string, port int) *consulapi.AgentServiceCheck {
+	check := m.taskProbe(name, task, address, port)
+	if check != nil {
+		check.DeregisterCriticalServiceAfter = m.deregisterCriticalAfter(task)
+	}
+
+	return check
+}
+
+// The check of a task service, without the options common to every
+// kind of check
+func (m *Mesos) taskProbe(name string, task *Task, address string, port int) *consulapi.AgentServiceCheck {
+	if task.label(noCheckLabel) == "true" || task.label(checkSkipLabel) == "true" {
+		return nil
+	}
+
+	if m.noCheck != nil && m.noCheck.MatchString(name) {
+		return nil
+	}
+
+	if m.config.CheckMode == config.CheckModeTTL {
+		return m.ttlCheck(task)
+	}
+
+	// Mirror the Mesos health check rather than probing the task twice
+	if _, ok := taskHealthy(task); ok && m.config.CheckMode == config.CheckModeMesos {
+		return m.ttlCheck(task)
+	}
+
+	interval := m.checkInterval(task, time.Now())
+
+	if cmd := task.label(dockerExecLabel); cmd != "" {
+		id := containerID(task)
+		if id == "" {
+			hclog.L().Warn("No container ID. Skipping check", append(task.logFields(), "label", dockerExecLabel)...)
+			return nil
+		}
+
+		// Mesos names the Docker containers it launches
+		// mesos-<container id>
+		return &consulapi.AgentServiceCheck{
+			DockerContainerID: fmt.Sprintf("mesos-%s", id),
+			Shell:             "/bin/sh",
+			Args:              []string{"/bin/sh", "-c", cmd},
+			Interval:          interval,
+		}
+	}
+
+	if cmd := task.label(scriptLabel); cmd != "" {
+		return &consulapi.AgentServiceCheck{
+			Args:     []string{"/bin/sh", "-c", cmd},
+			Interval: interval,
+		}
+	}
+
+	// Nothing but the script checks above can probe a UDP port
+	if port != 0 && task.nonTCPPort(port) {
+		hclog.L().Debug("Port not served over TCP. Skipping check", append(task.logFields(), "port", port)...)
+		return nil
+	}
+
+	if path := task.checkLabel(httpLabel); path != "" || task.label(expectBodyLabel) != "" {
+		if port == 0 {
+			hclog.L().Warn("No port. Skipping check", append(task.logFields(), "label", httpLabel)...)
+			return nil
+		}
+
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		url := fmt.Sprintf("http://%s%s", net.JoinHostPort(address, strconv.Itoa(port)), path)
+
+		// Consul cannot match the body of an HTTP check, so fetch
+		// it with a script check instead
+		if expect := task.label(expectBodyLabel); expect != "" {
+			return &consulapi.AgentServiceCheck{
+				Args:     []string{"/bin/sh", "-c", fmt.Sprintf("curl -sf %s | grep -qF -- %s", shellQuote(url), shellQuote(expect))},
+				Interval: interval,
+			}
+		}
+
+		return &consulapi.AgentServiceCheck{
+			HTTP:     url,
+			Interval: interval,
+		}
+	}
+
+	if task.label(grpcLabel) == "true" {
+		if port == 0 {
+			hclog.L().Warn("No port. Skipping check", append(task.logFields(), "label", grpcLabel)...)
+			return nil
+		}
+
+		return &consulapi.AgentServiceCheck{
+			GRPC:       net.JoinHostPort(address, strconv.Itoa(port)),
+			GRPCUseTLS: task.label(grpcTLSLabel) == "true",
+			Interval:   interval,
+		}
+	}
+
+	if task.checkLabel(tcpLabel) == "true" {
+		if port == 0 {
+			hclog.L().Warn("No port. Skipping check", append(task.logFields(), "label", tcpLabel)...)
+			return nil
+		}
+
+		return &consulapi.AgentServiceCheck{
+			TCP:      net.JoinHostPort(address, strconv.Itoa(port)),
+			Interval: interval,
+		}
+	}
+
+	return m.defaultCheck(address, port, interval)
+}
+
+// With --default-check, the check of a task service without check
+// labels, e.g. a TCP check of databases and queues serving no HTTP.
+// Services without a port get none.
+func (m *Mesos) defaultCheck(address string, port int, interval string) *consulapi.AgentServiceCheck {
+	if port == 0 {
+		return nil
+	}
+	target := net.JoinHostPort(address, strconv.Itoa(port))
+
+	switch m.config.DefaultCheck {
+	case config.DefaultCheckTCP:
+		return &consulapi.AgentServiceCheck{
+			TCP:      target,
+			Interval: interval,
+		}
+	case config.DefaultCheckHTTP:
+		return &consulapi.AgentServiceCheck{
+			HTTP:     "http://" + target + "/",
+			Interval: interval,
+		}
+	}
+
+	return nil
+}
+
+// Tell whether the check of a task runs a command on the Mesos agent
+// running it, so only the Consul agent there can run it
+func localCheck(task *Task) bool {
+	return task.label(dockerExecLabel) != "" || task.label(scriptLabel) != ""
+}
+
+// With --check-mode=ttl, or --check-mode=mesos for the tasks Mesos
+// health checks, a TTL check whose initial status is the task's health
+// as last reported by Mesos. Tasks without a Mesos health check pass
+// while running. The status is pushed again on every sync by
+// reportTTL.
+func (m *Mesos) ttlCheck(task *Task) *consulapi.AgentServiceCheck {
+	status, note := consulapi.HealthPassing, "task running, no Mesos health check"
+	if healthy, ok := taskHealthy(task); ok {
+		if healthy {
+			note = "Mesos reports the task healthy"
+		} else {
+			status, note = consulapi.HealthCritical, "Mesos reports the task unhealthy"
+		}
+	}
+
+	return &consulapi.AgentServiceCheck{
+		TTL:    fmt.Sprintf("%ds", int(3*m.config.Refresh.Seconds())),
+		Status: status,
+		Notes:  note,
+	}
+}
+
+// Push the status of the TTL check of a task service registered with
+// --check-mode=ttl or --check-mode=mesos to the agent it was
+// registered with
+func (m *Mesos) reportTTL(agent string, s *consulapi.AgentServiceRegistration) {
+	if m.config.CheckMode == config.CheckModeAgent || s.Check == nil || s.Check.TTL == "" {
+		return
+	}
+
+	err := m.Registry.UpdateTTL(m.syncCtx(), agent, "service:"+s.ID, s.Check.Status == consulapi.HealthPassing, s.Check.Notes)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Error("Unable to update the TTL check", "service_id", s.ID, "agent", agent, "error", err)
+	}
+}
+
+// With --check-context, add the context of task, running on the agent
+// of hostname, to the notes of check, which its TTL updates also push
+// as their output, so the checks of a task tell where to look when
+// they fail. The notes are left out of the comparison of
+// registrations, see sameCheck, so a new status message does not
+// register the service again.
+func (m *Mesos) withCheckContext(check *consulapi.AgentServiceCheck, task *Task, hostname string) *consulapi.AgentServiceCheck {
+	if !m.config.CheckContext || check == nil {
+		return check
+	}
+
+	check.Notes = withContext(check.Notes, checkContext(task.Id, hostname, taskTermination(*task, task.State)))
+	return check
+}
+
+// The context of the checks of task ID on the agent of hostname: its
+// ID, agent and latest status, e.g. "task web.1 on host-1, last status
+// TASK_RUNNING: Container started"
+func checkContext(taskID string, hostname string, end termination) string {
+	context := "task " + taskID
+	if hostname != "" {
+		context += " on " + hostname
+	}
+
+	status := end.State
+	if end.Reason != "" {
+		status += " " + end.Reason
+	}
+	if end.Message != "" {
+		status += ": " + end.Message
+	}
+	if status = strings.TrimSpace(status); status != "" {
+		context += ", last status " + status
+	}
+
+	return context
+}
+
+// The notes note with context appended
+func withContext(note string, context string) string {
+	if note == "" {
+		return context
+	}
+	return note + " (" + context + ")"
+}
+
+// With --require-healthy, tell whether a task Mesos health checks
+// passed its latest check. Tasks Mesos does not health check are ready
+// once running, as they are without the flag.
+func (m *Mesos) taskReady(task *Task) bool {
+	if !m.config.RequireHealthy {
+		return true
+	}
+
+	healthy, ok := taskHealthy(task)
+	return healthy || !ok
+}
+
+// Return the health of the most recent status of a task carrying one,
+// or false when Mesos does not health check the task
+func taskHealthy(task *Task) (healthy bool, ok bool) {
+	for i := len(task.Statuses) - 1; i >= 0; i-- {
+		if h := task.Statuses[i].Healthy; h != nil {
+			return *h, true
+		}
+	}
+
+	return false, false
+}
+
+// The check interval of a task, as set by its consul_check_interval label or
+// 10s. With --adaptive-check-interval it otherwise starts at
+// --check-interval-min and doubles as the task's uptime grows, so that
+// the task has been checked at least ten times at the current
+// interval, up to --check-interval-max. With
+// --check-interval-instances it doubles again for every doubling of
+// the running instances of the task's service past that number, within
+// the same bounds. Stepping in powers of two keeps the interval stable
+// between syncs.
+func (m *Mesos) checkInterval(task *Task, now time.Time) string {
+	if v := task.checkLabel(intervalLabel); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d.String()
+		}
+		hclog.L().Warn("Ignoring invalid label", append(task.logFields(), "label", intervalLabel, "value", v)...)
+	}
+
+	min, max := m.config.CheckIntervalMin, m.config.CheckIntervalMax
+
+	interval := 10 * time.Second
+	if m.config.AdaptiveCheckInterval {
+		interval = min
+		if started, ok := runningSince(task); ok {
+			uptime := now.Sub(started)
+			for interval*2 <= max && interval*2*10 <= uptime {
+				interval *= 2
+			}
+		}
+	}
+
+	if n := m.config.CheckIntervalInstances; n > 0 {
+		for count := m.instanceCounts[task.Id]; count > n && interval < max; count = (count + 1) / 2 {
+			interval *= 2
+		}
+		if interval < min {
+			interval = min
+		}
+		if interval > max {
+			interval = max
+		}
+	}
+
+	return interval.String()
+}
+
+// Count the running tasks of every task service, by the IDs of the
+// tasks, for --check-interval-instances
+func countInstances(names map[*Task]string) map[string]int {
+	running := make(map[string]int)
+	for task, name := range names {
+		if task.State == "TASK_RUNNING" {
+			running[name]++
+		}
+	}
+
+	counts := make(map[string]int, len(names))
+	for task, name := range names {
+		counts[task.Id] = running[name]
+	}
+
+	return counts
+}
+
+// How long the check of a task may stay critical before Consul
+// deregisters its service, as set by its
+// check-deregister-critical-after label or --deregister-critical-after.
+// Empty keeps critical services registered.
+func (m *Mesos) deregisterCriticalAfter(task *Task) string {
+	if v := task.label(deregisterCriticalLabel); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d.String()
+		}
+		hclog.L().Warn("Ignoring invalid label", append(task.logFields(), "label", deregisterCriticalLabel, "value", v)...)
+	}
+
+	if m.config.DeregisterCriticalAfter <= 0 {
+		return ""
+	}
+
+	return m.config.DeregisterCriticalAfter.String()
+}
+
+// Return when a task first reported TASK_RUNNING
+func runningSince(task *Task) (time.Time, bool) {
+	for _, status := range task.Statuses {
+		if status.State == "TASK_RUNNING" && status.Timestamp > 0 {
+			sec := int64(status.Timestamp)
+			nsec := int64((status.Timestamp - float64(sec)) * 1e9)
+			return time.Unix(sec, nsec), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Quote s for /bin/sh
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Return the Mesos container ID of the most recent status carrying one
+func containerID(task *Task) string {
+	for i := len(task.Statuses) - 1; i >= 0; i-- {
+		if id := task.Statuses[i].ContainerStatus.ContainerID.Value; id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
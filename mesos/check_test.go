@@ -0,0 +1,395 @@
+package mesos
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestTaskCheckDockerExec(t *testing.T) {
+	task := &Task{
+		Id:     "web.1",
+		Labels: []Label{{Key: "check-docker-exec", Value: "/healthcheck.sh"}},
+		Statuses: []Status{
+			{State: "TASK_RUNNING", ContainerStatus: ContainerStatus{ContainerID: ContainerID{Value: "abc"}}},
+		},
+	}
+
+	m := &Mesos{config: config.DefaultConfig()}
+
+	check := m.taskCheck("web", task, "10.0.0.1", 31000)
+	if check == nil {
+		t.Fatal("expected a docker exec check")
+	}
+
+	if check.DockerContainerID != "mesos-abc" {
+		t.Errorf("unexpected container: %s", check.DockerContainerID)
+	}
+	if len(check.Args) != 3 || check.Args[2] != "/healthcheck.sh" {
+		t.Errorf("unexpected args: %v", check.Args)
+	}
+
+	// No container to exec into
+	task.Statuses = nil
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected no check without a container ID, got %v", check)
+	}
+
+	if check := m.taskCheck("web", &Task{}, "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected no check without labels, got %v", check)
+	}
+}
+
+func TestTaskCheckNoCheck(t *testing.T) {
+	task := &Task{
+		Id:     "web.1",
+		Labels: []Label{{Key: "check-docker-exec", Value: "/healthcheck.sh"}},
+		Statuses: []Status{
+			{State: "TASK_RUNNING", ContainerStatus: ContainerStatus{ContainerID: ContainerID{Value: "abc"}}},
+		},
+	}
+
+	m := &Mesos{config: config.DefaultConfig(), noCheck: regexp.MustCompile("^batch-")}
+
+	if check := m.taskCheck("batch-import", task, "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected --no-check-services to drop the check, got %v", check)
+	}
+
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil {
+		t.Error("expected a check for services not matching --no-check-services")
+	}
+
+	task.Labels = append(task.Labels, Label{Key: "no-check", Value: "true"})
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected the no-check label to drop the check, got %v", check)
+	}
+}
+
+func TestTaskCheckHTTP(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	task := &Task{Labels: []Label{{Key: "consul_check_http", Value: "status"}}}
+	check := m.taskCheck("web", task, "10.0.0.1", 31000)
+	if check == nil || check.HTTP != "http://10.0.0.1:31000/status" {
+		t.Errorf("unexpected HTTP check: %v", check)
+	}
+
+	task.Labels = append(task.Labels, Label{Key: "check-expect-body", Value: "it's OK"})
+	check = m.taskCheck("web", task, "10.0.0.1", 31000)
+	if check == nil || check.HTTP != "" {
+		t.Fatalf("expected a script check, got %v", check)
+	}
+
+	want := `curl -sf 'http://10.0.0.1:31000/status' | grep -qF -- 'it'\''s OK'`
+	if len(check.Args) != 3 || check.Args[2] != want {
+		t.Errorf("unexpected script check: %v", check.Args)
+	}
+
+	if check := m.taskCheck("web", task, "10.0.0.1", 0); check != nil {
+		t.Errorf("expected no check without a port, got %v", check)
+	}
+}
+
+func TestTaskCheckTCP(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	task := &Task{Labels: []Label{{Key: "consul_check_tcp", Value: "true"}, {Key: "consul_check_interval", Value: "5s"}}}
+	check := m.taskCheck("web", task, "10.0.0.1", 31000)
+	if check == nil || check.TCP != "10.0.0.1:31000" || check.Interval != "5s" {
+		t.Errorf("unexpected TCP check: %v", check)
+	}
+
+	// The labels of earlier releases are still read
+	old := &Task{Labels: []Label{{Key: "check-tcp", Value: "true"}, {Key: "check-interval", Value: "5s"}}}
+	if check := m.taskCheck("web", old, "10.0.0.1", 31000); check == nil || check.TCP != "10.0.0.1:31000" || check.Interval != "5s" {
+		t.Errorf("unexpected TCP check of the older labels: %v", check)
+	}
+
+	if check := m.taskCheck("web", task, "10.0.0.1", 0); check != nil {
+		t.Errorf("expected no check without a port, got %v", check)
+	}
+
+	task.Labels[1].Value = "soon"
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil || check.Interval != "10s" {
+		t.Errorf("expected the default interval for an invalid consul_check_interval, got %v", check)
+	}
+}
+
+func TestTaskCheckDefault(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+	m.config.DefaultCheck = config.DefaultCheckTCP
+
+	check := m.taskCheck("db", &Task{}, "10.0.0.1", 31000)
+	if check == nil || check.TCP != "10.0.0.1:31000" || check.Interval != "10s" {
+		t.Errorf("unexpected default TCP check: %v", check)
+	}
+
+	if check := m.taskCheck("db", &Task{}, "10.0.0.1", 0); check != nil {
+		t.Errorf("expected no default check without a port, got %v", check)
+	}
+
+	m.config.DefaultCheck = config.DefaultCheckHTTP
+	if check := m.taskCheck("web", &Task{}, "10.0.0.1", 31000); check == nil || check.HTTP != "http://10.0.0.1:31000/" {
+		t.Errorf("unexpected default HTTP check: %v", check)
+	}
+
+	// Check labels take precedence
+	task := &Task{Labels: []Label{{Key: "check-http", Value: "/healthz"}}}
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil || check.HTTP != "http://10.0.0.1:31000/healthz" {
+		t.Errorf("expected the check-http check, got %v", check)
+	}
+
+	task.Labels = []Label{{Key: "consul_check_skip", Value: "true"}}
+	if check := m.taskCheck("queue", task, "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected consul_check_skip to drop the default check, got %v", check)
+	}
+}
+
+func TestTaskCheckScript(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	task := &Task{Labels: []Label{{Key: "consul_check_script", Value: "app-cli status"}}}
+	check := m.taskCheck("web", task, "10.0.0.1", 0)
+	if check == nil || !reflect.DeepEqual(check.Args, []string{"/bin/sh", "-c", "app-cli status"}) || check.Interval != "10s" {
+		t.Errorf("unexpected script check: %v", check)
+	}
+}
+
+func TestTaskCheckGRPC(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	task := &Task{Labels: []Label{{Key: "consul_check_grpc", Value: "true"}}}
+	check := m.taskCheck("web", task, "10.0.0.1", 31000)
+	if check == nil || check.GRPC != "10.0.0.1:31000" || check.GRPCUseTLS || check.Interval != "10s" {
+		t.Errorf("unexpected gRPC check: %v", check)
+	}
+
+	task.Labels = append(task.Labels, Label{Key: "consul_check_grpc_tls", Value: "true"})
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil || !check.GRPCUseTLS {
+		t.Errorf("expected a gRPC check over TLS, got %v", check)
+	}
+
+	if check := m.taskCheck("web", task, "10.0.0.1", 0); check != nil {
+		t.Errorf("expected no check without a port, got %v", check)
+	}
+}
+
+func TestTaskCheckDeregisterCriticalAfter(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	task := &Task{Labels: []Label{{Key: "check-tcp", Value: "true"}}}
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check.DeregisterCriticalServiceAfter != "" {
+		t.Errorf("expected critical services to stay registered, got %s", check.DeregisterCriticalServiceAfter)
+	}
+
+	c.DeregisterCriticalAfter = 30 * time.Minute
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check.DeregisterCriticalServiceAfter != "30m0s" {
+		t.Errorf("expected the global setting, got %s", check.DeregisterCriticalServiceAfter)
+	}
+
+	task.Labels = append(task.Labels, Label{Key: "check-deregister-critical-after", Value: "5m"})
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check.DeregisterCriticalServiceAfter != "5m0s" {
+		t.Errorf("expected the label to override the global setting, got %s", check.DeregisterCriticalServiceAfter)
+	}
+
+	task.Labels[1].Value = "later"
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check.DeregisterCriticalServiceAfter != "30m0s" {
+		t.Errorf("expected the global setting for an invalid label, got %s", check.DeregisterCriticalServiceAfter)
+	}
+
+	c.CheckMode = config.CheckModeTTL
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check.TTL == "" || check.DeregisterCriticalServiceAfter != "30m0s" {
+		t.Errorf("expected TTL checks to be deregistered too, got %v", check)
+	}
+}
+
+func TestCheckInterval(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	started := time.Unix(1000, 0)
+	task := &Task{Statuses: []Status{
+		{State: "TASK_STAGING", Timestamp: 900},
+		{State: "TASK_RUNNING", Timestamp: 1000},
+	}}
+
+	if interval := m.checkInterval(task, started); interval != "10s" {
+		t.Errorf("expected the fixed interval by default, got %s", interval)
+	}
+
+	c.AdaptiveCheckInterval = true
+
+	tests := []struct {
+		uptime   time.Duration
+		interval string
+	}{
+		{0, "5s"},
+		{99 * time.Second, "5s"},
+		{100 * time.Second, "10s"},
+		{200 * time.Second, "20s"},
+		{400 * time.Second, "40s"},
+		{24 * time.Hour, "40s"},
+	}
+
+	for _, tt := range tests {
+		if interval := m.checkInterval(task, started.Add(tt.uptime)); interval != tt.interval {
+			t.Errorf("uptime %s: got %s, want %s", tt.uptime, interval, tt.interval)
+		}
+	}
+
+	if interval := m.checkInterval(&Task{}, started); interval != "5s" {
+		t.Errorf("expected the minimum without a running status, got %s", interval)
+	}
+}
+
+func TestTaskCheckTTL(t *testing.T) {
+	c := config.DefaultConfig()
+	c.CheckMode = config.CheckModeTTL
+	m := &Mesos{config: c}
+
+	healthy, unhealthy := true, false
+	task := &Task{
+		Labels: []Label{{Key: "check-http", Value: "/status"}},
+		Statuses: []Status{
+			{State: "TASK_RUNNING", Healthy: &healthy},
+			{State: "TASK_RUNNING", Healthy: &unhealthy},
+			{State: "TASK_RUNNING"},
+		},
+	}
+
+	check := m.taskCheck("web", task, "10.0.0.1", 31000)
+	if check == nil || check.HTTP != "" || check.TTL != "180s" || check.Status != "critical" {
+		t.Errorf("expected a critical TTL check, got %v", check)
+	}
+
+	task.Statuses = task.Statuses[:1]
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil || check.Status != "passing" {
+		t.Errorf("expected a passing TTL check, got %v", check)
+	}
+
+	if check := m.taskCheck("web", &Task{}, "10.0.0.1", 31000); check == nil || check.Status != "passing" {
+		t.Errorf("expected a passing TTL check without Mesos health, got %v", check)
+	}
+}
+
+func TestTaskCheckMesos(t *testing.T) {
+	c := config.DefaultConfig()
+	c.CheckMode = config.CheckModeMesos
+	m := &Mesos{config: c}
+
+	unhealthy := false
+	task := &Task{
+		Labels:   []Label{{Key: "check-http", Value: "/status"}},
+		Statuses: []Status{{State: "TASK_RUNNING", Healthy: &unhealthy}},
+	}
+
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil || check.HTTP != "" || check.TTL == "" || check.Status != "critical" {
+		t.Errorf("expected a critical TTL check mirroring Mesos, got %v", check)
+	}
+
+	task.Statuses = []Status{{State: "TASK_RUNNING"}}
+	if check := m.taskCheck("web", task, "10.0.0.1", 31000); check == nil || check.HTTP != "http://10.0.0.1:31000/status" {
+		t.Errorf("expected the label check without a Mesos health check, got %v", check)
+	}
+}
+
+func TestCheckContext(t *testing.T) {
+	c := config.DefaultConfig()
+	c.CheckMode = config.CheckModeTTL
+	m := &Mesos{config: c}
+
+	unhealthy := false
+	task := &Task{
+		Id:    "web.1",
+		State: "TASK_RUNNING",
+		Statuses: []Status{{
+			State:   "TASK_RUNNING",
+			Healthy: &unhealthy,
+			Reason:  "REASON_TASK_HEALTH_CHECK_STATUS_UPDATED",
+			Message: "Health check failed",
+		}},
+	}
+
+	if check := m.withCheckContext(m.taskCheck("web", task, "10.0.0.1", 31000), task, "agent-1"); check.Notes != "Mesos reports the task unhealthy" {
+		t.Errorf("expected the notes without context by default, got %q", check.Notes)
+	}
+
+	c.CheckContext = true
+	check := m.withCheckContext(m.taskCheck("web", task, "10.0.0.1", 31000), task, "agent-1")
+	want := "Mesos reports the task unhealthy (task web.1 on agent-1, last status TASK_RUNNING REASON_TASK_HEALTH_CHECK_STATUS_UPDATED: Health check failed)"
+	if check.Notes != want {
+		t.Errorf("expected %q, got %q", want, check.Notes)
+	}
+
+	c.CheckMode = config.CheckModeAgent
+	task.Labels = []Label{{Key: "check-tcp", Value: "true"}}
+	task.Statuses = nil
+	if check := m.withCheckContext(m.taskCheck("web", task, "10.0.0.1", 31000), task, ""); check.Notes != "task web.1, last status TASK_RUNNING" {
+		t.Errorf("expected the context as the notes of a TCP check, got %q", check.Notes)
+	}
+}
+
+func TestReportTTLAgent(t *testing.T) {
+	h := newHarness(t, 1)
+	h.mesos.config.CheckMode = config.CheckModeTTL
+	h.run("web", 31000)
+	if err := h.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := h.registry
+	for id, s := range r.services {
+		if s.service.Check == nil || s.service.Check.TTL == "" {
+			continue
+		}
+		if agent, ok := r.ttls["service:"+id]; !ok || agent != s.agent || agent == "" {
+			t.Errorf("expected the TTL of %s updated on its agent %q, got %q", id, s.agent, agent)
+		}
+	}
+	if len(r.ttls) == 0 {
+		t.Error("expected the TTL check of the task service to be updated")
+	}
+}
+
+func TestCheckIntervalInstances(t *testing.T) {
+	c := config.DefaultConfig()
+	c.CheckIntervalInstances = 50
+	m := &Mesos{config: c}
+
+	tests := []struct {
+		instances int
+		interval  string
+	}{
+		{1, "10s"},
+		{50, "10s"},
+		{51, "20s"},
+		{100, "20s"},
+		{101, "40s"},
+		{200, "40s"},
+		{201, "1m0s"},
+		{5000, "1m0s"},
+	}
+
+	task := &Task{Id: "web.1"}
+	for _, tt := range tests {
+		m.instanceCounts = map[string]int{"web.1": tt.instances}
+		if interval := m.checkInterval(task, time.Now()); interval != tt.interval {
+			t.Errorf("%d instances: got %s, want %s", tt.instances, interval, tt.interval)
+		}
+	}
+
+	names := map[*Task]string{
+		{Id: "web.1", State: "TASK_RUNNING"}: "web",
+		{Id: "web.2", State: "TASK_RUNNING"}: "web",
+		{Id: "web.3", State: "TASK_STAGING"}: "web",
+		{Id: "db.1", State: "TASK_RUNNING"}:  "db",
+	}
+	counts := countInstances(names)
+	if counts["web.1"] != 2 || counts["web.3"] != 2 || counts["db.1"] != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
@@ -0,0 +1,84 @@
+package mesos
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Cleanup removes everything this instance owns from Consul, e.g. to
+// retire it: the cached services, the services Consul holds for it
+// the cache does not know and its prepared queries. The cache is read
+// from Consul first when no sync did, and is left empty. It fails when
+// any removal failed.
+func (m *Mesos) Cleanup(ctx context.Context) error {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.cacheLock.Lock()
+	defer m.unlockCache()
+
+	// Clean up the cache of the Mesos cluster when no sync loaded it
+	if m.ServiceCache == nil {
+		if sj, _, err := m.fetchState(); err == nil {
+			m.selectCluster(sj.Cluster)
+		} else {
+			hclog.L().Warn("Unable to read the Mesos state. Cleaning up the cache", "key", m.cacheKey+"/", "error", err)
+		}
+	}
+	m.loadServiceCache()
+
+	m.beginSummary()
+	errors := 0
+	removed := func(err error) bool {
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Error("Unable to clean up", "error", err)
+			errors++
+		}
+		return err == nil
+	}
+
+	listCtx := m.inScopes(ctx)
+	cached := make(map[string]bool)
+	for key, b := range m.ServiceCache {
+		cached[key.ID] = true
+		hclog.L().Info("Deregistering", "service_id", key.ID)
+		if removed(m.applier().Deregister(ctx, Registration{key.Datacenter, b.agent, b.service})) {
+			m.applied(eventDeregister, reasonCleanup, b.agent, b.service)
+			delete(m.ServiceCache, key)
+		}
+	}
+
+	services, err := m.Registry.Services(listCtx, "mesos-consul:")
+	if removed(err) {
+		for _, s := range services {
+			if cached[s.ID] || !registry.OwnedBy(s.AgentServiceRegistration, m.config.InstanceID) {
+				continue
+			}
+			hclog.L().Info("Deregistering uncached service", "service_id", s.ID, "agent", s.Agent)
+			if removed(m.applier().Deregister(ctx, Registration{localDatacenter, s.Agent, s.AgentServiceRegistration})) {
+				m.applied(eventDeregister, reasonCleanup, s.Agent, s.AgentServiceRegistration)
+			}
+		}
+	}
+
+	queries, err := m.Registry.Queries(ctx)
+	if removed(err) {
+		for _, q := range queries {
+			hclog.L().Info("Deleting prepared query", "query", q.Name)
+			removed(m.Registry.DeleteQuery(ctx, q.ID))
+		}
+	}
+
+	m.saveCache()
+	m.endSummary(nil, errors)
+
+	if errors > 0 {
+		return fmt.Errorf("%d removals failed", errors)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package mesos
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestCleanup(t *testing.T) {
+	r := newFakeRegistry()
+	r.services = []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:cached", Address: "10.0.0.1"},
+		{ID: "mesos-consul:orphan", Address: "10.0.0.2"},
+		{ID: "mesos-consul:other", Address: "10.0.0.2", Meta: map[string]string{registry.InstanceMeta: "elsewhere"}},
+	}
+	r.nodes = map[string]string{"mesos-consul:orphan": "10.0.0.5"}
+	r.queries = []*consulapi.PreparedQueryDefinition{{ID: "q1", Name: "a"}, {ID: "q2", Name: "b"}}
+
+	c := config.DefaultConfig()
+	c.InstanceID = "mesos-consul"
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:cached", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:cached"}, agent: "10.0.0.3"},
+		},
+	}
+
+	if err := m.Cleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.deregistered) != 2 || r.deregistered["mesos-consul:cached"] != "10.0.0.3" || r.deregistered["mesos-consul:orphan"] != "10.0.0.5" {
+		t.Errorf("expected the cached service and the owned orphan, from the agent of its node, to be deregistered, got %v", r.deregistered)
+	}
+	if len(m.ServiceCache) != 0 {
+		t.Errorf("expected an empty cache, got %v", m.ServiceCache)
+	}
+	if len(r.queries) != 0 {
+		t.Errorf("expected the prepared queries to be deleted, got %v", r.queries)
+	}
+}
@@ -0,0 +1,62 @@
+package mesos
+
+import (
+	"fmt"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Detect task services claiming the same address and port, which
+// with host networking points at a scheduling bug, and apply the
+// --port-collision-policy. services must be sorted by ID so "first"
+// keeps the same service on every sync.
+func (m *Mesos) resolveCollisions(services []*consulapi.AgentServiceRegistration) []*consulapi.AgentServiceRegistration {
+	byEndpoint := make(map[string][]*consulapi.AgentServiceRegistration)
+	for _, s := range services {
+		if s.Port == 0 {
+			continue
+		}
+
+		endpoint := fmt.Sprintf("%s:%d", s.Address, s.Port)
+		byEndpoint[endpoint] = append(byEndpoint[endpoint], s)
+	}
+
+	drop := make(map[*consulapi.AgentServiceRegistration]bool)
+	for endpoint, claims := range byEndpoint {
+		if len(claims) < 2 {
+			continue
+		}
+
+		ids := make([]string, len(claims))
+		for i, s := range claims {
+			ids[i] = s.ID
+		}
+		hclog.L().Warn("Port collision", "endpoint", endpoint, "service_ids", ids, "policy", m.config.PortCollisionPolicy)
+
+		switch m.config.PortCollisionPolicy {
+		case config.CollisionFirst:
+			for _, s := range claims[1:] {
+				drop[s] = true
+			}
+		case config.CollisionSkip:
+			for _, s := range claims {
+				drop[s] = true
+			}
+		}
+	}
+
+	if len(drop) == 0 {
+		return services
+	}
+
+	kept := make([]*consulapi.AgentServiceRegistration, 0, len(services)-len(drop))
+	for _, s := range services {
+		if !drop[s] {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,46 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestResolveCollisions(t *testing.T) {
+	services := []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:a", Address: "10.0.0.1", Port: 31000},
+		{ID: "mesos-consul:b", Address: "10.0.0.1", Port: 31000},
+		{ID: "mesos-consul:c", Address: "10.0.0.1", Port: 31001},
+		{ID: "mesos-consul:d", Address: "10.0.0.1"},
+		{ID: "mesos-consul:e", Address: "10.0.0.1"},
+	}
+
+	tests := []struct {
+		policy string
+		ids    []string
+	}{
+		{config.CollisionAll, []string{"mesos-consul:a", "mesos-consul:b", "mesos-consul:c", "mesos-consul:d", "mesos-consul:e"}},
+		{config.CollisionFirst, []string{"mesos-consul:a", "mesos-consul:c", "mesos-consul:d", "mesos-consul:e"}},
+		{config.CollisionSkip, []string{"mesos-consul:c", "mesos-consul:d", "mesos-consul:e"}},
+	}
+
+	for _, tt := range tests {
+		c := config.DefaultConfig()
+		c.PortCollisionPolicy = tt.policy
+
+		m := &Mesos{config: c}
+		kept := m.resolveCollisions(services)
+
+		if len(kept) != len(tt.ids) {
+			t.Errorf("%s: got %d services, want %d", tt.policy, len(kept), len(tt.ids))
+			continue
+		}
+
+		for i, s := range kept {
+			if s.ID != tt.ids[i] {
+				t.Errorf("%s: got %s at %d, want %s", tt.policy, s.ID, i, tt.ids[i])
+			}
+		}
+	}
+}
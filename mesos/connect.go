@@ -0,0 +1,66 @@
+package mesos
+
+import (
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Task labels registering a task's services with Consul Connect
+const (
+	connectLabel          = "connect"
+	connectUpstreamsLabel = "connect-upstreams"
+)
+
+// Build the Connect registration of a task's services from its
+// connect label: sidecar registers a sidecar proxy, on a port the
+// Consul agent picks, with the upstreams of the connect-upstreams
+// label, and native marks the task as Connect-native
+func connectService(task *Task) *consulapi.AgentServiceConnect {
+	switch mode := task.label(connectLabel); mode {
+	case "":
+		return nil
+	case "native":
+		return &consulapi.AgentServiceConnect{Native: true}
+	case "sidecar", "true":
+		return &consulapi.AgentServiceConnect{
+			SidecarService: &consulapi.AgentServiceRegistration{
+				Proxy: &consulapi.AgentServiceConnectProxyConfig{
+					Upstreams: connectUpstreams(task),
+				},
+			},
+		}
+	default:
+		hclog.L().Warn("Ignoring invalid label", append(task.logFields(), "label", connectLabel, "value", mode)...)
+		return nil
+	}
+}
+
+// Parse the connect-upstreams label, a comma-separated list of
+// service:port pairs binding each upstream service to a local port
+func connectUpstreams(task *Task) []consulapi.Upstream {
+	var upstreams []consulapi.Upstream
+
+	for _, u := range strings.Split(task.label(connectUpstreamsLabel), ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+
+		i := strings.LastIndex(u, ":")
+		port, err := strconv.Atoi(u[i+1:])
+		if i <= 0 || err != nil || port <= 0 || port > 65535 {
+			hclog.L().Warn("Ignoring invalid upstream", append(task.logFields(), "label", connectUpstreamsLabel, "upstream", u)...)
+			continue
+		}
+
+		upstreams = append(upstreams, consulapi.Upstream{
+			DestinationType: consulapi.UpstreamDestTypeService,
+			DestinationName: u[:i],
+			LocalBindPort:   port,
+		})
+	}
+
+	return upstreams
+}
@@ -0,0 +1,34 @@
+package mesos
+
+import (
+	"testing"
+)
+
+func TestConnectService(t *testing.T) {
+	if c := connectService(&Task{}); c != nil {
+		t.Errorf("expected no Connect without the label, got %+v", c)
+	}
+
+	if c := connectService(&Task{Labels: []Label{{Key: "connect", Value: "native"}}}); c == nil || !c.Native {
+		t.Errorf("expected a Connect-native service, got %+v", c)
+	}
+
+	if c := connectService(&Task{Labels: []Label{{Key: "connect", Value: "bogus"}}}); c != nil {
+		t.Errorf("expected an invalid label to be ignored, got %+v", c)
+	}
+
+	task := &Task{Labels: []Label{
+		{Key: "connect", Value: "sidecar"},
+		{Key: "connect-upstreams", Value: "db:5432, cache:6379,broken,:1"},
+	}}
+
+	c := connectService(task)
+	if c == nil || c.SidecarService == nil || c.SidecarService.Proxy == nil {
+		t.Fatalf("expected a sidecar service, got %+v", c)
+	}
+
+	upstreams := c.SidecarService.Proxy.Upstreams
+	if len(upstreams) != 2 || upstreams[0].DestinationName != "db" || upstreams[0].LocalBindPort != 5432 || upstreams[1].DestinationName != "cache" {
+		t.Errorf("unexpected upstreams %+v", upstreams)
+	}
+}
@@ -0,0 +1,149 @@
+package mesos
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The parts of an entry of an agent's /containers the port mappings
+// are read from
+type agentContainer struct {
+	ContainerID string `json:"container_id"`
+	ExecutorID  string `json:"executor_id"`
+	FrameworkID string `json:"framework_id"`
+	Status      struct {
+		NetworkInfos []NetworkInfo `json:"network_infos"`
+	} `json:"status"`
+}
+
+// With --agent-containers, read the port mappings of the Docker
+// bridge-mode tasks from the /containers of their agents, so the ports
+// their labels give, e.g. the container port of consul-port, are registered
+// as the host ports forwarding to them. The agents are read like with
+// --agent-state. An agent that cannot be read leaves its tasks as they
+// are.
+func (m *Mesos) mapContainerPorts(sj StateJSON) {
+	if !m.config.AgentContainers {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var agents []*follower
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			if !bridged(task) || seen[task.FollowerId] {
+				continue
+			}
+			seen[task.FollowerId] = true
+			if f, err := sj.Followers.byId(task.FollowerId); err == nil {
+				agents = append(agents, f)
+			}
+		}
+	}
+	if len(agents) == 0 {
+		return
+	}
+
+	start := time.Now()
+	containers := make(map[string][]agentContainer, len(agents))
+	var lock sync.Mutex
+	m.readAgents(agents, func(f *follower) {
+		found, err := m.loadAgentContainers(f)
+		if err != nil {
+			hclog.L().Warn("Unable to read the agent containers", "agent", f.Id, "hostname", f.Hostname, "error", err)
+			return
+		}
+
+		lock.Lock()
+		containers[f.Id] = found
+		lock.Unlock()
+	})
+
+	hclog.L().Debug("Read the agent containers", "agents", len(agents), "read", len(containers), "duration", time.Since(start))
+
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			if !bridged(task) {
+				continue
+			}
+
+			for _, c := range containers[task.FollowerId] {
+				if c.runs(task) {
+					task.portMappings = nil
+					for _, ni := range c.Status.NetworkInfos {
+						task.portMappings = append(task.portMappings, ni.PortMappings...)
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+// Read the containers of an agent
+func (m *Mesos) loadAgentContainers(f *follower) ([]agentContainer, error) {
+	host, port, err := parsePID(f.Pid, m.config.PidParseStrict)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.agentTimeout()
+	defer cancel()
+
+	var containers []agentContainer
+	err = m.requestJSONContext(ctx, "GET", m.mesosURL(hostPort(host, port), "/containers"), "", &containers)
+
+	return containers, err
+}
+
+// Tell whether the container runs task: the one of its latest status
+// or, without one, of its executor. Tasks of the command executor are
+// run by an executor of their own ID.
+func (c agentContainer) runs(task *Task) bool {
+	for i := len(task.Statuses) - 1; i >= 0; i-- {
+		if id := task.Statuses[i].ContainerStatus.ContainerID.Value; id != "" {
+			return id == c.ContainerID
+		}
+	}
+
+	executor := task.ExecutorId
+	if executor == "" {
+		executor = task.Id
+	}
+
+	return c.ExecutorID == executor && (c.FrameworkID == "" || c.FrameworkID == task.FrameworkId)
+}
+
+// Tell whether a running task is a Docker container on a bridge
+// network, reachable through the host ports mapped to its ports
+func bridged(task *Task) bool {
+	c := task.Container
+	return task.State == "TASK_RUNNING" && c != nil && c.Type == "DOCKER" &&
+		c.Docker != nil && strings.EqualFold(c.Docker.Network, "BRIDGE")
+}
+
+// The host port forwarding to port of the task's container, port
+// itself when none does or it is a mapped host port already
+func (t *Task) hostPort(port int) int {
+	if port <= 0 {
+		return port
+	}
+
+	for _, pm := range t.portMappings {
+		if pm.HostPort == port {
+			return port
+		}
+	}
+	for _, pm := range t.portMappings {
+		if pm.ContainerPort == port && pm.HostPort > 0 {
+			return pm.HostPort
+		}
+	}
+
+	return port
+}
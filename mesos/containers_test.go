@@ -0,0 +1,88 @@
+package mesos
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestMapContainerPorts(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, `[
+			{"container_id":"c-web","executor_id":"web.1","status":{"network_infos":[{"port_mappings":[
+				{"host_port":31000,"container_port":8080,"protocol":"tcp"},
+				{"host_port":31001,"container_port":9090,"protocol":"tcp"}]}]}},
+			{"container_id":"c-db","executor_id":"db.1","framework_id":"f1","status":{"network_infos":[{"port_mappings":[
+				{"host_port":31002,"container_port":5432}]}]}}]`)
+	}))
+	defer agent.Close()
+
+	bridge := &ContainerInfo{Type: "DOCKER", Docker: &DockerInfo{Network: "BRIDGE"}}
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1", Pid: "slave(1)@" + agent.Listener.Addr().String()}},
+		Frameworks: Frameworks{{Tasks: Tasks{
+			{
+				Id:         "web.1",
+				Name:       "web",
+				FollowerId: "1",
+				State:      "TASK_RUNNING",
+				Resources:  Resources{Ports: "[31000-31000]"},
+				Labels:     []Label{{Key: "consul-port", Value: "8080"}},
+				Statuses:   []Status{{State: "TASK_RUNNING", ContainerStatus: ContainerStatus{ContainerID: ContainerID{Value: "c-web"}}}},
+				Container:  bridge,
+			},
+			{
+				Id:          "db.1",
+				Name:        "db",
+				FrameworkId: "f1",
+				FollowerId:  "1",
+				State:       "TASK_RUNNING",
+				Labels:      []Label{{Key: "consul-port", Value: "5432"}},
+				Container:   bridge,
+			},
+			{
+				Id:         "cache.1",
+				Name:       "cache",
+				FollowerId: "1",
+				State:      "TASK_RUNNING",
+				Labels:     []Label{{Key: "consul-port", Value: "8080"}},
+				Container:  &ContainerInfo{Type: "DOCKER", Docker: &DockerInfo{Network: "HOST"}},
+			},
+		}}},
+	}
+
+	c := config.DefaultConfig()
+	c.AgentContainers = true
+	m := &Mesos{config: c}
+	m.mapContainerPorts(sj)
+
+	services, _ := m.taskServices(sj)
+	ports := make(map[string]int)
+	for _, s := range services {
+		ports[s.Name] = s.Port
+	}
+	if len(services) != 3 {
+		t.Fatalf("expected 3 services, got %d", len(services))
+	}
+	if ports["web"] != 31000 {
+		t.Errorf("expected the container port of the label to be mapped to its host port, got %d", ports["web"])
+	}
+	if ports["db"] != 31002 {
+		t.Errorf("expected the container of the executor to be found without a container ID, got %d", ports["db"])
+	}
+	if ports["cache"] != 8080 {
+		t.Errorf("expected the port of a task off the bridge network to be kept, got %d", ports["cache"])
+	}
+
+	if task := sj.Frameworks[0].Tasks[0]; task.hostPort(9090) != 31001 || task.hostPort(31001) != 31001 || task.hostPort(7000) != 7000 {
+		t.Errorf("expected container ports to be mapped and other ports kept, got %+v", task.portMappings)
+	}
+}
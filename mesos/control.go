@@ -0,0 +1,95 @@
+package mesos
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// With --service-controls, read the control flags operators keep under
+// <kv-prefix>/control/<service name>/enabled. The task services of a
+// name whose flag is false are pulled from the catalog until it is
+// true again or deleted. A failed read keeps the flags of the last
+// one, and flags that are not booleans are logged and ignored.
+func (m *Mesos) loadControls() {
+	if !m.config.ServiceControls {
+		m.disabled = nil
+		return
+	}
+
+	prefix := m.config.KVPrefix + "/control/"
+	values, _, err := m.Registry.List(m.syncCtx(), prefix, 0, 0)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to read the service controls", "key", prefix, "error", err)
+		return
+	}
+
+	m.disabled = make(map[string]bool)
+	for key, value := range values {
+		name := strings.TrimPrefix(key, prefix)
+		if !strings.HasSuffix(name, "/enabled") {
+			continue
+		}
+		name = strings.TrimSuffix(name, "/enabled")
+
+		enabled, err := strconv.ParseBool(strings.TrimSpace(string(value)))
+		if err != nil {
+			hclog.L().Warn("Ignoring invalid service control", "key", key, "value", string(value))
+			continue
+		}
+		if !enabled {
+			m.disabled[name] = true
+		}
+	}
+}
+
+// The task services, and their agents by ID, without those of the
+// names disabled by --service-controls
+func (m *Mesos) withoutDisabled(services []*consulapi.AgentServiceRegistration, agents map[string]string) []*consulapi.AgentServiceRegistration {
+	if len(m.disabled) == 0 {
+		return services
+	}
+
+	enabled := services[:0:0]
+	for _, s := range services {
+		if m.disabled[s.Name] {
+			hclog.L().Debug("Service disabled. Not registering", "service_id", s.ID, "service", s.Name)
+			delete(agents, s.ID)
+			continue
+		}
+		enabled = append(enabled, s)
+	}
+
+	return enabled
+}
+
+// Deregister the cached task services of the names disabled by
+// --service-controls right away, whatever --deregister-delay and
+// --max-deregister-percent say: pulling a service is an emergency
+// drain an operator asked for
+func (m *Mesos) deregisterDisabled() {
+	if len(m.disabled) == 0 {
+		return
+	}
+
+	for key, b := range m.ServiceCache {
+		if !m.disabled[b.service.Name] || b.service.Meta[taskIDMeta] == "" {
+			continue
+		}
+
+		old := *b
+		dc := key.Datacenter
+		hclog.L().Info("Service disabled. Deregistering", "service_id", key.ID, "service", old.service.Name)
+		m.write(func(ctx context.Context) error {
+			return m.applier().Deregister(ctx, Registration{dc, old.agent, old.service})
+		}, func() {
+			m.applied(eventDeregister, reasonDisabled, old.agent, old.service)
+		})
+
+		delete(m.ServiceCache, key)
+	}
+}
@@ -0,0 +1,62 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestServiceControls(t *testing.T) {
+	r := &kvRegistry{newFakeRegistry(), map[string][]byte{
+		"mesos-consul/control/web/enabled":   []byte("false\n"),
+		"mesos-consul/control/api/enabled":   []byte("true"),
+		"mesos-consul/control/batch/enabled": []byte("nope"),
+		"mesos-consul/control/db/notes":      []byte("false"),
+	}}
+
+	c := config.DefaultConfig()
+	c.ServiceControls = true
+
+	web := ServiceKey{"mesos-consul:10.0.0.1:web:31000", localDatacenter}
+	mirrored := ServiceKey{"mesos-consul:10.0.0.1:web:31000", "dc2"}
+	api := ServiceKey{"mesos-consul:10.0.0.1:api:31001", localDatacenter}
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			web:      {service: &consulapi.AgentServiceRegistration{ID: web.ID, Name: "web", Meta: map[string]string{taskIDMeta: "web.1"}}, agent: "10.0.0.1"},
+			mirrored: {service: &consulapi.AgentServiceRegistration{ID: web.ID, Name: "web", Meta: map[string]string{taskIDMeta: "web.1"}}, agent: "10.0.0.1"},
+			api:      {service: &consulapi.AgentServiceRegistration{ID: api.ID, Name: "api", Meta: map[string]string{taskIDMeta: "api.1"}}, agent: "10.0.0.1"},
+		},
+	}
+	m.loadControls()
+
+	if len(m.disabled) != 1 || !m.disabled["web"] {
+		t.Fatalf("expected only web to be disabled, got %v", m.disabled)
+	}
+
+	services := []*consulapi.AgentServiceRegistration{m.ServiceCache[web].service, m.ServiceCache[api].service}
+	agents := map[string]string{web.ID: "10.0.0.1", api.ID: "10.0.0.1"}
+	services = m.withoutDisabled(services, agents)
+	if len(services) != 1 || services[0].Name != "api" || len(agents) != 1 {
+		t.Errorf("expected only the api service, got %v, %v", services, agents)
+	}
+
+	m.deregisterDisabled()
+	if _, ok := m.ServiceCache[web]; ok {
+		t.Error("expected the web service to leave the cache")
+	}
+	if _, ok := m.ServiceCache[mirrored]; ok {
+		t.Error("expected the mirrored web service to leave the cache")
+	}
+	if _, ok := m.ServiceCache[api]; !ok || len(r.deregistered) != 1 {
+		t.Errorf("expected only web to be deregistered, got %v", r.deregistered)
+	}
+
+	c.ServiceControls = false
+	m.loadControls()
+	if m.disabled != nil {
+		t.Error("expected no disabled services without service-controls")
+	}
+}
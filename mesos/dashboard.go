@@ -0,0 +1,148 @@
+package mesos
+
+// The page served on /ui/ with --admin-ui. It reads /v1/health,
+// /v1/cache and /v1/checks, relative to itself so it works under the
+// /<cluster>/ prefixes of --cluster too, and refreshes every 10s.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mesos-consul</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 1em 2em; color: #222; }
+h1 { font-size: 20px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 2px 1em; }
+dt { font-weight: bold; }
+dd { margin: 0; }
+.error { color: #b00; }
+.passing { color: #080; }
+.warning { color: #b80; }
+.critical { color: #b00; font-weight: bold; }
+#filter { margin: 1em 0; width: 20em; }
+</style>
+</head>
+<body>
+<h1>mesos-consul</h1>
+<dl id="health"></dl>
+<input id="filter" type="search" placeholder="Filter services">
+<table>
+<thead>
+<tr><th>Service</th><th>ID</th><th>Address</th><th>Agent</th><th>Framework</th><th>Task</th><th>Checks</th></tr>
+</thead>
+<tbody id="services"></tbody>
+</table>
+<script>
+"use strict";
+
+function get(path) {
+	return fetch(path).then(function(resp) {
+		if (!resp.ok) {
+			return resp.text().then(function(text) { throw new Error(path + ": " + text); });
+		}
+		return resp.json();
+	});
+}
+
+function cell(row, text, className) {
+	var td = document.createElement("td");
+	td.textContent = text || "";
+	if (className) {
+		td.className = className;
+	}
+	row.appendChild(td);
+	return td;
+}
+
+function showHealth(h, checksErr) {
+	var dl = document.getElementById("health");
+	dl.textContent = "";
+	var add = function(name, value, className) {
+		var dt = document.createElement("dt");
+		dt.textContent = name;
+		var dd = document.createElement("dd");
+		dd.textContent = value;
+		if (className) {
+			dd.className = className;
+		}
+		dl.appendChild(dt);
+		dl.appendChild(dd);
+	};
+
+	add("Last sync", h.last_sync ? new Date(h.last_sync).toLocaleString() + " (" + h.sync_duration + ")" : "never");
+	add("Last sync error", h.sync_error || "none", h.sync_error ? "error" : "");
+	if (h.mesos_error) {
+		add("Mesos error", h.mesos_error, "error");
+	}
+	if (checksErr) {
+		add("Consul error", checksErr, "error");
+	}
+	add("Cached services", h.cache_entries);
+	add("Syncs", h.syncs + ", " + h.registrations + " registrations, " + h.deregistrations + " deregistrations");
+}
+
+function showServices(cache, checks) {
+	var byService = {};
+	(checks || []).forEach(function(c) {
+		(byService[c.ServiceID] = byService[c.ServiceID] || []).push(c);
+	});
+
+	var filter = document.getElementById("filter").value.toLowerCase();
+	var tbody = document.getElementById("services");
+	tbody.textContent = "";
+	cache.sort(function(a, b) { return a.service.Name.localeCompare(b.service.Name) || a.service.ID.localeCompare(b.service.ID); });
+	cache.forEach(function(e) {
+		var s = e.service, meta = s.Meta || {};
+		var text = [s.Name, s.ID, s.Address, meta["mesos-task-id"], meta["mesos-framework"]].join(" ").toLowerCase();
+		if (filter && text.indexOf(filter) < 0) {
+			return;
+		}
+
+		var row = document.createElement("tr");
+		cell(row, s.Name);
+		cell(row, s.ID);
+		cell(row, s.Address + (s.Port ? ":" + s.Port : ""));
+		cell(row, (e.datacenter ? e.datacenter + " " : "") + (e.agent || "local"));
+		cell(row, meta["mesos-framework"]);
+		cell(row, meta["mesos-task-id"]);
+
+		var td = cell(row, "");
+		var found = byService[s.ID] || [];
+		if (found.length === 0) {
+			td.textContent = checks ? "none" : "unknown";
+		}
+		found.forEach(function(c) {
+			var div = document.createElement("div");
+			div.className = c.Status;
+			div.textContent = c.Status + " " + c.Name;
+			div.title = c.Output || c.Notes || "";
+			td.appendChild(div);
+		});
+
+		tbody.appendChild(row);
+	});
+}
+
+var last = { cache: [], checks: null };
+
+function refresh() {
+	var checks = get("../v1/checks").then(function(c) { return { checks: c }; }, function(err) { return { err: err.message }; });
+
+	Promise.all([get("../v1/health"), get("../v1/cache"), checks]).then(function(r) {
+		last = { cache: r[1] || [], checks: r[2].checks || null };
+		showHealth(r[0], r[2].err);
+		showServices(last.cache, last.checks);
+	}, function(err) {
+		document.getElementById("health").textContent = err.message;
+	});
+}
+
+document.getElementById("filter").addEventListener("input", function() { showServices(last.cache, last.checks); });
+refresh();
+setInterval(refresh, 10000);
+</script>
+</body>
+</html>
+`
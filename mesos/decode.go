@@ -0,0 +1,178 @@
+package mesos
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// The state of a large cluster runs into hundreds of megabytes, most
+// of it executors, offers and flags no sync reads. json.Decoder holds
+// a whole value in memory before decoding it, so the answers of the
+// masters and agents are walked token by token instead: the arrays of
+// structs, e.g. the tasks of a framework, are decoded one element at a
+// time and the keys without a field are skipped as they are read.
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// The body of resp, decompressed when Mesos gzipped it
+func responseBody(resp *http.Response) (io.ReadCloser, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body, nil
+	}
+
+	return gzip.NewReader(resp.Body)
+}
+
+// Decode the JSON of r into v, a pointer, as json.Decoder does but
+// without holding the whole document in memory
+func decodeStream(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("json: cannot decode into %T", v)
+	}
+
+	return decodeValue(json.NewDecoder(r), rv.Elem())
+}
+
+// Whether the values of t are decoded token by token: structs and
+// slices of structs, unless they decode themselves
+func streamed(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Struct && !reflect.PtrTo(t.Elem()).Implements(unmarshalerType)
+	}
+
+	return false
+}
+
+func decodeValue(dec *json.Decoder, v reflect.Value) error {
+	if !streamed(v.Type()) {
+		return dec.Decode(v.Addr().Interface())
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	delim, _ := tok.(json.Delim)
+	switch {
+	case v.Kind() == reflect.Struct && delim == '{':
+		return decodeObject(dec, v)
+	case v.Kind() == reflect.Slice && delim == '[':
+		return decodeArray(dec, v)
+	}
+
+	return fmt.Errorf("json: cannot decode %v into %s", tok, v.Type())
+}
+
+// Decode the rest of an object into struct v, skipping the keys v has
+// no field for
+func decodeObject(dec *json.Decoder, v reflect.Value) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		key, _ := tok.(string)
+		if f, ok := jsonField(v, key); ok {
+			err = decodeValue(dec, f)
+		} else {
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := dec.Token()
+	return err
+}
+
+// Decode the rest of an array into slice v, an element at a time
+func decodeArray(dec *json.Decoder, v reflect.Value) error {
+	v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	for dec.More() {
+		v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		if err := decodeValue(dec, v.Index(v.Len()-1)); err != nil {
+			return err
+		}
+	}
+
+	_, err := dec.Token()
+	return err
+}
+
+// The field of struct v key decodes into, as json.Unmarshal finds it:
+// by its tag or name, exactly or else ignoring case, including the
+// fields of untagged embedded structs
+func jsonField(v reflect.Value, key string) (reflect.Value, bool) {
+	var folded reflect.Value
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" || (sf.PkgPath != "" && !sf.Anonymous) {
+			continue
+		}
+
+		if name == "" {
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				if f, ok := jsonField(v.Field(i), key); ok {
+					return f, true
+				}
+				continue
+			}
+			name = sf.Name
+		}
+
+		if name == key {
+			return v.Field(i), true
+		}
+		if !folded.IsValid() && strings.EqualFold(name, key) {
+			folded = v.Field(i)
+		}
+	}
+
+	return folded, folded.IsValid()
+}
+
+// Read past the value next in dec
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package mesos
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+const bigState = `{
+  "leader": "master@10.0.0.1:5050",
+  "cluster": "prod",
+  "flags": {"work_dir": "/var/lib/mesos", "quorum": "2"},
+  "slaves": [{"id": "1", "hostname": "10.0.0.2", "pid": "slave(1)@10.0.0.2:5051", "attributes": {"rack": "r1"}, "resources": {"cpus": 8}, "used_resources": {"cpus": 1}}],
+  "frameworks": [{
+    "id": "fw-1",
+    "name": "marathon",
+    "role": "team-a",
+    "offers": [{"id": "o-1", "resources": {"cpus": 4}}],
+    "executors": [{"id": "e-1", "tasks": [{"id": "ignored"}]}],
+    "tasks": [
+      {"id": "web.1", "name": "web", "slave_id": "1", "state": "TASK_RUNNING", "resources": {"cpus": 0.5, "ports": "[31000-31000]"}, "labels": [{"key": "env", "value": "prod"}], "statuses": [{"state": "TASK_RUNNING", "healthy": true}], "discovery": {"name": "web"}},
+      {"id": "api.1", "name": "api", "slave_id": "1", "state": "TASK_STAGING", "labels": null, "container": null}
+    ],
+    "unreachable_tasks": null,
+    "completed_tasks": [{"id": "web.0", "name": "web", "state": "TASK_FAILED", "statuses": [{"state": "TASK_FAILED", "reason": "REASON_COMMAND_EXECUTOR_FAILED"}]}]
+  }],
+  "completed_frameworks": [{"id": "fw-0", "tasks": [{"id": "old"}]}]
+}`
+
+func TestDecodeStream(t *testing.T) {
+	var want StateJSON
+	if err := json.Unmarshal([]byte(bigState), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	var got StateJSON
+	if err := decodeStream(strings.NewReader(bigState), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the streamed state to match json.Unmarshal:\n%+v\n%+v", got, want)
+	}
+}
+
+func TestDecodeStreamErrors(t *testing.T) {
+	for _, data := range []string{`{"frameworks": {}}`, `{"frameworks": [{"tasks": [`, `{"leader": 1}`} {
+		var sj StateJSON
+		if err := decodeStream(strings.NewReader(data), &sj); err == nil {
+			t.Errorf("expected %s to fail", data)
+		}
+	}
+}
+
+func TestRequestGzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("expected gzip to be requested, got %q", r.Header.Get("Accept-Encoding"))
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(bigState))
+		gz.Close()
+	}))
+	defer ts.Close()
+
+	m := &Mesos{config: config.DefaultConfig()}
+
+	var sj StateJSON
+	if err := m.requestJSON("GET", ts.URL+"/master/state", "", &sj); err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@10.0.0.1:5050" || len(sj.Frameworks) != 1 || len(sj.Frameworks[0].Tasks) != 2 {
+		t.Errorf("unexpected state %+v", sj)
+	}
+}
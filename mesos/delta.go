@@ -0,0 +1,77 @@
+package mesos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The digest of the parts of a state the sync reads. The masters send
+// no ETag or version of their state, so it is what tells an unchanged
+// state apart.
+func stateHash(sj StateJSON) string {
+	data, err := json.Marshal(sj)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// The features whose registration pass depends on more than the
+// state, e.g. on TTL checks, the time, the KV store or another
+// request, by option. A sync with any of them enabled never skips
+// the pass: a new feature of the kind must be listed here.
+var everySync = []struct {
+	option  string
+	enabled func(c *config.Config) bool
+}{
+	{"check-mode", func(c *config.Config) bool {
+		return c.CheckMode == config.CheckModeTTL || c.CheckMode == config.CheckModeMesos
+	}},
+	{"aggregate-health", func(c *config.Config) bool { return c.AggregateHealth }},
+	{"adaptive-check-interval", func(c *config.Config) bool { return c.AdaptiveCheckInterval }},
+	{"preserve-tags", func(c *config.Config) bool { return c.PreserveTags != "" }},
+	{"address-translator", func(c *config.Config) bool { return c.AddressTranslator != "" }},
+	{"agent-state", func(c *config.Config) bool { return c.AgentState }},
+	{"agent-containers", func(c *config.Config) bool { return c.AgentContainers }},
+	{"check-overrides", func(c *config.Config) bool { return c.CheckOverrides }},
+	{"lost-task-grace", func(c *config.Config) bool { return c.LostTaskGrace > 0 }},
+	{"blue-green-live", func(c *config.Config) bool { return c.BlueGreenLive }},
+	{"service-controls", func(c *config.Config) bool { return c.ServiceControls }},
+	{"flap-threshold", func(c *config.Config) bool { return c.FlapThreshold > 0 }},
+	{"transform-plugin", func(c *config.Config) bool { return c.TransformPlugin != "" }},
+	{"sync-maintenance", func(c *config.Config) bool { return c.SyncMaintenance }},
+}
+
+// With --skip-unchanged, tell whether the registration pass can be
+// skipped for a freshly fetched state with digest hash: the last pass
+// synced the same state without failing a Consul call, the cache and
+// configuration are the same since, and no service waits out
+// --deregister-delay or a drain. With a feature of everySync, the
+// pass is always run.
+func (m *Mesos) unchanged(hash string, fresh bool) bool {
+	c := m.config
+	if !c.SkipUnchanged || !fresh || hash == "" || hash != m.syncedHash {
+		return false
+	}
+
+	for _, f := range everySync {
+		if f.enabled(c) {
+			hclog.L().Debug("State unchanged. Running the registration pass for an option", "option", f.option)
+			return false
+		}
+	}
+
+	for _, b := range m.ServiceCache {
+		if b.missed > 0 {
+			return false
+		}
+	}
+
+	return true
+}
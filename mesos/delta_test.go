@@ -0,0 +1,55 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestUnchanged(t *testing.T) {
+	sj := StateJSON{Leader: "master@10.0.0.1:5050", Frameworks: Frameworks{{Id: "fw", Name: "marathon"}}}
+	hash := stateHash(sj)
+
+	c := config.DefaultConfig()
+	c.SkipUnchanged = true
+	m := &Mesos{config: c, syncedHash: hash, ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+	if !m.unchanged(hash, true) {
+		t.Error("expected the same state to be unchanged")
+	}
+	if m.unchanged(hash, false) {
+		t.Error("expected a re-affirmed state to be synced")
+	}
+
+	sj.Frameworks[0].Name = "chronos"
+	if m.unchanged(stateHash(sj), true) {
+		t.Error("expected a changed state to be synced")
+	}
+
+	m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}] = &CacheEntry{missed: 1}
+	if m.unchanged(hash, true) {
+		t.Error("expected a pending deregistration to be synced")
+	}
+	delete(m.ServiceCache, ServiceKey{"mesos-consul:a", localDatacenter})
+
+	c.CheckMode = config.CheckModeTTL
+	if m.unchanged(hash, true) {
+		t.Error("expected TTL checks to be synced")
+	}
+	c.CheckMode = config.CheckModeMesos
+	if m.unchanged(hash, true) {
+		t.Error("expected Mesos health checks to be synced")
+	}
+	c.CheckMode = config.CheckModeAgent
+
+	c.SyncMaintenance = true
+	if m.unchanged(hash, true) {
+		t.Error("expected the maintenance to be synced")
+	}
+	c.SyncMaintenance = false
+
+	m.mergeEntries(nil)
+	if m.unchanged(hash, true) {
+		t.Error("expected a merged cache to be synced")
+	}
+}
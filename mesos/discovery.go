@@ -0,0 +1,111 @@
+package mesos
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The task label naming the service of the port with the given index,
+// e.g. consul_port_1_name=metrics
+const portNameLabel = "consul_port_%d_name"
+
+// Look up the DiscoveryInfo port of a task with the given number
+func (t *Task) discoveryPort(number int) *DiscoveryPort {
+	if t.Discovery == nil {
+		return nil
+	}
+
+	for i, p := range t.Discovery.Ports.Ports {
+		if p.Number == number {
+			return &t.Discovery.Ports.Ports[i]
+		}
+	}
+
+	return nil
+}
+
+// The protocols the DiscoveryInfo of a task declares the port with the
+// given number for, e.g. udp for a statsd receiver, or tcp and udp for
+// a DNS server the framework lists once per protocol
+func (t *Task) portProtocols(number int) []string {
+	if t.Discovery == nil {
+		return nil
+	}
+
+	var protocols []string
+	seen := make(map[string]bool)
+	for _, p := range t.Discovery.Ports.Ports {
+		if p.Number != number {
+			continue
+		}
+		for _, protocol := range strings.Split(strings.ToLower(p.Protocol), ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" && !seen[protocol] {
+				seen[protocol] = true
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+
+	return protocols
+}
+
+// Tell whether the DiscoveryInfo of a task declares the port with the
+// given number for protocols other than TCP only, e.g. UDP, so that it
+// cannot be probed by TCP, HTTP or gRPC checks
+func (t *Task) nonTCPPort(number int) bool {
+	protocols := t.portProtocols(number)
+	for _, protocol := range protocols {
+		if protocol == "tcp" {
+			return false
+		}
+	}
+
+	return len(protocols) > 0
+}
+
+// Name and tag the service of the port at index among a task's ports.
+// A port named by a consul_port_<index>_name label is registered under
+// that name. A port named in the task's DiscoveryInfo otherwise gets
+// its own <name>-<port name> service, so consumers can resolve it by
+// name. Either is tagged with the DiscoveryInfo protocols and labels of
+// the port. Other ports are registered under the task's name, tagged
+// with their protocols unless they are plain TCP ones.
+func portService(name string, task *Task, index int, port int) (string, []string) {
+	p := task.discoveryPort(port)
+	protocols := task.portProtocols(port)
+	labelled := task.label(fmt.Sprintf(portNameLabel, index))
+	if labelled == "" && (p == nil || p.Name == "") {
+		if len(protocols) == 1 && protocols[0] == "tcp" {
+			return name, nil
+		}
+		return name, protocols
+	}
+
+	tags := protocols
+	if p != nil {
+		for _, l := range p.Labels.Labels {
+			if l.Value == "" {
+				tags = append(tags, l.Key)
+			} else {
+				tags = append(tags, fmt.Sprintf("%s=%s", l.Key, l.Value))
+			}
+		}
+	}
+
+	if labelled != "" {
+		return cleanName(labelled), tags
+	}
+
+	return fmt.Sprintf("%s-%s", name, cleanName(p.Name)), tags
+}
+
+// The name and tags of portService, the name made a DNS label with
+// --dns-srv
+func (m *Mesos) taskPortService(name string, task *Task, index int, port int) (string, []string) {
+	name, tags := portService(name, task, index, port)
+	if m.config.DNSSRV {
+		name = dnsName(name)
+	}
+
+	return name, tags
+}
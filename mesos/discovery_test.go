@@ -0,0 +1,137 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestPortService(t *testing.T) {
+	task := &Task{Discovery: &DiscoveryInfo{
+		Ports: DiscoveryPorts{Ports: []DiscoveryPort{
+			{Number: 31000, Name: "http", Protocol: "tcp", Labels: DiscoveryLabels{Labels: []Label{{Key: "vip", Value: "web:80"}, {Key: "public"}}}},
+			{Number: 31001, Name: "Admin_UI"},
+			{Number: 31002},
+		}},
+	}}
+
+	tests := []struct {
+		port int
+		name string
+		tags []string
+	}{
+		{31000, "web-http", []string{"tcp", "vip=web:80", "public"}},
+		{31001, "web-adminui", nil},
+		{31002, "web", nil},
+		{31003, "web", nil},
+	}
+
+	for _, tt := range tests {
+		name, tags := portService("web", task, tt.port-31000, tt.port)
+		if name != tt.name || !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("port %d: got %s %v, want %s %v", tt.port, name, tags, tt.name, tt.tags)
+		}
+	}
+
+	if name, tags := portService("web", &Task{}, 0, 31000); name != "web" || tags != nil {
+		t.Errorf("expected the task name without DiscoveryInfo, got %s %v", name, tags)
+	}
+
+	task.Labels = []Label{{Key: "consul_port_0_name", Value: "frontend"}, {Key: "consul_port_2_name", Value: "Metrics"}}
+	labelled := []struct {
+		port int
+		name string
+		tags []string
+	}{
+		{31000, "frontend", []string{"tcp", "vip=web:80", "public"}},
+		{31001, "web-adminui", nil},
+		{31002, "metrics", nil},
+	}
+
+	for _, tt := range labelled {
+		name, tags := portService("web", task, tt.port-31000, tt.port)
+		if name != tt.name || !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("labelled port %d: got %s %v, want %s %v", tt.port, name, tags, tt.name, tt.tags)
+		}
+	}
+}
+
+func TestPortProtocols(t *testing.T) {
+	task := &Task{Discovery: &DiscoveryInfo{
+		Ports: DiscoveryPorts{Ports: []DiscoveryPort{
+			{Number: 31000, Protocol: "udp"},
+			{Number: 31001, Name: "dns", Protocol: "udp"},
+			{Number: 31001, Name: "dns", Protocol: "TCP"},
+			{Number: 31002, Protocol: "tcp"},
+		}},
+	}}
+
+	tests := []struct {
+		port   int
+		name   string
+		tags   []string
+		nonTCP bool
+	}{
+		{31000, "statsd", []string{"udp"}, true},
+		{31001, "statsd-dns", []string{"udp", "tcp"}, false},
+		{31002, "statsd", nil, false},
+		{31003, "statsd", nil, false},
+	}
+
+	for _, tt := range tests {
+		name, tags := portService("statsd", task, 0, tt.port)
+		if name != tt.name || !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("port %d: got %s %v, want %s %v", tt.port, name, tags, tt.name, tt.tags)
+		}
+		if task.nonTCPPort(tt.port) != tt.nonTCP {
+			t.Errorf("port %d: expected nonTCPPort %v", tt.port, tt.nonTCP)
+		}
+	}
+
+	// UDP ports get no TCP or HTTP check, scripts still run
+	c := config.DefaultConfig()
+	c.DefaultCheck = config.DefaultCheckTCP
+	m := &Mesos{config: c}
+	if check := m.taskCheck("statsd", task, "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected no check of the UDP port, got %+v", check)
+	}
+	if check := m.taskCheck("statsd", task, "10.0.0.1", 31001); check == nil || check.TCP != "10.0.0.1:31001" {
+		t.Errorf("expected a TCP check of the TCP and UDP port, got %+v", check)
+	}
+	task.Labels = []Label{{Key: scriptLabel, Value: "echo stats | nc -u -w1 localhost 8125"}}
+	if check := m.taskCheck("statsd", task, "10.0.0.1", 31000); check == nil || len(check.Args) == 0 {
+		t.Errorf("expected the script check of the UDP port, got %+v", check)
+	}
+}
+
+func TestDiscoveryService(t *testing.T) {
+	task := &Task{Name: "web.prod", Discovery: &DiscoveryInfo{
+		Name:        "Storefront",
+		Environment: "prod",
+		Version:     "1.2",
+		Labels:      DiscoveryLabels{Labels: []Label{{Key: "team", Value: "shop"}, {Key: "canary"}}},
+	}}
+
+	m := &Mesos{config: config.DefaultConfig()}
+	if name := m.taskServiceName("marathon", task); name != "storefront" {
+		t.Errorf("expected the DiscoveryInfo name, got %s", name)
+	}
+
+	tags, meta := discoveryService(task)
+	if want := []string{"prod", "1.2", "team=shop", "canary"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("expected tags %v, got %v", want, tags)
+	}
+	if len(meta) != 4 || meta["environment"] != "prod" || meta["version"] != "1.2" || meta["team"] != "shop" || meta["canary"] != "" {
+		t.Errorf("unexpected meta %v", meta)
+	}
+
+	task.Discovery.Name = ""
+	if name := m.taskServiceName("marathon", task); name != cleanName(task.Name) {
+		t.Errorf("expected the task name without a DiscoveryInfo name, got %s", name)
+	}
+
+	if tags, meta := discoveryService(&Task{Name: "web"}); tags != nil || meta != nil {
+		t.Errorf("expected nothing without DiscoveryInfo, got %v %v", tags, meta)
+	}
+}
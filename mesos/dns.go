@@ -0,0 +1,128 @@
+package mesos
+
+import (
+	"fmt"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// The longest label of a DNS name
+const maxDNSLabel = 63
+
+// What keeps a service from being looked up with Consul DNS, see
+// DNSReport
+const (
+	// No port: its SRV records point at port 0
+	DNSNoPort = "no port"
+
+	// The name is not a single lowercase DNS label, e.g. it has dots
+	// or underscores, so <name>.service.consul finds something else
+	// or nothing
+	DNSInvalidName = "invalid name"
+
+	// Tags that are not DNS labels cannot be looked up as
+	// <tag>.<name>.service.consul
+	DNSInvalidTags = "invalid tags"
+
+	// Other ports of the same task are registered under the same
+	// name, so the SRV records of the name do not tell them apart
+	DNSSharedName = "shared name"
+)
+
+// A problem of a cached service for DNS consumers
+type DNSProblem struct {
+	Datacenter string `json:"datacenter"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Problem    string `json:"problem"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// s as a lowercase DNS label: the runs of other characters replaced
+// with -, cut to the longest label
+func dnsName(s string) string {
+	s = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(s) > maxDNSLabel {
+		s = strings.TrimRight(s[:maxDNSLabel], "-")
+	}
+
+	return s
+}
+
+func isDNSName(s string) bool {
+	return s != "" && dnsName(s) == s
+}
+
+// With --dns-srv, turn the tags of s into DNS labels, dropping those
+// left empty and the duplicates. The services of a task share their
+// tags, so s gets a copy.
+func (m *Mesos) dnsTags(s *consulapi.AgentServiceRegistration) {
+	if !m.config.DNSSRV {
+		return
+	}
+
+	var tags []string
+	seen := make(map[string]bool, len(s.Tags))
+	for _, t := range s.Tags {
+		t = dnsName(t)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	s.Tags = tags
+}
+
+// DNSReport returns the problems the cached task services would have
+// with Consul DNS, by datacenter and service ID. With --dns-srv, names
+// and tags are DNS labels, so only the ports are left to check.
+func (m *Mesos) DNSReport() []DNSProblem {
+	cached := m.CachedServices()
+
+	type port struct {
+		dc, task, name string
+	}
+	shared := make(map[port]int)
+	for _, e := range cached {
+		if task := e.Service.Meta[taskIDMeta]; task != "" {
+			shared[port{e.Datacenter, task, e.Service.Name}]++
+		}
+	}
+
+	problems := []DNSProblem{}
+	for _, e := range cached {
+		s := e.Service
+		task := s.Meta[taskIDMeta]
+		if task == "" {
+			continue
+		}
+
+		add := func(problem string, detail string) {
+			problems = append(problems, DNSProblem{e.Datacenter, s.ID, s.Name, problem, detail})
+		}
+
+		if s.Port == 0 {
+			add(DNSNoPort, "")
+		}
+		if !isDNSName(s.Name) {
+			add(DNSInvalidName, fmt.Sprintf("%q would be %q", s.Name, dnsName(s.Name)))
+		}
+
+		var invalid []string
+		for _, t := range s.Tags {
+			if !isDNSName(t) {
+				invalid = append(invalid, t)
+			}
+		}
+		if len(invalid) > 0 {
+			add(DNSInvalidTags, strings.Join(invalid, ", "))
+		}
+
+		if n := shared[port{e.Datacenter, task, s.Name}]; n > 1 {
+			add(DNSSharedName, fmt.Sprintf("%d ports of task %s", n, task))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,89 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestDNSName(t *testing.T) {
+	tests := map[string]string{
+		"web":            "web",
+		"Web_App.prod":   "web-app-prod",
+		"env=prod":       "env-prod",
+		"--x--":          "x",
+		"a.-b":           "a--b",
+		"":               "",
+		"urlprefix-/api": "urlprefix--api",
+	}
+	long := "a23456789012345678901234567890123456789012345678901234567890123-b"
+	tests[long] = long[:63]
+
+	for in, want := range tests {
+		if got := dnsName(in); got != want {
+			t.Errorf("dnsName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDNSTags(t *testing.T) {
+	c := config.DefaultConfig()
+	c.DNSSRV = true
+	m := &Mesos{config: c}
+
+	tags := []string{"env=prod", "v1.2", "env:prod", "==", "web"}
+	s := &consulapi.AgentServiceRegistration{Tags: tags}
+	m.dnsTags(s)
+
+	if want := []string{"env-prod", "v1-2", "web"}; !reflect.DeepEqual(s.Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, s.Tags)
+	}
+	if tags[0] != "env=prod" {
+		t.Error("expected the shared tags to be left alone")
+	}
+}
+
+func TestDNSServiceNames(t *testing.T) {
+	c := config.DefaultConfig()
+	c.DNSSRV = true
+	m := &Mesos{config: c}
+
+	if got := m.normalizeName("marathon", "Web_App.prod"); got != "web-app-prod" {
+		t.Errorf("expected a DNS label, got %q", got)
+	}
+
+	c.ServiceNameSeparator = "_"
+	if got := m.normalizeName("marathon", "Web App"); got != "web-app" {
+		t.Errorf("expected the separator to give way to a DNS label, got %q", got)
+	}
+}
+
+func TestDNSReport(t *testing.T) {
+	service := func(id, name string, port int, tags ...string) *CacheEntry {
+		return &CacheEntry{service: &consulapi.AgentServiceRegistration{
+			ID: id, Name: name, Port: port, Tags: tags,
+			Meta: map[string]string{taskIDMeta: "web.1"},
+		}}
+	}
+
+	m := &Mesos{ServiceCache: map[ServiceKey]*CacheEntry{
+		{"a", localDatacenter}: service("a", "web", 31000),
+		{"b", localDatacenter}: service("b", "web", 31001, "env=prod"),
+		{"c", localDatacenter}: service("c", "web.admin", 0),
+		{"d", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "d", Name: "mesos"}},
+	}}
+
+	got := m.DNSReport()
+	want := []DNSProblem{
+		{localDatacenter, "a", "web", DNSSharedName, "2 ports of task web.1"},
+		{localDatacenter, "b", "web", DNSInvalidTags, "env=prod"},
+		{localDatacenter, "b", "web", DNSSharedName, "2 ports of task web.1"},
+		{localDatacenter, "c", "web.admin", DNSNoPort, ""},
+		{localDatacenter, "c", "web.admin", DNSInvalidName, `"web.admin" would be "web-admin"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected report:\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package mesos
+
+import (
+	"strconv"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Task label overriding --min-healthy-before-drain for its service
+const minHealthyLabel = "min-healthy-before-drain"
+
+// Work out how many running instances each task service needs before
+// its instances that went away are deregistered, by the names the
+// services are registered under, see drainNames(). Services no longer
+// in the state at all have no minimum and are drained right away.
+func (m *Mesos) drainMinimums(sj StateJSON) map[string]int {
+	minimums := make(map[string]int)
+	names, _ := m.serviceNames(sj)
+
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			name := names[task]
+
+			min := m.config.MinHealthyBeforeDrain
+			if v := task.label(minHealthyLabel); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					hclog.L().Warn("Ignoring invalid label", append(task.logFields(), "label", minHealthyLabel, "value", v)...)
+				} else {
+					min = n
+				}
+			}
+
+			for _, name := range m.drainNames(name, task) {
+				if min > minimums[name] {
+					minimums[name] = min
+				}
+			}
+		}
+	}
+
+	return minimums
+}
+
+// The names the services of a task named sname are registered under,
+// one per port, which drainable() looks the minimums up by
+func (m *Mesos) drainNames(sname string, task *Task) []string {
+	if task.Resources.Ports == "" {
+		return []string{sname}
+	}
+
+	var names []string
+	for i, port := range yankPorts(task.Resources.Ports) {
+		name, _ := m.taskPortService(sname, task, i, port)
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Return a check telling whether the unmarked entry of a service can
+// be drained, i.e. enough instances of the service are running and
+// registered in this sync to take over from it
+func (m *Mesos) drainable() func(*CacheEntry) bool {
+	running := make(map[string]int)
+	for _, b := range m.ServiceCache {
+		if b.isRegistered {
+			running[b.service.Name]++
+		}
+	}
+
+	return func(b *CacheEntry) bool {
+		min := m.minHealthy[b.service.Name]
+		if running[b.service.Name] >= min {
+			return true
+		}
+
+		hclog.L().Info("Holding service until enough instances are running", "service_id", b.service.ID, "service", b.service.Name, "min", min, "running", running[b.service.Name])
+		return false
+	}
+}
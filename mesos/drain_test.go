@@ -0,0 +1,68 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestDrainMinimums(t *testing.T) {
+	c := config.DefaultConfig()
+	c.MinHealthyBeforeDrain = 1
+
+	m := &Mesos{config: c}
+
+	sj := StateJSON{
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{Name: "web"},
+				{Name: "api", Labels: []Label{{Key: "min-healthy-before-drain", Value: "3"}}},
+				{Name: "db", Labels: []Label{{Key: "min-healthy-before-drain", Value: "many"}}},
+				{
+					Name:      "cache",
+					Labels:    []Label{{Key: "min-healthy-before-drain", Value: "2"}, {Key: "consul_port_1_name", Value: "admin"}},
+					Resources: Resources{Ports: "[31000-31001]"},
+				},
+			}},
+		},
+	}
+
+	minimums := m.drainMinimums(sj)
+	if minimums["web"] != 1 || minimums["api"] != 3 || minimums["db"] != 1 {
+		t.Errorf("unexpected minimums: %v", minimums)
+	}
+
+	// Keyed by the names of the port services, as drainable() looks
+	// them up
+	if minimums["cache"] != 2 || minimums["admin"] != 2 {
+		t.Errorf("unexpected minimums of the port services: %v", minimums)
+	}
+}
+
+func TestDrainable(t *testing.T) {
+	entry := func(id string, registered bool) *CacheEntry {
+		return &CacheEntry{
+			service:      &consulapi.AgentServiceRegistration{ID: id, Name: "web"},
+			isRegistered: registered,
+		}
+	}
+
+	old := entry("mesos-consul:old", false)
+	m := &Mesos{
+		minHealthy: map[string]int{"web": 2},
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:old", localDatacenter}:  old,
+			{"mesos-consul:new1", localDatacenter}: entry("mesos-consul:new1", true),
+		},
+	}
+
+	if m.drainable()(old) {
+		t.Error("expected the old instance to be held with 1 of 2 instances running")
+	}
+
+	m.ServiceCache[ServiceKey{"mesos-consul:new2", localDatacenter}] = entry("mesos-consul:new2", true)
+	if !m.drainable()(old) {
+		t.Error("expected the old instance to drain with 2 of 2 instances running")
+	}
+}
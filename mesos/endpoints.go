@@ -0,0 +1,54 @@
+package mesos
+
+import (
+	"fmt"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// The path of the metrics of the masters and agents
+const metricsPath = "/metrics/snapshot"
+
+// The service meta of the endpoint services: the URL of a UI and the
+// path of the metrics
+const (
+	urlMeta         = "url"
+	metricsPathMeta = "metrics_path"
+)
+
+// With --register-mesos-endpoints, the services of the UI and metrics
+// endpoints of a master or agent, e.g. mesos-master-ui and
+// mesos-agent-metrics, for monitoring to find its scrape targets. role
+// names the node, master or agent, id tells it apart and health is the
+// path checking the UI, the one the mesos service of the node checks.
+// The metrics are checked by reading them.
+func (m *Mesos) endpointServices(role string, id string, host string, port int, health string, tags []string) []*consulapi.AgentServiceRegistration {
+	if !m.config.RegisterMesosEndpoints {
+		return nil
+	}
+
+	addr := hostPort(host, port)
+	ui := "mesos-" + role + "-ui"
+	metrics := "mesos-" + role + "-metrics"
+
+	return []*consulapi.AgentServiceRegistration{
+		{
+			ID:      fmt.Sprintf("mesos-consul:%s:%s", ui, id),
+			Name:    ui,
+			Port:    port,
+			Address: host,
+			Tags:    append([]string(nil), tags...),
+			Meta:    map[string]string{urlMeta: m.mesosURL(addr, "/")},
+			Check:   m.hostCheck(m.mesosURL(addr, health)),
+		},
+		{
+			ID:      fmt.Sprintf("mesos-consul:%s:%s", metrics, id),
+			Name:    metrics,
+			Port:    port,
+			Address: host,
+			Tags:    append([]string(nil), tags...),
+			Meta:    map[string]string{metricsPathMeta: metricsPath},
+			Check:   m.hostCheck(m.mesosURL(addr, metricsPath)),
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package mesos
+
+import (
+	"context"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/hook"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Actions reported in Consul events
+const (
+	eventRegister   = "register"
+	eventDeregister = "deregister"
+)
+
+// Fire a Consul event for a registration change when enabled with
+// --emit-consul-events, and notify the --hook-* hooks, telling them
+// how the task ended for the deregistration of a task service
+func (m *Mesos) emitEvent(ctx context.Context, action string, s *consulapi.AgentServiceRegistration, end termination) {
+	if m.hooks != nil {
+		m.hooks.Fire(hook.Event{
+			Action:      action,
+			ID:          s.ID,
+			Name:        s.Name,
+			Address:     s.Address,
+			Port:        s.Port,
+			TaskState:   end.State,
+			TaskReason:  end.Reason,
+			TaskMessage: end.Message,
+		})
+	}
+
+	if !m.config.EmitEvents {
+		return
+	}
+
+	if err := m.Registry.FireEvent(ctx, m.config.EventName, action, s); err != nil {
+		hclog.L().Error("Unable to fire the event", "service_id", s.ID, "action", action, "error", err)
+	}
+}
+
+// The hooks configured with --hook-exec, --hook-slack and
+// --hook-webhook, or nil without any. A dry run notifies none.
+func newHooks(c *config.Config) hook.Hook {
+	if c.DryRun {
+		return nil
+	}
+
+	var hooks []hook.Hook
+	if c.HookExec != "" {
+		hooks = append(hooks, hook.Exec(c.HookExec))
+	}
+	if c.HookSlack != "" {
+		hooks = append(hooks, hook.Slack(c.HookSlack))
+	}
+	if c.HookWebhook != "" {
+		hooks = append(hooks, hook.Webhook(c.HookWebhook))
+	}
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	return hook.Queue(hookQueueSize, hooks...)
+}
+
+// Events waiting for slow hooks before new ones are dropped
+const hookQueueSize = 1000
@@ -0,0 +1,234 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// With --export-state, write the topology of the cluster under
+// <kv-prefix>/state/ for consumers such as consul-template:
+//
+//	leader                          host:port of the leading master
+//	masters/<host:port>             "leader" or "master"
+//	slaves/<id>/hostname            host name of a Mesos agent
+//	slaves/<id>/pid                 its libprocess PID
+//	slaves/<id>/attributes/<name>   each of its attributes
+//	frameworks/<id>/name            name of a framework
+//	frameworks/<id>/webui_url       its web UI, when it has one
+//	frameworks/<id>/running_tasks   number of its running tasks
+//
+// Only the keys that changed since the last export are written, and
+// those gone from the state are deleted, including those a previous
+// run or --lock holder left: the keys under the prefix are read on
+// first use, and again after a failed write.
+func (m *Mesos) exportState(sj StateJSON) {
+	if !m.config.ExportState {
+		return
+	}
+
+	if m.exported == nil {
+		exported, err := m.listExported(m.stateKey())
+		if err != nil {
+			return
+		}
+		m.exported = exported
+	}
+
+	values := m.stateValues(sj)
+	puts, deletes := changedEntries(values, m.exported)
+	if len(puts) == 0 && len(deletes) == 0 {
+		return
+	}
+
+	err := m.Registry.Txn(m.syncCtx(), puts, deletes)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to export the state", "key", m.stateKey()+"/", "error", err)
+		m.exported = nil
+		return
+	}
+
+	m.exported = values
+}
+
+// The keys already exported under prefix, never nil once read
+func (m *Mesos) listExported(prefix string) (map[string][]byte, error) {
+	exported, _, err := m.Registry.List(m.syncCtx(), prefix+"/", 0, 0)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to read the exported keys", "key", prefix+"/", "error", err)
+		return nil, err
+	}
+	if exported == nil {
+		exported = make(map[string][]byte)
+	}
+
+	return exported, nil
+}
+
+// The KV entries describing the cluster, see exportState()
+func (m *Mesos) stateValues(sj StateJSON) map[string][]byte {
+	prefix := m.stateKey()
+	values := make(map[string][]byte)
+	set := func(value string, path ...string) {
+		for i, p := range path {
+			path[i] = url.PathEscape(p)
+		}
+		values[prefix+"/"+strings.Join(path, "/")] = []byte(value)
+	}
+
+	if i := strings.Index(sj.Leader, "@"); i >= 0 {
+		set(sj.Leader[i+1:], "leader")
+	}
+
+	for _, ma := range m.getMasters() {
+		role := "master"
+		if ma.isLeader {
+			role = "leader"
+		}
+		set(role, "masters", hostPort(ma.host, ma.port))
+	}
+
+	for _, f := range sj.Followers {
+		set(f.Hostname, "slaves", f.Id, "hostname")
+		set(f.Pid, "slaves", f.Id, "pid")
+		for name, value := range f.Attributes {
+			set(fmt.Sprint(value), "slaves", f.Id, "attributes", name)
+		}
+	}
+
+	for _, fw := range sj.Frameworks {
+		running := 0
+		for _, task := range fw.Tasks {
+			if task.State == "TASK_RUNNING" {
+				running++
+			}
+		}
+
+		set(fw.Name, "frameworks", fw.Id, "name")
+		if fw.WebuiURL != "" {
+			set(fw.WebuiURL, "frameworks", fw.Id, "webui_url")
+		}
+		set(strconv.Itoa(running), "frameworks", fw.Id, "running_tasks")
+	}
+
+	return values
+}
+
+// The KV prefix the state is exported under
+func (m *Mesos) stateKey() string {
+	return m.config.KVPrefix + "/state"
+}
+
+// The document --export-services writes for a task service
+type serviceDocument struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Address    string            `json:"address"`
+	Port       int               `json:"port"`
+	Tags       []string          `json:"tags"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Datacenter string            `json:"datacenter,omitempty"`
+	Agent      string            `json:"agent,omitempty"`
+	Health     string            `json:"health"`
+}
+
+// With --export-services, write a JSON document per task service
+// under <kv-prefix>/services/<name>/<id>, or <name>/<datacenter>/<id>
+// for the services of other datacenters, so consul-template and
+// envconsul can render task health and metadata the catalog omits.
+// Services of tasks gone from the state are deleted right away, even
+// while waiting out --deregister-delay. As with exportState(), only
+// the documents that changed are written, and those a previous run
+// left are deleted.
+func (m *Mesos) exportServices(sj StateJSON) {
+	if !m.config.ExportServices {
+		return
+	}
+
+	if m.exportedServices == nil {
+		exported, err := m.listExported(m.servicesKey())
+		if err != nil {
+			return
+		}
+		m.exportedServices = exported
+	}
+
+	values := m.serviceValues(sj)
+	puts, deletes := changedEntries(values, m.exportedServices)
+	if len(puts) == 0 && len(deletes) == 0 {
+		return
+	}
+
+	err := m.Registry.Txn(m.syncCtx(), puts, deletes)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to export the services", "key", m.servicesKey()+"/", "error", err)
+		m.exportedServices = nil
+		return
+	}
+
+	m.exportedServices = values
+}
+
+// The KV entries of the cached task services, see exportServices()
+func (m *Mesos) serviceValues(sj StateJSON) map[string][]byte {
+	tasks := make(map[string]*Task)
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			tasks[fw.Tasks[i].Id] = &fw.Tasks[i]
+		}
+	}
+
+	values := make(map[string][]byte)
+	for key, b := range m.ServiceCache {
+		s := b.service
+		task, ok := tasks[s.Meta[taskIDMeta]]
+		if !ok || task.State != "TASK_RUNNING" {
+			continue
+		}
+
+		doc := serviceDocument{
+			ID:         s.ID,
+			Name:       s.Name,
+			Address:    s.Address,
+			Port:       s.Port,
+			Tags:       s.Tags,
+			Meta:       s.Meta,
+			Datacenter: key.Datacenter,
+			Agent:      b.agent,
+			Health:     consulapi.HealthPassing,
+		}
+		if healthy, ok := taskHealthy(task); ok && !healthy {
+			doc.Health = consulapi.HealthCritical
+		}
+		if doc.Tags == nil {
+			doc.Tags = []string{}
+		}
+
+		value, err := json.Marshal(doc)
+		if err != nil {
+			hclog.L().Warn("Unable to export service", "service_id", s.ID, "error", err)
+			continue
+		}
+
+		path := []string{url.PathEscape(s.Name), url.PathEscape(s.ID)}
+		if key.Datacenter != localDatacenter {
+			path = []string{path[0], url.PathEscape(key.Datacenter), path[1]}
+		}
+		values[m.servicesKey()+"/"+strings.Join(path, "/")] = value
+	}
+
+	return values
+}
+
+// The KV prefix the task services are exported under
+func (m *Mesos) servicesKey() string {
+	return m.config.KVPrefix + "/services"
+}
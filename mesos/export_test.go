@@ -0,0 +1,150 @@
+package mesos
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+type txnRegistry struct {
+	fakeRegistry
+	puts    map[string][]byte
+	deletes []string
+}
+
+func (r *txnRegistry) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	r.puts, r.deletes = puts, deletes
+	return nil
+}
+
+func TestExportState(t *testing.T) {
+	r := &txnRegistry{fakeRegistry: *newFakeRegistry()}
+	c := config.DefaultConfig()
+	c.ExportState = true
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		Masters:  &[]MesosHost{{host: "10.0.0.1", port: "5050", isLeader: true}},
+	}
+
+	sj := StateJSON{
+		Leader:    "master@10.0.0.1:5050",
+		Followers: Followers{{Id: "s-1", Hostname: "node-1", Pid: "slave(1)@10.0.0.2:5051", Attributes: map[string]interface{}{"rack": "r1"}}},
+		Frameworks: Frameworks{{
+			Tasks: Tasks{{Id: "web.1", State: "TASK_RUNNING"}, {Id: "web.2", State: "TASK_STAGING"}},
+			Id:    "fw-1",
+			Name:  "marathon",
+		}},
+	}
+
+	m.exportState(sj)
+
+	expected := map[string]string{
+		"mesos-consul/state/leader":                        "10.0.0.1:5050",
+		"mesos-consul/state/masters/10.0.0.1:5050":         "leader",
+		"mesos-consul/state/slaves/s-1/hostname":           "node-1",
+		"mesos-consul/state/slaves/s-1/pid":                "slave(1)@10.0.0.2:5051",
+		"mesos-consul/state/slaves/s-1/attributes/rack":    "r1",
+		"mesos-consul/state/frameworks/fw-1/name":          "marathon",
+		"mesos-consul/state/frameworks/fw-1/running_tasks": "1",
+	}
+	if len(r.puts) != len(expected) {
+		t.Errorf("expected %d keys, got %d", len(expected), len(r.puts))
+	}
+	for key, value := range expected {
+		if string(r.puts[key]) != value {
+			t.Errorf("%s: expected %q, got %q", key, value, r.puts[key])
+		}
+	}
+
+	// Only the changes are written on the next sync
+	sj.Followers = nil
+	r.puts = nil
+	m.exportState(sj)
+	if len(r.puts) != 0 || len(r.deletes) != 3 {
+		t.Errorf("expected the agent's keys to be deleted, got %d puts and %v", len(r.puts), r.deletes)
+	}
+}
+
+func TestExportStateLeftover(t *testing.T) {
+	r := &kvRegistry{newFakeRegistry(), map[string][]byte{
+		"mesos-consul/state/leader":               []byte("10.0.0.1:5050"),
+		"mesos-consul/state/slaves/gone/hostname": []byte("node-0"),
+		"mesos-consul/statement":                  []byte("other"),
+	}}
+	c := config.DefaultConfig()
+	c.ExportState = true
+	m := &Mesos{Registry: r, config: c, Masters: &[]MesosHost{}}
+
+	m.exportState(StateJSON{Leader: "master@10.0.0.1:5050"})
+	if _, ok := r.kv["mesos-consul/state/slaves/gone/hostname"]; ok {
+		t.Error("expected the key of a previous run to be deleted")
+	}
+	if len(r.kv) != 2 || string(r.kv["mesos-consul/state/leader"]) != "10.0.0.1:5050" {
+		t.Errorf("expected the leader and the key outside the prefix kept, got %v", r.kv)
+	}
+}
+
+func TestExportServices(t *testing.T) {
+	r := &txnRegistry{fakeRegistry: *newFakeRegistry()}
+	c := config.DefaultConfig()
+	c.ExportServices = true
+	m := &Mesos{Registry: r, config: c}
+
+	unhealthy := false
+	m.ServiceCache = map[ServiceKey]*CacheEntry{
+		{"web-1", localDatacenter}: {service: &consulapi.AgentServiceRegistration{
+			ID: "web-1", Name: "web", Address: "10.0.0.2", Port: 31000, Tags: []string{"v2"},
+			Meta: map[string]string{taskIDMeta: "web.1"},
+		}},
+		{"web-2", "dc2"}: {service: &consulapi.AgentServiceRegistration{
+			ID: "web-2", Name: "web", Address: "10.0.0.3", Port: 31001,
+			Meta: map[string]string{taskIDMeta: "web.2"},
+		}},
+		{"node-1", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "node-1", Name: "mesos"}},
+	}
+	sj := StateJSON{Frameworks: Frameworks{{Tasks: Tasks{
+		{Id: "web.1", State: "TASK_RUNNING"},
+		{Id: "web.2", State: "TASK_RUNNING", Statuses: []Status{{State: "TASK_RUNNING", Healthy: &unhealthy}}},
+	}}}}
+
+	m.exportServices(sj)
+
+	if len(r.puts) != 2 {
+		t.Fatalf("expected the 2 task services to be exported, got %v", r.puts)
+	}
+
+	var doc serviceDocument
+	if err := json.Unmarshal(r.puts["mesos-consul/services/web/web-1"], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Address != "10.0.0.2" || doc.Port != 31000 || doc.Health != consulapi.HealthPassing || len(doc.Tags) != 1 {
+		t.Errorf("unexpected document %+v", doc)
+	}
+
+	if err := json.Unmarshal(r.puts["mesos-consul/services/web/dc2/web-2"], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Datacenter != "dc2" || doc.Health != consulapi.HealthCritical {
+		t.Errorf("unexpected document %+v", doc)
+	}
+
+	// The document of a finished task goes at once
+	sj.Frameworks[0].Tasks[0].State = "TASK_FINISHED"
+	r.puts = nil
+	m.exportServices(sj)
+	if len(r.puts) != 0 || len(r.deletes) != 1 || r.deletes[0] != "mesos-consul/services/web/web-1" {
+		t.Errorf("expected web-1 to be deleted, got %d puts and %v", len(r.puts), r.deletes)
+	}
+
+	// The documents of a previous run are deleted on first use
+	kv := &kvRegistry{newFakeRegistry(), map[string][]byte{"mesos-consul/services/web/web-0": []byte(`{}`)}}
+	m = &Mesos{Registry: kv, config: c, ServiceCache: m.ServiceCache}
+	m.exportServices(sj)
+	if _, ok := kv.kv["mesos-consul/services/web/web-0"]; ok || len(kv.kv) != 1 {
+		t.Errorf("expected only web-2 left exported, got %v", kv.kv)
+	}
+}
@@ -0,0 +1,69 @@
+package mesos
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// A Client replaying recorded states, see --from-file
+type fileClient struct {
+	lock  sync.Mutex
+	files []string
+	next  int
+}
+
+// NewFileClient returns a Client reading the state from path, a
+// /master/state or state.json answer saved to a file, or a directory
+// of them. The snapshots of a directory are replayed in the order of
+// their names, e.g. timestamps, one per sync, and the last one is
+// synced again once they are all replayed.
+func NewFileClient(path string) (Client, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return &fileClient{files: []string{path}}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, &os.PathError{Op: "replay", Path: path, Err: os.ErrNotExist}
+	}
+	sort.Strings(files)
+
+	return &fileClient{files: files}, nil
+}
+
+func (f *fileClient) State(ctx context.Context) (StateJSON, error) {
+	f.lock.Lock()
+	file := f.files[f.next]
+	if f.next < len(f.files)-1 {
+		f.next++
+	}
+	f.lock.Unlock()
+
+	hclog.L().Info("Replaying the Mesos state", "file", file)
+
+	var sj StateJSON
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return sj, err
+	}
+
+	if err := json.Unmarshal(data, &sj); err != nil {
+		return sj, &os.PathError{Op: "decode", Path: file, Err: err}
+	}
+
+	return sj, nil
+}
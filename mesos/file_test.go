@@ -0,0 +1,44 @@
+package mesos
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileClient(t *testing.T) {
+	dir := t.TempDir()
+	for name, leader := range map[string]string{
+		"2026-10-14T09:00:00.json": "master@10.0.0.1:5050",
+		"2026-10-14T09:01:00.json": "master@10.0.0.2:5050",
+		"notes.txt":                "",
+	} {
+		os.WriteFile(filepath.Join(dir, name), []byte(`{"leader":"`+leader+`"}`), 0644)
+	}
+
+	client, err := NewFileClient(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"master@10.0.0.1:5050", "master@10.0.0.2:5050", "master@10.0.0.2:5050"} {
+		if sj, err := client.State(context.Background()); err != nil || sj.Leader != want {
+			t.Errorf("expected the state led by %s, got %+v, %v", want, sj, err)
+		}
+	}
+
+	file := filepath.Join(dir, "2026-10-14T09:00:00.json")
+	client, err = NewFileClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(file, []byte(`{`), 0644)
+	if _, err := client.State(context.Background()); err == nil {
+		t.Error("expected an unreadable snapshot to fail the sync")
+	}
+
+	if _, err := NewFileClient(t.TempDir()); err == nil {
+		t.Error("expected a directory without snapshots to fail")
+	}
+}
@@ -0,0 +1,82 @@
+package mesos
+
+import (
+	"regexp"
+)
+
+// Frameworks and tasks to sync, see --fw-whitelist, --fw-blacklist,
+// --task-whitelist, --task-blacklist, --role-whitelist and
+// --role-blacklist. A nil whitelist lets every name through.
+type filter struct {
+	fwWhitelist   *regexp.Regexp
+	fwBlacklist   *regexp.Regexp
+	taskWhitelist *regexp.Regexp
+	taskBlacklist *regexp.Regexp
+	roleWhitelist *regexp.Regexp
+	roleBlacklist *regexp.Regexp
+}
+
+func compileFilter(expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+
+	return regexp.MustCompile(expr)
+}
+
+func allowed(name string, whitelist, blacklist *regexp.Regexp) bool {
+	if whitelist != nil && !whitelist.MatchString(name) {
+		return false
+	}
+
+	return blacklist == nil || !blacklist.MatchString(name)
+}
+
+// Drop the frameworks and tasks that are not to be synced from the
+// state, as if Mesos never reported them
+func (m *Mesos) filterState(sj StateJSON) StateJSON {
+	f := m.filter
+	if f == (filter{}) {
+		return sj
+	}
+
+	frameworks := sj.Frameworks[:0:0]
+	for _, fw := range sj.Frameworks {
+		if !allowed(fw.Name, f.fwWhitelist, f.fwBlacklist) {
+			continue
+		}
+
+		tasks := make(Tasks, 0, len(fw.Tasks))
+		for _, task := range fw.Tasks {
+			if !allowed(task.Name, f.taskWhitelist, f.taskBlacklist) {
+				continue
+			}
+			if (f.roleWhitelist != nil || f.roleBlacklist != nil) && !allowed(taskRole(fw.Role, fw.Roles, task), f.roleWhitelist, f.roleBlacklist) {
+				continue
+			}
+			tasks = append(tasks, task)
+		}
+		fw.Tasks = tasks
+
+		frameworks = append(frameworks, fw)
+	}
+	sj.Frameworks = frameworks
+
+	return sj
+}
+
+// The Mesos role of a task: the one its resources are allocated to
+// or reserved for, as Mesos reports it, and otherwise the role of its
+// framework, the first of them for frameworks of several roles
+func taskRole(role string, roles []string, task Task) string {
+	switch {
+	case task.Role != "":
+		return task.Role
+	case role != "":
+		return role
+	case len(roles) > 0:
+		return roles[0]
+	}
+
+	return "*"
+}
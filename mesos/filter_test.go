@@ -0,0 +1,80 @@
+package mesos
+
+import (
+	"testing"
+)
+
+func TestFilterState(t *testing.T) {
+	sj := StateJSON{Frameworks: Frameworks{
+		{Name: "marathon", Tasks: Tasks{{Name: "web"}, {Name: "web-canary"}, {Name: "api"}}},
+		{Name: "batch-etl", Tasks: Tasks{{Name: "job"}}},
+	}}
+
+	m := &Mesos{filter: filter{
+		fwBlacklist:   compileFilter("^batch-"),
+		taskWhitelist: compileFilter("^(web|api)"),
+		taskBlacklist: compileFilter("-canary$"),
+	}}
+
+	got := m.filterState(sj)
+	if len(got.Frameworks) != 1 || got.Frameworks[0].Name != "marathon" {
+		t.Fatalf("expected only the marathon framework, got %v", got.Frameworks)
+	}
+
+	tasks := got.Frameworks[0].Tasks
+	if len(tasks) != 2 || tasks[0].Name != "web" || tasks[1].Name != "api" {
+		t.Errorf("unexpected tasks: %v", tasks)
+	}
+
+	if len(sj.Frameworks) != 2 || len(sj.Frameworks[0].Tasks) != 3 {
+		t.Error("expected the original state to be left alone")
+	}
+
+	if got := (&Mesos{}).filterState(sj); len(got.Frameworks) != 2 {
+		t.Error("expected every framework without filters")
+	}
+}
+
+func TestFilterRoles(t *testing.T) {
+	sj := StateJSON{Frameworks: Frameworks{
+		{Name: "marathon", Role: "team-a", Tasks: Tasks{{Name: "web"}, {Name: "api", Role: "team-b"}, {Name: "db", Role: "team-a/db"}}},
+		{Name: "aurora", Roles: []string{"team-b", "team-a"}, Tasks: Tasks{{Name: "job"}}},
+		{Name: "chronos", Tasks: Tasks{{Name: "cron"}}},
+	}}
+
+	m := &Mesos{filter: filter{
+		roleWhitelist: compileFilter("^(team-a(/|$)|\\*$)"),
+		roleBlacklist: compileFilter("/db$"),
+	}}
+
+	got := m.filterState(sj)
+	var names []string
+	for _, fw := range got.Frameworks {
+		for _, task := range fw.Tasks {
+			names = append(names, task.Name)
+		}
+	}
+	if len(names) != 2 || names[0] != "web" || names[1] != "cron" {
+		t.Errorf("expected the web and cron tasks, got %v", names)
+	}
+}
+
+func TestTaskRole(t *testing.T) {
+	tests := []struct {
+		role  string
+		roles []string
+		task  Task
+		want  string
+	}{
+		{"fw", nil, Task{Role: "task"}, "task"},
+		{"fw", []string{"other"}, Task{}, "fw"},
+		{"", []string{"first", "second"}, Task{}, "first"},
+		{"", nil, Task{}, "*"},
+	}
+
+	for _, tt := range tests {
+		if got := taskRole(tt.role, tt.roles, tt.task); got != tt.want {
+			t.Errorf("taskRole(%q, %v) = %q, want %q", tt.role, tt.roles, got, tt.want)
+		}
+	}
+}
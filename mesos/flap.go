@@ -0,0 +1,176 @@
+package mesos
+
+import (
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The tag of the task services of a flapping app, see --flap-threshold
+const flappingTag = "flapping"
+
+// The registrations of the task services of the apps, to tell the
+// crash-looping ones apart, see --flap-threshold. An app is the task
+// services of a name in a framework. Only kept in memory.
+type flapTracker struct {
+	sync.Mutex
+
+	// When the task services still registered were registered, by ID
+	registered map[string]time.Time
+
+	// The flaps of the apps within --flap-window, by app
+	flaps map[string][]time.Time
+
+	// Until when the new registrations of the flapping apps are held
+	// off, by app
+	heldUntil map[string]time.Time
+
+	flapsTotal int
+	heldTotal  int
+}
+
+// The app of the task service s
+func flapApp(s *consulapi.AgentServiceRegistration) string {
+	return s.Meta[frameworkMeta] + "/" + s.Name
+}
+
+// Record a change to a task service applied at now. A service
+// deregistered as its task is gone, or registered again for another
+// launch of it, within --flap-window of its registration flaps. An app
+// flapping --flap-threshold times in the window has its new
+// registrations held off for --flap-backoff from the last flap.
+func (m *Mesos) recordFlap(action string, reason string, s *consulapi.AgentServiceRegistration, now time.Time) {
+	c := m.config
+	if c.FlapThreshold <= 0 || s.Meta[taskIDMeta] == "" {
+		return
+	}
+
+	f := &m.flaps
+	f.Lock()
+	defer f.Unlock()
+
+	if f.registered == nil {
+		f.registered = make(map[string]time.Time)
+		f.flaps = make(map[string][]time.Time)
+		f.heldUntil = make(map[string]time.Time)
+	}
+
+	since, known := f.registered[s.ID]
+	flapped := known && now.Sub(since) < c.FlapWindow
+	switch {
+	case action == eventDeregister:
+		delete(f.registered, s.ID)
+		flapped = flapped && reason == reasonGone
+	case reason == reasonRelaunched || !known:
+		f.registered[s.ID] = now
+		flapped = flapped && reason == reasonRelaunched
+	default:
+		flapped = false
+	}
+	if !flapped {
+		return
+	}
+
+	app := flapApp(s)
+	f.flapsTotal++
+	f.flaps[app] = append(f.recent(app, now, c.FlapWindow), now)
+	if len(f.flaps[app]) < c.FlapThreshold {
+		return
+	}
+
+	if _, ok := f.heldUntil[app]; !ok {
+		hclog.L().Warn("App flapping. Holding off its new registrations", "app", app, "flaps", len(f.flaps[app]), "window", c.FlapWindow, "backoff", c.FlapBackoff)
+	}
+	f.heldUntil[app] = now.Add(c.FlapBackoff)
+}
+
+// The flaps of app within window of now
+func (f *flapTracker) recent(app string, now time.Time, window time.Duration) []time.Time {
+	flaps := f.flaps[app]
+	for len(flaps) > 0 && now.Sub(flaps[0]) >= window {
+		flaps = flaps[1:]
+	}
+	if len(flaps) == 0 {
+		delete(f.flaps, app)
+		return nil
+	}
+
+	f.flaps[app] = flaps
+	return flaps
+}
+
+// Tell whether app is flapping at now, still within its backoff or
+// its flaps not yet out of --flap-window, and whether its new
+// registrations are held off. An app past both is stable again.
+func (m *Mesos) flapping(app string, now time.Time) (flapping bool, held bool) {
+	f := &m.flaps
+	f.Lock()
+	defer f.Unlock()
+
+	until, ok := f.heldUntil[app]
+	if !ok {
+		return false, false
+	}
+
+	held = now.Before(until)
+	if !held && len(f.recent(app, now, m.config.FlapWindow)) == 0 {
+		hclog.L().Info("App stable again", "app", app)
+		delete(f.heldUntil, app)
+		return false, false
+	}
+
+	return true, held
+}
+
+// The task services, and their agents by ID, with those of the
+// flapping apps tagged flapping and without the new registrations held
+// off, see --flap-threshold. The services of the tasks already
+// registered are kept as they are, those of relaunches of them held
+// off too.
+func (m *Mesos) withoutHeldOff(services []*consulapi.AgentServiceRegistration, agents map[string]string) []*consulapi.AgentServiceRegistration {
+	if m.config.FlapThreshold <= 0 {
+		return services
+	}
+
+	now := time.Now()
+	kept := services[:0:0]
+	for _, s := range services {
+		flapping, held := m.flapping(flapApp(s), now)
+		if !flapping {
+			kept = append(kept, s)
+			continue
+		}
+
+		b, cached := m.ServiceCache[ServiceKey{s.ID, localDatacenter}]
+		if held && (!cached || relaunched(b.service, s)) {
+			hclog.L().Debug("App flapping. Not registering", "service_id", s.ID, "app", flapApp(s))
+			if cached {
+				b.isRegistered = true
+			}
+			delete(agents, s.ID)
+
+			m.flaps.Lock()
+			m.flaps.heldTotal++
+			m.flaps.Unlock()
+			continue
+		}
+
+		tagged := *s
+		tagged.Tags = append(append([]string(nil), s.Tags...), flappingTag)
+		kept = append(kept, &tagged)
+	}
+
+	return kept
+}
+
+// The totals of the flap dampening: the flaps, the apps flapping and
+// the registrations held off
+func (m *Mesos) flapStats() (flaps int, apps int, held int) {
+	f := &m.flaps
+	f.Lock()
+	defer f.Unlock()
+
+	return f.flapsTotal, len(f.heldUntil), f.heldTotal
+}
@@ -0,0 +1,77 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlapping(t *testing.T) {
+	h := newHarness(t, 1)
+	c := h.mesos.config
+	c.FlapThreshold = 2
+	c.FlapWindow = time.Hour
+	c.FlapBackoff = time.Hour
+
+	// A task service is kept for a sync, see --deregister-delay
+	sync := func() {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			if err := h.sync(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tagged := func(name string) bool {
+		h.registry.lock.Lock()
+		defer h.registry.lock.Unlock()
+		for _, s := range h.registry.services {
+			if s.service.Name == name {
+				return contains(s.service.Tags, flappingTag)
+			}
+		}
+		t.Fatalf("expected %s in Consul", name)
+		return false
+	}
+
+	h.run("db", 31001)
+	for _, port := range []int{31000, 31002} {
+		h.run("web", port)
+		sync()
+		h.kill("web")
+		sync()
+	}
+	if flaps, apps, _ := h.mesos.flapStats(); flaps != 2 || apps != 1 {
+		t.Fatalf("expected 2 flaps of 1 app, got %d of %d", flaps, apps)
+	}
+
+	h.run("web", 31003)
+	sync()
+	if got, want := h.taskServices(), []string{"db@10.0.0.1:31001"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the flapping web to be held off, got %v", got)
+	}
+	if _, _, held := h.mesos.flapStats(); held != 2 {
+		t.Errorf("expected the registration held off on both syncs, got %d", held)
+	}
+	if tagged("db") {
+		t.Error("expected the stable db to be left untagged")
+	}
+
+	// Past the backoff, its flaps still within the window
+	for app := range h.mesos.flaps.heldUntil {
+		h.mesos.flaps.heldUntil[app] = time.Now()
+	}
+	sync()
+	if !tagged("web") {
+		t.Errorf("expected web to be registered tagged flapping after the backoff, got %v", h.taskServices())
+	}
+
+	c.FlapWindow = time.Nanosecond
+	sync()
+	if tagged("web") {
+		t.Error("expected web to lose the flapping tag once stable")
+	}
+	if _, apps, _ := h.mesos.flapStats(); apps != 0 {
+		t.Errorf("expected no app flapping, got %d", apps)
+	}
+}
@@ -2,8 +2,14 @@ package mesos
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// The attribute holding a follower's role
+const roleAttribute = "role"
+
 // Look up a follwer host name by the follower ID
 func (fs *Followers) hostById(id string) (string, error) {
 	for _, f := range *fs {
@@ -14,3 +20,116 @@ func (fs *Followers) hostById(id string) (string, error) {
 
 	return "", fmt.Errorf("Follower not found: %s", id)
 }
+
+// Look up a follower by the follower ID
+func (fs *Followers) byId(id string) (*follower, error) {
+	for i := range *fs {
+		if (*fs)[i].Id == id {
+			return &(*fs)[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Follower not found: %s", id)
+}
+
+// Check whether a follower's role attribute is one of roles. An
+// empty list of roles matches every follower.
+func (f *follower) hasRole(roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	role, ok := f.Attributes[roleAttribute]
+	if !ok {
+		return false
+	}
+
+	for _, r := range roles {
+		if fmt.Sprint(role) == r {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the named attributes of a follower that it has, e.g. rack or
+// zone, see --follower-attributes
+func (f *follower) attributes(names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string]string)
+	for _, name := range names {
+		if v, ok := f.Attributes[name]; ok {
+			attrs[name] = fmt.Sprint(v)
+		}
+	}
+
+	return attrs
+}
+
+// With --follower-resources, the meta and tags describing the named
+// scalar resources of a follower, or all of them for *: a
+// resource-<name> meta holding the amount, e.g. resource-gpus=4, and a
+// <name> tag when the follower has any, e.g. gpus
+func (f *follower) resourceInfo(names []string) ([]string, map[string]string) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	if len(names) == 1 && names[0] == "*" {
+		names = make([]string, 0, len(f.Resources))
+		for name := range f.Resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var tags []string
+	meta := make(map[string]string)
+	for _, name := range names {
+		amount, ok := f.Resources[name].(float64)
+		if !ok {
+			// Ports and other ranges or sets
+			continue
+		}
+
+		if key := metaKey("resource-" + name); key != "" {
+			meta[key] = strconv.FormatFloat(amount, 'f', -1, 64)
+		}
+		if amount > 0 {
+			tags = append(tags, name)
+		}
+	}
+
+	if len(meta) == 0 {
+		meta = nil
+	}
+
+	return tags, meta
+}
+
+// With --follower-capabilities, the tags of a follower's capabilities,
+// e.g. capability-multi-role for MULTI_ROLE
+func (f *follower) capabilityTags() []string {
+	tags := make([]string, 0, len(f.Capabilities))
+	for _, c := range f.Capabilities {
+		tags = append(tags, "capability-"+strings.ToLower(strings.Replace(c, "_", "-", -1)))
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// Tag services with follower attributes as name=value, in name order
+func attributeTags(attrs map[string]string) []string {
+	tags := make([]string, 0, len(attrs))
+	for name, value := range attrs {
+		tags = append(tags, fmt.Sprintf("%s=%s", name, value))
+	}
+	sort.Strings(tags)
+
+	return tags
+}
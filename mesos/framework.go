@@ -0,0 +1,88 @@
+package mesos
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Build a service named after the framework and --framework-ui-suffix,
+// e.g. marathon-ui, for the webui_url each framework reports, when
+// enabled with --register-framework-uis. The check requests the URL.
+func (m *Mesos) frameworkServices(sj StateJSON) []*consulapi.AgentServiceRegistration {
+	if !m.config.RegisterFrameworkUIs {
+		return nil
+	}
+
+	var services []*consulapi.AgentServiceRegistration
+
+	for _, fw := range sj.Frameworks {
+		if fw.WebuiURL == "" {
+			continue
+		}
+
+		host, port, scheme, path, err := parseWebuiURL(fw.WebuiURL)
+		if err != nil {
+			hclog.L().Warn("Skipping framework UI", "framework", fw.Name, "error", err)
+			continue
+		}
+
+		address := m.address(host)
+		if address == "" {
+			continue
+		}
+		services = append(services, &consulapi.AgentServiceRegistration{
+			ID:      fmt.Sprintf("mesos-consul:ui:%s", fw.Id),
+			Name:    m.normalizeName("", fw.Name) + m.config.FrameworkUISuffix,
+			Port:    port,
+			Address: address,
+			Tags:    []string{scheme},
+			Check: &consulapi.AgentServiceCheck{
+				HTTP:     fmt.Sprintf("%s://%s%s", scheme, hostPort(address, port), path),
+				Interval: "10s",
+			},
+		})
+	}
+
+	return services
+}
+
+// Split a webui_url into its host, port, scheme and path with the
+// query. The port defaults to the scheme's.
+func parseWebuiURL(webui string) (string, int, string, string, error) {
+	u, err := url.Parse(webui)
+	if err != nil {
+		return "", 0, "", "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", 0, "", "", fmt.Errorf("unsupported scheme in %s", webui)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return "", 0, "", "", fmt.Errorf("no host in %s", webui)
+	}
+
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			return host, 443, u.Scheme, path, nil
+		}
+		return host, 80, u.Scheme, path, nil
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return "", 0, "", "", fmt.Errorf("invalid port in %s", webui)
+	}
+
+	return host, port, u.Scheme, path, nil
+}
@@ -0,0 +1,34 @@
+package mesos
+
+import (
+	"testing"
+)
+
+func TestParseWebuiURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		host   string
+		port   int
+		scheme string
+		path   string
+		ok     bool
+	}{
+		{"http://10.0.0.1:8080", "10.0.0.1", 8080, "http", "", true},
+		{"https://marathon.example.com/ui", "marathon.example.com", 443, "https", "/ui", true},
+		{"http://chronos.example.com/?ui=1", "chronos.example.com", 80, "http", "/?ui=1", true},
+		{"ftp://10.0.0.1", "", 0, "", "", false},
+		{"http://:8080", "", 0, "", "", false},
+	}
+
+	for _, tt := range tests {
+		host, port, scheme, path, err := parseWebuiURL(tt.url)
+		if tt.ok != (err == nil) {
+			t.Errorf("parseWebuiURL(%q): unexpected error state: %v", tt.url, err)
+			continue
+		}
+
+		if host != tt.host || port != tt.port || scheme != tt.scheme || path != tt.path {
+			t.Errorf("parseWebuiURL(%q) = %s, %d, %s, %s", tt.url, host, port, scheme, path)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package mesos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A harness syncs the state of fake Mesos masters into an in-memory
+// registry through the same code paths as a live cluster, so faults,
+// e.g. a master failing over or Consul losing its leader, can be
+// injected between syncs.
+type harness struct {
+	t        *testing.T
+	masters  []*fakeMaster
+	registry *memRegistry
+	mesos    *Mesos
+
+	lock  sync.Mutex
+	state StateJSON
+}
+
+// The agent every task of the harness runs on
+const harnessAgent = "10.0.0.1"
+
+// Start n fake masters, the first of them leading, with an empty
+// state and a healthy registry
+func newHarness(t *testing.T, n int) *harness {
+	h := &harness{t: t, registry: newMemRegistry()}
+	h.state.Followers = Followers{{Id: "s1", Hostname: harnessAgent, Pid: "slave(1)@" + harnessAgent + ":5051"}}
+	h.state.Frameworks = Frameworks{{Id: "f1", Name: "marathon"}}
+
+	for i := 0; i < n; i++ {
+		fm := &fakeMaster{harness: h}
+		fm.srv = httptest.NewServer(fm)
+		t.Cleanup(fm.srv.Close)
+		h.masters = append(h.masters, fm)
+	}
+	h.restart()
+	h.elect(0)
+
+	return h
+}
+
+// Start mesos-consul again, with an empty cache
+func (h *harness) restart() {
+	c := config.DefaultConfig()
+	c.MesosStateAPI = config.MesosStateHTTP
+	c.MesosRetries = 0
+	c.RegistryRetries = 0
+	c.MesosTimeout = time.Second
+	// No retries of failed registrations between the syncs
+	c.Once = true
+
+	// Read the state from the masters, as found in Zookeeper, rather
+	// than from a client
+	m, err := NewWithClient(c, h.registry, &fakeClient{})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	m.client = nil
+	m.Masters = &[]MesosHost{}
+	if h.mesos != nil {
+		m.Masters = h.mesos.Masters
+	}
+	h.mesos = m
+}
+
+// Let the fake Zookeeper name master i the leader
+func (h *harness) elect(i int) {
+	m := h.mesos
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	hosts := make([]MesosHost, len(h.masters))
+	for j, fm := range h.masters {
+		host, port, _ := net.SplitHostPort(fm.srv.Listener.Addr().String())
+		hosts[j] = MesosHost{host: host, port: port, isLeader: j == i}
+	}
+	*m.Masters = hosts
+}
+
+// Run a task of the marathon framework with a port
+func (h *harness) run(name string, port int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.state.Frameworks[0].Tasks = append(h.state.Frameworks[0].Tasks, Task{
+		FrameworkId: "f1",
+		Id:          name + ".1",
+		Name:        name,
+		FollowerId:  "s1",
+		State:       "TASK_RUNNING",
+		Resources:   Resources{Ports: fmt.Sprintf("[%d-%d]", port, port)},
+	})
+}
+
+// End the task of name
+func (h *harness) kill(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	tasks := h.state.Frameworks[0].Tasks[:0]
+	for _, task := range h.state.Frameworks[0].Tasks {
+		if task.Name != name {
+			tasks = append(tasks, task)
+		}
+	}
+	h.state.Frameworks[0].Tasks = tasks
+}
+
+// Sync once, returning the error of the Mesos state or else of the
+// Consul calls
+func (h *harness) sync() error {
+	if err := h.mesos.Refresh(); err != nil {
+		return err
+	}
+	return h.mesos.ConsulErr()
+}
+
+// The task services in the registry, as name@address:port, sorted
+func (h *harness) taskServices() []string {
+	return h.registry.taskServices()
+}
+
+// A fake Mesos master serving the state of its harness, naming the
+// master Zookeeper elected as the leader
+type fakeMaster struct {
+	harness *harness
+	srv     *httptest.Server
+
+	lock sync.Mutex
+	// The connections are dropped, as by a master gone
+	down bool
+	// The state is cut off halfway
+	truncated bool
+}
+
+func (fm *fakeMaster) set(f func(fm *fakeMaster)) {
+	fm.lock.Lock()
+	defer fm.lock.Unlock()
+	f(fm)
+}
+
+func (fm *fakeMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fm.lock.Lock()
+	down, truncated := fm.down, fm.truncated
+	fm.lock.Unlock()
+
+	if down {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	if r.URL.Path != "/master/state" {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := fm.harness
+	ip, port := h.mesos.getLeader()
+	h.lock.Lock()
+	sj := h.state
+	sj.Leader = "master@" + hostPort(ip, port)
+	body, err := json.Marshal(sj)
+	h.lock.Unlock()
+	if err != nil {
+		h.t.Error(err)
+		return
+	}
+	if truncated {
+		body = body[:len(body)/2]
+	}
+	w.Write(body)
+}
+
+// A Client never called, standing in for the masters until the
+// harness points its Mesos at them
+type fakeClient struct{}
+
+func (fakeClient) State(ctx context.Context) (StateJSON, error) {
+	return StateJSON{}, errors.New("no client")
+}
+
+// The error of the calls to a registry that is down, as Consul answers
+// without a leader
+var errRegistryDown = errors.New("Unexpected response code: 500 (No cluster leader)")
+
+// An in-memory Registry, safe for the concurrent writes of
+// --registry-concurrency, whose every call fails while it is down
+type memRegistry struct {
+	lock     sync.Mutex
+	down     bool
+	services map[string]memService
+	kv       map[string][]byte
+	queries  []*consulapi.PreparedQueryDefinition
+	index    uint64
+	// The agent of the last update of every TTL check
+	ttls map[string]string
+}
+
+type memService struct {
+	agent   string
+	service consulapi.AgentServiceRegistration
+}
+
+func newMemRegistry() *memRegistry {
+	return &memRegistry{services: map[string]memService{}, kv: map[string][]byte{}, index: 1, ttls: map[string]string{}}
+}
+
+func (r *memRegistry) setDown(down bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.down = down
+}
+
+// Run a call with the registry locked, failing while it is down
+func (r *memRegistry) do(call func()) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.down {
+		return errRegistryDown
+	}
+	call()
+	return nil
+}
+
+func (r *memRegistry) taskServices() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var services []string
+	for _, s := range r.services {
+		if s.service.Meta[taskIDMeta] != "" {
+			services = append(services, fmt.Sprintf("%s@%s:%d", s.service.Name, s.service.Address, s.service.Port))
+		}
+	}
+	sort.Strings(services)
+
+	return services
+}
+
+func (r *memRegistry) Register(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	return r.do(func() {
+		r.services[s.ID] = memService{agent, *s}
+		r.index++
+	})
+}
+
+func (r *memRegistry) Deregister(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	return r.do(func() {
+		delete(r.services, s.ID)
+		r.index++
+	})
+}
+
+func (r *memRegistry) Services(ctx context.Context, prefix string) (services []*registry.Service, err error) {
+	err = r.do(func() {
+		for id, s := range r.services {
+			if strings.HasPrefix(id, prefix) {
+				service := s.service
+				services = append(services, &registry.Service{AgentServiceRegistration: &service, Agent: s.agent})
+			}
+		}
+	})
+	return services, err
+}
+
+func (r *memRegistry) WaitServices(ctx context.Context, waitIndex uint64, wait time.Duration) (index uint64, err error) {
+	err = r.do(func() { index = r.index })
+	return index, err
+}
+
+func (r *memRegistry) Checks(ctx context.Context, prefix string) ([]*consulapi.HealthCheck, error) {
+	return nil, r.do(func() {})
+}
+
+func (r *memRegistry) Get(ctx context.Context, key string, waitIndex uint64, wait time.Duration) (value []byte, index uint64, err error) {
+	err = r.do(func() { value, index = r.kv[key], r.index })
+	return value, index, err
+}
+
+func (r *memRegistry) Put(ctx context.Context, key string, value []byte) error {
+	return r.do(func() {
+		r.kv[key] = value
+		r.index++
+	})
+}
+
+func (r *memRegistry) List(ctx context.Context, prefix string, waitIndex uint64, wait time.Duration) (values map[string][]byte, index uint64, err error) {
+	err = r.do(func() {
+		values = make(map[string][]byte)
+		for key, value := range r.kv {
+			if strings.HasPrefix(key, prefix) {
+				values[key] = value
+			}
+		}
+		index = r.index
+	})
+	return values, index, err
+}
+
+func (r *memRegistry) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	return r.do(func() {
+		for key, value := range puts {
+			r.kv[key] = value
+		}
+		for _, key := range deletes {
+			delete(r.kv, key)
+		}
+		r.index++
+	})
+}
+
+func (r *memRegistry) CheckRegistration(ctx context.Context) error {
+	return r.do(func() {})
+}
+
+func (r *memRegistry) UpdateTTL(ctx context.Context, agent string, checkID string, passing bool, note string) error {
+	return r.do(func() { r.ttls[checkID] = agent })
+}
+
+func (r *memRegistry) Maintenance(ctx context.Context, agent string, enable bool, reason string) error {
+	return r.do(func() {})
+}
+
+func (r *memRegistry) FireEvent(ctx context.Context, name string, action string, s *consulapi.AgentServiceRegistration) error {
+	return r.do(func() {})
+}
+
+func (r *memRegistry) Queries(ctx context.Context) (queries []*consulapi.PreparedQueryDefinition, err error) {
+	err = r.do(func() { queries = append(queries, r.queries...) })
+	return queries, err
+}
+
+func (r *memRegistry) SetQuery(ctx context.Context, q *consulapi.PreparedQueryDefinition) error {
+	return r.do(func() {
+		if q.ID == "" {
+			q.ID = fmt.Sprintf("query-%d", r.index)
+			r.index++
+		}
+		for i, existing := range r.queries {
+			if existing.ID == q.ID {
+				r.queries[i] = q
+				return
+			}
+		}
+		r.queries = append(r.queries, q)
+	})
+}
+
+func (r *memRegistry) DeleteQuery(ctx context.Context, id string) error {
+	return r.do(func() {
+		queries := r.queries[:0]
+		for _, q := range r.queries {
+			if q.ID != id {
+				queries = append(queries, q)
+			}
+		}
+		r.queries = queries
+	})
+}
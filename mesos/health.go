@@ -0,0 +1,367 @@
+package mesos
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The outcome of the last sync, served by HealthHandler(), and the
+// running totals served by MetricsHandler()
+type health struct {
+	sync.Mutex
+
+	lastSync     time.Time
+	lastGoodSync time.Time
+	syncErr      error
+	mesosErr     error
+	consulErrors int
+
+	syncStart         time.Time
+	syncDuration      time.Duration
+	syncs             int
+	registrations     int
+	deregistrations   int
+	mesosErrorsTotal  int
+	consulErrorsTotal int
+	driftTotal        int
+	evictionsTotal    int
+	dropsTotal        int
+	invalidTotal      int
+	pluginFailures    int
+	pluginVetoes      int
+
+	// Registrations waiting for a retry, and those of them failing
+	// for longer than --refresh, see queuePending()
+	pendingRetries     int
+	persistentFailures int
+
+	// Registrations over Consul's limits, by limit, see
+	// limitRegistration()
+	limitViolations map[string]int
+
+	// Task services deregistered, by how their task ended, see
+	// termination.reason()
+	taskEnds map[string]int
+
+	// When the state the last sync used was read, and whether it was
+	// used as reading a new one failed, see --freeze-on-failure
+	stateFetched time.Time
+	frozen       bool
+
+	// The task service registrations of each framework in the sync in
+	// progress, and in the last one that read the state
+	frameworks     map[string]*frameworkRegistrations
+	lastFrameworks map[string]*frameworkRegistrations
+}
+
+// The registrations made for the task services of a framework in a
+// sync
+type frameworkRegistrations struct {
+	registered int
+	failed     int
+}
+
+// Mark the start of a sync
+func (h *health) begin() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.consulErrors = 0
+	h.syncStart = time.Now()
+	h.frameworks = make(map[string]*frameworkRegistrations)
+}
+
+// Record the outcome of a Consul call made during the sync
+func (h *health) consulResult(err error) {
+	if err == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.consulErrors++
+	h.consulErrorsTotal++
+}
+
+// Count a service --reconcile-interval found lost or orphaned
+func (h *health) drifted() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.driftTotal++
+}
+
+// Count a service evicted from the full cache, or dropped when there
+// was none to evict
+func (h *health) cacheOverflow(evicted bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	if evicted {
+		h.evictionsTotal++
+	} else {
+		h.dropsTotal++
+	}
+}
+
+// Count a registration refused as Consul would reject it
+func (h *health) invalidRegistration() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.invalidTotal++
+}
+
+// Count the services a --transform-plugin run vetoed, or its failure
+func (h *health) pluginResult(vetoed int, failed bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.pluginVetoes += vetoed
+	if failed {
+		h.pluginFailures++
+	}
+}
+
+// Count a registration over the Consul limit limit
+func (h *health) limitViolation(limit string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.limitViolations == nil {
+		h.limitViolations = make(map[string]int)
+	}
+	h.limitViolations[limit]++
+}
+
+// Record the registrations waiting for a retry
+func (h *health) pendingRegistrations(pending int, persistent int) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.pendingRetries = pending
+	h.persistentFailures = persistent
+}
+
+// Count a successful registration (or deregistration)
+func (h *health) registered(deregistered bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	if deregistered {
+		h.deregistrations++
+	} else {
+		h.registrations++
+	}
+}
+
+// Count the deregistration of a task service whose task ended for
+// reason
+func (h *health) taskDeregistered(reason string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.taskEnds == nil {
+		h.taskEnds = make(map[string]int)
+	}
+	h.taskEnds[reason]++
+}
+
+// Count a registration of a task service of framework, failed when
+// err is not nil
+func (h *health) frameworkRegistration(framework string, err error) {
+	if framework == "" {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	r, ok := h.frameworks[framework]
+	if !ok {
+		r = &frameworkRegistrations{}
+		h.frameworks[framework] = r
+	}
+	if err != nil {
+		r.failed++
+	} else {
+		r.registered++
+	}
+}
+
+// The number of Consul calls of the sync that failed
+func (h *health) syncErrors() int {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.consulErrors
+}
+
+// Report the Consul calls of the sync that failed
+func (h *health) consulErr() error {
+	if h.consulErrors > 0 {
+		return fmt.Errorf("%d Consul calls failed in the last sync", h.consulErrors)
+	}
+
+	return nil
+}
+
+// Record the state the sync uses
+func (h *health) stateRead(fetched time.Time, frozen bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.stateFetched = fetched
+	h.frozen = frozen
+}
+
+// Record the outcome of the sync and of reaching the masters
+func (h *health) end(syncErr error, mesosErr error) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.lastSync = time.Now()
+	h.syncErr = syncErr
+	h.mesosErr = mesosErr
+
+	if syncErr == nil && h.consulErrors == 0 {
+		h.lastGoodSync = h.lastSync
+	}
+
+	h.syncs++
+	h.syncDuration = h.lastSync.Sub(h.syncStart)
+	if mesosErr != nil {
+		h.mesosErrorsTotal++
+	}
+	if syncErr == nil {
+		h.lastFrameworks = h.frameworks
+	}
+}
+
+// Check that a quorum of the masters known from Zookeeper answers
+// on /master/health
+func (m *Mesos) checkQuorum() error {
+	masters := m.getMasters()
+
+	client := *m.mesosClient()
+	client.Timeout = 5 * time.Second
+
+	total, reachable := 0, 0
+	for _, ma := range masters {
+		if ma.host == "" {
+			continue
+		}
+		total++
+
+		req, err := http.NewRequestWithContext(m.syncCtx(), "GET", m.mesosURL(hostPort(toIP(ma.host), ma.port), "/master/health"), nil)
+		if err != nil {
+			continue
+		}
+		m.authenticate(req)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			hclog.L().Debug("Master unreachable", "master", ma.host+":"+ma.port, "error", err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			reachable++
+		}
+	}
+
+	if reachable <= total/2 {
+		return fmt.Errorf("%d of %d masters reachable, no quorum", reachable, total)
+	}
+
+	return nil
+}
+
+// HealthHandler serves the health of mesos-consul:
+//
+//	/health         a sync read the state and made every Consul call
+//	                within --health-staleness
+//	/health/mesos   the leader answered and a quorum of masters is reachable
+//	/health/consul  every Consul call of the last sync succeeded
+//
+// Each path answers 200 when healthy and 503 otherwise.
+func (m *Mesos) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		m.health.Lock()
+		defer m.health.Unlock()
+
+		var err error
+		switch {
+		case m.health.lastSync.IsZero():
+			err = fmt.Errorf("no sync yet")
+		case time.Since(m.health.lastGoodSync) <= m.staleness():
+		case m.health.syncErr != nil:
+			err = m.health.syncErr
+		case m.health.consulErrors > 0:
+			err = m.health.consulErr()
+		default:
+			err = fmt.Errorf("last successful sync at %s", m.health.lastGoodSync.Format(time.RFC3339))
+		}
+
+		writeHealth(w, err)
+	})
+
+	mux.HandleFunc("/health/mesos", func(w http.ResponseWriter, r *http.Request) {
+		m.health.Lock()
+		defer m.health.Unlock()
+
+		err := m.health.mesosErr
+		if m.health.lastSync.IsZero() {
+			err = fmt.Errorf("no sync yet")
+		}
+
+		writeHealth(w, err)
+	})
+
+	mux.HandleFunc("/health/consul", func(w http.ResponseWriter, r *http.Request) {
+		m.health.Lock()
+		defer m.health.Unlock()
+
+		writeHealth(w, m.health.consulErr())
+	})
+
+	return mux
+}
+
+// How long /health stays OK after a successful sync, three refreshes
+// unless set with --health-staleness
+func (m *Mesos) staleness() time.Duration {
+	c := m.currentConfig()
+	if c.HealthStaleness > 0 {
+		return c.HealthStaleness
+	}
+
+	return 3 * c.Refresh
+}
+
+// ConsulErr tells whether a Consul call of the last sync failed, e.g.
+// for the exit status of --once
+func (m *Mesos) ConsulErr() error {
+	m.health.Lock()
+	defer m.health.Unlock()
+
+	return m.health.consulErr()
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,103 @@
+package mesos
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestHealthHandler(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+	h := m.HealthHandler()
+
+	status := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	if code := status("/health"); code != http.StatusServiceUnavailable {
+		t.Errorf("/health before the first sync: got %d", code)
+	}
+
+	m.health.begin()
+	m.health.end(nil, errors.New("no quorum"))
+
+	if code := status("/health"); code != http.StatusOK {
+		t.Errorf("/health after a sync: got %d", code)
+	}
+	if code := status("/health/mesos"); code != http.StatusServiceUnavailable {
+		t.Errorf("/health/mesos without quorum: got %d", code)
+	}
+	if code := status("/health/consul"); code != http.StatusOK {
+		t.Errorf("/health/consul without errors: got %d", code)
+	}
+
+	m.health.begin()
+	m.health.consulResult(errors.New("permission denied"))
+	m.health.end(nil, nil)
+
+	if code := status("/health/mesos"); code != http.StatusOK {
+		t.Errorf("/health/mesos with quorum: got %d", code)
+	}
+	if code := status("/health/consul"); code != http.StatusServiceUnavailable {
+		t.Errorf("/health/consul with errors: got %d", code)
+	}
+}
+
+func TestHealthStaleness(t *testing.T) {
+	c := config.DefaultConfig()
+	c.HealthStaleness = time.Hour
+	m := &Mesos{config: c}
+	h := m.HealthHandler()
+
+	status := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		return w.Code
+	}
+
+	// A failed sync right after a good one is tolerated
+	m.health.begin()
+	m.health.end(nil, nil)
+	m.health.begin()
+	m.health.consulResult(errors.New("permission denied"))
+	m.health.end(nil, nil)
+	if code := status(); code != http.StatusOK {
+		t.Errorf("/health within the staleness window: got %d", code)
+	}
+
+	// Once the last good sync is too old the failure is reported
+	m.health.lastGoodSync = time.Now().Add(-2 * time.Hour)
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("/health past the staleness window: got %d", code)
+	}
+}
+
+// Run with -race: the handlers read the config Reload replaces
+func TestHealthReload(t *testing.T) {
+	m := &Mesos{}
+	m.setConfig(config.DefaultConfig())
+	m.health.begin()
+	m.health.end(nil, nil)
+	health := m.HealthHandler()
+	metrics := m.MetricsHandler()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.Reload(config.DefaultConfig())
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		health.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+		metrics.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	}
+	<-done
+}
@@ -0,0 +1,96 @@
+package mesos
+
+import (
+	"fmt"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The ID prefix of the heartbeat service, apart from the mesos-consul:
+// prefix of the synced services so that reconciliation leaves it alone
+const heartbeatPrefix = "mesos-consul-heartbeat:"
+
+// The heartbeat service of --heartbeat-service, whose TTL check every
+// sync reports to, or nil without it
+func (m *Mesos) heartbeatService() *consulapi.AgentServiceRegistration {
+	if m.config.HeartbeatService == "" {
+		return nil
+	}
+
+	ttl := m.config.HeartbeatTTL
+	if ttl == 0 {
+		ttl = 3 * m.config.Refresh
+	}
+
+	return &consulapi.AgentServiceRegistration{
+		ID:        heartbeatPrefix + m.config.InstanceID,
+		Name:      m.config.HeartbeatService,
+		Namespace: m.config.ConsulNamespace,
+		Partition: m.config.ConsulPartition,
+		Check: &consulapi.AgentServiceCheck{
+			TTL: ttl.String(),
+		},
+	}
+}
+
+// Report the outcome of a sync to the TTL check of the heartbeat
+// service, registering it with the --consul-addr agent first when it
+// is not yet or its settings changed. A sync that failed fails the
+// check, one that never ends lets it expire.
+func (m *Mesos) heartbeat(err error) {
+	s := m.heartbeatService()
+	if m.beat != nil && (s == nil || m.beat.ID != s.ID || m.beat.Name != s.Name || m.beat.Check.TTL != s.Check.TTL) {
+		m.deregisterHeartbeat()
+	}
+	if s == nil {
+		return
+	}
+
+	if m.beat == nil {
+		if err := m.Registry.Register(m.syncCtx(), "", s); err != nil {
+			hclog.L().Warn("Unable to register the heartbeat service", "service_id", s.ID, "error", err)
+			return
+		}
+		m.beat = s
+	}
+
+	m.cacheLock.Lock()
+	note := fmt.Sprintf("Synced %d services", len(m.ServiceCache))
+	m.cacheLock.Unlock()
+
+	if err == nil && m.health.syncErrors() > 0 {
+		err = fmt.Errorf("%d failed Consul calls", m.health.syncErrors())
+	}
+	passing := err == nil
+	if !passing {
+		note = "Sync failed: " + err.Error()
+	}
+
+	// The agent loses the check when it restarts without its data
+	// directory, so register it again on the next sync
+	if err := m.Registry.UpdateTTL(m.syncCtx(), "", "service:"+s.ID, passing, note); err != nil {
+		hclog.L().Warn("Unable to update the heartbeat check", "error", err)
+		m.beat = nil
+	}
+}
+
+// DeregisterHeartbeat removes the heartbeat service, e.g. on shutdown,
+// so a stopped instance does not look stalled
+func (m *Mesos) DeregisterHeartbeat() {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.deregisterHeartbeat()
+}
+
+func (m *Mesos) deregisterHeartbeat() {
+	if m.beat == nil {
+		return
+	}
+
+	if err := m.Registry.Deregister(m.syncCtx(), "", m.beat); err != nil {
+		hclog.L().Warn("Unable to deregister the heartbeat service", "error", err)
+	}
+	m.beat = nil
+}
@@ -0,0 +1,59 @@
+package mesos
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+// A registry recording the TTL updates of the heartbeat check
+type ttlRegistry struct {
+	*fakeRegistry
+	passing []bool
+	err     error
+}
+
+func (r *ttlRegistry) UpdateTTL(ctx context.Context, agent string, checkID string, passing bool, note string) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.passing = append(r.passing, passing)
+	return nil
+}
+
+func TestHeartbeat(t *testing.T) {
+	r := &ttlRegistry{fakeRegistry: newFakeRegistry()}
+	c := config.DefaultConfig()
+	c.InstanceID = "mesos-consul"
+	c.HeartbeatService = "mesos-consul"
+	m := &Mesos{Registry: r, config: c, ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+	id := heartbeatPrefix + "mesos-consul"
+	if s := m.heartbeatService(); s.ID != id || s.Check.TTL != "3m0s" {
+		t.Errorf("expected a TTL of 3 refreshes, got %+v", s.Check)
+	}
+
+	m.heartbeat(nil)
+	m.heartbeat(errors.New("no master"))
+	if _, ok := r.registered[id]; !ok || len(r.passing) != 2 || !r.passing[0] || r.passing[1] {
+		t.Errorf("expected the service registered and its check passed then failed, got %v, %v", r.registered, r.passing)
+	}
+
+	// A check the agent lost is registered again
+	delete(r.registered, id)
+	r.err = errors.New("unknown check")
+	m.heartbeat(nil)
+	r.err = nil
+	m.heartbeat(nil)
+	if _, ok := r.registered[id]; !ok {
+		t.Error("expected the heartbeat service registered again")
+	}
+
+	c.HeartbeatService = ""
+	m.heartbeat(nil)
+	if _, ok := r.deregistered[id]; !ok || m.beat != nil {
+		t.Error("expected the heartbeat service deregistered once disabled")
+	}
+}
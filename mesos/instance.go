@@ -0,0 +1,127 @@
+package mesos
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Metadata of the --instance-tags instance of a task
+const (
+	instanceMeta    = "mesos-instance"
+	incarnationMeta = "mesos-incarnation"
+)
+
+// The replica of its service a task is, see --instance-tags
+type instance struct {
+	index       int
+	incarnation int
+}
+
+// The tag of a task's instance
+func (i instance) tag() string {
+	return "instance-" + strconv.Itoa(i.index)
+}
+
+// With --instance-tags, or a --service-id-template rendering the
+// instance, number the running tasks of every service from 0 so
+// consumers can address a replica, e.g. a broker, while clients
+// still balance across the service. A task keeps its index for as
+// long as it runs, and one replacing a task that went away takes the
+// lowest free index, with the next incarnation of it. The indexes
+// are recovered from the cache after a restart.
+func (m *Mesos) assignInstances(sj StateJSON, names map[*Task]string) map[*Task]instance {
+	if !m.config.InstanceTags && !m.idInstances() {
+		return nil
+	}
+
+	if m.instances == nil {
+		m.loadInstances()
+	}
+
+	groups := make(map[string][]*Task)
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			if task.State == "TASK_RUNNING" {
+				group := fw.Name + "/" + names[task]
+				groups[group] = append(groups[group], task)
+			}
+		}
+	}
+
+	assigned := make(map[*Task]instance)
+	for group, tasks := range groups {
+		// The oldest tasks are numbered first
+		sort.Slice(tasks, func(i, j int) bool {
+			if a, b := startTime(tasks[i]), startTime(tasks[j]); a != b {
+				return a < b
+			}
+			return tasks[i].Id < tasks[j].Id
+		})
+
+		used := make(map[int]bool)
+		var pending []*Task
+		for _, task := range tasks {
+			if i, ok := m.instances[task.Id]; ok && !used[i.index] {
+				used[i.index] = true
+				assigned[task] = i
+
+				// Incarnations recovered from the cache
+				key := group + "#" + strconv.Itoa(i.index)
+				if m.incarnations[key] < i.incarnation {
+					m.incarnations[key] = i.incarnation
+				}
+				continue
+			}
+			pending = append(pending, task)
+		}
+
+		index := 0
+		for _, task := range pending {
+			for used[index] {
+				index++
+			}
+			used[index] = true
+
+			key := group + "#" + strconv.Itoa(index)
+			m.incarnations[key]++
+			assigned[task] = instance{index, m.incarnations[key]}
+		}
+	}
+
+	instances := make(map[string]instance, len(assigned))
+	for task, i := range assigned {
+		instances[task.Id] = i
+	}
+
+	// Tasks that went away free their index
+	m.instances = instances
+
+	return assigned
+}
+
+// Recover the instances of the cached task services
+func (m *Mesos) loadInstances() {
+	m.instances = make(map[string]instance)
+	m.incarnations = make(map[string]int)
+
+	for _, b := range m.ServiceCache {
+		meta := b.service.Meta
+		index, err := strconv.Atoi(meta[instanceMeta])
+		if err != nil || meta["mesos-task-id"] == "" {
+			continue
+		}
+		incarnation, _ := strconv.Atoi(meta[incarnationMeta])
+
+		m.instances[meta["mesos-task-id"]] = instance{index, incarnation}
+	}
+}
+
+// The time of the first status of a task, 0 without any
+func startTime(task *Task) float64 {
+	if len(task.Statuses) == 0 {
+		return 0
+	}
+
+	return task.Statuses[0].Timestamp
+}
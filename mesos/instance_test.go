@@ -0,0 +1,60 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestAssignInstances(t *testing.T) {
+	c := config.DefaultConfig()
+	c.InstanceTags = true
+	m := &Mesos{
+		config: c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:b", localDatacenter}: {service: &consulapi.AgentServiceRegistration{
+				Meta: map[string]string{"mesos-task-id": "kafka.b", instanceMeta: "1", incarnationMeta: "2"},
+			}},
+		},
+	}
+
+	running := func(id string, started float64) Task {
+		return Task{Id: id, State: "TASK_RUNNING", Statuses: []Status{{Timestamp: started}}}
+	}
+	assign := func(tasks Tasks) map[string]instance {
+		sj := StateJSON{Frameworks: Frameworks{{Name: "marathon", Tasks: tasks}}}
+		names := make(map[*Task]string)
+		for i := range sj.Frameworks[0].Tasks {
+			names[&sj.Frameworks[0].Tasks[i]] = "kafka"
+		}
+
+		byID := make(map[string]instance)
+		for task, i := range m.assignInstances(sj, names) {
+			byID[task.Id] = i
+		}
+		return byID
+	}
+
+	got := assign(Tasks{running("kafka.c", 3), running("kafka.b", 2), running("kafka.a", 1)})
+	want := map[string]instance{"kafka.a": {0, 1}, "kafka.b": {1, 2}, "kafka.c": {2, 1}}
+	for id, i := range want {
+		if got[id] != i {
+			t.Errorf("expected %s to be %+v, got %+v", id, i, got[id])
+		}
+	}
+
+	got = assign(Tasks{running("kafka.c", 3), running("kafka.b", 2), running("kafka.d", 4)})
+	if got["kafka.d"] != (instance{0, 2}) || got["kafka.b"] != (instance{1, 2}) || got["kafka.c"] != (instance{2, 1}) {
+		t.Errorf("expected the replacement to take instance 0 incarnation 2, got %+v", got)
+	}
+
+	got = assign(Tasks{running("kafka.b", 2), running("kafka.c", 3), running("kafka.e", 5), running("kafka.d", 4)})
+	if got["kafka.e"] != (instance{3, 1}) {
+		t.Errorf("expected a new task to take the next instance, got %+v", got)
+	}
+
+	if i := (instance{index: 3}); i.tag() != "instance-3" {
+		t.Errorf("unexpected tag %s", i.tag())
+	}
+}
@@ -0,0 +1,222 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The most tags Consul takes on a service, see maxTagLength and
+// maxMetaPairs for the other limits
+const maxTags = 64
+
+// The limits a registration can exceed, labelling
+// mesos_consul_limit_violations_total
+const (
+	limitTags      = "tags"
+	limitTagLength = "tag_length"
+	limitMeta      = "meta"
+	limitMetaKey   = "meta_key"
+	limitMetaValue = "meta_value_length"
+)
+
+// The tags and meta of a service kept out of its registration by
+// --limit-policy=kv, as stored in the KV store
+type overflow struct {
+	Tags []string          `json:"tags,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// Keep s within Consul's limits on tags and meta per --limit-policy:
+// refuse it, cut what goes over, or cut it and keep the rest in the
+// KV store, for the services of the local datacenter, not their
+// mirrors. Returns an error when s is refused.
+func (m *Mesos) limitRegistration(dc string, s *consulapi.AgentServiceRegistration) error {
+	tags, meta, over, violated := splitLimits(s, maxMetaPairs-registryMetaPairs)
+	if len(violated) == 0 {
+		return nil
+	}
+	for _, v := range violated {
+		m.health.limitViolation(v)
+	}
+
+	policy := m.config.LimitPolicy
+	if policy == config.LimitPolicyFail {
+		return fmt.Errorf("over Consul's limits on %s", strings.Join(violated, ", "))
+	}
+
+	hclog.L().Warn("Registration over Consul's limits. Cutting it", "service_id", s.ID, "limits", violated, "policy", policy, "tags_dropped", len(over.Tags), "meta_dropped", len(over.Meta))
+	s.Tags = tags
+	s.Meta = meta
+	if policy == config.LimitPolicyKV && m.overflow != nil && dc == localDatacenter {
+		m.overflow[s.ID] = over
+	}
+
+	return nil
+}
+
+// Whether tag is one mesos-consul marks services with, kept over the
+// others when they go over the limit
+func ownTag(tag string) bool {
+	return tag == flappingTag || tag == mirrorTag
+}
+
+// Split the tags and meta of s into those within the limits, with at
+// most pairs meta pairs, the mesos-* ones first, and those over them,
+// returning the limits violated. The tags mesos-consul adds, see
+// ownTag(), are kept in place of the last others. Overlong tags and
+// meta values are cut, the full ones going over.
+func splitLimits(s *consulapi.AgentServiceRegistration, pairs int) ([]string, map[string]string, overflow, []string) {
+	var over overflow
+	violations := make(map[string]bool)
+
+	reserved := 0
+	for _, t := range s.Tags {
+		if ownTag(t) {
+			reserved++
+		}
+	}
+
+	var tags []string
+	for _, t := range s.Tags {
+		switch {
+		case ownTag(t):
+			reserved--
+		case len(tags)+reserved >= maxTags:
+			violations[limitTags] = true
+			over.Tags = append(over.Tags, t)
+			continue
+		case len(t) > maxTagLength:
+			violations[limitTagLength] = true
+			over.Tags = append(over.Tags, t)
+			t = t[:maxTagLength]
+		}
+		tags = append(tags, t)
+	}
+
+	keys := make([]string, 0, len(s.Meta))
+	for k := range s.Meta {
+		keys = append(keys, k)
+	}
+	own := func(k string) bool { return strings.HasPrefix(k, "mesos-") }
+	sort.Slice(keys, func(i, j int) bool {
+		if own(keys[i]) != own(keys[j]) {
+			return own(keys[i])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var meta map[string]string
+	if s.Meta != nil {
+		meta = make(map[string]string, len(s.Meta))
+	}
+	drop := func(limit string, k string) {
+		violations[limit] = true
+		if over.Meta == nil {
+			over.Meta = make(map[string]string)
+		}
+		over.Meta[k] = s.Meta[k]
+	}
+	for _, k := range keys {
+		v := s.Meta[k]
+		switch {
+		case len(k) > maxMetaKeyLen || strings.HasPrefix(strings.ToLower(k), "consul-"):
+			drop(limitMetaKey, k)
+			continue
+		case len(meta) == pairs:
+			drop(limitMeta, k)
+			continue
+		case len(v) > maxMetaValueLen:
+			drop(limitMetaValue, k)
+			v = v[:maxMetaValueLen]
+		}
+		meta[k] = v
+	}
+
+	violated := make([]string, 0, len(violations))
+	for v := range violations {
+		violated = append(violated, v)
+	}
+	sort.Strings(violated)
+
+	return tags, meta, over, violated
+}
+
+// The KV prefix of the overflows of --limit-policy=kv
+func (m *Mesos) overflowKey() string {
+	return m.config.KVPrefix + "/overflow"
+}
+
+// Start collecting the overflows of the registration pass of a sync
+func (m *Mesos) beginOverflow() {
+	if m.config.LimitPolicy != config.LimitPolicyKV {
+		m.overflow = nil
+		return
+	}
+	m.overflow = make(map[string]overflow)
+}
+
+// Write the overflows of the registration pass to the KV store, under
+// <kv-prefix>/overflow/<service-id>, and delete those of the services
+// back within the limits or gone
+func (m *Mesos) saveOverflow() {
+	if m.overflow == nil {
+		return
+	}
+
+	prefix := m.overflowKey() + "/"
+	if m.savedOverflow == nil {
+		values, _, err := m.Registry.List(m.syncCtx(), prefix, 0, 0)
+		if err != nil {
+			hclog.L().Warn("Unable to read the tags and meta over Consul's limits", "key", prefix, "error", err)
+			return
+		}
+		m.savedOverflow = values
+	}
+
+	puts := make(map[string][]byte)
+	for id, over := range m.overflow {
+		value, err := json.Marshal(over)
+		if err != nil {
+			continue
+		}
+		key := prefix + url.PathEscape(id)
+		if string(m.savedOverflow[key]) != string(value) {
+			puts[key] = value
+		}
+	}
+
+	var deletes []string
+	for key := range m.savedOverflow {
+		id, err := url.PathUnescape(strings.TrimPrefix(key, prefix))
+		if _, ok := m.overflow[id]; err != nil || !ok {
+			deletes = append(deletes, key)
+		}
+	}
+	sort.Strings(deletes)
+
+	if len(puts) == 0 && len(deletes) == 0 {
+		return
+	}
+
+	err := m.Registry.Txn(m.syncCtx(), puts, deletes)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to save the tags and meta over Consul's limits", "error", err)
+		m.savedOverflow = nil
+		return
+	}
+
+	for key, value := range puts {
+		m.savedOverflow[key] = value
+	}
+	for _, key := range deletes {
+		delete(m.savedOverflow, key)
+	}
+}
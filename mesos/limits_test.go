@@ -0,0 +1,111 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A service of 70 tags, one of them overlong
+func overLimits() *consulapi.AgentServiceRegistration {
+	s := &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web", Address: "10.0.0.1", Meta: map[string]string{"mesos-task-id": "web.1"}}
+	for i := 0; i < 70; i++ {
+		s.Tags = append(s.Tags, fmt.Sprintf("t%02d", i))
+	}
+	s.Tags[1] = strings.Repeat("x", 300)
+
+	return s
+}
+
+func TestSplitLimits(t *testing.T) {
+	s := overLimits()
+	s.Meta["consul-version"] = "1"
+	s.Meta["note"] = strings.Repeat("n", 600)
+
+	tags, meta, over, violated := splitLimits(s, 2)
+	if len(tags) != maxTags || tags[0] != "t00" || len(tags[1]) != maxTagLength || tags[maxTags-1] != "t63" {
+		t.Errorf("expected the first 64 tags, the long one cut, got %d: %v", len(tags), tags)
+	}
+	if len(over.Tags) != 7 || len(over.Tags[0]) != 300 || over.Tags[1] != "t64" {
+		t.Errorf("expected the long tag and the 6 beyond the limit over, got %v", over.Tags)
+	}
+	if len(meta) != 2 || meta["mesos-task-id"] != "web.1" || len(meta["note"]) != maxMetaValueLen {
+		t.Errorf("expected the task ID and the cut note, got %v", meta)
+	}
+	if over.Meta["consul-version"] != "1" || len(over.Meta["note"]) != 600 {
+		t.Errorf("expected the reserved key and the full note over, got %v", over.Meta)
+	}
+	if want := "meta_key meta_value_length tag_length tags"; strings.Join(violated, " ") != want {
+		t.Errorf("expected %s violated, got %v", want, violated)
+	}
+
+	if _, _, _, violated := splitLimits(&consulapi.AgentServiceRegistration{Tags: []string{"a"}}, 2); len(violated) != 0 {
+		t.Errorf("expected no violations, got %v", violated)
+	}
+}
+
+func TestLimitPolicy(t *testing.T) {
+	for _, policy := range []string{config.LimitPolicyFail, config.LimitPolicyTruncate, config.LimitPolicyKV} {
+		r := &kvRegistry{newFakeRegistry(), map[string][]byte{"mesos-consul/overflow/mesos-consul:gone": []byte(`{}`)}}
+		c := config.DefaultConfig()
+		c.LimitPolicy = policy
+		m := &Mesos{Registry: r, config: c, ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+		m.beginOverflow()
+		m.registerAt(localDatacenter, "10.0.0.1", overLimits())
+		m.flush()
+		m.saveOverflow()
+
+		_, registered := r.registered["mesos-consul:a"]
+		if registered == (policy == config.LimitPolicyFail) {
+			t.Errorf("%s: unexpected registration: %v", policy, registered)
+		}
+		if e := m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}]; registered && len(e.service.Tags) != maxTags {
+			t.Errorf("%s: expected %d tags, got %d", policy, maxTags, len(e.service.Tags))
+		}
+
+		var over overflow
+		json.Unmarshal(r.kv["mesos-consul/overflow/mesos-consul:a"], &over)
+		if (len(over.Tags) == 7) != (policy == config.LimitPolicyKV) {
+			t.Errorf("%s: unexpected overflow: %v", policy, r.kv)
+		}
+		if _, ok := r.kv["mesos-consul/overflow/mesos-consul:gone"]; ok == (policy == config.LimitPolicyKV) {
+			t.Errorf("%s: expected the overflow of the gone service deleted only with kv, got %v", policy, r.kv)
+		}
+
+		if n := m.health.limitViolations[limitTags]; n != 1 {
+			t.Errorf("%s: expected a violation of the tag limit counted, got %d", policy, n)
+		}
+	}
+}
+
+func TestLimitFinalRegistration(t *testing.T) {
+	// The marker tags mesos-consul adds last are kept over the others
+	s := overLimits()
+	s.Tags = append(s.Tags, flappingTag, mirrorTag)
+	tags, _, over, _ := splitLimits(s, 2)
+	if len(tags) != maxTags || !contains(tags, flappingTag) || !contains(tags, mirrorTag) || tags[maxTags-3] != "t61" {
+		t.Errorf("expected the marker tags kept in place of t62 and t63, got %v", tags)
+	}
+	if len(over.Tags) != 9 || contains(over.Tags, flappingTag) {
+		t.Errorf("expected the long tag and the 8 others beyond the limit over, got %v", over.Tags)
+	}
+
+	// The external tags stop at the limit
+	m := &Mesos{externalTags: map[string][]string{"mesos-consul:a": {"ext-1", "ext-2", "ext-3"}}}
+	s = &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}
+	for i := 0; i < maxTags-2; i++ {
+		s.Tags = append(s.Tags, fmt.Sprintf("t%02d", i))
+	}
+	reg := m.withExternalTags(s)
+	if len(reg.Tags) != maxTags || reg.Tags[maxTags-1] != "ext-2" || len(s.Tags) != maxTags-2 {
+		t.Errorf("expected the first 2 external tags added to a copy, got %v", reg.Tags)
+	}
+	if n := m.health.limitViolations[limitTags]; n != 1 {
+		t.Errorf("expected a violation of the tag limit counted, got %d", n)
+	}
+}
@@ -0,0 +1,217 @@
+package mesos
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// States of the tasks whose agent Mesos lost contact with, which may
+// come back once the partition heals, see --lost-task-grace
+var lostStates = map[string]bool{
+	"TASK_LOST":        true,
+	"TASK_UNREACHABLE": true,
+	"TASK_UNKNOWN":     true,
+}
+
+// Terminal states of the tasks that ended, whose services are
+// deregistered without waiting out --deregister-delay
+var finishedStates = map[string]bool{
+	"TASK_FINISHED":         true,
+	"TASK_KILLED":           true,
+	"TASK_FAILED":           true,
+	"TASK_ERROR":            true,
+	"TASK_DROPPED":          true,
+	"TASK_GONE":             true,
+	"TASK_GONE_BY_OPERATOR": true,
+}
+
+// The notes of the critical check of the services held for a lost task
+const lostNote = "Mesos reports the task "
+
+// How a task that is not running got there, e.g. TASK_FAILED with
+// REASON_CONTAINER_LIMITATION_MEMORY for an OOM-killed one, from its
+// latest status
+type termination struct {
+	State   string
+	Reason  string
+	Message string
+}
+
+func taskTermination(task Task, state string) termination {
+	end := termination{State: state}
+	if n := len(task.Statuses); n > 0 {
+		end.Reason = task.Statuses[n-1].Reason
+		end.Message = task.Statuses[n-1].Message
+	}
+
+	return end
+}
+
+// The log fields of a termination, none when unknown
+func (end termination) logFields() []interface{} {
+	var fields []interface{}
+	if end.State != "" {
+		fields = append(fields, "task_state", end.State)
+	}
+	if end.Reason != "" {
+		fields = append(fields, "task_reason", end.Reason)
+	}
+	if end.Message != "" {
+		fields = append(fields, "task_message", end.Message)
+	}
+
+	return fields
+}
+
+// The most precise account of a termination: its reason, its state
+// without one, or unknown for a task gone from the state
+func (end termination) reason() string {
+	switch {
+	case end.Reason != "":
+		return end.Reason
+	case end.State != "":
+		return end.State
+	}
+
+	return "unknown"
+}
+
+// Sort the tasks of the state that are not running into those that
+// ended, including the completed tasks of the frameworks, and those
+// lost, and with --lost-task-grace keep those lost within the grace
+// window, counted from the first sync that found them lost
+func (m *Mesos) taskOutcomes(sj StateJSON) {
+	m.terminations = make(map[string]termination)
+	m.lostTasks = make(map[string]string)
+
+	lost := make(map[string]string)
+	for _, fw := range sj.Frameworks {
+		for _, task := range fw.CompletedTasks {
+			if finishedStates[task.State] {
+				m.terminations[task.Id] = taskTermination(task, task.State)
+			}
+		}
+		for _, task := range fw.Tasks {
+			if finishedStates[task.State] || lostStates[task.State] {
+				m.terminations[task.Id] = taskTermination(task, task.State)
+			}
+			if lostStates[task.State] {
+				lost[task.Id] = task.State
+			}
+		}
+		for _, task := range fw.UnreachableTasks {
+			m.terminations[task.Id] = taskTermination(task, "TASK_UNREACHABLE")
+			lost[task.Id] = "TASK_UNREACHABLE"
+		}
+	}
+
+	grace := m.config.LostTaskGrace
+	if grace <= 0 {
+		m.lostSince = nil
+		return
+	}
+
+	now := time.Now()
+	since := make(map[string]time.Time, len(lost))
+	for id, state := range lost {
+		first, ok := m.lostSince[id]
+		if !ok {
+			first = now
+		}
+		since[id] = first
+
+		if now.Sub(first) < grace {
+			m.lostTasks[id] = state
+		}
+	}
+	m.lostSince = since
+}
+
+// Keep the cached services of the tasks lost within --lost-task-grace
+// registered, registering them again with a critical check so they
+// stop receiving traffic. Once the task runs again, its services are
+// registered with their own check.
+func (m *Mesos) holdLost() {
+	for key, b := range m.ServiceCache {
+		state, ok := m.lostTasks[b.service.Meta[taskIDMeta]]
+		if !ok || b.isRegistered {
+			continue
+		}
+		b.isRegistered = true
+
+		if held(b.service) {
+			m.summary.keep()
+			continue
+		}
+
+		hclog.L().Warn("Task lost. Holding service with a critical check", "service_id", key.ID, "state", state)
+		original := b.service
+		s := *b.service
+		s.Checks = nil
+		s.Check = &consulapi.AgentServiceCheck{
+			TTL:    fmt.Sprintf("%ds", int(3*m.config.Refresh.Seconds())),
+			Status: consulapi.HealthCritical,
+			Notes:  lostNote + state,
+		}
+		if m.config.CheckContext {
+			hostname := ""
+			if f, err := m.lastState.Followers.byId(original.Meta["mesos-agent-id"]); err == nil {
+				hostname = f.Hostname
+			}
+			end, ok := m.terminations[original.Meta[taskIDMeta]]
+			if !ok {
+				end.State = state
+			}
+			s.Check.Notes = withContext(s.Check.Notes, checkContext(original.Meta[taskIDMeta], hostname, end))
+		}
+		if original.Check != nil {
+			s.Check.DeregisterCriticalServiceAfter = original.Check.DeregisterCriticalServiceAfter
+		}
+		b.service = &s
+
+		key, entry, agent := key, b, b.agent
+		m.write(func(ctx context.Context) error {
+			err := m.applier().Register(ctx, Registration{key.Datacenter, agent, &s})
+			if err != nil {
+				m.holdFailed(key, entry, original)
+			}
+			return err
+		}, func() {
+			m.applied(eventRegister, reasonUnreachable, agent, &s)
+		})
+	}
+}
+
+// Tell whether s is registered with the critical check of a lost task
+func held(s *consulapi.AgentServiceRegistration) bool {
+	return s.Check != nil && s.Check.TTL != "" && strings.HasPrefix(s.Check.Notes, lostNote)
+}
+
+// Remember the service a cache entry held for a lost task had before
+// its registration failed, see forgetFailed()
+func (m *Mesos) holdFailed(key ServiceKey, entry *CacheEntry, original *consulapi.AgentServiceRegistration) {
+	m.failedLock.Lock()
+	defer m.failedLock.Unlock()
+
+	if m.failedHolds == nil {
+		m.failedHolds = make(map[ServiceKey]heldService)
+	}
+	m.failedHolds[key] = heldService{entry, original}
+}
+
+// A cache entry and the service it held before, see holdFailed()
+type heldService struct {
+	entry    *CacheEntry
+	original *consulapi.AgentServiceRegistration
+}
+
+// Tell whether the service of a cache entry belongs to a task that
+// ended, so it is deregistered without waiting out --deregister-delay
+func (m *Mesos) finished(b *CacheEntry) bool {
+	return finishedStates[m.terminations[b.service.Meta[taskIDMeta]].State]
+}
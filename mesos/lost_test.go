@@ -0,0 +1,127 @@
+package mesos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/hook"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestLostTasks(t *testing.T) {
+	r := newFakeRegistry()
+	c := config.DefaultConfig()
+	c.DeregisterDelay = 3
+	c.LostTaskGrace = time.Minute
+	m := &Mesos{Registry: r, config: c, Masters: &[]MesosHost{}, ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+	service := func(id, task string) *consulapi.AgentServiceRegistration {
+		return &consulapi.AgentServiceRegistration{
+			ID:    id,
+			Name:  "web",
+			Meta:  map[string]string{taskIDMeta: task},
+			Check: &consulapi.AgentServiceCheck{HTTP: "http://10.0.0.1:31000/", DeregisterCriticalServiceAfter: "1h"},
+		}
+	}
+	lost := ServiceKey{"mesos-consul:lost", localDatacenter}
+	done := ServiceKey{"mesos-consul:done", localDatacenter}
+	gone := ServiceKey{"mesos-consul:gone", localDatacenter}
+	m.ServiceCache[lost] = &CacheEntry{service: service(lost.ID, "t1"), agent: "10.0.0.1"}
+	m.ServiceCache[done] = &CacheEntry{service: service(done.ID, "t2"), agent: "10.0.0.1"}
+	m.ServiceCache[gone] = &CacheEntry{service: service(gone.ID, "t3"), agent: "10.0.0.1"}
+
+	sj := StateJSON{Frameworks: Frameworks{{
+		Id:               "fw",
+		Tasks:            Tasks{{Id: "t2", State: "TASK_KILLED"}},
+		UnreachableTasks: Tasks{{Id: "t1", State: "TASK_UNREACHABLE"}},
+	}}}
+	m.taskOutcomes(sj)
+	m.markRunning(sj)
+	m.deregister()
+
+	b, ok := m.ServiceCache[lost]
+	if !ok || !held(b.service) || b.service.Check.Status != consulapi.HealthCritical {
+		t.Fatalf("expected the lost task's service to be held with a critical check, got %+v", b)
+	}
+	if b.service.Check.DeregisterCriticalServiceAfter != "1h" || r.registered[lost.ID] != "10.0.0.1" {
+		t.Errorf("unexpected hold %+v, registered %v", b.service.Check, r.registered)
+	}
+	if _, ok := m.ServiceCache[done]; ok || r.deregistered[done.ID] == "" {
+		t.Errorf("expected the killed task's service to be deregistered at once, got %v", r.deregistered)
+	}
+	if m.ServiceCache[gone].missed != 1 {
+		t.Errorf("expected the vanished task's service to wait out the delay, got %+v", m.ServiceCache[gone])
+	}
+
+	delete(r.registered, lost.ID)
+	m.taskOutcomes(sj)
+	m.markRunning(sj)
+	if len(r.registered) != 0 {
+		t.Errorf("expected a held service to be left as is, got %v", r.registered)
+	}
+
+	m.lostSince["t1"] = time.Now().Add(-2 * time.Minute)
+	m.taskOutcomes(sj)
+	m.markRunning(sj)
+	if m.ServiceCache[lost].isRegistered {
+		t.Error("expected the service to be swept past the grace period")
+	}
+}
+
+// A hook recording the events it is told about
+type recordingHook struct {
+	events []hook.Event
+}
+
+func (h *recordingHook) Fire(e hook.Event) error {
+	h.events = append(h.events, e)
+	return nil
+}
+
+func TestTaskTerminations(t *testing.T) {
+	r := newFakeRegistry()
+	h := &recordingHook{}
+	m := &Mesos{Registry: r, config: config.DefaultConfig(), Masters: &[]MesosHost{}, ServiceCache: map[ServiceKey]*CacheEntry{}, hooks: h}
+
+	oom := ServiceKey{"mesos-consul:oom", localDatacenter}
+	gone := ServiceKey{"mesos-consul:gone", localDatacenter}
+	m.ServiceCache[oom] = &CacheEntry{service: &consulapi.AgentServiceRegistration{ID: oom.ID, Name: "web", Meta: map[string]string{taskIDMeta: "t1"}}, agent: "10.0.0.1"}
+	m.ServiceCache[gone] = &CacheEntry{service: &consulapi.AgentServiceRegistration{ID: gone.ID, Name: "web", Meta: map[string]string{taskIDMeta: "t2"}}, agent: "10.0.0.1"}
+
+	// Acknowledged terminal tasks move to the completed tasks
+	sj := StateJSON{Frameworks: Frameworks{{
+		Id: "fw",
+		CompletedTasks: Tasks{{Id: "t1", State: "TASK_FAILED", Statuses: []Status{
+			{State: "TASK_RUNNING"},
+			{State: "TASK_FAILED", Reason: "REASON_CONTAINER_LIMITATION_MEMORY", Message: "Memory limit exceeded"},
+		}}},
+	}}}
+	m.beginSummary()
+	m.taskOutcomes(sj)
+	m.markRunning(sj)
+	m.deregister()
+
+	if len(m.ServiceCache) != 0 || len(m.summary.Changes) != 2 || len(h.events) != 2 {
+		t.Fatalf("expected both services to be deregistered, got %+v", m.summary.Changes)
+	}
+	for i, c := range m.summary.Changes {
+		e := h.events[i]
+		switch c.ID {
+		case oom.ID:
+			if c.TaskState != "TASK_FAILED" || c.TaskReason != "REASON_CONTAINER_LIMITATION_MEMORY" || c.TaskMessage != "Memory limit exceeded" {
+				t.Errorf("expected the change to tell how the task ended, got %+v", c)
+			}
+			if e.TaskReason != "REASON_CONTAINER_LIMITATION_MEMORY" {
+				t.Errorf("expected the hook to be told how the task ended, got %+v", e)
+			}
+		case gone.ID:
+			if c.TaskState != "" || e.TaskState != "" {
+				t.Errorf("expected no termination for a vanished task, got %+v", c)
+			}
+		}
+	}
+	if ends := m.health.taskEnds; ends["REASON_CONTAINER_LIMITATION_MEMORY"] != 1 || ends["unknown"] != 1 {
+		t.Errorf("unexpected deregistration counts %v", ends)
+	}
+}
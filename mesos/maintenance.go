@@ -0,0 +1,181 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The reason of the Consul maintenance mode mesos-consul enables
+const maintenanceReason = "Mesos maintenance (mesos-consul)"
+
+// A machine of a Mesos maintenance schedule
+type machineID struct {
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+}
+
+// The machines of the leader's /master/maintenance/status
+type maintenanceStatus struct {
+	DrainingMachines []struct {
+		ID machineID `json:"id"`
+	} `json:"draining_machines"`
+	DownMachines []machineID `json:"down_machines"`
+}
+
+// The host names and IPs of the draining and down machines
+func (s maintenanceStatus) machines() map[string]bool {
+	machines := make(map[string]bool)
+	add := func(id machineID) {
+		for _, h := range []string{id.Hostname, id.IP} {
+			if h != "" {
+				machines[h] = true
+			}
+		}
+	}
+
+	for _, d := range s.DrainingMachines {
+		add(d.ID)
+	}
+	for _, id := range s.DownMachines {
+		add(id)
+	}
+
+	return machines
+}
+
+// With --sync-maintenance, put the Consul agent of every follower
+// Mesos drains or took down for maintenance into maintenance mode, so
+// its services stop receiving traffic ahead of the downtime, and take
+// it out once the maintenance is over. Agents gone from the state
+// stay in maintenance until they come back.
+func (m *Mesos) syncMaintenance(sj StateJSON) {
+	if !m.config.SyncMaintenance {
+		return
+	}
+
+	status, err := m.loadMaintenance(sj.Leader)
+	if err != nil {
+		hclog.L().Warn("Unable to read the maintenance status", "error", err)
+		return
+	}
+
+	m.applyMaintenance(sj, status.machines())
+}
+
+// The KV key of the agents mesos-consul put into maintenance mode
+func (m *Mesos) maintenanceKey() string {
+	return m.config.KVPrefix + "/maintenance"
+}
+
+// Switch the maintenance mode of the followers' agents to match the
+// machines under maintenance. The agents put into maintenance are
+// kept under <kv-prefix>/maintenance, read on first use, so those of
+// a previous run or lock holder are taken out of it too.
+func (m *Mesos) applyMaintenance(sj StateJSON, machines map[string]bool) {
+	if m.maintenance == nil {
+		value, _, err := m.Registry.Get(m.syncCtx(), m.maintenanceKey(), 0, 0)
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Warn("Unable to read the agents in maintenance", "key", m.maintenanceKey(), "error", err)
+			return
+		}
+
+		var agents []string
+		if len(value) > 0 {
+			if err := json.Unmarshal(value, &agents); err != nil {
+				hclog.L().Warn("Ignoring the invalid agents in maintenance", "key", m.maintenanceKey(), "error", err)
+			}
+		}
+		m.maintenance = make(map[string]bool)
+		for _, agent := range agents {
+			m.maintenance[agent] = true
+		}
+	}
+
+	changed := false
+	defer func() {
+		if changed {
+			m.saveMaintenance()
+		}
+	}()
+
+	for _, f := range sj.Followers {
+		agent := toIP(f.Hostname)
+		wanted := machines[f.Hostname] || machines[agent]
+		if wanted == m.maintenance[agent] {
+			continue
+		}
+
+		if wanted {
+			hclog.L().Info("Follower under Mesos maintenance. Enabling Consul maintenance", "follower", f.Hostname, "agent", agent)
+		} else {
+			hclog.L().Info("Mesos maintenance over. Disabling Consul maintenance", "follower", f.Hostname, "agent", agent)
+		}
+
+		err := m.Registry.Maintenance(m.syncCtx(), agent, wanted, maintenanceReason)
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Error("Unable to set the Consul maintenance", "agent", agent, "error", err)
+			continue
+		}
+
+		if wanted {
+			m.maintenance[agent] = true
+		} else {
+			delete(m.maintenance, agent)
+		}
+		changed = true
+	}
+}
+
+// Write the agents in maintenance mode to <kv-prefix>/maintenance
+func (m *Mesos) saveMaintenance() {
+	agents := make([]string, 0, len(m.maintenance))
+	for agent := range m.maintenance {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+
+	value, err := json.Marshal(agents)
+	if err == nil {
+		err = m.Registry.Put(m.syncCtx(), m.maintenanceKey(), value)
+		m.health.consulResult(err)
+	}
+	if err != nil {
+		hclog.L().Warn("Unable to save the agents in maintenance", "key", m.maintenanceKey(), "error", err)
+	}
+}
+
+// Read the maintenance status from the leader, given as in the state,
+// e.g. master@10.0.0.1:5050
+func (m *Mesos) loadMaintenance(leader string) (status maintenanceStatus, err error) {
+	addr := leader[strings.Index(leader, "@")+1:]
+	url := m.mesosURL(addr, "/master/maintenance/status")
+
+	ctx, cancel := m.mesosTimeout()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return status, err
+	}
+	m.authenticate(req)
+
+	resp, err := m.mesosClient().Do(req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
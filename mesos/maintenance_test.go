@@ -0,0 +1,63 @@
+package mesos
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestMaintenanceMachines(t *testing.T) {
+	var status maintenanceStatus
+	body := `{
+		"draining_machines": [{"id": {"hostname": "node-1", "ip": "10.0.0.1"}, "statuses": []}],
+		"down_machines": [{"ip": "10.0.0.3"}]
+	}`
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+
+	machines := status.machines()
+	if len(machines) != 3 || !machines["node-1"] || !machines["10.0.0.1"] || !machines["10.0.0.3"] {
+		t.Errorf("unexpected machines: %v", machines)
+	}
+}
+
+func TestApplyMaintenance(t *testing.T) {
+	kv := &kvRegistry{newFakeRegistry(), map[string][]byte{}}
+	r := kv.fakeRegistry
+	m := &Mesos{Registry: kv, config: config.DefaultConfig()}
+
+	sj := StateJSON{
+		Followers: Followers{
+			{Id: "1", Hostname: "10.0.0.1"},
+			{Id: "2", Hostname: "10.0.0.2"},
+		},
+	}
+
+	m.applyMaintenance(sj, map[string]bool{"10.0.0.1": true})
+	if len(r.maintenance) != 1 || !r.maintenance["10.0.0.1"] {
+		t.Errorf("expected only the draining follower in maintenance, got %v", r.maintenance)
+	}
+	if saved := string(kv.kv["mesos-consul/maintenance"]); saved != `["10.0.0.1"]` {
+		t.Errorf("expected the agent in maintenance saved, got %s", saved)
+	}
+
+	// A restarted instance takes it out of maintenance too
+	m = &Mesos{Registry: kv, config: config.DefaultConfig()}
+
+	// Nothing changes while the follower is away
+	r.maintenance = nil
+	m.applyMaintenance(StateJSON{Followers: sj.Followers[1:]}, nil)
+	if len(r.maintenance) != 0 {
+		t.Errorf("expected no maintenance change, got %v", r.maintenance)
+	}
+
+	m.applyMaintenance(sj, nil)
+	if len(r.maintenance) != 1 || r.maintenance["10.0.0.1"] {
+		t.Errorf("expected the maintenance to be over, got %v", r.maintenance)
+	}
+	if saved := string(kv.kv["mesos-consul/maintenance"]); saved != `[]` {
+		t.Errorf("expected no agent left saved, got %s", saved)
+	}
+}
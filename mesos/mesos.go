@@ -1,157 +1,1120 @@
 package mesos
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/CiscoCloud/mesos-consul/config"
-	"github.com/CiscoCloud/mesos-consul/consul"
+	"github.com/CiscoCloud/mesos-consul/hook"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	"github.com/CiscoCloud/mesos-consul/retry"
+	"github.com/CiscoCloud/mesos-consul/tracing"
 
 	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
 )
 
+// The datacenter of the agent a service is registered with
+const localDatacenter = ""
+
+// Services are cached per datacenter they are registered into, so the
+// same service ID in two datacenters is deduplicated and swept
+// independently.
+type ServiceKey struct {
+	ID         string
+	Datacenter string
+}
+
 type CacheEntry struct {
 	service      *consulapi.AgentServiceRegistration
 	isRegistered bool
+
+	// Address of the Consul agent the service is registered with,
+	// empty for the --consul-addr agent
+	agent string
+
+	// Consecutive syncs the service was missing from, see
+	// --deregister-delay
+	missed int
 }
 
 type Mesos struct {
-	Consul       *consul.Consul
+	Registry     registry.Registry
+	config       *config.Config
 	Masters      *[]MesosHost
 	Lock         sync.Mutex
-	ServiceCache map[string]*CacheEntry
+	ServiceCache map[ServiceKey]*CacheEntry
+
+	// The config of the last setConfig, for the readers not holding
+	// syncLock, see currentConfig()
+	shared atomic.Pointer[config.Config]
+
+	// Services registered without checks, see --no-check-services
+	noCheck *regexp.Regexp
+
+	// The --mirror-services, nil without one
+	mirrorServices *regexp.Regexp
+
+	// Frameworks and tasks to sync
+	filter filter
+
+	// Extra tags of task services, see --service-tags-template
+	tagsTemplate *template.Template
+
+	// The --service-id-template, nil without one
+	idTemplate *template.Template
+
+	// The KV key the service cache is persisted under, see --kv-prefix
+	cacheKey string
+
+	// The Mesos cluster the cache belongs to, see selectCluster()
+	mesosCluster string
+
+	// Serializes Refresh() so concurrent callers take turns
+	syncLock sync.Mutex
+
+	// Guards ServiceCache against the --cache-watch watcher and
+	// the retries between syncs
+	cacheLock  sync.Mutex
+	savedCache map[string][]byte
+	// The cache was loaded from the single value of an older release
+	legacyCache bool
+	// The cache was loaded by the --cache-watch watcher while on
+	// standby and no sync has used it yet, see warmCache()
+	warm      bool
+	watchOnce sync.Once
+
+	// The services of the cache as of the last change, which
+	// CachedServices() serves without waiting for cacheLock, see
+	// unlockCache()
+	cached atomic.Pointer[[]CachedService]
+
+	// Stops the running --watch-services watcher, see watchServices()
+	stopWatching context.CancelFunc
+
+	// When the last sync ended
+	syncEnded time.Time
+
+	// Running instances required per service before draining its
+	// old ones, see --min-healthy-before-drain
+	minHealthy map[string]int
+
+	// The last good state, see --state-refresh
+	lastState    StateJSON
+	stateFetched time.Time
+	quorumErr    error
+
+	// How long, and until when, the state fetches back off from an
+	// overloaded leader, see backOffState()
+	stateBackoff      time.Duration
+	stateBackoffUntil time.Time
+
+	// The error of the leader while the fetches back off from it,
+	// reported as the Mesos error of the syncs
+	backoffErr error
+
+	// Whether the sync in progress is on the last good state as
+	// reading the state failed, see --freeze-on-failure
+	frozen bool
+
+	// The last comparison with Consul, see --reconcile-interval
+	reconciled time.Time
+
+	// Tags other tooling added to the registered services by service
+	// ID, see --preserve-tags, read at the services index and for the
+	// prefix given
+	externalTags   map[string][]string
+	externalIndex  uint64
+	externalPrefix string
+
+	// The check labels of the --check-overrides overrides, by service
+	// name
+	checkOverrides map[string][]Label
+
+	// The service names --service-controls disabled
+	disabled map[string]bool
+
+	// The live colours of the --blue-green-live deployments, by
+	// service name
+	liveColours map[string]string
+
+	// The cache entries of the registrations of the sync that failed,
+	// see forgetFailed()
+	failedLock  sync.Mutex
+	failed      map[ServiceKey]*CacheEntry
+	failedHolds map[ServiceKey]heldService
+
+	// The registrations that failed, retried on a backoff between
+	// syncs, see queuePending()
+	pending      map[ServiceKey]*pendingRegistration
+	pendingTimer *time.Timer
+
+	// The tags and meta over Consul's limits of the services of the
+	// registration pass, by service ID, and those saved in the KV
+	// store, by key, see saveOverflow()
+	overflow      map[string]overflow
+	savedOverflow map[string][]byte
+
+	// The work_dir of the agents running tasks, by agent ID, see
+	// loadWorkDirs()
+	workDirs map[string]string
+
+	// The heartbeat service registered, see heartbeat()
+	beat *consulapi.AgentServiceRegistration
+
+	// How the tasks of the state that are not running ended or were
+	// lost, and the IDs of those lost within --lost-task-grace with
+	// their state, by task ID, see taskOutcomes()
+	terminations map[string]termination
+	lostTasks    map[string]string
+
+	// When each lost task was first found lost
+	lostSince map[string]time.Time
+
+	// Agents put into maintenance mode, see --sync-maintenance
+	maintenance map[string]bool
+
+	// The last state written to the KV store, see --export-state
+	exported map[string][]byte
+
+	// The last task services written to the KV store, see
+	// --export-services
+	exportedServices map[string][]byte
+
+	// Notified of the registration changes, see --hook-*
+	hooks hook.Hook
+
+	// Provides the state instead of the masters, see NewWithClient
+	client Client
+
+	// The context of the sync in progress, see RefreshContext
+	ctx context.Context
+
+	// The state API of each master, see --mesos-state-api
+	stateAPIs map[string]string
+
+	// Client of the requests to Mesos, see --mesos-ssl
+	httpClient *http.Client
+
+	// The sync in progress and where its changes are recorded, see
+	// --change-log
+	summary   *syncSummary
+	changeLog *json.Encoder
+
+	// Where the spans of the syncs are exported, see --otlp-endpoint
+	tracer *tracing.Tracer
+
+	// The service definition files written, see --service-files-dir
+	serviceFiles *registry.FileRegistry
+
+	// The flaps of the apps, see --flap-threshold
+	flaps flapTracker
+
+	// The digest of the state the last registration pass synced, see
+	// --skip-unchanged. Cleared when the cache or configuration
+	// changes.
+	syncedHash string
+
+	// The --instance-tags instances of the running tasks by task ID,
+	// and the last incarnation of every instance
+	instances    map[string]instance
+	incarnations map[string]int
+
+	// The running instances of the service of each task, by task ID,
+	// see --check-interval-instances
+	instanceCounts map[string]int
+
+	// The pods of the running tasks of task groups, see taskPods()
+	pods map[*Task]*pod
+
+	// Registered addresses of Mesos ones, see --address-map-file, and
+	// the --address-translator results of the sync in progress
+	addressMap map[string]string
+	translated map[string]string
+
+	// The last --transform-plugin run of the sync in progress
+	pluginRun *pluginRun
+
+	health health
+
+	// Workers of the registry writes, see --registry-concurrency
+	pool *pool
+
+	// The replaced stages of the syncs, see SetStages
+	stages Stages
 }
 
-func New(c *config.Config, consul *consul.Consul) *Mesos {
-	m := new(Mesos)
+// A Client provides the Mesos state to sync in place of the masters
+// found in Zookeeper, e.g. to embed the bridge with a custom one. State
+// gives up once ctx is done.
+type Client interface {
+	State(ctx context.Context) (StateJSON, error)
+}
 
-	if c.Zk == "" {
+func New(c *config.Config, r registry.Registry) *Mesos {
+	var client Client
+	if c.FromFile != "" {
+		var err error
+		if client, err = NewFileClient(c.FromFile); err != nil {
+			hclog.L().Error("Unable to read the state file", "file", c.FromFile, "error", err)
+			os.Exit(1)
+		}
+	} else if c.Zk == "" {
 		return nil
 	}
 
-	m.Consul = consul
-
-	m.zkDetector(c.Zk)
+	m, err := NewWithClient(c, r, client)
+	if err != nil {
+		hclog.L().Error("Unable to start", "error", err)
+		os.Exit(1)
+	}
 
 	return m
 }
 
+// Build a Mesos syncing the state of client into r, or of the masters
+// found in --zk when client is nil. Errors, e.g. an unreachable
+// Zookeeper, are returned instead of exiting.
+func NewWithClient(c *config.Config, r registry.Registry, client Client) (*Mesos, error) {
+	m := new(Mesos)
+
+	if c.ServiceFilesDir != "" {
+		m.serviceFiles = registry.Files(r, c.ServiceFilesDir, c.ServiceFilesReload, agentHTTPAddr(c), c.RegistrationAPI == config.RegistrationFiles)
+		r = m.serviceFiles
+	}
+
+	if c.OTLPEndpoint != "" {
+		r = registry.Traced(r)
+	}
+
+	r = registry.Owned(r, c.InstanceID)
+
+	if c.DryRun {
+		r = registry.DryRun(r)
+	}
+
+	if c.RegistryRate > 0 || c.RegistryRetries > 0 {
+		r = registry.Limit(r, c.RegistryRate, c.RegistryRetries)
+	}
+
+	if c.ServicePrefix != "" || c.ServiceSuffix != "" {
+		r = registry.Affixed(r, c.ServicePrefix, c.ServiceSuffix)
+	}
+
+	if c.Cluster != nil {
+		r = registry.Cluster(r, c.Cluster.Name, c.Cluster.ServicePrefix)
+	}
+
+	m.Registry = r
+	m.cacheKey = c.KVPrefix + "/cache"
+	m.hooks = newHooks(c)
+	m.setConfig(c)
+
+	if c.RegistryConcurrency > 1 {
+		m.pool = newPool(c.RegistryConcurrency)
+	}
+
+	httpClient, err := newMesosClient(c.MesosSSL)
+	if err != nil {
+		return nil, fmt.Errorf("mesos-ssl: %s", err)
+	}
+	m.httpClient = httpClient
+
+	if c.AddressMapFile != "" {
+		if m.addressMap, err = loadAddressMap(c.AddressMapFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if client != nil {
+		m.client = client
+		m.Masters = &[]MesosHost{}
+		return m, nil
+	}
+
+	if err := m.zkDetector(c.Zk); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Use c for the following syncs, compiling its filters and templates
+func (m *Mesos) setConfig(c *config.Config) {
+	m.config = c
+	m.shared.Store(c)
+
+	m.noCheck = nil
+	if c.NoCheckServices != "" {
+		m.noCheck = regexp.MustCompile(c.NoCheckServices)
+	}
+
+	m.mirrorServices = nil
+	if c.MirrorServices != "" {
+		m.mirrorServices = regexp.MustCompile(c.MirrorServices)
+	}
+
+	m.filter = filter{
+		fwWhitelist:   compileFilter(c.FwWhitelist),
+		fwBlacklist:   compileFilter(c.FwBlacklist),
+		taskWhitelist: compileFilter(c.TaskWhitelist),
+		taskBlacklist: compileFilter(c.TaskBlacklist),
+		roleWhitelist: compileFilter(c.RoleWhitelist),
+		roleBlacklist: compileFilter(c.RoleBlacklist),
+	}
+
+	m.tagsTemplate = nil
+	if c.ServiceTagsTemplate != "" {
+		m.tagsTemplate = template.Must(template.New("service-tags").Parse(c.ServiceTagsTemplate))
+	}
+
+	m.idTemplate = nil
+	if c.ServiceIDTemplate != "" {
+		m.idTemplate = template.Must(template.New("service-id").Funcs(ServiceIDFuncs).Parse(c.ServiceIDTemplate))
+	}
+}
+
+// The configuration for the HTTP handlers and the event stream, which
+// run alongside the syncs and Reload
+func (m *Mesos) currentConfig() *config.Config {
+	if c := m.shared.Load(); c != nil {
+		return c
+	}
+	return m.config
+}
+
+// Reload switches to a new configuration between syncs, e.g. on
+// SIGHUP. The settings only read at startup must be the same as the
+// current ones, see config.KeepRestartSettings.
+func (m *Mesos) Reload(c *config.Config) {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.setConfig(c)
+
+	m.cacheLock.Lock()
+	m.syncedHash = ""
+	m.cacheLock.Unlock()
+}
+
 func (m *Mesos) Refresh() error {
-	sj, err := m.loadState()
+	return m.RefreshContext(context.Background())
+}
+
+// RefreshContext syncs like Refresh, abandoning the Mesos requests and
+// registry calls left once ctx is done
+func (m *Mesos) RefreshContext(ctx context.Context) (err error) {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	ctx, span := m.tracer.Start(ctx, "sync", m.traceAttrs()...)
+	m.ctx = ctx
+	defer func() { m.ctx = nil }()
+	m.translated = nil
+	m.pluginRun = nil
+
+	m.health.begin()
+	m.beginSummary()
+
+	var mesosErr error
+	defer func() {
+		m.syncEnded = time.Now()
+		span.Set("services", len(m.ServiceCache))
+		span.Fail(err)
+		span.End()
+		m.health.end(err, mesosErr)
+		m.endSummary(err, m.health.syncErrors())
+		m.heartbeat(err)
+		m.serviceFiles.Reload()
+	}()
+
+	endFetch := m.beginSpan("mesos.fetch")
+	sj, fresh, err := m.fetchState()
+	endFetch(err)
+	m.frozen = false
 	if err != nil {
-		log.Print("[ERROR] No master")
+		mesosErr = err
+		if !m.config.FreezeOnFailure || m.stateFetched.IsZero() {
+			return err
+		}
+
+		// Keep the services of the last good state rather than
+		// acting on no state at all
+		hclog.L().Warn("Unable to read the Mesos state. Freezing on the last good one", "fetched", m.stateFetched.Format(time.RFC3339), "error", err)
+		sj, m.frozen = m.lastState, true
+		m.summary.Frozen = true
+		defer func() { err = mesosErr }()
+	}
+	m.health.stateRead(m.stateFetched, m.frozen)
+	m.summary.Leader = sj.Leader
+
+	if fresh && m.config.HealthAddr != "" {
+		m.quorumErr = m.checkQuorum()
+	}
+	if !m.frozen {
+		mesosErr = m.quorumErr
+	}
+	if m.backoffErr != nil {
+		mesosErr = m.backoffErr
+	}
+
+	m.cacheLock.Lock()
+	defer m.unlockCache()
+
+	if err := m.selectCluster(sj.Cluster); err != nil {
+		mesosErr = err
 		return err
 	}
+	m.loadServiceCache()
+	m.warm = false
 
-	if sj.Leader == "" {
-		return errors.New("Empty master")
+	m.WatchCache()
+	if m.config.WatchServices {
+		if m.stopWatching == nil {
+			var ctx context.Context
+			ctx, m.stopWatching = context.WithCancel(context.Background())
+			go m.watchServices(ctx)
+		}
 	}
 
-	if m.ServiceCache == nil {
-		log.Print("[INFO] Creating ServiceCache")
-		m.ServiceCache = make(map[string]*CacheEntry)
-		m.LoadCache()
+	hash := stateHash(sj)
+	if m.unchanged(hash, fresh) {
+		hclog.L().Debug("State unchanged. Skipping the registration pass")
+		m.summary.Unchanged = len(m.ServiceCache)
+		m.reconcile()
+		m.exportState(sj)
+		m.exportServices(sj)
+		if m.health.syncErrors() > 0 {
+			m.syncedHash = ""
+		}
+		return nil
+	}
+	m.syncedHash = ""
+
+	m.loadExternalTags()
+	m.loadCheckOverrides()
+	m.loadControls()
+	m.loadLiveColours()
+	m.beginOverflow()
+	m.parseState(m.filterState(sj))
+	if !m.frozen {
+		m.syncMaintenance(sj)
+		m.reconcile()
+	}
+	m.forgetFailed()
+	m.saveCache()
+	m.saveOverflow()
+	m.syncQueries()
+	if !m.frozen {
+		m.exportState(sj)
+		m.exportServices(sj)
 	}
 
-	m.parseState(sj)
+	if m.health.syncErrors() == 0 && !m.frozen {
+		m.syncedHash = hash
+	}
 
 	return nil
 }
 
-func (m *Mesos) loadState() (StateJSON, error) {
-	var err error
-	var sj StateJSON
+// Create the cache on the first sync, from the copy persisted in
+// Consul KV or, without one, from the services registered in Consul
+func (m *Mesos) loadServiceCache() {
+	if m.ServiceCache != nil {
+		return
+	}
 
-	defer func() {
-		if rec := recover(); rec != nil {
-			err = errors.New("can't connect to Mesos")
+	hclog.L().Info("Creating ServiceCache")
+	m.ServiceCache = make(map[ServiceKey]*CacheEntry)
+	if ok, err := m.loadKVCache(); !ok {
+		if err != nil {
+			hclog.L().Warn("Unable to read the cache", "key", m.cacheKey, "error", err)
 		}
-	}()
+		m.LoadCache()
+	}
+	m.forgetUnwritten()
+}
+
+// Return the state to sync. With --state-refresh the last good state
+// is reused until it is older than the interval, so registrations can
+// be re-affirmed more often than the masters are queried. fresh tells
+// whether the state was just fetched.
+func (m *Mesos) fetchState() (sj StateJSON, fresh bool, err error) {
+	if m.config.StateRefresh > 0 && !m.stateFetched.IsZero() && time.Since(m.stateFetched) < m.config.StateRefresh {
+		hclog.L().Debug("Reusing the state", "fetched", m.stateFetched.Format(time.RFC3339))
+		return m.lastState, false, nil
+	}
+
+	if m.backingOff() {
+		// Only the replicas are asked until the backoff is over
+		if sj, err = m.loadFromReplicas(); err != nil {
+			hclog.L().Debug("Backing off the leader", "until", m.stateBackoffUntil.Format(time.RFC3339))
+			return m.lastState, false, nil
+		}
+	} else {
+		m.staggerState()
+
+		// Ride out a master failing over or a dropped connection
+		err = retry.Do(m.config.MesosRetries, func() (err error) {
+			sj, err = m.loadState()
+			return err
+		})
+		if err != nil {
+			if overloaded(err) && m.backOffState() {
+				hclog.L().Warn("Leading master overloaded. Backing off", "until", m.stateBackoffUntil.Format(time.RFC3339), "error", err)
+				m.backoffErr = err
+				return m.lastState, false, nil
+			}
+			m.backoffErr = nil
+			hclog.L().Error("No master")
+			return sj, false, err
+		}
+		m.stateBackoff = 0
+		m.backoffErr = nil
+	}
+
+	if sj.Leader == "" {
+		return sj, false, errors.New("Empty master")
+	}
+
+	m.enrichTasks(sj)
+	m.mapContainerPorts(sj)
+	m.loadWorkDirs(sj)
+
+	m.lastState = sj
+	m.stateFetched = time.Now()
+
+	return sj, true, nil
+}
+
+// The context of the registry calls and Mesos requests of the sync in
+// progress
+func (m *Mesos) syncCtx() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// With --consul-allow-stale, a copy of ctx for the reads any Consul
+// server can answer, e.g. reconciliation's
+func (m *Mesos) allowStale(ctx context.Context) context.Context {
+	if !m.config.ConsulAllowStale {
+		return ctx
+	}
+	return registry.AllowStale(ctx)
+}
+
+// The context of the reads loading the cache. After taking over the
+// --lock, they go to the leader, so they see the last writes of the
+// previous holder.
+func (m *Mesos) loadCtx() context.Context {
+	if m.config.Lock != "" {
+		return m.syncCtx()
+	}
+	return m.allowStale(m.syncCtx())
+}
+
+// The HTTP address of the Consul agent at agent, as the consul CLI
+// takes it in CONSUL_HTTP_ADDR: on --registry-port unless agent names
+// a port, over HTTPS with --registry-ssl
+func agentHTTPAddr(c *config.Config) func(agent string) string {
+	return func(agent string) string {
+		if strings.Contains(agent, "://") {
+			return agent
+		}
+		if _, _, err := net.SplitHostPort(agent); err != nil {
+			agent = hostPort(agent, c.RegistryPort)
+		}
+		if c.RegistrySSL != nil && c.RegistrySSL.Enabled {
+			return "https://" + agent
+		}
+		return agent
+	}
+}
+
+// Bound a request to Mesos by --mesos-timeout
+func (m *Mesos) mesosTimeout() (context.Context, context.CancelFunc) {
+	if m.config.MesosTimeout <= 0 {
+		return context.WithCancel(m.syncCtx())
+	}
+	return context.WithTimeout(m.syncCtx(), m.config.MesosTimeout)
+}
+
+func (m *Mesos) loadState() (StateJSON, error) {
+	if m.client != nil {
+		ctx, cancel := m.mesosTimeout()
+		defer cancel()
+
+		return m.client.State(ctx)
+	}
+
+	if sj, err := m.loadFromReplicas(); err == nil {
+		return sj, nil
+	}
 
 	ip, port := m.getLeader()
 	if ip == "" {
-		return sj, errors.New("No master in zookeeper")
+		return StateJSON{}, errors.New("No master in zookeeper")
 	}
 
-	log.Printf("[INFO] Zookeeper leader: %s:%s", ip, port)
+	hclog.L().Info("Zookeeper leader", "master", ip+":"+port)
 
-	log.Print("[INFO] reloading from master ", ip)
-	sj = m.loadFromMaster(ip, port)
+	hclog.L().Info("Reloading from master", "master", ip)
+	sj, err := m.loadFromMaster(ip, port)
+	if err != nil {
+		// During a failover Zookeeper can still name the old leader
+		// for a while. Any master that answers knows the new one.
+		hclog.L().Warn("Leader unreachable", "master", ip+":"+port, "error", err)
+		sj, err = m.loadFromFollowingMaster(ip, port)
+		if err != nil {
+			return sj, err
+		}
+	}
+
+	if sj.Leader == "" {
+		return sj, nil
+	}
 
 	if rip := leaderIP(sj.Leader); rip != ip {
-		log.Print("[WARN] master changed to ", rip)
-		sj = m.loadFromMaster(rip, port)
+		hclog.L().Warn("Master changed", "master", rip)
+		sj, err = m.loadFromMaster(rip, port)
+	}
+
+	return sj, err
+}
+
+// Load the state from the first master other than ip:port that answers
+func (m *Mesos) loadFromFollowingMaster(ip string, port string) (StateJSON, error) {
+	err := errors.New("can't connect to Mesos")
+
+	for _, ma := range m.getMasters() {
+		mip := toIP(ma.host)
+		if mip == "" || (mip == ip && ma.port == port) {
+			continue
+		}
+
+		var sj StateJSON
+		sj, err = m.loadFromMaster(mip, ma.port)
+		if err == nil {
+			return sj, nil
+		}
+		hclog.L().Debug("Master unreachable", "master", mip+":"+ma.port, "error", err)
+	}
+
+	return StateJSON{}, err
+}
+
+// Load the state from the master at ip:port with the API of its
+// version, see stateAPI()
+func (m *Mesos) loadFromMaster(ip string, port string) (sj StateJSON, err error) {
+	addr := hostPort(ip, port)
+
+	api := m.stateAPI(addr)
+	if api == config.MesosStateV1 {
+		sj, err = m.loadFromOperatorAPI(addr)
+	} else {
+		err = m.requestJSON("GET", m.mesosURL(addr, stateEndpoints[api]), "", &sj)
+	}
+
+	if err != nil {
+		delete(m.stateAPIs, addr)
 	}
 
 	return sj, err
 }
 
-func (m *Mesos) loadFromMaster(ip string, port string) (sj StateJSON) {
-	url := "http://" + ip + ":" + port + "/master/state.json"
+// Make a request to a master, with body when it is not empty, and
+// decode its JSON answer into v
+func (m *Mesos) requestJSON(method string, url string, body string, v interface{}) error {
+	ctx, cancel := m.mesosTimeout()
+	defer cancel()
+
+	return m.requestJSONContext(ctx, method, url, body, v)
+}
+
+// Make a request like requestJSON, to a master or agent, within ctx
+func (m *Mesos) requestJSONContext(ctx context.Context, method string, url string, body string, v interface{}) error {
+	_, err := m.requestHeader(ctx, method, url, body, v)
+	return err
+}
+
+// Make a request like requestJSONContext, returning the header of the
+// answer too
+func (m *Mesos) requestHeader(ctx context.Context, method string, url string, body string, v interface{}) (http.Header, error) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	ctx, span := tracing.Client(ctx, "mesos.request", "http.method", method, "http.url", url)
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	m.authenticate(req)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := m.mesosClient().Do(req)
 	if err != nil {
-		log.Fatal("[ERROR] ", err)
+		span.Fail(err)
+		return nil, err
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal("[ERROR] ", err)
+
+	span.Set("http.status_code", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		err = statusError{url, resp.Status, resp.StatusCode}
+		span.Fail(err)
+		// e.g. wrong credentials, which retrying does not fix
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			err = retry.Permanent(err)
+		}
+		return nil, err
 	}
 
-	err = json.Unmarshal(body, &sj)
+	content, err := responseBody(resp)
 	if err != nil {
-		log.Fatal("[ERROR] ", err)
+		return nil, err
 	}
+	defer content.Close()
 
-	return sj
+	err = decodeStream(content, v)
+	span.Fail(err)
+	return resp.Header, err
+}
+
+// An answer of a master or agent other than 200 OK
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e statusError) Error() string {
+	return e.url + ": " + e.status
 }
 
 func (m *Mesos) parseState(sj StateJSON) {
-	log.Print("[INFO] Running parseState")
+	hclog.L().Info("Running parseState")
+
+	m.minHealthy = m.drainMinimums(sj)
+	m.taskOutcomes(sj)
+
+	if m.frozen {
+		// Nothing is known to have gone away: register the services
+		// of the last good state again and keep the others
+		m.registerState(sj)
+		for _, b := range m.ServiceCache {
+			b.isRegistered = false
+		}
+		hclog.L().Info("State frozen. Skipping deregistration")
+		return
+	}
+
+	switch m.config.SyncOrder {
+	case config.SyncDeregisterFirst:
+		// Withdraw services that have gone away before advertising
+		// new ones so stale endpoints are never handed out.
+		m.markRunning(sj)
+		m.deregister()
+		m.flush()
+		m.registerState(sj)
+	default:
+		// Register new locations before withdrawing old ones so there
+		// is always at least one instance of a moved service.
+		m.registerState(sj)
+
+		// Remove completed tasks
+		m.deregister()
+		m.flush()
+	}
+}
+
+func (m *Mesos) registerState(sj StateJSON) {
+	defer m.beginSpan("register")(nil)
 
 	m.RegisterHosts(sj)
-	log.Print("[DEBUG] Done running RegisterHosts")
+	hclog.L().Debug("Done running RegisterHosts")
+
+	services, agents := m.taskServices(sj)
+	services = m.withoutDisabled(services, agents)
+	services = m.withoutHeldOff(services, agents)
+	m.deregisterDisabled()
+	for _, s := range services {
+		m.registerAt(localDatacenter, agents[s.ID], s)
+		m.registerMirror(s)
+	}
+	m.holdLost()
+
+	for _, s := range m.frameworkServices(sj) {
+		m.register(localDatacenter, s)
+	}
+
+	// TTL checks only exist once their service is registered
+	m.flush()
+	for _, s := range services {
+		m.reportTTL(agents[s.ID], s)
+	}
+
+	m.registerAggregates(sj)
+
+	hclog.L().Debug("Cache size", "services", len(m.ServiceCache))
+}
+
+// Sort registrations by service ID
+type byID []*consulapi.AgentServiceRegistration
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+// markRunning flags the cache entries of every host and task that is
+// still present in the state so a following deregister() only sweeps
+// the ones that have gone away.
+func (m *Mesos) markRunning(sj StateJSON) {
+	for _, b := range m.ServiceCache {
+		b.isRegistered = false
+	}
+
+	for key := range m.stateServiceKeys(sj) {
+		if b, ok := m.ServiceCache[key]; ok {
+			b.isRegistered = true
+		}
+	}
+	m.holdLost()
+}
+
+// Return the keys of every service a sync of the state registers
+func (m *Mesos) stateServiceKeys(sj StateJSON) map[ServiceKey]bool {
+	tasks, _ := m.taskServices(sj)
+	services := append(m.hostServices(sj), tasks...)
+	services = append(services, m.frameworkServices(sj)...)
+	for _, a := range m.aggregateServices(sj) {
+		services = append(services, a.service)
+	}
+
+	keys := make(map[ServiceKey]bool, len(services))
+	for _, s := range services {
+		keys[ServiceKey{s.ID, localDatacenter}] = true
+	}
+	for _, s := range tasks {
+		if m.mirrored(s) {
+			keys[ServiceKey{s.ID, m.config.MirrorDatacenter}] = true
+		}
+	}
+
+	return keys
+}
+
+// Build the registrations of the running tasks and the address of the
+// Consul agent each one is registered with, by service ID
+func (m *Mesos) taskServices(sj StateJSON) ([]*consulapi.AgentServiceRegistration, map[string]string) {
+	var services []*consulapi.AgentServiceRegistration
+	agents := make(map[string]string)
+	names, collisions := m.serviceNames(sj)
+	m.logNameCollisions(collisions)
+	instances := m.assignInstances(sj, names)
+	if m.config.CheckIntervalInstances > 0 {
+		m.instanceCounts = countInstances(names)
+	}
+	m.pods = taskPods(sj)
+	nodes := followerAddresses(sj)
 
 	for _, fw := range sj.Frameworks {
-		for _, task := range fw.Tasks {
-			host, err := sj.Followers.hostById(task.FollowerId)
+		_, span := tracing.Begin(m.syncCtx(), "framework", "framework", fw.Name, "tasks", len(fw.Tasks))
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			f, err := sj.Followers.byId(task.FollowerId)
 			if err == nil && task.State == "TASK_RUNNING" {
+				if !m.taskReady(task) {
+					hclog.L().Debug("Task not healthy yet. Not registering", task.logFields()...)
+					continue
+				}
+
+				host := f.Hostname
+				address := m.taskAddress(task, f)
+				if address == "" {
+					hclog.L().Warn("No address for task. Not registering", task.logFields()...)
+					continue
+				}
+				namespace := m.taskNamespace(fw.Name, task)
+				partition := task.label(partitionLabel)
 				tname := cleanName(task.Name)
+				stags, dmeta := discoveryService(task)
+				sname := names[task]
+				if !m.liveTask(sname, task) {
+					hclog.L().Debug("Task of a colour that is not live. Not registering", task.logFields()...)
+					continue
+				}
+				attrs := f.attributes(m.config.FollowerAttributes)
+				meta := taskMeta(fw.Name, task, attrs, dmeta)
+				if sandbox := m.sandboxURL(task); sandbox != "" {
+					meta[sandboxMeta] = sandbox
+				}
+				instance, numbered := instances[task]
+				if numbered {
+					meta[instanceMeta] = strconv.Itoa(instance.index)
+					meta[incarnationMeta] = strconv.Itoa(instance.incarnation)
+					if m.config.InstanceTags {
+						stags = append(stags, instance.tag())
+					}
+				}
+				if colour := m.deploymentColour(task); colour != "" {
+					stags = append(stags, colour)
+				}
+				stags = append(stags, attributeTags(attrs)...)
+				stags = append(stags, m.templateTags(fw.Name, task, f)...)
+				tdata := newTagData(fw.Name, task, f)
+				stags = append(stags, m.labelTags(task)...)
+				stags = append(stags, m.podTags(task)...)
 				if task.Resources.Ports != "" {
-					for _, port := range yankPorts(task.Resources.Ports) {
-						m.register(&consulapi.AgentServiceRegistration{
-							ID:      fmt.Sprintf("mesos-consul:%s:%s:%d", host, tname, port),
-							Name:    tname,
-							Port:    port,
-							Address: toIP(host),
+					ports := yankPorts(task.Resources.Ports)
+					for i, port := range ports {
+						name, tags := m.taskPortService(sname, task, i, port)
+						ctask := m.withCheckOverride(name, task)
+						agent := m.taskAgent(ctask, address, f, nodes)
+
+						// Checks target the allocated port unless
+						// check-port says otherwise, whatever port
+						// is advertised
+						checkPort := port
+						if p, ok := ctask.labelPort(checkPortLabel); ok {
+							checkPort = task.hostPort(p)
+						}
+
+						advertised := port
+						if p, ok := task.labelPort(consulPortLabel); ok && i == 0 {
+							if len(ports) > 1 {
+								hclog.L().Warn("Label only overrides the first port", append(task.logFields(), "label", consulPortLabel, "ports", len(ports))...)
+							}
+							advertised = task.hostPort(p)
+						}
+
+						id := m.taskServiceID(idData{tdata, name, address, port, instance.index, i}, fmt.Sprintf("mesos-consul:%s:%s:%d", host, tname, port))
+						if _, ok := agents[id]; ok && m.idTemplate != nil {
+							// A template without the port
+							id += ":" + strconv.Itoa(port)
+						}
+						id = uniqueID(agents, id, task)
+						agents[id] = agent
+
+						services = append(services, &consulapi.AgentServiceRegistration{
+							ID:                id,
+							Name:              name,
+							Tags:              append(append([]string{}, stags...), tags...),
+							Meta:              meta,
+							Port:              advertised,
+							Address:           address,
+							TaggedAddresses:   m.taggedAddresses(task, address, advertised),
+							Namespace:         namespace,
+							Partition:         partition,
+							Check:             m.withCheckContext(m.taskCheck(name, ctask, address, checkPort), task, host),
+							Connect:           connectService(task),
+							EnableTagOverride: m.config.EnableTagOverride,
+							Weights:           m.taskWeights(task),
 						})
 					}
 				} else {
-					m.register(&consulapi.AgentServiceRegistration{
-						ID:      fmt.Sprintf("mesos-consul:%s-%s", host, tname),
-						Name:    tname,
-						Address: toIP(host),
+					ctask := m.withCheckOverride(sname, task)
+					agent := m.taskAgent(ctask, address, f, nodes)
+					checkPort, _ := ctask.labelPort(checkPortLabel)
+					checkPort = task.hostPort(checkPort)
+					port, _ := task.labelPort(consulPortLabel)
+					port = task.hostPort(port)
+
+					id := m.taskServiceID(idData{tdata, sname, address, port, instance.index, 0}, fmt.Sprintf("mesos-consul:%s-%s", host, tname))
+					id = uniqueID(agents, id, task)
+					agents[id] = agent
+
+					services = append(services, &consulapi.AgentServiceRegistration{
+						ID:                id,
+						Name:              sname,
+						Tags:              stags,
+						Meta:              meta,
+						Port:              port,
+						Address:           address,
+						TaggedAddresses:   m.taggedAddresses(task, address, port),
+						Namespace:         namespace,
+						Partition:         partition,
+						Check:             m.withCheckContext(m.taskCheck(sname, ctask, address, checkPort), task, host),
+						Connect:           connectService(task),
+						EnableTagOverride: m.config.EnableTagOverride,
+						Weights:           m.taskWeights(task),
 					})
 				}
 			}
 		}
+		span.End()
+	}
+
+	// Keep a stable order so the same services are dropped on every
+	// sync when the cache is full or ports collide
+	sort.Sort(byID(services))
+
+	return m.transform(m.resolveCollisions(services), agents)
+}
+
+// The task label naming the Consul agent the services of the task are
+// registered with, as --consul-agent-attribute values do
+const agentLabel = "consul-agent"
+
+// The Consul agent a task service is registered with: the one named
+// by the consul-agent label of the task or the --consul-agent-attribute
+// of the Mesos agent running it, or else the one on the Mesos agent.
+// With --task-agent=address, a task on the address of another Mesos
+// agent, nodes, is registered with the one there, unless its check
+// runs a command. Container addresses have no agent of their own.
+func (m *Mesos) taskAgent(task *Task, address string, f *follower, nodes map[string]bool) string {
+	if agent := task.label(agentLabel); agent != "" {
+		return agent
+	}
+
+	if name := m.config.ConsulAgentAttribute; name != "" {
+		if agent := f.attributes([]string{name})[name]; agent != "" {
+			return agent
+		}
+	}
+
+	if m.config.TaskAgent == config.TaskAgentAddress && nodes[address] && !localCheck(task) {
+		return address
+	}
+
+	return toIP(f.Hostname)
+}
+
+// The addresses of the Mesos agents of the state, those of their
+// hostnames and of their PIDs
+func followerAddresses(sj StateJSON) map[string]bool {
+	nodes := make(map[string]bool, len(sj.Followers))
+	for _, f := range sj.Followers {
+		nodes[toIP(f.Hostname)] = true
+		nodes[f.Hostname] = true
+		if h, _, err := parsePID(f.Pid, false); err == nil {
+			nodes[h] = true
+		}
 	}
 
-	// Remove completed tasks
-	m.deregister()
+	return nodes
 }
 
 func yankPorts(ports string) []int {
@@ -0,0 +1,225 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestFetchStateReusesLastState(t *testing.T) {
+	c := config.DefaultConfig()
+	c.StateRefresh = time.Hour
+
+	m := &Mesos{
+		config:       c,
+		lastState:    StateJSON{Leader: "master@10.0.0.1:5050"},
+		stateFetched: time.Now(),
+	}
+
+	sj, fresh, err := m.fetchState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fresh {
+		t.Error("expected the last state to be reused")
+	}
+	if sj.Leader != "master@10.0.0.1:5050" {
+		t.Errorf("unexpected state leader: %s", sj.Leader)
+	}
+}
+
+func TestTaskServicesConsulPort(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{
+					Id:         "web.1",
+					Name:       "web",
+					FollowerId: "1",
+					State:      "TASK_RUNNING",
+					Resources:  Resources{Ports: "[31000-31000]"},
+					Labels:     []Label{{Key: "consul-port", Value: "8080"}},
+				},
+			}},
+		},
+	}
+
+	services, _ := m.taskServices(sj)
+	if len(services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(services))
+	}
+
+	s := services[0]
+	if s.ID != "mesos-consul:10.0.0.1:web:31000" {
+		t.Errorf("expected the ID to keep the allocated port, got %s", s.ID)
+	}
+	if s.Port != 8080 {
+		t.Errorf("expected the consul-port label to be advertised, got %d", s.Port)
+	}
+}
+
+func TestTaskServicesAgent(t *testing.T) {
+	c := config.DefaultConfig()
+	c.AddressPriority = []string{config.AddressLabel}
+	m := &Mesos{config: c}
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{
+					Id:         "web.1",
+					Name:       "web",
+					FollowerId: "1",
+					State:      "TASK_RUNNING",
+					Labels:     []Label{{Key: "address", Value: "172.17.0.2"}},
+				},
+			}},
+		},
+	}
+
+	// No agent runs on a container address
+	services, agents := m.taskServices(sj)
+	if len(services) != 1 || agents[services[0].ID] != "10.0.0.1" {
+		t.Errorf("expected the agent on the follower for a container address, got %v", agents)
+	}
+
+	sj.Followers = append(sj.Followers, follower{Id: "2", Hostname: "172.17.0.2"})
+	services, agents = m.taskServices(sj)
+	if len(services) != 1 || agents[services[0].ID] != "172.17.0.2" {
+		t.Errorf("expected the agent on the task's address of another follower, got %v", agents)
+	}
+
+	c.TaskAgent = config.TaskAgentFollower
+	services, agents = m.taskServices(sj)
+	if len(services) != 1 || services[0].Address != "172.17.0.2" || agents[services[0].ID] != "10.0.0.1" {
+		t.Errorf("expected the agent on the follower, got %v", agents)
+	}
+
+	// Only the agent on the follower can run a command in the task
+	c.TaskAgent = config.TaskAgentAddress
+	sj.Frameworks[0].Tasks[0].Labels = append(sj.Frameworks[0].Tasks[0].Labels, Label{Key: "consul_check_script", Value: "true"})
+	services, agents = m.taskServices(sj)
+	if len(services) != 1 || agents[services[0].ID] != "10.0.0.1" {
+		t.Errorf("expected the agent on the follower for a script check, got %v", agents)
+	}
+
+	c.ConsulAgentAttribute = "consul_agent"
+	services, agents = m.taskServices(sj)
+	if len(services) != 1 || agents[services[0].ID] != "10.0.0.1" {
+		t.Errorf("expected followers without the attribute to keep their agent, got %v", agents)
+	}
+
+	sj.Followers[0].Attributes = map[string]interface{}{"consul_agent": "10.1.2.3:8501"}
+	services, agents = m.taskServices(sj)
+	if len(services) != 1 || agents[services[0].ID] != "10.1.2.3:8501" {
+		t.Errorf("expected the agent of the follower's attribute, got %v", agents)
+	}
+
+	sj.Frameworks[0].Tasks[0].Labels = append(sj.Frameworks[0].Tasks[0].Labels, Label{Key: "consul-agent", Value: "10.1.2.4"})
+	services, agents = m.taskServices(sj)
+	if len(services) != 1 || agents[services[0].ID] != "10.1.2.4" {
+		t.Errorf("expected the agent of the task's label, got %v", agents)
+	}
+}
+
+func TestTaskServicesRequireHealthy(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	healthy, unhealthy := true, false
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{Id: "web.1", Name: "web", FollowerId: "1", State: "TASK_RUNNING", Statuses: []Status{{State: "TASK_RUNNING", Healthy: &healthy}}},
+				{Id: "web.2", Name: "web", FollowerId: "1", State: "TASK_RUNNING", Statuses: []Status{{State: "TASK_RUNNING", Healthy: &unhealthy}}},
+				{Id: "db.1", Name: "db", FollowerId: "1", State: "TASK_RUNNING"},
+				{Id: "db.2", Name: "db", FollowerId: "1", State: "TASK_STAGING"},
+			}},
+		},
+	}
+
+	names := func() map[string]int {
+		services, _ := m.taskServices(sj)
+		n := make(map[string]int)
+		for _, s := range services {
+			n[s.Name]++
+		}
+		return n
+	}
+
+	if n := names(); n["web"] != 2 || n["db"] != 1 {
+		t.Errorf("expected the running tasks only, got %v", n)
+	}
+
+	c.RequireHealthy = true
+	if n := names(); n["web"] != 1 || n["db"] != 1 {
+		t.Errorf("expected the healthy and unchecked running tasks, got %v", n)
+	}
+}
+
+func TestLoadStateLeaderUnreachable(t *testing.T) {
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"leader":"master@%s","frameworks":[{"name":"marathon"}]}`, r.Host)
+	}))
+	defer follower.Close()
+
+	// A leader that went away
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadHost, deadPort, _ := net.SplitHostPort(dead.Listener.Addr().String())
+	dead.Close()
+
+	host, port, _ := net.SplitHostPort(follower.Listener.Addr().String())
+
+	m := &Mesos{
+		config: config.DefaultConfig(),
+		Masters: &[]MesosHost{
+			{host: host, port: port},
+			{host: deadHost, port: deadPort, isLeader: true},
+		},
+	}
+
+	sj, err := m.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sj.Frameworks) != 1 || sj.Frameworks[0].Name != "marathon" {
+		t.Errorf("expected the state of the answering master, got %+v", sj)
+	}
+}
+
+func TestLoadFromMasterAuthenticates(t *testing.T) {
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "mesos-consul" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"leader":"master@10.0.0.1:5050"}`)
+	}))
+	defer master.Close()
+
+	host, port, _ := net.SplitHostPort(master.Listener.Addr().String())
+
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	if _, err := m.loadFromMaster(host, port); err == nil {
+		t.Error("expected the unauthenticated request to fail")
+	}
+
+	c.MesosUser, c.MesosPassword = "mesos-consul", "secret"
+	sj, err := m.loadFromMaster(host, port)
+	if err != nil || sj.Leader != "master@10.0.0.1:5050" {
+		t.Errorf("expected the state, got %+v, %v", sj, err)
+	}
+}
@@ -0,0 +1,149 @@
+package mesos
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Limits Consul enforces on service metadata
+const (
+	maxMetaPairs    = 64
+	maxMetaKeyLen   = 128
+	maxMetaValueLen = 512
+)
+
+// Pairs the registry adds, see registry.Cluster and registry.Owned
+const registryMetaPairs = 3
+
+// Pairs --instance-tags adds, see assignInstances()
+const instanceMetaPairs = 2
+
+// Metadata keys naming the framework and task of a task service, and
+// the launch of the task, telling a relaunch under the same task ID
+const (
+	frameworkMeta = "mesos-framework"
+	taskIDMeta    = "mesos-task-id"
+	launchMeta    = "mesos-task-launch"
+)
+
+// Task label naming the file of the ACL token its services are
+// registered with, under --registry-token-dir
+const tokenPathLabel = "consul_token_path"
+
+var invalidMetaKey = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Make a task label or DiscoveryInfo key a valid Consul metadata key.
+// Consul reserves the consul- prefix, so such keys, e.g. the
+// consul-port label, are dropped.
+func metaKey(key string) string {
+	key = invalidMetaKey.ReplaceAllString(key, "_")
+	if len(key) > maxMetaKeyLen {
+		key = key[:maxMetaKeyLen]
+	}
+
+	if strings.HasPrefix(strings.ToLower(key), "consul-") {
+		return ""
+	}
+
+	return key
+}
+
+// Build the metadata of a task's services: its task labels, the
+// --follower-attributes of its agent, the DiscoveryInfo metadata of
+// discoveryService and the mesos-* keys identifying the task, in
+// increasing precedence. Pairs beyond the Consul limit are dropped in
+// key order.
+func taskMeta(framework string, task *Task, attributes map[string]string, discovery map[string]string) map[string]string {
+	meta := make(map[string]string)
+
+	set := func(key, value string) {
+		if key = metaKey(key); key == "" {
+			return
+		}
+		if len(value) > maxMetaValueLen {
+			value = value[:maxMetaValueLen]
+		}
+		meta[key] = value
+	}
+
+	for _, l := range task.Labels {
+		set(l.Key, l.Value)
+	}
+
+	for k, v := range attributes {
+		set(k, v)
+	}
+
+	for k, v := range discovery {
+		set(k, v)
+	}
+
+	// Keep room for the mesos-* keys, the --instance-tags ones and
+	// the registry's
+	if room := maxMetaPairs - 7 - instanceMetaPairs - registryMetaPairs; len(meta) > room {
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		hclog.L().Warn("Too many metadata pairs. Dropping some", append(task.logFields(), "dropped", len(keys)-room)...)
+		for _, k := range keys[room:] {
+			delete(meta, k)
+		}
+	}
+
+	set(frameworkMeta, framework)
+	set(taskIDMeta, task.Id)
+	if launch := taskLaunch(task); launch != "" {
+		set(launchMeta, launch)
+	}
+	if path := task.label(tokenPathLabel); path != "" {
+		if filepath.IsLocal(path) {
+			set(registry.TokenPathMeta, path)
+		} else {
+			hclog.L().Warn("Ignoring invalid label", append(task.logFields(), "label", tokenPathLabel, "value", path)...)
+		}
+	}
+	set("mesos-agent-id", task.FollowerId)
+	if task.ExecutorId != "" {
+		set("mesos-executor-id", task.ExecutorId)
+	}
+	if task.Container != nil && task.Container.Docker != nil && task.Container.Docker.Image != "" {
+		set("mesos-image", task.Container.Docker.Image)
+	}
+
+	return meta
+}
+
+// Identify the launch of a task: its container ID, new on every launch,
+// or the time of its first status when Mesos reports no container
+func taskLaunch(task *Task) string {
+	if id := containerID(task); id != "" {
+		return id
+	}
+
+	for _, status := range task.Statuses {
+		if status.Timestamp > 0 {
+			sec := int64(status.Timestamp)
+			nsec := int64((status.Timestamp - float64(sec)) * 1e9)
+			return time.Unix(sec, nsec).UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	return ""
+}
+
+// Tell whether s is the service of another launch of the task of the
+// cached service, e.g. a task its framework relaunched under the same ID
+func relaunched(cached, s *consulapi.AgentServiceRegistration) bool {
+	launch, ok := cached.Meta[launchMeta]
+	return ok && s.Meta[launchMeta] != "" && launch != s.Meta[launchMeta]
+}
@@ -0,0 +1,113 @@
+package mesos
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestTaskMeta(t *testing.T) {
+	task := &Task{
+		Id:         "web.1",
+		FollowerId: "agent-1",
+		ExecutorId: "web.1",
+		Labels: []Label{
+			{Key: "team", Value: "shop"},
+			{Key: "app.version", Value: "1.2"},
+			{Key: "consul-port", Value: "8080"},
+			{Key: "mesos-task-id", Value: "spoofed"},
+		},
+		Container: &ContainerInfo{Docker: &DockerInfo{Image: "nginx:1.9"}},
+		Statuses:  []Status{{State: "TASK_RUNNING", Timestamp: 1000}},
+	}
+
+	meta := taskMeta("marathon", task, nil, map[string]string{"environment": "prod", "team": "store"})
+
+	want := map[string]string{
+		"team":              "store",
+		"app_version":       "1.2",
+		"environment":       "prod",
+		"mesos-framework":   "marathon",
+		"mesos-task-id":     "web.1",
+		"mesos-agent-id":    "agent-1",
+		"mesos-executor-id": "web.1",
+		"mesos-image":       "nginx:1.9",
+		"mesos-task-launch": "1970-01-01T00:16:40Z",
+	}
+	if len(meta) != len(want) {
+		t.Errorf("expected %v, got %v", want, meta)
+	}
+	for k, v := range want {
+		if meta[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, meta[k])
+		}
+	}
+
+	// Token paths must stay under --registry-token-dir
+	task.Labels = []Label{{Key: tokenPathLabel, Value: "shop"}}
+	if meta = taskMeta("marathon", task, nil, nil); meta[registry.TokenPathMeta] != "shop" {
+		t.Errorf("expected the token path, got %v", meta)
+	}
+	task.Labels = []Label{{Key: tokenPathLabel, Value: "/etc/shadow"}}
+	if meta = taskMeta("marathon", task, nil, nil); meta[registry.TokenPathMeta] != "" {
+		t.Errorf("expected the absolute token path to be ignored, got %v", meta)
+	}
+
+	// Labels beyond the Consul limit are dropped, not the task's keys,
+	// keeping room for the mesos-token-path the task has none of
+	task.Labels = nil
+	for i := 0; i < 100; i++ {
+		task.Labels = append(task.Labels, Label{Key: fmt.Sprintf("l%03d", i), Value: "v"})
+	}
+	meta = taskMeta("marathon", task, nil, nil)
+	if len(meta) != maxMetaPairs-registryMetaPairs-instanceMetaPairs-1 || meta["mesos-task-id"] != "web.1" || meta["l000"] != "v" || meta["l099"] != "" {
+		t.Errorf("unexpected truncated meta: %d pairs", len(meta))
+	}
+}
+
+func TestRelaunched(t *testing.T) {
+	task := &Task{Id: "web.1", Statuses: []Status{{State: "TASK_RUNNING", Timestamp: 1000}}}
+	cached := &consulapi.AgentServiceRegistration{Meta: taskMeta("marathon", task, nil, nil)}
+
+	if relaunched(cached, &consulapi.AgentServiceRegistration{Meta: taskMeta("marathon", task, nil, nil)}) {
+		t.Error("expected the same launch not to be a relaunch")
+	}
+
+	// A container ID tells the launches apart
+	task.Statuses = append(task.Statuses, Status{ContainerStatus: ContainerStatus{ContainerID: ContainerID{Value: "c-2"}}})
+	s := &consulapi.AgentServiceRegistration{Meta: taskMeta("marathon", task, nil, nil)}
+	if s.Meta[launchMeta] != "c-2" || !relaunched(cached, s) {
+		t.Errorf("expected a relaunch, got launch %q", s.Meta[launchMeta])
+	}
+
+	// Services cached before launches were tracked are not relaunched
+	delete(cached.Meta, launchMeta)
+	if relaunched(cached, s) {
+		t.Error("expected a service without a cached launch not to be a relaunch")
+	}
+}
+
+func TestFollowerAttributes(t *testing.T) {
+	f := &follower{Attributes: map[string]interface{}{"rack": "r1", "zone": "us-east-1a", "cpus_type": 2.0}}
+
+	attrs := f.attributes([]string{"zone", "rack", "instance_type"})
+	if len(attrs) != 2 || attrs["rack"] != "r1" || attrs["zone"] != "us-east-1a" {
+		t.Errorf("expected the rack and zone attributes, got %v", attrs)
+	}
+
+	tags := attributeTags(attrs)
+	if fmt.Sprint(tags) != "[rack=r1 zone=us-east-1a]" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	meta := taskMeta("marathon", &Task{Id: "web.1"}, attrs, map[string]string{"zone": "override"})
+	if meta["rack"] != "r1" || meta["zone"] != "override" {
+		t.Errorf("expected attributes below DiscoveryInfo in the metadata, got %v", meta)
+	}
+
+	if f.attributes(nil) != nil {
+		t.Error("expected no attributes without --follower-attributes")
+	}
+}
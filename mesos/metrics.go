@@ -0,0 +1,136 @@
+package mesos
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/metrics"
+)
+
+// MetricsHandler serves the totals of mesos-consul on /metrics in the
+// Prometheus text format
+func (m *Mesos) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		last := ""
+		for _, s := range m.Metrics() {
+			if s.Name != last {
+				writeHeader(w, s.Name, s.Kind, s.Help)
+				last = s.Name
+			}
+			writeSample(w, s)
+		}
+	})
+
+	return mux
+}
+
+// Metrics returns the current samples of the metrics of MetricsHandler,
+// e.g. for a metrics.Sink
+func (m *Mesos) Metrics() []metrics.Sample {
+	cached := m.cachedCount()
+	flaps, flapping, held := m.flapStats()
+
+	m.health.Lock()
+	defer m.health.Unlock()
+
+	var samples []metrics.Sample
+	add := func(name string, kind string, help string, value float64) {
+		samples = append(samples, metrics.Sample{Name: name, Kind: kind, Help: help, Value: value})
+	}
+	labelled := func(name string, kind string, help string, label string, value string, sample float64) {
+		samples = append(samples, metrics.Sample{Name: name, Kind: kind, Help: help, Labels: map[string]string{label: value}, Value: sample})
+	}
+
+	add("mesos_consul_syncs_total", metrics.Counter, "Syncs of the Mesos state.", float64(m.health.syncs))
+	add("mesos_consul_sync_duration_seconds", metrics.Gauge, "Duration of the last sync.", m.health.syncDuration.Seconds())
+	add("mesos_consul_registrations_total", metrics.Counter, "Services registered.", float64(m.health.registrations))
+	add("mesos_consul_deregistrations_total", metrics.Counter, "Services deregistered.", float64(m.health.deregistrations))
+	add("mesos_consul_mesos_errors_total", metrics.Counter, "Syncs that failed to reach the Mesos masters.", float64(m.health.mesosErrorsTotal))
+	add("mesos_consul_consul_errors_total", metrics.Counter, "Failed Consul calls.", float64(m.health.consulErrorsTotal))
+	add("mesos_consul_drift_repairs_total", metrics.Counter, "Services reconciliation found missing from Consul or the cache.", float64(m.health.driftTotal))
+	add("mesos_consul_invalid_registrations_total", metrics.Counter, "Registrations refused as Consul would reject them.", float64(m.health.invalidTotal))
+	add("mesos_consul_pending_registrations", metrics.Gauge, "Failed registrations waiting for a retry.", float64(m.health.pendingRetries))
+	add("mesos_consul_persistent_registration_failures", metrics.Gauge, "Registrations still failing after --refresh.", float64(m.health.persistentFailures))
+	age := 0.0
+	if !m.health.stateFetched.IsZero() {
+		age = time.Since(m.health.stateFetched).Seconds()
+	}
+	frozen := 0.0
+	if m.health.frozen {
+		frozen = 1
+	}
+	add("mesos_consul_state_age_seconds", metrics.Gauge, "Age of the Mesos state the last sync used.", age)
+	add("mesos_consul_state_frozen", metrics.Gauge, "1 when the last sync kept the last good state as reading the state failed.", frozen)
+	add("mesos_consul_cache_entries", metrics.Gauge, "Services in the cache.", float64(cached))
+	add("mesos_consul_cache_max_entries", metrics.Gauge, "Services the cache holds at most, 0 for unlimited.", float64(m.currentConfig().MaxCacheEntries))
+	add("mesos_consul_cache_evictions_total", metrics.Counter, "Services evicted from the full cache.", float64(m.health.evictionsTotal))
+	add("mesos_consul_cache_drops_total", metrics.Counter, "New services not registered as the cache was full.", float64(m.health.dropsTotal))
+	add("mesos_consul_flaps_total", metrics.Counter, "Task services deregistered or relaunched soon after their registration.", float64(flaps))
+	add("mesos_consul_flapping_apps", metrics.Gauge, "Apps whose task services are tagged flapping.", float64(flapping))
+	add("mesos_consul_flap_held_registrations_total", metrics.Counter, "Registrations of flapping apps held off.", float64(held))
+	add("mesos_consul_plugin_failures_total", metrics.Counter, "Failed runs of the transform plugin.", float64(m.health.pluginFailures))
+	add("mesos_consul_plugin_vetoes_total", metrics.Counter, "Task services the transform plugin vetoed.", float64(m.health.pluginVetoes))
+
+	frameworks := make([]string, 0, len(m.health.lastFrameworks))
+	for framework := range m.health.lastFrameworks {
+		frameworks = append(frameworks, framework)
+	}
+	sort.Strings(frameworks)
+
+	for _, framework := range frameworks {
+		labelled("mesos_consul_framework_registrations", metrics.Gauge, "Task services of the framework registered by the last sync.", "framework", framework, float64(m.health.lastFrameworks[framework].registered))
+	}
+	for _, framework := range frameworks {
+		labelled("mesos_consul_framework_registration_errors", metrics.Gauge, "Task services of the framework the last sync failed to register.", "framework", framework, float64(m.health.lastFrameworks[framework].failed))
+	}
+
+	limits := make([]string, 0, len(m.health.limitViolations))
+	for limit := range m.health.limitViolations {
+		limits = append(limits, limit)
+	}
+	sort.Strings(limits)
+
+	for _, limit := range limits {
+		labelled("mesos_consul_limit_violations_total", metrics.Counter, "Registrations over Consul's limits on tags and meta, by limit.", "limit", limit, float64(m.health.limitViolations[limit]))
+	}
+
+	reasons := make([]string, 0, len(m.health.taskEnds))
+	for reason := range m.health.taskEnds {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		labelled("mesos_consul_task_deregistrations_total", metrics.Counter, "Task services deregistered, by the reason or state their task ended with.", "task_reason", reason, float64(m.health.taskEnds[reason]))
+	}
+
+	return samples
+}
+
+func writeHeader(w http.ResponseWriter, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Write a sample, with its labels in order
+func writeSample(w http.ResponseWriter, s metrics.Sample) {
+	if len(s.Labels) == 0 {
+		fmt.Fprintf(w, "%s %g\n", s.Name, s.Value)
+		return
+	}
+
+	labels := make([]string, 0, len(s.Labels))
+	for label, value := range s.Labels {
+		labels = append(labels, label+"="+strconv.Quote(value))
+	}
+	sort.Strings(labels)
+
+	fmt.Fprintf(w, "%s{%s} %g\n", s.Name, strings.Join(labels, ","), s.Value)
+}
@@ -0,0 +1,48 @@
+package mesos
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := &Mesos{
+		config:       config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{{"a", localDatacenter}: {}, {"b", localDatacenter}: {}},
+	}
+
+	m.health.begin()
+	m.health.consulResult(errors.New("permission denied"))
+	m.health.registered(false)
+	m.health.registered(false)
+	m.health.registered(true)
+	m.health.frameworkRegistration("marathon", nil)
+	m.health.frameworkRegistration("marathon", errors.New("invalid name"))
+	m.health.frameworkRegistration("chronos", nil)
+	m.health.end(nil, errors.New("no quorum"))
+
+	w := httptest.NewRecorder()
+	m.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		"mesos_consul_syncs_total 1\n",
+		"mesos_consul_registrations_total 2\n",
+		"mesos_consul_deregistrations_total 1\n",
+		"mesos_consul_mesos_errors_total 1\n",
+		"mesos_consul_consul_errors_total 1\n",
+		"mesos_consul_cache_entries 2\n",
+		"# TYPE mesos_consul_sync_duration_seconds gauge\n",
+		"mesos_consul_framework_registrations{framework=\"chronos\"} 1\n",
+		"mesos_consul_framework_registrations{framework=\"marathon\"} 1\n",
+		"mesos_consul_framework_registration_errors{framework=\"marathon\"} 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
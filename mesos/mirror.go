@@ -0,0 +1,65 @@
+package mesos
+
+import (
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// The tag of the task services mirrored into --mirror-datacenter
+const mirrorTag = "external-dc"
+
+// Whether the task service s is mirrored into --mirror-datacenter:
+// with neither --mirror-services nor --mirror-tags every one is,
+// otherwise those whose name matches the first or that carry one of
+// the second
+func (m *Mesos) mirrored(s *consulapi.AgentServiceRegistration) bool {
+	if m.config.MirrorDatacenter == "" {
+		return false
+	}
+	if m.mirrorServices == nil && len(m.config.MirrorTags) == 0 {
+		return true
+	}
+
+	if m.mirrorServices != nil && m.mirrorServices.MatchString(s.Name) {
+		return true
+	}
+	for _, tag := range m.config.MirrorTags {
+		if contains(s.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The name of the check of the mirrors, see mirrorService()
+const mirrorCheckName = "Mesos task health"
+
+// The registration of the task service s in the catalog of
+// --mirror-datacenter, tagged external-dc. No agent runs the checks
+// of the catalog, so the mirror carries a check of a fixed status
+// instead: that of the TTL check of s with --check-mode=ttl or mesos,
+// passing otherwise, as its task runs. A new status registers the
+// mirror again.
+func mirrorService(s *consulapi.AgentServiceRegistration) *consulapi.AgentServiceRegistration {
+	mirror := *s
+	mirror.Tags = append(append([]string(nil), s.Tags...), mirrorTag)
+
+	check := &consulapi.AgentServiceCheck{Name: mirrorCheckName, Status: consulapi.HealthPassing, Notes: "task running"}
+	if s.Check != nil && s.Check.TTL != "" {
+		check.Status, check.Notes = s.Check.Status, s.Check.Notes
+	}
+	mirror.Check = check
+	mirror.Checks = nil
+
+	return &mirror
+}
+
+// Register the mirror of the task service s. Called after registering
+// s, whose scope is set by then.
+func (m *Mesos) registerMirror(s *consulapi.AgentServiceRegistration) {
+	if !m.mirrored(s) {
+		return
+	}
+
+	m.registerAt(m.config.MirrorDatacenter, s.Address, mirrorService(s))
+}
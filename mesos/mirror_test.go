@@ -0,0 +1,121 @@
+package mesos
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A fakeRegistry recording the datacenter of the registrations
+type datacenterRegistry struct {
+	fakeRegistry
+	registeredIn   map[string][]string
+	deregisteredIn map[string][]string
+	mirrors        map[string]*consulapi.AgentServiceRegistration
+}
+
+func (r *datacenterRegistry) Register(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	dc := registry.Datacenter(ctx)
+	r.registeredIn[s.ID] = append(r.registeredIn[s.ID], dc)
+	if dc != "" {
+		r.mirrors[s.ID] = s
+	}
+	return nil
+}
+
+func (r *datacenterRegistry) Deregister(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	r.deregisteredIn[s.ID] = append(r.deregisteredIn[s.ID], registry.Datacenter(ctx))
+	return nil
+}
+
+func TestMirrorDatacenter(t *testing.T) {
+	r := &datacenterRegistry{
+		fakeRegistry:   *newFakeRegistry(),
+		registeredIn:   map[string][]string{},
+		deregisteredIn: map[string][]string{},
+		mirrors:        map[string]*consulapi.AgentServiceRegistration{},
+	}
+	c := config.DefaultConfig()
+	c.MirrorDatacenter = "dc2"
+	c.MirrorTags = []string{"public"}
+	m := &Mesos{Registry: r, ServiceCache: map[ServiceKey]*CacheEntry{}}
+	m.setConfig(c)
+
+	services := []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:web", Name: "web", Address: "10.0.0.1", Tags: []string{"public"}, Check: &consulapi.AgentServiceCheck{TCP: "10.0.0.1:80"}},
+		{ID: "mesos-consul:db", Name: "db", Address: "10.0.0.2"},
+	}
+	for _, s := range services {
+		m.registerAt(localDatacenter, "", s)
+		m.registerMirror(s)
+	}
+
+	if dcs := r.registeredIn["mesos-consul:web"]; len(dcs) != 2 || dcs[0] != "" || dcs[1] != "dc2" {
+		t.Errorf("expected web registered locally and in dc2, got %v", dcs)
+	}
+	if dcs := r.registeredIn["mesos-consul:db"]; len(dcs) != 1 || dcs[0] != "" {
+		t.Errorf("expected db only registered locally, got %v", dcs)
+	}
+	mirror := r.mirrors["mesos-consul:web"]
+	if mirror == nil || !contains(mirror.Tags, mirrorTag) || mirror.Check == nil || mirror.Check.TCP != "" || mirror.Check.Status != consulapi.HealthPassing {
+		t.Errorf("expected a mirror tagged %s with a passing check of a fixed status, got %+v", mirrorTag, mirror)
+	}
+	if contains(services[0].Tags, mirrorTag) {
+		t.Errorf("expected the local registration untagged, got %v", services[0].Tags)
+	}
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:web", "dc2"}]; !ok || len(m.ServiceCache) != 3 {
+		t.Errorf("expected the mirror cached under dc2, got %v", m.ServiceCache)
+	}
+
+	// The health of the TTL check is carried over, registering the
+	// mirror again
+	unhealthy := *services[0]
+	unhealthy.Check = &consulapi.AgentServiceCheck{TTL: "30s", Status: consulapi.HealthCritical}
+	for _, b := range m.ServiceCache {
+		b.isRegistered = false
+	}
+	m.registerAt(localDatacenter, "", &unhealthy)
+	m.registerMirror(&unhealthy)
+	if mirror := r.mirrors["mesos-consul:web"]; mirror.Check.Status != consulapi.HealthCritical || mirror.Check.TTL != "" {
+		t.Errorf("expected the mirror critical, got %+v", mirror.Check)
+	}
+	if dcs := r.registeredIn["mesos-consul:web"]; len(dcs) != 4 {
+		t.Errorf("expected web and its mirror registered again, got %v", dcs)
+	}
+
+	// The task is gone
+	m.deregister()
+	m.deregister()
+	if dcs := r.deregisteredIn["mesos-consul:web"]; len(dcs) != 2 || dcs[0] == dcs[1] {
+		t.Errorf("expected web deregistered locally and from dc2, got %v", dcs)
+	}
+}
+
+func TestMirrored(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{}
+	m.setConfig(c)
+	web := &consulapi.AgentServiceRegistration{Name: "web", Tags: []string{"public"}}
+	if m.mirrored(web) {
+		t.Error("expected no mirror without mirror-datacenter")
+	}
+
+	c.MirrorDatacenter = "dc2"
+	if !m.mirrored(web) {
+		t.Error("expected every service mirrored without filters")
+	}
+
+	c.MirrorServices = "^api-"
+	m.setConfig(c)
+	if m.mirrored(web) || !m.mirrored(&consulapi.AgentServiceRegistration{Name: "api-users"}) {
+		t.Error("expected only the services matching mirror-services mirrored")
+	}
+
+	c.MirrorTags = []string{"public"}
+	if !m.mirrored(web) {
+		t.Error("expected the services with a mirror-tags tag mirrored")
+	}
+}
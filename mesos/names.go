@@ -0,0 +1,216 @@
+package mesos
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Runs of characters that are not valid in a DNS label
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Instance numbers of the tasks of stateful frameworks, e.g. the 3 of
+// broker-3 or the 0 of node-0-server
+var instanceNumber = regexp.MustCompile(`-[0-9]+(-|$)`)
+
+// A naming strategy turns the task names of a framework into the names
+// of their services, before normalizeName()
+type namingStrategy func(framework string, name string) string
+
+var namingStrategies = map[string]namingStrategy{
+	// Marathon names the tasks of app /team-a/backend/web
+	// web.backend.team-a, take app IDs to the same form
+	config.NamingMarathon: func(framework string, name string) string {
+		if !strings.HasPrefix(name, "/") {
+			return name
+		}
+
+		segments := strings.Split(strings.Trim(name, "/"), "/")
+		for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+			segments[i], segments[j] = segments[j], segments[i]
+		}
+		return strings.Join(segments, ".")
+	},
+
+	// Chronos names its tasks ChronosTask:<job>, and older releases
+	// ct:<due>:<attempt>:<job>
+	config.NamingChronos: func(framework string, name string) string {
+		if strings.HasPrefix(name, "ChronosTask:") {
+			return strings.TrimPrefix(name, "ChronosTask:")
+		}
+
+		if fields := strings.Split(name, ":"); len(fields) >= 4 && fields[0] == "ct" {
+			return fields[3]
+		}
+		return name
+	},
+
+	// Brokers are named broker-<id> and nodes node-<n>[-<role>]: all
+	// the instances share a service named after the framework
+	config.NamingCassandra: instanceName,
+	config.NamingKafka:     instanceName,
+
+	config.NamingGeneric: func(framework string, name string) string {
+		return name
+	},
+}
+
+// Drop the instance numbers of a task name, prefixed with the name of
+// its framework, e.g. broker-3 of kafka is kafka-broker
+func instanceName(framework string, name string) string {
+	name = instanceNumber.ReplaceAllString(name, "$1")
+	name = strings.TrimSuffix(name, "-")
+
+	if framework == "" || strings.HasPrefix(name, framework) {
+		return name
+	}
+	return framework + "-" + name
+}
+
+// The naming strategy of a framework's tasks: the one --naming-strategy
+// maps the framework to, else the built-in one named after the
+// framework, else the generic one keeping the task names
+func (m *Mesos) namingStrategy(framework string) namingStrategy {
+	if s, ok := namingStrategies[m.config.NamingStrategies[framework]]; ok {
+		return s
+	}
+	if s, ok := namingStrategies[framework]; ok {
+		return s
+	}
+	return namingStrategies[config.NamingGeneric]
+}
+
+// Normalize a name into a service name. With --strip-marathon-groups
+// the groups of a Marathon task, web.backend.team-a for app
+// /team-a/backend/web, are dropped. With --service-name-separator the
+// characters invalid in DNS are replaced with the separator, otherwise
+// they are cleaned as cleanName() always has. With --dns-srv the name
+// is a DNS label whatever the separator, - by default.
+func (m *Mesos) normalizeName(framework string, name string) string {
+	if m.config.StripMarathonGroups && framework == "marathon" {
+		name = strings.SplitN(name, ".", 2)[0]
+	}
+
+	sep := m.config.ServiceNameSeparator
+	if sep == "" && m.config.DNSSRV {
+		sep = "-"
+	}
+	if sep == "" {
+		return cleanName(name)
+	}
+
+	s := invalidNameChars.ReplaceAllString(strings.ToLower(name), sep)
+	s = strings.Trim(s, sep)
+	if m.config.DNSSRV {
+		s = dnsName(s)
+	}
+	return s
+}
+
+// The name a task's services are registered under before resolving
+// collisions: with --blue-green, the deployment group of the task,
+// else its DiscoveryInfo name, else its task name as named by the
+// framework's naming strategy
+func (m *Mesos) taskServiceName(framework string, task *Task) string {
+	if group := m.deploymentGroup(task); group != "" {
+		if name := m.normalizeName(framework, group); name != "" {
+			return name
+		}
+	}
+
+	if d := task.Discovery; d != nil {
+		if name := m.normalizeName(framework, d.Name); name != "" {
+			return name
+		}
+	}
+
+	return m.normalizeName(framework, m.namingStrategy(framework)(framework, task.Name))
+}
+
+// Name the services of every task in the state. Tasks of
+// different apps whose names normalize to the same service name, e.g.
+// Web and web, or web.prod and web.dev with --strip-marathon-groups,
+// are handled per --name-collision-policy: merged under the shared
+// name, or, but for the first app in name order, suffixed with their
+// app index, e.g. web, web-2 and web-3. The colliding apps are
+// returned by name.
+func (m *Mesos) serviceNames(sj StateJSON) (map[*Task]string, map[string][]string) {
+	names := make(map[*Task]string)
+	apps := make(map[string][]string)
+	appOf := make(map[*Task]string)
+
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			name := m.taskServiceName(fw.Name, task)
+
+			app := fw.Name + "/" + task.Name
+			if d := task.Discovery; d != nil && d.Name != "" {
+				app = fw.Name + "/" + d.Name
+			}
+			// Both colours of a deployment are one app
+			if group := m.deploymentGroup(task); group != "" {
+				app = fw.Name + "/" + group
+			}
+
+			names[task] = name
+			appOf[task] = app
+			if !contains(apps[name], app) {
+				apps[name] = append(apps[name], app)
+			}
+		}
+	}
+
+	collisions := make(map[string][]string)
+	for name, claims := range apps {
+		if len(claims) > 1 {
+			sort.Strings(claims)
+			collisions[name] = claims
+		}
+	}
+
+	if m.config.NameCollisionPolicy != config.NameCollisionSuffix {
+		return names, collisions
+	}
+
+	for task, name := range names {
+		claims := collisions[name]
+		for i, app := range claims {
+			if app == appOf[task] && i > 0 {
+				names[task] = fmt.Sprintf("%s-%d", name, i+1)
+			}
+		}
+	}
+
+	return names, collisions
+}
+
+func (m *Mesos) logNameCollisions(collisions map[string][]string) {
+	for name, apps := range collisions {
+		hclog.L().Warn("Service name collision", "service", name, "apps", apps, "policy", m.config.NameCollisionPolicy)
+	}
+}
+
+// Keep the services of tasks merged under one name apart, appending
+// the Mesos task ID to an ID another task's service already took
+func uniqueID(taken map[string]string, id string, task *Task) string {
+	if _, ok := taken[id]; !ok {
+		return id
+	}
+
+	return id + ":" + task.Id
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
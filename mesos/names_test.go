@@ -0,0 +1,111 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		separator string
+		strip     bool
+		framework string
+		name      string
+		want      string
+	}{
+		{"", false, "marathon", "Web_App.prod", "webapp.prod"},
+		{"-", false, "marathon", "Web_App.prod", "web-app-prod"},
+		{"-", false, "marathon", "/web//api/", "web-api"},
+		{"", true, "marathon", "web.backend.team-a", "web"},
+		{"", true, "aurora", "web.backend.team-a", "web.backend.team-a"},
+		{"_", true, "marathon", "My App.team", "my_app"},
+	}
+
+	for _, tt := range tests {
+		c := config.DefaultConfig()
+		c.ServiceNameSeparator = tt.separator
+		c.StripMarathonGroups = tt.strip
+		m := &Mesos{config: c}
+
+		if got := m.normalizeName(tt.framework, tt.name); got != tt.want {
+			t.Errorf("normalizeName(%q, %q) with %q, %v = %q; want %q", tt.framework, tt.name, tt.separator, tt.strip, got, tt.want)
+		}
+	}
+}
+
+func TestServiceNamesCollisions(t *testing.T) {
+	sj := StateJSON{
+		Frameworks: Frameworks{
+			{Name: "marathon", Tasks: Tasks{
+				{Id: "1", Name: "web.prod"},
+				{Id: "2", Name: "web.dev"},
+				{Id: "3", Name: "web.dev"},
+				{Id: "4", Name: "db.prod"},
+			}},
+		},
+	}
+	tasks := sj.Frameworks[0].Tasks
+
+	c := config.DefaultConfig()
+	c.StripMarathonGroups = true
+	m := &Mesos{config: c}
+
+	names, collisions := m.serviceNames(sj)
+	for i := range tasks {
+		if want := []string{"web", "web", "web", "db"}[i]; names[&tasks[i]] != want {
+			t.Errorf("merge: task %s named %q, want %q", tasks[i].Id, names[&tasks[i]], want)
+		}
+	}
+
+	if apps := collisions["web"]; len(collisions) != 1 || !reflect.DeepEqual(apps, []string{"marathon/web.dev", "marathon/web.prod"}) {
+		t.Errorf("expected web.dev and web.prod to collide, got %v", collisions)
+	}
+
+	c.NameCollisionPolicy = config.NameCollisionSuffix
+	names, _ = m.serviceNames(sj)
+	for i := range tasks {
+		if want := []string{"web-2", "web", "web", "db"}[i]; names[&tasks[i]] != want {
+			t.Errorf("suffix: task %s named %q, want %q", tasks[i].Id, names[&tasks[i]], want)
+		}
+	}
+}
+
+func TestUniqueID(t *testing.T) {
+	taken := map[string]string{"mesos-consul:host-web": ""}
+
+	if id := uniqueID(taken, "mesos-consul:host-db", &Task{Id: "db.1"}); id != "mesos-consul:host-db" {
+		t.Errorf("expected a free ID to be kept, got %s", id)
+	}
+	if id := uniqueID(taken, "mesos-consul:host-web", &Task{Id: "web.2"}); id != "mesos-consul:host-web:web.2" {
+		t.Errorf("expected the task ID appended to a taken ID, got %s", id)
+	}
+}
+
+func TestNamingStrategies(t *testing.T) {
+	c := config.DefaultConfig()
+	c.NamingStrategies = map[string]string{"brokers": config.NamingKafka}
+	m := &Mesos{config: c}
+
+	tests := []struct {
+		framework string
+		name      string
+		want      string
+	}{
+		{"marathon", "/team-a/backend/web", "web.backend.team-a"},
+		{"marathon", "web.backend.team-a", "web.backend.team-a"},
+		{"chronos", "ChronosTask:nightly-report", "nightly-report"},
+		{"chronos", "ct:1453830000000:0:nightly-report:", "nightly-report"},
+		{"cassandra", "node-0-server", "cassandra-node-server"},
+		{"kafka", "broker-3", "kafka-broker"},
+		{"brokers", "broker-12", "brokers-broker"},
+		{"aurora", "ct:1:0:job:", "ct10job"},
+	}
+
+	for _, tt := range tests {
+		if got := m.taskServiceName(tt.framework, &Task{Name: tt.name}); got != tt.want {
+			t.Errorf("%s task %q named %q, want %q", tt.framework, tt.name, got, tt.want)
+		}
+	}
+}
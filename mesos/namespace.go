@@ -0,0 +1,90 @@
+package mesos
+
+import (
+	"context"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Labels placing the services of a task in a Consul Enterprise
+// namespace or admin partition instead of --consul-namespace and
+// --consul-partition
+const (
+	namespaceLabel = "consul-namespace"
+	partitionLabel = "consul-partition"
+)
+
+// Map the Marathon group of a task to a Consul namespace, unless the
+// consul-namespace label names one.
+//
+// Marathon names the tasks of app /team-a/backend/web as
+// web.backend.team-a, so the groups are the name's segments in
+// reverse minus the app itself. The first --namespace-from-group
+// groups are joined with '-' to form the namespace. Tasks without a
+// group, and tasks of other frameworks, stay in the --consul-namespace.
+func (m *Mesos) taskNamespace(framework string, task *Task) string {
+	if ns := task.label(namespaceLabel); ns != "" {
+		return ns
+	}
+
+	depth := m.config.NamespaceDepth
+	if depth <= 0 || framework != "marathon" {
+		return ""
+	}
+
+	segments := strings.Split(task.Name, ".")
+
+	var groups []string
+	for i := len(segments) - 1; i > 0; i-- {
+		groups = append(groups, segments[i])
+	}
+
+	if len(groups) > depth {
+		groups = groups[:depth]
+	}
+
+	return cleanName(strings.Join(groups, "-"))
+}
+
+// Place s in the --consul-namespace and --consul-partition unless its
+// task picked others
+func (m *Mesos) scope(s *consulapi.AgentServiceRegistration) {
+	if s.Namespace == "" {
+		s.Namespace = m.config.ConsulNamespace
+	}
+	if s.Partition == "" {
+		s.Partition = m.config.ConsulPartition
+	}
+}
+
+// Whether two registrations of a service are in the same namespace
+// and partition. Consul reports the unnamed ones as "default".
+func sameScope(a, b *consulapi.AgentServiceRegistration) bool {
+	return orDefault(a.Namespace) == orDefault(b.Namespace) && orDefault(a.Partition) == orDefault(b.Partition)
+}
+
+// ctx also listing the services of the namespaces and partitions of
+// the cached ones, see registry.InScopes(). Called with the cache
+// held.
+func (m *Mesos) inScopes(ctx context.Context) context.Context {
+	seen := make(map[registry.Scope]bool)
+	var scopes []registry.Scope
+	for _, b := range m.ServiceCache {
+		scope := registry.Scope{Namespace: orDefault(b.service.Namespace), Partition: orDefault(b.service.Partition)}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return registry.InScopes(ctx, scopes)
+}
+
+func orDefault(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
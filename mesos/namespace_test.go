@@ -0,0 +1,77 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestTaskNamespace(t *testing.T) {
+	tests := []struct {
+		framework string
+		name      string
+		depth     int
+		namespace string
+	}{
+		{"marathon", "web.backend.team-a", 1, "team-a"},
+		{"marathon", "web.backend.team-a", 2, "team-a-backend"},
+		{"marathon", "web.backend.team-a", 5, "team-a-backend"},
+		{"marathon", "web", 1, ""},
+		{"marathon", "web.backend.team-a", 0, ""},
+		{"chronos", "web.backend.team-a", 1, ""},
+	}
+
+	for _, tt := range tests {
+		c := config.DefaultConfig()
+		c.NamespaceDepth = tt.depth
+
+		m := &Mesos{config: c}
+		if ns := m.taskNamespace(tt.framework, &Task{Name: tt.name}); ns != tt.namespace {
+			t.Errorf("taskNamespace(%s, %s, %d) = %q, want %q", tt.framework, tt.name, tt.depth, ns, tt.namespace)
+		}
+	}
+
+	m := &Mesos{config: config.DefaultConfig()}
+	task := &Task{Name: "web.backend.team-a", Labels: []Label{{Key: namespaceLabel, Value: "payments"}}}
+	if ns := m.taskNamespace("marathon", task); ns != "payments" {
+		t.Errorf("expected the label to name the namespace, got %q", ns)
+	}
+}
+
+func TestRegisterAtScope(t *testing.T) {
+	r := newFakeRegistry()
+	c := config.DefaultConfig()
+	c.ConsulNamespace = "mesos"
+	c.ConsulPartition = "east"
+	key := ServiceKey{"mesos-consul:a", localDatacenter}
+
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			key: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web"}, agent: "10.0.0.1"},
+		},
+	}
+
+	s := &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web"}
+	m.registerAt(localDatacenter, "10.0.0.1", s)
+	if s.Namespace != "mesos" || s.Partition != "east" {
+		t.Errorf("expected the configured namespace and partition, got %q and %q", s.Namespace, s.Partition)
+	}
+	if _, ok := r.deregistered["mesos-consul:a"]; !ok || r.registered["mesos-consul:a"] != "10.0.0.1" {
+		t.Errorf("expected the service to move namespaces, got %v and %v", r.deregistered, r.registered)
+	}
+
+	r = newFakeRegistry()
+	m.Registry = r
+	s = &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web", Namespace: "payments"}
+	m.registerAt(localDatacenter, "10.0.0.1", s)
+	if s.Namespace != "payments" || len(r.deregistered) != 1 {
+		t.Errorf("expected the task's namespace to win, got %q and %v", s.Namespace, r.deregistered)
+	}
+
+	if !sameScope(&consulapi.AgentServiceRegistration{}, &consulapi.AgentServiceRegistration{Namespace: "default", Partition: "default"}) {
+		t.Error(`expected "default" to match an unnamed namespace and partition`)
+	}
+}
@@ -0,0 +1,138 @@
+package mesos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The longest the state fetches back off from an overloaded leader, in
+// refreshes
+const maxStateBackoff = 16
+
+var errNoReplica = errors.New("no --mesos-state-replica answered")
+
+// Load the state from the first --mesos-state-replica that answers
+// with a state no older than --mesos-state-max-age
+func (m *Mesos) loadFromReplicas() (StateJSON, error) {
+	if len(m.config.MesosStateReplicas) == 0 {
+		return StateJSON{}, errNoReplica
+	}
+
+	for _, url := range m.config.MesosStateReplicas {
+		sj, err := m.loadFromReplica(url)
+		if err == nil {
+			return sj, nil
+		}
+		hclog.L().Warn("Unable to read the state replica", "url", url, "error", err)
+	}
+
+	return StateJSON{}, errNoReplica
+}
+
+// Load the state from a replica serving the /master/state JSON of the
+// leader, e.g. a caching proxy telling how old its copy is in the Age
+// header
+func (m *Mesos) loadFromReplica(url string) (StateJSON, error) {
+	ctx, cancel := m.mesosTimeout()
+	defer cancel()
+
+	var sj StateJSON
+	header, err := m.requestHeader(ctx, "GET", url, "", &sj)
+	if err != nil {
+		return StateJSON{}, err
+	}
+	if sj.Leader == "" {
+		return StateJSON{}, errors.New("no leader in the state")
+	}
+
+	if max := m.config.MesosStateMaxAge; max > 0 {
+		age, _ := strconv.Atoi(header.Get("Age"))
+		if time.Duration(age)*time.Second > max {
+			return StateJSON{}, errors.New("state " + header.Get("Age") + "s old")
+		}
+	}
+
+	return sj, nil
+}
+
+// Whether err tells that a master is under load: it answered 429 or
+// 503, or did not answer within --mesos-timeout
+func overloaded(err error) bool {
+	var s statusError
+	if errors.As(err, &s) {
+		return s.code == http.StatusTooManyRequests || s.code == http.StatusServiceUnavailable
+	}
+
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// Whether the state fetches are backing off from the leader and the
+// last good state is still young enough to be reused
+func (m *Mesos) backingOff() bool {
+	return time.Now().Before(m.stateBackoffUntil) && m.reusable()
+}
+
+// Whether the last good state may be synced instead of a new one,
+// being no older than --mesos-state-max-age
+func (m *Mesos) reusable() bool {
+	if m.stateFetched.IsZero() {
+		return false
+	}
+
+	return m.config.MesosStateMaxAge <= 0 || time.Since(m.stateFetched) < m.config.MesosStateMaxAge
+}
+
+// Back off from an overloaded leader, reusing the last good state for
+// a refresh, then twice as long every time the leader is still
+// overloaded, up to maxStateBackoff refreshes. Tells whether the last
+// state is reused, which it cannot be without --mesos-state-backoff,
+// on the first sync, with --once or past --mesos-state-max-age.
+func (m *Mesos) backOffState() bool {
+	if !m.config.MesosStateBackoff || m.config.Once || m.config.Refresh <= 0 || !m.reusable() {
+		return false
+	}
+
+	switch {
+	case m.stateBackoff == 0:
+		m.stateBackoff = m.config.Refresh
+	case m.stateBackoff < maxStateBackoff*m.config.Refresh:
+		m.stateBackoff *= 2
+	}
+	m.stateBackoffUntil = time.Now().Add(m.stateBackoff + m.stateJitter())
+
+	return true
+}
+
+// Wait a random part of --mesos-state-jitter before fetching the
+// state, so that the instances started together, e.g. one per
+// cluster, do not all fetch it at the same time
+func (m *Mesos) staggerState() {
+	wait := m.stateJitter()
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-m.syncCtx().Done():
+	}
+}
+
+func (m *Mesos) stateJitter() time.Duration {
+	if m.config.MesosStateJitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(m.config.MesosStateJitter)))
+}
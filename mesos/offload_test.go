@@ -0,0 +1,134 @@
+package mesos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+// A Client answering every state request with err
+type failingClient struct {
+	err   error
+	calls int
+}
+
+func (f *failingClient) State(ctx context.Context) (StateJSON, error) {
+	f.calls++
+	return StateJSON{}, f.err
+}
+
+func TestLoadFromReplicas(t *testing.T) {
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Age", "600")
+		fmt.Fprint(w, `{"leader": "master@10.0.0.1:5050"}`)
+	}))
+	defer stale.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	fresh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Age", "5")
+		fmt.Fprint(w, `{"leader": "master@10.0.0.2:5050"}`)
+	}))
+	defer fresh.Close()
+
+	c := config.DefaultConfig()
+	c.MesosStateMaxAge = time.Minute
+	c.MesosStateReplicas = []string{stale.URL, down.URL, fresh.URL}
+	m := &Mesos{config: c}
+
+	sj, err := m.loadFromReplicas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@10.0.0.2:5050" {
+		t.Errorf("expected the state of the fresh replica, got %s", sj.Leader)
+	}
+
+	c.MesosStateReplicas = []string{stale.URL, down.URL}
+	if _, err := m.loadFromReplicas(); err != errNoReplica {
+		t.Errorf("expected no replica to answer, got %v", err)
+	}
+}
+
+func TestFetchStateBacksOff(t *testing.T) {
+	c := config.DefaultConfig()
+	c.MesosRetries = 0
+	c.MesosStateBackoff = true
+	c.MesosStateMaxAge = time.Hour
+
+	client := &failingClient{err: statusError{"http://10.0.0.1:5050/master/state", "503 Service Unavailable", http.StatusServiceUnavailable}}
+	m := &Mesos{
+		config:       c,
+		client:       client,
+		lastState:    StateJSON{Leader: "master@10.0.0.1:5050"},
+		stateFetched: time.Now(),
+	}
+
+	sj, fresh, err := m.fetchState()
+	if err != nil || fresh || sj.Leader != "master@10.0.0.1:5050" {
+		t.Fatalf("expected the last state, got %v, %v, %v", sj.Leader, fresh, err)
+	}
+	if m.stateBackoff != c.Refresh {
+		t.Errorf("expected to back off for a refresh, got %s", m.stateBackoff)
+	}
+
+	if _, _, err := m.fetchState(); err != nil || client.calls != 1 {
+		t.Errorf("expected the leader to be left alone while backing off, got %d calls, %v", client.calls, err)
+	}
+
+	if m.backoffErr == nil {
+		t.Error("expected the error of the leader to be kept while backing off")
+	}
+
+	m.stateBackoffUntil = time.Now()
+	m.fetchState()
+	if m.stateBackoff != 2*c.Refresh {
+		t.Errorf("expected the backoff to double, got %s", m.stateBackoff)
+	}
+
+	m.stateBackoffUntil = time.Now()
+	m.stateFetched = time.Now().Add(-2 * time.Hour)
+	if _, _, err := m.fetchState(); err == nil {
+		t.Error("expected the state past mesos-state-max-age not to be reused")
+	}
+
+	client.err = fmt.Errorf("connection refused")
+	m.stateFetched = time.Now()
+	if _, _, err := m.fetchState(); err == nil {
+		t.Error("expected other errors not to back off")
+	}
+
+	c.MesosStateBackoff = false
+	client.err = statusError{"http://10.0.0.1:5050/master/state", "503 Service Unavailable", http.StatusServiceUnavailable}
+	if _, _, err := m.fetchState(); err == nil {
+		t.Error("expected no backoff without mesos-state-backoff")
+	}
+}
+
+func TestOverloaded(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{statusError{"u", "429 Too Many Requests", http.StatusTooManyRequests}, true},
+		{statusError{"u", "503 Service Unavailable", http.StatusServiceUnavailable}, true},
+		{statusError{"u", "500 Internal Server Error", http.StatusInternalServerError}, false},
+		{fmt.Errorf("get: %w", context.DeadlineExceeded), true},
+		{fmt.Errorf("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		if got := overloaded(tt.err); got != tt.want {
+			t.Errorf("overloaded(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
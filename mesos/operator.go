@@ -0,0 +1,352 @@
+package mesos
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+// The JSON of the v1 operator API calls the state is read with. Most
+// messages are the same protobufs /master/state renders, but IDs are
+// {"value": ...} objects, resources and attributes are lists, and the
+// slaves are agents.
+type v1ID struct {
+	Value string `json:"value"`
+}
+
+type v1Labels struct {
+	Labels []Label `json:"labels"`
+}
+
+type v1Scalar struct {
+	Value float64 `json:"value"`
+}
+
+type v1Range struct {
+	Begin uint64 `json:"begin"`
+	End   uint64 `json:"end"`
+}
+
+type v1Resource struct {
+	Name   string    `json:"name"`
+	Scalar *v1Scalar `json:"scalar"`
+	Ranges *struct {
+		Range []v1Range `json:"range"`
+	} `json:"ranges"`
+	AllocationInfo *struct {
+		Role string `json:"role"`
+	} `json:"allocation_info"`
+	Reservations []struct {
+		Role string `json:"role"`
+	} `json:"reservations"`
+}
+
+type v1Attribute struct {
+	Name   string    `json:"name"`
+	Scalar *v1Scalar `json:"scalar"`
+	Text   *struct {
+		Value string `json:"value"`
+	} `json:"text"`
+}
+
+type v1Status struct {
+	State           string          `json:"state"`
+	Timestamp       float64         `json:"timestamp"`
+	Healthy         *bool           `json:"healthy"`
+	Labels          v1Labels        `json:"labels"`
+	ContainerStatus ContainerStatus `json:"container_status"`
+	Reason          string          `json:"reason"`
+	Message         string          `json:"message"`
+}
+
+type v1Task struct {
+	Name        string         `json:"name"`
+	TaskID      v1ID           `json:"task_id"`
+	FrameworkID v1ID           `json:"framework_id"`
+	AgentID     v1ID           `json:"agent_id"`
+	ExecutorID  v1ID           `json:"executor_id"`
+	State       string         `json:"state"`
+	Resources   []v1Resource   `json:"resources"`
+	Labels      v1Labels       `json:"labels"`
+	Statuses    []v1Status     `json:"statuses"`
+	Discovery   *DiscoveryInfo `json:"discovery"`
+	Container   *ContainerInfo `json:"container"`
+}
+
+type v1Agent struct {
+	AgentInfo struct {
+		ID         v1ID          `json:"id"`
+		Hostname   string        `json:"hostname"`
+		Port       int           `json:"port"`
+		Attributes []v1Attribute `json:"attributes"`
+	} `json:"agent_info"`
+	Pid            string       `json:"pid"`
+	TotalResources []v1Resource `json:"total_resources"`
+	Capabilities   []struct {
+		Type string `json:"type"`
+	} `json:"capabilities"`
+}
+
+type v1Framework struct {
+	FrameworkInfo struct {
+		ID       v1ID     `json:"id"`
+		Name     string   `json:"name"`
+		Role     string   `json:"role"`
+		Roles    []string `json:"roles"`
+		WebuiURL string   `json:"webui_url"`
+	} `json:"framework_info"`
+}
+
+// The answer to GET_STATE
+type v1State struct {
+	GetState struct {
+		GetTasks struct {
+			Tasks            []v1Task `json:"tasks"`
+			UnreachableTasks []v1Task `json:"unreachable_tasks"`
+			CompletedTasks   []v1Task `json:"completed_tasks"`
+		} `json:"get_tasks"`
+		GetAgents struct {
+			Agents []v1Agent `json:"agents"`
+		} `json:"get_agents"`
+		GetFrameworks struct {
+			Frameworks []v1Framework `json:"frameworks"`
+		} `json:"get_frameworks"`
+	} `json:"get_state"`
+}
+
+// The answer to GET_MASTER
+type v1Master struct {
+	GetMaster struct {
+		MasterInfo struct {
+			Pid     string `json:"pid"`
+			Address struct {
+				Hostname string `json:"hostname"`
+				IP       string `json:"ip"`
+				Port     int    `json:"port"`
+			} `json:"address"`
+		} `json:"master_info"`
+	} `json:"get_master"`
+}
+
+// The answer to GET_FLAGS
+type v1Flags struct {
+	GetFlags struct {
+		Flags []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"flags"`
+	} `json:"get_flags"`
+}
+
+// The value of the master flag name, empty when unset
+func (v v1Flags) flag(name string) string {
+	for _, f := range v.GetFlags.Flags {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+
+	return ""
+}
+
+// Read the state of the master at addr, given as host:port, with the
+// GET_MASTER, GET_STATE and GET_FLAGS calls of the v1 operator API, the
+// last naming the cluster as /master/state does
+func (m *Mesos) loadFromOperatorAPI(addr string) (StateJSON, error) {
+	url := m.mesosURL(addr, stateEndpoints[config.MesosStateV1])
+
+	var master v1Master
+	if err := m.requestJSON("POST", url, `{"type":"GET_MASTER"}`, &master); err != nil {
+		return StateJSON{}, err
+	}
+
+	var state v1State
+	if err := m.requestJSON("POST", url, `{"type":"GET_STATE"}`, &state); err != nil {
+		return StateJSON{}, err
+	}
+
+	var flags v1Flags
+	if err := m.requestJSON("POST", url, `{"type":"GET_FLAGS"}`, &flags); err != nil {
+		return StateJSON{}, err
+	}
+
+	leader, err := master.leader()
+	if err != nil {
+		return StateJSON{}, err
+	}
+
+	sj := state.toState()
+	sj.Leader = leader
+	sj.Cluster = flags.flag("cluster")
+
+	return sj, nil
+}
+
+// The PID of the master, as in the Leader of /master/state
+func (v v1Master) leader() (string, error) {
+	info := v.GetMaster.MasterInfo
+	if info.Pid != "" {
+		return info.Pid, nil
+	}
+
+	host := info.Address.IP
+	if host == "" {
+		host = info.Address.Hostname
+	}
+	if host == "" || info.Address.Port == 0 {
+		return "", errors.New("GET_MASTER: no master address")
+	}
+
+	return "master@" + hostPort(host, info.Address.Port), nil
+}
+
+// Convert the v1 state to the /master/state one
+func (v v1State) toState() StateJSON {
+	var sj StateJSON
+
+	for _, a := range v.GetState.GetAgents.Agents {
+		info := a.AgentInfo
+		pid := a.Pid
+		if pid == "" {
+			pid = "slave(1)@" + hostPort(info.Hostname, info.Port)
+		}
+
+		f := follower{
+			Id:       info.ID.Value,
+			Hostname: info.Hostname,
+			Pid:      pid,
+		}
+		if len(info.Attributes) > 0 {
+			f.Attributes = make(map[string]interface{}, len(info.Attributes))
+			for _, attr := range info.Attributes {
+				switch {
+				case attr.Text != nil:
+					f.Attributes[attr.Name] = attr.Text.Value
+				case attr.Scalar != nil:
+					f.Attributes[attr.Name] = attr.Scalar.Value
+				}
+			}
+		}
+
+		// The resources of every role add up
+		for _, r := range a.TotalResources {
+			if r.Scalar == nil {
+				continue
+			}
+			if f.Resources == nil {
+				f.Resources = make(map[string]interface{})
+			}
+			amount, _ := f.Resources[r.Name].(float64)
+			f.Resources[r.Name] = amount + r.Scalar.Value
+		}
+		for _, c := range a.Capabilities {
+			f.Capabilities = append(f.Capabilities, c.Type)
+		}
+
+		sj.Followers = append(sj.Followers, f)
+	}
+
+	tasks := make(map[string]Tasks)
+	for _, t := range v.GetState.GetTasks.Tasks {
+		tasks[t.FrameworkID.Value] = append(tasks[t.FrameworkID.Value], t.toTask())
+	}
+
+	unreachable := make(map[string]Tasks)
+	for _, t := range v.GetState.GetTasks.UnreachableTasks {
+		unreachable[t.FrameworkID.Value] = append(unreachable[t.FrameworkID.Value], t.toTask())
+	}
+
+	completed := make(map[string]Tasks)
+	for _, t := range v.GetState.GetTasks.CompletedTasks {
+		completed[t.FrameworkID.Value] = append(completed[t.FrameworkID.Value], t.toTask())
+	}
+
+	for _, fw := range v.GetState.GetFrameworks.Frameworks {
+		info := fw.FrameworkInfo
+		sj.Frameworks = append(sj.Frameworks, Frameworks{{
+			Tasks:            tasks[info.ID.Value],
+			UnreachableTasks: unreachable[info.ID.Value],
+			CompletedTasks:   completed[info.ID.Value],
+			Id:               info.ID.Value,
+			Name:             info.Name,
+			Role:             info.Role,
+			Roles:            info.Roles,
+			WebuiURL:         info.WebuiURL,
+		}}...)
+	}
+
+	return sj
+}
+
+func (t v1Task) toTask() Task {
+	task := Task{
+		FrameworkId: t.FrameworkID.Value,
+		Id:          t.TaskID.Value,
+		Name:        t.Name,
+		FollowerId:  t.AgentID.Value,
+		ExecutorId:  t.ExecutorID.Value,
+		State:       t.State,
+		Labels:      t.Labels.Labels,
+		Discovery:   t.Discovery,
+		Container:   t.Container,
+	}
+
+	for _, r := range t.Resources {
+		if task.Role == "" {
+			task.Role = resourceRole(r)
+		}
+
+		switch {
+		case r.Name == "cpus" && r.Scalar != nil:
+			task.Cpus += r.Scalar.Value
+		case r.Name == "mem" && r.Scalar != nil:
+			task.Mem += r.Scalar.Value
+		case r.Name == "ports" && r.Ranges != nil:
+			task.Ports = portRanges(r.Ranges.Range)
+		}
+	}
+
+	for _, s := range t.Statuses {
+		task.Statuses = append(task.Statuses, Status{
+			State:           s.State,
+			Timestamp:       s.Timestamp,
+			Healthy:         s.Healthy,
+			Labels:          s.Labels.Labels,
+			ContainerStatus: s.ContainerStatus,
+			Reason:          s.Reason,
+			Message:         s.Message,
+		})
+	}
+
+	return task
+}
+
+// The role a resource of a task is allocated to or, before allocation
+// roles, the role of its innermost reservation
+func resourceRole(r v1Resource) string {
+	if r.AllocationInfo != nil {
+		return r.AllocationInfo.Role
+	}
+	if n := len(r.Reservations); n > 0 {
+		return r.Reservations[n-1].Role
+	}
+
+	return ""
+}
+
+// Render port ranges the way /master/state does, e.g.
+// [31000-31000, 31005-31006]
+func portRanges(ranges []v1Range) string {
+	if len(ranges) == 0 {
+		return ""
+	}
+
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("%d-%d", r.Begin, r.End)
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
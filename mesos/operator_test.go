@@ -0,0 +1,109 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+const v1StateResponse = `{
+  "type": "GET_STATE",
+  "get_state": {
+    "get_tasks": {"tasks": [{
+      "name": "web",
+      "task_id": {"value": "web.1"},
+      "framework_id": {"value": "fw-1"},
+      "agent_id": {"value": "agent-1"},
+      "state": "TASK_RUNNING",
+      "resources": [
+        {"name": "cpus", "type": "SCALAR", "scalar": {"value": 0.5}, "allocation_info": {"role": "team-a"}},
+        {"name": "mem", "type": "SCALAR", "scalar": {"value": 128}},
+        {"name": "ports", "type": "RANGES", "ranges": {"range": [{"begin": 31000, "end": 31000}, {"begin": 31005, "end": 31006}]}}
+      ],
+      "labels": {"labels": [{"key": "env", "value": "prod"}]},
+      "statuses": [{"state": "TASK_RUNNING", "healthy": true, "container_status": {"network_infos": [{"ip_addresses": [{"ip_address": "172.17.0.2"}]}]}}]
+    }]},
+    "get_agents": {"agents": [{
+      "agent_info": {"id": {"value": "agent-1"}, "hostname": "10.0.0.2", "port": 5051, "attributes": [
+        {"name": "rack", "type": "TEXT", "text": {"value": "r1"}},
+        {"name": "cores", "type": "SCALAR", "scalar": {"value": 8}}
+      ]},
+      "pid": "slave(1)@10.0.0.2:5051"
+    }]},
+    "get_frameworks": {"frameworks": [{"framework_info": {"id": {"value": "fw-1"}, "name": "marathon", "webui_url": "http://10.0.0.1:8080"}}]}
+  }
+}`
+
+func TestV1StateToState(t *testing.T) {
+	var v v1State
+	if err := json.Unmarshal([]byte(v1StateResponse), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	sj := v.toState()
+
+	want := Followers{{Id: "agent-1", Hostname: "10.0.0.2", Pid: "slave(1)@10.0.0.2:5051", Attributes: map[string]interface{}{"rack": "r1", "cores": 8.0}}}
+	if !reflect.DeepEqual(sj.Followers, want) {
+		t.Errorf("expected followers %+v, got %+v", want, sj.Followers)
+	}
+
+	if len(sj.Frameworks) != 1 || sj.Frameworks[0].Name != "marathon" || len(sj.Frameworks[0].Tasks) != 1 {
+		t.Fatalf("expected the marathon framework and its task, got %+v", sj.Frameworks)
+	}
+
+	task := sj.Frameworks[0].Tasks[0]
+	if task.Id != "web.1" || task.FollowerId != "agent-1" || task.State != "TASK_RUNNING" || task.Role != "team-a" || task.label("env") != "prod" {
+		t.Errorf("unexpected task %+v", task)
+	}
+	if task.Cpus != 0.5 || task.Mem != 128 || task.Ports != "[31000-31000, 31005-31006]" {
+		t.Errorf("unexpected resources %+v", task.Resources)
+	}
+	if !reflect.DeepEqual(yankPorts(task.Ports), []int{31000, 31005, 31006}) {
+		t.Errorf("expected the ports to parse, got %v", yankPorts(task.Ports))
+	}
+	if healthy, ok := taskHealthy(&task); !healthy || !ok {
+		t.Error("expected the task to be healthy")
+	}
+}
+
+func TestLoadFromOperatorAPI(t *testing.T) {
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprint(w, `{"version":"1.4.0"}`)
+		case "/api/v1":
+			body, _ := ioutil.ReadAll(r.Body)
+			switch string(body) {
+			case `{"type":"GET_MASTER"}`:
+				fmt.Fprint(w, `{"type":"GET_MASTER","get_master":{"master_info":{"address":{"ip":"10.0.0.1","port":5050}}}}`)
+			case `{"type":"GET_STATE"}`:
+				fmt.Fprint(w, v1StateResponse)
+			case `{"type":"GET_FLAGS"}`:
+				fmt.Fprint(w, `{"type":"GET_FLAGS","get_flags":{"flags":[{"name":"authenticate_agents","value":"false"},{"name":"cluster","value":"prod"}]}}`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer master.Close()
+
+	host, port, _ := net.SplitHostPort(master.Listener.Addr().String())
+	m := &Mesos{config: config.DefaultConfig()}
+
+	sj, err := m.loadFromMaster(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@10.0.0.1:5050" || sj.Cluster != "prod" || len(sj.Followers) != 1 || len(sj.Frameworks) != 1 {
+		t.Errorf("expected the state of the operator API, got %+v", sj)
+	}
+}
@@ -0,0 +1,106 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The task labels configuring a check, the aliases of
+// checkLabelAliases included, which a check override replaces
+var checkLabels = []string{
+	dockerExecLabel,
+	scriptLabel,
+	noCheckLabel,
+	checkSkipLabel,
+	httpLabel,
+	expectBodyLabel,
+	tcpLabel,
+	grpcLabel,
+	grpcTLSLabel,
+	intervalLabel,
+	deregisterCriticalLabel,
+	checkPortLabel,
+	"check-http",
+	"check-tcp",
+	"check-interval",
+}
+
+// With --check-overrides, read the check overrides operators keep
+// under <kv-prefix>/overrides/<service name>/check, each a JSON object
+// of check labels, e.g. {"consul_check_http": "/healthz"}. A failed read
+// keeps the overrides of the last one, and invalid overrides are
+// logged and ignored.
+func (m *Mesos) loadCheckOverrides() {
+	if !m.config.CheckOverrides {
+		m.checkOverrides = nil
+		return
+	}
+
+	prefix := m.config.KVPrefix + "/overrides/"
+	values, _, err := m.Registry.List(m.syncCtx(), prefix, 0, 0)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to read the check overrides", "key", prefix, "error", err)
+		return
+	}
+
+	m.checkOverrides = make(map[string][]Label)
+	for key, value := range values {
+		name := strings.TrimPrefix(key, prefix)
+		if !strings.HasSuffix(name, "/check") {
+			continue
+		}
+		name = strings.TrimSuffix(name, "/check")
+
+		labels, err := parseCheckOverride(value)
+		if err != nil {
+			hclog.L().Warn("Ignoring invalid check override", "key", key, "error", err)
+			continue
+		}
+		m.checkOverrides[name] = labels
+	}
+}
+
+// The labels of a check override, in a stable order
+func parseCheckOverride(value []byte) ([]Label, error) {
+	var values map[string]string
+	if err := json.Unmarshal(value, &values); err != nil {
+		return nil, err
+	}
+
+	labels := make([]Label, 0, len(values))
+	for key, value := range values {
+		if !contains(checkLabels, key) {
+			return nil, fmt.Errorf("unknown check label %q", key)
+		}
+		labels = append(labels, Label{Key: key, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Key < labels[j].Key })
+
+	return labels, nil
+}
+
+// The task the check of its service name is built from: with a check
+// override for the name, a copy whose check labels are those of the
+// override alone. The task itself is left untouched.
+func (m *Mesos) withCheckOverride(name string, task *Task) *Task {
+	override, ok := m.checkOverrides[name]
+	if !ok {
+		return task
+	}
+
+	t := *task
+	t.Labels = nil
+	for _, l := range task.Labels {
+		if !contains(checkLabels, l.Key) {
+			t.Labels = append(t.Labels, l)
+		}
+	}
+	t.Labels = append(t.Labels, override...)
+
+	return &t
+}
@@ -0,0 +1,41 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestCheckOverrides(t *testing.T) {
+	r := &changesRegistry{keys: map[string][]byte{
+		"mesos-consul/overrides/web/check":   []byte(`{"check-http": "/healthz", "check-interval": "5s"}`),
+		"mesos-consul/overrides/batch/check": []byte(`{"no-check": "true"}`),
+		"mesos-consul/overrides/bad/check":   []byte(`{"check-htp": "/x"}`),
+		"mesos-consul/overrides/web/notes":   []byte(`ignored`),
+	}}
+
+	c := config.DefaultConfig()
+	c.CheckOverrides = true
+	m := &Mesos{Registry: r, config: c}
+	m.loadCheckOverrides()
+
+	if len(m.checkOverrides) != 2 {
+		t.Fatalf("expected the web and batch overrides, got %v", m.checkOverrides)
+	}
+
+	task := &Task{Labels: []Label{{Key: "check-tcp", Value: "true"}, {Key: "team", Value: "a"}}}
+	check := m.taskCheck("web", m.withCheckOverride("web", task), "10.0.0.1", 31000)
+	if check == nil || check.HTTP != "http://10.0.0.1:31000/healthz" || check.TCP != "" || check.Interval != "5s" {
+		t.Errorf("expected the overridden HTTP check, got %+v", check)
+	}
+	if len(task.Labels) != 2 {
+		t.Errorf("expected the task to be left untouched, got %v", task.Labels)
+	}
+
+	if check := m.taskCheck("batch", m.withCheckOverride("batch", task), "10.0.0.1", 31000); check != nil {
+		t.Errorf("expected no check, got %+v", check)
+	}
+	if check := m.taskCheck("db", m.withCheckOverride("db", task), "10.0.0.1", 31000); check == nil || check.TCP == "" {
+		t.Errorf("expected the check of the labels without an override, got %+v", check)
+	}
+}
@@ -0,0 +1,166 @@
+package mesos
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// The first wait before a failed registration is retried, doubling
+// with every attempt up to --refresh
+const pendingBackoff = 5 * time.Second
+
+// A registration that failed, waiting for its retry, see
+// retryPending()
+type pendingRegistration struct {
+	entry    *CacheEntry
+	attempts int
+
+	// When the registration first failed, and when it is retried
+	since time.Time
+	due   time.Time
+}
+
+// The wait before the retry of a registration that failed attempts
+// times in a row
+func (m *Mesos) pendingWait(attempts int) time.Duration {
+	wait := pendingBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if m.config.Refresh > 0 && wait >= m.config.Refresh {
+			return m.config.Refresh
+		}
+	}
+
+	return wait
+}
+
+// Queue the registrations that failed for a retry. A sync attempts
+// every service it registers, so afterwards only those of its
+// registrations that failed are pending. A retry round only attempted
+// the registrations of attempted, and the others stay pending. Called
+// with cacheLock held.
+func (m *Mesos) queuePending(failed map[ServiceKey]*CacheEntry, attempted map[ServiceKey]bool) {
+	now := time.Now()
+	pending := make(map[ServiceKey]*pendingRegistration)
+	if attempted != nil {
+		for key, p := range m.pending {
+			if !attempted[key] {
+				pending[key] = p
+			}
+		}
+	}
+
+	for key, entry := range failed {
+		p, ok := m.pending[key]
+		if !ok {
+			p = &pendingRegistration{since: now}
+		}
+		p.entry = entry
+		p.attempts++
+		p.due = now.Add(m.pendingWait(p.attempts))
+		pending[key] = p
+	}
+	m.pending = pending
+
+	// Registrations still failing after a full --refresh are unlikely
+	// to recover by themselves
+	persistent := 0
+	for _, p := range pending {
+		if now.Sub(p.since) >= m.config.Refresh {
+			persistent++
+		}
+	}
+	m.health.pendingRegistrations(len(pending), persistent)
+
+	m.schedulePending()
+}
+
+// Retry the pending registrations once the first of them is due,
+// rather than waiting for the next sync. Called with cacheLock held.
+func (m *Mesos) schedulePending() {
+	if m.pendingTimer != nil {
+		m.pendingTimer.Stop()
+		m.pendingTimer = nil
+	}
+	if len(m.pending) == 0 || m.config.Once {
+		return
+	}
+
+	var due time.Time
+	for _, p := range m.pending {
+		if due.IsZero() || p.due.Before(due) {
+			due = p.due
+		}
+	}
+	m.pendingTimer = time.AfterFunc(time.Until(due), m.retryPending)
+}
+
+// Retry the pending registrations that are due, between syncs. Those
+// failing again are queued with a longer wait.
+func (m *Mesos) retryPending() {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.cacheLock.Lock()
+	defer m.unlockCache()
+
+	m.beginSummary()
+	now := time.Now()
+	attempted := make(map[ServiceKey]bool)
+	for key, p := range m.pending {
+		if p.due.After(now) {
+			continue
+		}
+		attempted[key] = true
+
+		// Registered by a sync in the meantime
+		if _, ok := m.ServiceCache[key]; ok {
+			continue
+		}
+		m.retryRegistration(key, p)
+	}
+	m.flush()
+
+	m.failedLock.Lock()
+	errors := len(m.failed)
+	m.failedLock.Unlock()
+
+	m.dropFailed(attempted)
+	m.saveCache()
+	m.endSummary(nil, errors)
+}
+
+// Register the service of a pending registration again. Called with
+// cacheLock held.
+func (m *Mesos) retryRegistration(key ServiceKey, p *pendingRegistration) {
+	entry := p.entry
+	s := entry.service
+	if m.cacheFull(s.ID) {
+		return
+	}
+
+	hclog.L().Info("Retrying registration", "service_id", s.ID, "attempt", p.attempts+1)
+	entry.isRegistered = true
+	m.ServiceCache[key] = entry
+
+	reg := m.withExternalTags(s)
+	m.write(func(ctx context.Context) error {
+		err := m.applier().Register(ctx, Registration{key.Datacenter, entry.agent, reg})
+		if err != nil {
+			m.registrationFailed(key, entry)
+		}
+		return err
+	}, func() {
+		m.applied(eventRegister, reasonRetried, entry.agent, s)
+	})
+}
+
+// Stop retrying the pending registrations, e.g. when every service is
+// deregistered. Called with cacheLock held.
+func (m *Mesos) clearPending() {
+	m.pending = nil
+	m.schedulePending()
+	m.health.pendingRegistrations(0, 0)
+}
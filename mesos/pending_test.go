@@ -0,0 +1,67 @@
+package mesos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestRetryPending(t *testing.T) {
+	r := &rejectRegistry{fakeRegistry: *newFakeRegistry(), reject: map[string]bool{"web": true}}
+	c := config.DefaultConfig()
+	c.Once = true
+	m := &Mesos{Registry: r, config: c, ServiceCache: map[ServiceKey]*CacheEntry{}}
+	key := ServiceKey{"mesos-consul:web", localDatacenter}
+
+	m.registerAt(localDatacenter, "10.0.0.1", &consulapi.AgentServiceRegistration{ID: key.ID, Name: "web"})
+	m.forgetFailed()
+
+	p := m.pending[key]
+	if p == nil || p.attempts != 1 || len(m.ServiceCache) != 0 {
+		t.Fatalf("expected the failed registration to be pending, got %v %v", m.pending, m.ServiceCache)
+	}
+	if wait := p.due.Sub(p.since); wait < pendingBackoff-time.Second || wait > pendingBackoff {
+		t.Errorf("expected a retry after %s, got %s", pendingBackoff, wait)
+	}
+
+	// Still failing after --refresh
+	p.due = time.Now()
+	p.since = time.Now().Add(-2 * c.Refresh)
+	m.retryPending()
+	if p := m.pending[key]; p == nil || p.attempts != 2 || m.health.persistentFailures != 1 {
+		t.Fatalf("expected the retry to stay pending as a persistent failure, got %v %d", m.pending, m.health.persistentFailures)
+	}
+
+	// Not due yet
+	delete(r.reject, "web")
+	m.retryPending()
+	if len(r.registered) != 0 {
+		t.Fatalf("expected no retry before the backoff, got %v", r.registered)
+	}
+
+	m.pending[key].due = time.Now()
+	m.retryPending()
+	if r.registered[key.ID] != "10.0.0.1" || m.ServiceCache[key] == nil {
+		t.Errorf("expected the retry to register the service, got %v %v", r.registered, m.ServiceCache)
+	}
+	if len(m.pending) != 0 || m.health.pendingRetries != 0 || m.health.persistentFailures != 0 {
+		t.Errorf("expected nothing pending, got %v %d", m.pending, m.health.pendingRetries)
+	}
+}
+
+func TestPendingWait(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig()}
+
+	for attempts, want := range map[int]time.Duration{
+		1:  pendingBackoff,
+		2:  2 * pendingBackoff,
+		3:  4 * pendingBackoff,
+		10: time.Minute,
+	} {
+		if got := m.pendingWait(attempts); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package mesos
+
+import (
+	"context"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A sync runs in stages: a Fetcher reads the Mesos state, the task
+// services of the state are built from it and passed through the
+// Transformers, a Differ compares every one with its cached
+// registration, and an Applier makes the registrations and
+// deregistrations that takes. Each stage can be replaced, see
+// SetStages, and tested on its own.
+
+// A Fetcher reads the Mesos state, see Client
+type Fetcher = Client
+
+// A Registration of a service with the Consul agent Agent, empty for
+// the --consul-addr one, in Datacenter, empty for the local one
+type Registration struct {
+	Datacenter string
+	Agent      string
+	Service    *consulapi.AgentServiceRegistration
+}
+
+// A Transformer rewrites the task services a sync registers, e.g.
+// renaming, dropping or readdressing them. Services keep their IDs,
+// and are registered in the local datacenter.
+type Transformer interface {
+	Transform(services []Registration) []Registration
+}
+
+// A TransformerFunc is a Transformer calling itself
+type TransformerFunc func(services []Registration) []Registration
+
+func (f TransformerFunc) Transform(services []Registration) []Registration {
+	return f(services)
+}
+
+// Chain returns a Transformer running transformers in order
+func Chain(transformers ...Transformer) Transformer {
+	return TransformerFunc(func(services []Registration) []Registration {
+		for _, t := range transformers {
+			services = t.Transform(services)
+		}
+		return services
+	})
+}
+
+// What registering a service takes, see Differ
+const (
+	// Nothing: the cached registration is the same
+	ChangeKeep = "keep"
+
+	// Registering the service again, replacing the cached one
+	ChangeRegister = "register"
+
+	// Deregistering the cached registration and registering the
+	// service, e.g. with another agent
+	ChangeMove = "move"
+)
+
+// A Change a Differ found, with the reason recorded in the change
+// log, e.g. changed or relaunched
+type Change struct {
+	Action string
+	Reason string
+}
+
+// A Differ tells what registering desired takes when the cache holds
+// the cached registration of the same service
+type Differ interface {
+	Diff(cached Registration, desired Registration) Change
+}
+
+// The Differ of the syncs without another one: a service moves when
+// its agent, namespace or partition changed, and is registered again
+// when its task was relaunched or its registration changed
+var DefaultDiffer Differ = defaultDiffer{}
+
+type defaultDiffer struct{}
+
+func (defaultDiffer) Diff(cached Registration, desired Registration) Change {
+	switch {
+	case cached.Agent != desired.Agent || !sameScope(cached.Service, desired.Service):
+		return Change{ChangeMove, reasonMoved}
+	case relaunched(cached.Service, desired.Service):
+		return Change{ChangeRegister, reasonRelaunched}
+	case serviceChanged(cached.Service, desired.Service):
+		return Change{ChangeRegister, reasonChanged}
+	}
+
+	return Change{ChangeKeep, ""}
+}
+
+// An Applier makes the registrations and deregistrations of a sync
+type Applier interface {
+	Register(ctx context.Context, r Registration) error
+	Deregister(ctx context.Context, r Registration) error
+}
+
+// RegistryApplier returns the Applier of the syncs without another
+// one, calling r. The registrations of other datacenters go to their
+// catalog, see registry.InDatacenter.
+func RegistryApplier(r registry.Registry) Applier {
+	return registryApplier{r}
+}
+
+type registryApplier struct {
+	registry registry.Registry
+}
+
+func (a registryApplier) Register(ctx context.Context, r Registration) error {
+	return a.registry.Register(inDatacenter(ctx, r.Datacenter), r.Agent, r.Service)
+}
+
+func (a registryApplier) Deregister(ctx context.Context, r Registration) error {
+	return a.registry.Deregister(inDatacenter(ctx, r.Datacenter), r.Agent, r.Service)
+}
+
+// The context of the registry calls of the services of datacenter dc
+func inDatacenter(ctx context.Context, dc string) context.Context {
+	if dc == localDatacenter {
+		return ctx
+	}
+
+	return registry.InDatacenter(ctx, dc)
+}
+
+// The replaceable stages of the syncs. Nil ones are the default ones.
+type Stages struct {
+	Transformers []Transformer
+	Differ       Differ
+	Applier      Applier
+}
+
+// SetStages uses the stages of s from the next sync on
+func (m *Mesos) SetStages(s Stages) {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.stages = s
+}
+
+func (m *Mesos) differ() Differ {
+	if m.stages.Differ != nil {
+		return m.stages.Differ
+	}
+	return DefaultDiffer
+}
+
+func (m *Mesos) applier() Applier {
+	if m.stages.Applier != nil {
+		return m.stages.Applier
+	}
+	return RegistryApplier(m.Registry)
+}
+
+// Pass the task services and their agents, by service ID, through
+// the --transform-plugin and then the Transformers
+func (m *Mesos) transform(services []*consulapi.AgentServiceRegistration, agents map[string]string) ([]*consulapi.AgentServiceRegistration, map[string]string) {
+	if len(m.stages.Transformers) == 0 && m.config.TransformPlugin == "" {
+		return services, agents
+	}
+
+	registrations := make([]Registration, len(services))
+	for i, s := range services {
+		registrations[i] = Registration{Datacenter: localDatacenter, Agent: agents[s.ID], Service: s}
+	}
+	registrations = Chain(append([]Transformer{TransformerFunc(m.runPlugin)}, m.stages.Transformers...)...).Transform(registrations)
+
+	services = make([]*consulapi.AgentServiceRegistration, 0, len(registrations))
+	agents = make(map[string]string, len(registrations))
+	for _, r := range registrations {
+		services = append(services, r.Service)
+		agents[r.Service.ID] = r.Agent
+	}
+
+	return services, agents
+}
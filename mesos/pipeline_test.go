@@ -0,0 +1,126 @@
+package mesos
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// An Applier recording the registrations
+type recordingApplier struct {
+	registered   []Registration
+	deregistered []Registration
+}
+
+func (a *recordingApplier) Register(ctx context.Context, r Registration) error {
+	a.registered = append(a.registered, r)
+	return nil
+}
+
+func (a *recordingApplier) Deregister(ctx context.Context, r Registration) error {
+	a.deregistered = append(a.deregistered, r)
+	return nil
+}
+
+func TestStages(t *testing.T) {
+	m := &Mesos{config: config.DefaultConfig(), ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{Id: "web.1", Name: "web", FollowerId: "1", State: "TASK_RUNNING"},
+				{Id: "debug.1", Name: "debug", FollowerId: "1", State: "TASK_RUNNING"},
+			}},
+		},
+	}
+
+	drop := TransformerFunc(func(services []Registration) []Registration {
+		var kept []Registration
+		for _, r := range services {
+			if r.Service.Name != "debug" {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	})
+	rename := TransformerFunc(func(services []Registration) []Registration {
+		for i := range services {
+			services[i].Service.Name = "team-" + services[i].Service.Name
+			services[i].Agent = "10.0.0.9"
+		}
+		return services
+	})
+	applier := &recordingApplier{}
+	m.SetStages(Stages{Transformers: []Transformer{drop, rename}, Applier: applier})
+
+	services, agents := m.taskServices(sj)
+	for _, s := range services {
+		m.registerAt(localDatacenter, agents[s.ID], s)
+	}
+
+	if len(applier.registered) != 1 {
+		t.Fatalf("expected one registration, got %v", applier.registered)
+	}
+	r := applier.registered[0]
+	if r.Service.Name != "team-web" || r.Agent != "10.0.0.9" {
+		t.Errorf("expected the transformed registration, got %s with %s", r.Service.Name, r.Agent)
+	}
+
+	// A Differ keeping every cached service
+	m.SetStages(Stages{Applier: applier, Differ: keepDiffer{}})
+	s := *r.Service
+	s.Tags = []string{"changed"}
+	m.registerAt(localDatacenter, "10.0.0.9", &s)
+	if len(applier.registered) != 1 {
+		t.Errorf("expected the differ to keep the cached service, got %v", applier.registered)
+	}
+}
+
+type keepDiffer struct{}
+
+func (keepDiffer) Diff(cached, desired Registration) Change {
+	return Change{Action: ChangeKeep}
+}
+
+func TestDefaultDiffer(t *testing.T) {
+	cached := Registration{Agent: "10.0.0.1", Service: &consulapi.AgentServiceRegistration{ID: "a", Name: "web", Port: 80}}
+
+	tests := []struct {
+		desired Registration
+		change  Change
+	}{
+		{cached, Change{ChangeKeep, ""}},
+		{Registration{Agent: "10.0.0.2", Service: cached.Service}, Change{ChangeMove, reasonMoved}},
+		{Registration{Agent: "10.0.0.1", Service: &consulapi.AgentServiceRegistration{ID: "a", Name: "web", Port: 81}}, Change{ChangeRegister, reasonChanged}},
+	}
+
+	for i, tt := range tests {
+		if change := DefaultDiffer.Diff(cached, tt.desired); change != tt.change {
+			t.Errorf("%d: expected %+v, got %+v", i, tt.change, change)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	upper := TransformerFunc(func(services []Registration) []Registration {
+		for _, r := range services {
+			r.Service.Name = strings.ToUpper(r.Service.Name)
+		}
+		return services
+	})
+	suffix := TransformerFunc(func(services []Registration) []Registration {
+		for _, r := range services {
+			r.Service.Name += "-x"
+		}
+		return services
+	})
+
+	services := Chain(upper, suffix).Transform([]Registration{{Service: &consulapi.AgentServiceRegistration{Name: "web"}}})
+	if services[0].Service.Name != "WEB-x" {
+		t.Errorf("expected the transformers applied in order, got %s", services[0].Service.Name)
+	}
+}
@@ -0,0 +1,138 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/hook"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// A task service as a --transform-plugin reads and prints it, the
+// service in the JSON of the Consul agent API
+type pluginRegistration struct {
+	Agent   string                              `json:"agent"`
+	Service *consulapi.AgentServiceRegistration `json:"service"`
+}
+
+// A --transform-plugin run of the sync in progress, reused for the
+// same command and input
+type pluginRun struct {
+	command string
+	input   []byte
+	output  []byte
+	err     error
+}
+
+// Pass the task services through the --transform-plugin command. It
+// runs with the shell, see hook.Shell, reads the services as a JSON
+// array of {"agent": ..., "service": {...}} on its standard input and
+// prints the ones to register in the same form: services it leaves
+// out are vetoed, the others registered as it prints them. A service
+// must keep its ID; those of IDs it was not given are ignored. When
+// the command fails or prints no valid JSON, the services already
+// registered keep their registrations and the others wait for a
+// sync it succeeds in.
+func (m *Mesos) runPlugin(services []Registration) []Registration {
+	command := m.config.TransformPlugin
+	if command == "" {
+		return services
+	}
+
+	in := make([]pluginRegistration, len(services))
+	byID := make(map[string]bool, len(services))
+	for i, r := range services {
+		in[i] = pluginRegistration{r.Agent, r.Service}
+		byID[r.Service.ID] = true
+	}
+	input, err := json.Marshal(in)
+	if err != nil {
+		hclog.L().Warn("Unable to pass the services to the transform plugin", "error", err)
+		return m.pluginFallback(services)
+	}
+
+	run := m.pluginRun
+	fresh := run == nil || run.command != command || !bytes.Equal(run.input, input)
+	if fresh {
+		run = &pluginRun{command: command, input: input}
+		run.output, run.err = m.execPlugin(command, input)
+		m.pluginRun = run
+	}
+
+	var out []pluginRegistration
+	err = run.err
+	if err == nil {
+		err = json.Unmarshal(run.output, &out)
+	}
+	if err != nil {
+		if fresh {
+			hclog.L().Warn("Transform plugin failed. Keeping the registered services", "command", command, "error", err)
+			m.health.pluginResult(0, true)
+		}
+		return m.pluginFallback(services)
+	}
+
+	transformed := make([]Registration, 0, len(out))
+	for _, r := range out {
+		if r.Service == nil || !byID[r.Service.ID] {
+			if fresh && r.Service != nil {
+				hclog.L().Warn("Transform plugin printed a service it was not given, or twice. Ignoring it", "service_id", r.Service.ID)
+			}
+			continue
+		}
+		delete(byID, r.Service.ID)
+		transformed = append(transformed, Registration{Datacenter: localDatacenter, Agent: r.Agent, Service: r.Service})
+	}
+
+	if fresh {
+		for _, r := range services {
+			if byID[r.Service.ID] {
+				hclog.L().Debug("Service vetoed by the transform plugin", "service_id", r.Service.ID)
+			}
+		}
+		m.health.pluginResult(len(byID), false)
+	}
+
+	return transformed
+}
+
+// Run the --transform-plugin command with input, returning what it
+// printed
+func (m *Mesos) execPlugin(command string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(m.syncCtx(), m.config.TransformPluginTimeout)
+	defer cancel()
+
+	cmd := hook.Shell(ctx, command)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// The services as they are registered, for a sync the
+// --transform-plugin failed in: the cached registrations of those in
+// the cache, without the others
+func (m *Mesos) pluginFallback(services []Registration) []Registration {
+	var kept []Registration
+	for _, r := range services {
+		if b, ok := m.ServiceCache[ServiceKey{r.Service.ID, localDatacenter}]; ok {
+			s := *b.service
+			kept = append(kept, Registration{Datacenter: localDatacenter, Agent: b.agent, Service: &s})
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,58 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestRunPlugin(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c, ServiceCache: map[ServiceKey]*CacheEntry{}}
+	services := func() []Registration {
+		return []Registration{
+			{Agent: "10.0.0.1", Service: &consulapi.AgentServiceRegistration{ID: "a", Name: "web", Port: 31000}},
+			{Agent: "10.0.0.1", Service: &consulapi.AgentServiceRegistration{ID: "b", Name: "db", Port: 31001}},
+		}
+	}
+	summary := func(rs []Registration) []string {
+		var s []string
+		for _, r := range rs {
+			s = append(s, r.Service.ID+" "+r.Service.Name+"@"+r.Agent)
+		}
+		return s
+	}
+
+	c.TransformPlugin = "cat"
+	if got, want := summary(m.runPlugin(services())), []string{"a web@10.0.0.1", "b db@10.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the services passed through, got %v", got)
+	}
+
+	c.TransformPlugin = `echo '[{"agent": "10.0.0.2", "service": {"ID": "a", "Name": "zone-web", "Port": 31000}}, {"service": {"ID": "c", "Name": "new"}}]'`
+	got := m.runPlugin(services())
+	if want := []string{"a zone-web@10.0.0.2"}; !reflect.DeepEqual(summary(got), want) {
+		t.Errorf("expected web renamed and moved, db vetoed and the unknown service ignored, got %v", summary(got))
+	}
+	if got[0].Service.Port != 31000 || m.health.pluginVetoes != 1 {
+		t.Errorf("expected the service as printed and 1 veto, got port %d and %d vetoes", got[0].Service.Port, m.health.pluginVetoes)
+	}
+
+	// Failing, the registered services are kept and the others held
+	m.ServiceCache[ServiceKey{"a", localDatacenter}] = &CacheEntry{service: got[0].Service, agent: got[0].Agent}
+	for _, command := range []string{"echo failed >&2; exit 1", "true"} {
+		c.TransformPlugin = command
+		if got, want := summary(m.runPlugin(services())), []string{"a zone-web@10.0.0.2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected the cached registration only, got %v", command, got)
+		}
+	}
+	if m.health.pluginFailures != 2 {
+		t.Errorf("expected 2 failures, got %d", m.health.pluginFailures)
+	}
+
+	c.TransformPlugin = ""
+	if got := m.runPlugin(services()); len(got) != 2 || got[0].Service.Name != "web" {
+		t.Errorf("expected the services as they are without a plugin, got %v", summary(got))
+	}
+}
@@ -0,0 +1,80 @@
+package mesos
+
+// The tag prefix of the task services of a pod, followed by the ID of
+// its executor, e.g. pod=instance-web.6d7e4d93
+const podTagPrefix = "pod="
+
+// A pod, i.e. a Mesos task group: tasks the default executor runs in
+// nested containers sharing the network namespace, and so the IP, of
+// its executor's container
+type pod struct {
+	id string
+
+	// The container IP the first of its tasks reporting one reports,
+	// empty on the host network
+	ip string
+}
+
+// Whether the task runs in a container nested in its executor's one,
+// as the tasks of a task group do
+func nestedTask(task *Task) bool {
+	for _, status := range task.Statuses {
+		if status.ContainerStatus.ContainerID.Parent != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Group the running tasks of the task groups of the state by pod. A
+// pod is the executor of the task group on its agent.
+func taskPods(sj StateJSON) map[*Task]*pod {
+	type podKey struct{ follower, executor string }
+
+	pods := make(map[podKey]*pod)
+	tasks := make(map[*Task]*pod)
+	for _, fw := range sj.Frameworks {
+		for i := range fw.Tasks {
+			task := &fw.Tasks[i]
+			if task.State != "TASK_RUNNING" || task.ExecutorId == "" || !nestedTask(task) {
+				continue
+			}
+
+			key := podKey{task.FollowerId, task.ExecutorId}
+			p, ok := pods[key]
+			if !ok {
+				p = &pod{id: task.ExecutorId}
+				pods[key] = p
+			}
+			if p.ip == "" {
+				p.ip = containerIP(task)
+			}
+			tasks[task] = p
+		}
+	}
+
+	return tasks
+}
+
+// The container IP of the task or, for the task of a pod reporting
+// none of its own, the one of its pod
+func (m *Mesos) taskContainerIP(task *Task) string {
+	if ip := containerIP(task); ip != "" {
+		return ip
+	}
+	if p := m.pods[task]; p != nil {
+		return p.ip
+	}
+
+	return ""
+}
+
+// The tag of the services of the task of a pod, none for other tasks
+func (m *Mesos) podTags(task *Task) []string {
+	if p := m.pods[task]; p != nil {
+		return []string{podTagPrefix + p.id}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package mesos
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestTaskServicesPod(t *testing.T) {
+	c := config.DefaultConfig()
+	c.AddressPriority = []string{config.AddressContainer, config.AddressHostname}
+	m := &Mesos{config: c}
+
+	// The tasks of a task group, only the first reporting the IP of
+	// the pod
+	var statuses [2][]Status
+	for i, s := range []string{
+		`[{"state": "TASK_RUNNING", "container_status": {"container_id": {"value": "c1", "parent": {"value": "p"}}, "network_infos": [{"ip_addresses": [{"ip_address": "172.16.0.5"}]}]}}]`,
+		`[{"state": "TASK_RUNNING", "container_status": {"container_id": {"value": "c2", "parent": {"value": "p"}}}}]`,
+	} {
+		if err := json.Unmarshal([]byte(s), &statuses[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{
+			{Tasks: Tasks{
+				{Id: "web.app", Name: "app", FollowerId: "1", ExecutorId: "instance-web.1", State: "TASK_RUNNING", Resources: Resources{Ports: "[31000-31000]"}, Statuses: statuses[0]},
+				{Id: "web.sidecar", Name: "sidecar", FollowerId: "1", ExecutorId: "instance-web.1", State: "TASK_RUNNING", Resources: Resources{Ports: "[31001-31001]"}, Statuses: statuses[1]},
+				{Id: "db.1", Name: "db", FollowerId: "1", ExecutorId: "db.1", State: "TASK_RUNNING", Resources: Resources{Ports: "[31002-31002]"}},
+			}},
+		},
+	}
+
+	services, _ := m.taskServices(sj)
+	if len(services) != 3 {
+		t.Fatalf("expected 3 services, got %d", len(services))
+	}
+
+	for _, s := range services {
+		pod := contains(s.Tags, "pod=instance-web.1")
+		switch s.Name {
+		case "app", "sidecar":
+			if s.Address != "172.16.0.5" || !pod {
+				t.Errorf("expected %s on the pod IP with the pod tag, got %s %v", s.Name, s.Address, s.Tags)
+			}
+		default:
+			if s.Address != "10.0.0.1" || pod {
+				t.Errorf("expected %s outside the pod, got %s %v", s.Name, s.Address, s.Tags)
+			}
+		}
+	}
+}
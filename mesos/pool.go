@@ -0,0 +1,59 @@
+package mesos
+
+import (
+	"context"
+	"sync"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Registry writes of a sync in flight on up to
+// --registry-concurrency workers
+type pool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newPool(workers int) *pool {
+	return &pool{slots: make(chan struct{}, workers)}
+}
+
+// Run a registry write and, when it succeeds, done. Without a pool
+// the write runs right away, otherwise on the next free worker, and
+// done must not touch the cache.
+func (m *Mesos) write(op func(ctx context.Context) error, done func()) {
+	ctx := m.syncCtx()
+	run := func() {
+		err := op(ctx)
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Error("Registry write failed", "error", err)
+			return
+		}
+
+		done()
+	}
+
+	if m.pool == nil {
+		run()
+		return
+	}
+
+	m.pool.slots <- struct{}{}
+	m.pool.wg.Add(1)
+	go func() {
+		defer func() {
+			<-m.pool.slots
+			m.pool.wg.Done()
+		}()
+
+		run()
+	}()
+}
+
+// Wait for the writes in flight
+func (m *Mesos) flush() {
+	if m.pool != nil {
+		m.pool.wg.Wait()
+	}
+}
@@ -0,0 +1,110 @@
+package mesos
+
+import (
+	"sort"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// With --prepared-queries, keep a prepared query named after every
+// service in the cache, so <name>.query.consul fails over to the
+// --query-failover-nearest or --query-failover-datacenters
+// datacenters once no instance passes its checks in the local one.
+// Queries of services gone from the cache are deleted.
+func (m *Mesos) syncQueries() {
+	if !m.config.PreparedQueries {
+		return
+	}
+
+	ctx := m.syncCtx()
+	existing, err := m.Registry.Queries(ctx)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to list the prepared queries", "error", err)
+		return
+	}
+
+	queries := make(map[string]*consulapi.PreparedQueryDefinition, len(existing))
+	for _, q := range existing {
+		queries[q.Name] = q
+	}
+
+	names := make(map[string]bool)
+	for key, b := range m.ServiceCache {
+		if key.Datacenter == localDatacenter {
+			names[b.service.Name] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		q := m.serviceQuery(name)
+		if old, ok := queries[name]; ok {
+			if sameQuery(old, q) {
+				continue
+			}
+			q.ID = old.ID
+		}
+
+		hclog.L().Info("Setting prepared query", "query", name)
+		err := m.Registry.SetQuery(ctx, q)
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Warn("Unable to set the prepared query", "query", name, "error", err)
+		}
+	}
+
+	for name, q := range queries {
+		if names[name] {
+			continue
+		}
+
+		hclog.L().Info("Deleting prepared query", "query", name)
+		err := m.Registry.DeleteQuery(ctx, q.ID)
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Warn("Unable to delete the prepared query", "query", name, "error", err)
+		}
+	}
+}
+
+// The prepared query of the service name
+func (m *Mesos) serviceQuery(name string) *consulapi.PreparedQueryDefinition {
+	return &consulapi.PreparedQueryDefinition{
+		Name: name,
+		Service: consulapi.ServiceQuery{
+			Service:     name,
+			OnlyPassing: true,
+			Failover: consulapi.QueryFailoverOptions{
+				NearestN:    m.config.QueryFailoverNearest,
+				Datacenters: m.config.QueryFailoverDatacenters,
+			},
+		},
+	}
+}
+
+// Tell whether an existing query does what q does. The failover
+// datacenters are tried in order, so their order matters.
+func sameQuery(old, q *consulapi.PreparedQueryDefinition) bool {
+	a, b := old.Service, q.Service
+	if a.Service != b.Service || a.OnlyPassing != b.OnlyPassing || a.Failover.NearestN != b.Failover.NearestN {
+		return false
+	}
+
+	if len(a.Failover.Datacenters) != len(b.Failover.Datacenters) {
+		return false
+	}
+	for i, dc := range a.Failover.Datacenters {
+		if b.Failover.Datacenters[i] != dc {
+			return false
+		}
+	}
+
+	return true
+}
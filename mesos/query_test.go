@@ -0,0 +1,48 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestSyncQueries(t *testing.T) {
+	r := newFakeRegistry()
+	r.queries = []*consulapi.PreparedQueryDefinition{
+		{ID: "q-old", Name: "old", Service: consulapi.ServiceQuery{Service: "old"}},
+	}
+	c := config.DefaultConfig()
+	c.PreparedQueries = true
+	c.QueryFailoverDatacenters = []string{"dc2", "dc3"}
+
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{Name: "web"}},
+			{"mesos-consul:b", localDatacenter}: {service: &consulapi.AgentServiceRegistration{Name: "web"}},
+			{"mesos-consul:c", "dc2"}:           {service: &consulapi.AgentServiceRegistration{Name: "remote"}},
+		},
+	}
+
+	m.syncQueries()
+	if len(r.queries) != 1 || r.queries[0].Name != "web" {
+		t.Fatalf("expected a query of web only, got %+v", r.queries)
+	}
+	q := r.queries[0]
+	if q.Service.Service != "web" || !q.Service.OnlyPassing || len(q.Service.Failover.Datacenters) != 2 {
+		t.Errorf("unexpected query %+v", q.Service)
+	}
+
+	m.syncQueries()
+	if r.queries[0] != q {
+		t.Error("expected an unchanged query to be left alone")
+	}
+
+	c.QueryFailoverDatacenters = []string{"dc3", "dc2"}
+	m.syncQueries()
+	if r.queries[0].ID != q.ID || r.queries[0].Service.Failover.Datacenters[0] != "dc3" {
+		t.Errorf("expected the query to be updated with the new order, got %+v", r.queries[0])
+	}
+}
@@ -0,0 +1,84 @@
+package mesos
+
+import (
+	"context"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/registry"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Every --reconcile-interval, and with --cleanup-orphans on the first
+// sync too, compare the services registered in Consul with the cache
+// instead of trusting the cache alone. Cached services Consul lost,
+// e.g. to an agent restarting without its data dir, are registered
+// again. Services of this instance the cache does not know, e.g. left
+// behind when it lost its cache, are deregistered, within
+// --max-deregister-percent.
+func (m *Mesos) reconcile() {
+	if !m.reconcileDue() {
+		return
+	}
+	m.reconciled = time.Now()
+
+	services, err := m.Registry.Services(m.inScopes(m.allowStale(m.syncCtx())), "mesos-consul:")
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to reconcile with Consul", "error", err)
+		return
+	}
+
+	registered := make(map[string]bool)
+	var orphans []*registry.Service
+	for _, s := range services {
+		registered[s.ID] = true
+		if _, ok := m.ServiceCache[ServiceKey{s.ID, localDatacenter}]; !ok && registry.OwnedBy(s.AgentServiceRegistration, m.config.InstanceID) {
+			orphans = append(orphans, s)
+		}
+	}
+
+	// Services waiting out --deregister-delay are left alone
+	var lost []CacheEntry
+	for key, b := range m.ServiceCache {
+		if key.Datacenter == localDatacenter && b.missed == 0 && !registered[key.ID] {
+			lost = append(lost, *b)
+		}
+	}
+
+	hclog.L().Info("Reconciled the cache with Consul", "services", len(services), "lost", len(lost), "orphans", len(orphans))
+
+	for _, b := range lost {
+		b := b
+		hclog.L().Warn("Service missing from Consul. Registering again", "service_id", b.service.ID)
+		m.write(func(ctx context.Context) error {
+			return m.applier().Register(ctx, Registration{localDatacenter, b.agent, b.service})
+		}, func() {
+			m.health.drifted()
+			m.applied(eventRegister, reasonLost, b.agent, b.service)
+		})
+	}
+
+	if !m.tooManyRemovals(len(orphans)) {
+		for _, s := range orphans {
+			s := s
+			hclog.L().Warn("Service missing from the cache. Deregistering", "service_id", s.ID, "agent", s.Agent)
+			m.write(func(ctx context.Context) error {
+				return m.applier().Deregister(ctx, Registration{localDatacenter, s.Agent, s.AgentServiceRegistration})
+			}, func() {
+				m.health.drifted()
+				m.applied(eventDeregister, reasonOrphaned, s.Agent, s.AgentServiceRegistration)
+			})
+		}
+	}
+
+	m.flush()
+}
+
+func (m *Mesos) reconcileDue() bool {
+	if m.reconciled.IsZero() && m.config.CleanupOrphans {
+		return true
+	}
+
+	interval := m.config.ReconcileInterval
+	return interval > 0 && time.Since(m.reconciled) >= interval
+}
@@ -0,0 +1,122 @@
+package mesos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestReconcile(t *testing.T) {
+	r := newFakeRegistry()
+	r.services = []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:kept", Address: "10.0.0.1"},
+		{ID: "mesos-consul:orphan", Address: "10.0.0.2"},
+		{ID: "mesos-consul:owned", Address: "10.0.0.2", Meta: map[string]string{registry.InstanceMeta: "mesos-consul"}},
+		{ID: "mesos-consul:other", Address: "10.0.0.2", Meta: map[string]string{registry.InstanceMeta: "elsewhere"}},
+	}
+	r.nodes = map[string]string{"mesos-consul:orphan": "10.0.0.5"}
+
+	c := config.DefaultConfig()
+	c.ReconcileInterval = time.Minute
+	c.InstanceID = "mesos-consul"
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:kept", localDatacenter}:    {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:kept"}, agent: "10.0.0.1"},
+			{"mesos-consul:lost", localDatacenter}:    {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:lost"}, agent: "10.0.0.3"},
+			{"mesos-consul:leaving", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:leaving"}, missed: 1},
+		},
+	}
+
+	m.reconcile()
+
+	if len(r.registered) != 1 || r.registered["mesos-consul:lost"] != "10.0.0.3" {
+		t.Errorf("expected only the lost service to be registered again, got %v", r.registered)
+	}
+	if _, ok := r.deregistered["mesos-consul:owned"]; len(r.deregistered) != 2 || r.deregistered["mesos-consul:orphan"] != "10.0.0.5" || !ok {
+		t.Errorf("expected only the unmarked and owned orphans to be deregistered from the agent of their node, got %v", r.deregistered)
+	}
+	if m.health.driftTotal != 3 {
+		t.Errorf("expected 3 drift repairs, got %d", m.health.driftTotal)
+	}
+
+	// Not due again before the interval elapses
+	r.registered = map[string]string{}
+	m.reconcile()
+	if len(r.registered) != 0 {
+		t.Errorf("expected no reconciliation before the interval, got %v", r.registered)
+	}
+}
+
+func TestCleanupOrphansOnFirstSync(t *testing.T) {
+	c := config.DefaultConfig()
+	c.CleanupOrphans = true
+	m := &Mesos{config: c}
+
+	if !m.reconcileDue() {
+		t.Error("expected --cleanup-orphans to reconcile on the first sync")
+	}
+
+	m.reconciled = time.Now()
+	if m.reconcileDue() {
+		t.Error("expected no further reconciliation without --reconcile-interval")
+	}
+}
+
+func TestRepairServices(t *testing.T) {
+	r := newFakeRegistry()
+	r.services = []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:kept", Name: "web", Address: "10.0.0.1", Port: 80, Tags: []string{"a", "added"}, Meta: map[string]string{"k": "v", registry.InstanceMeta: "mesos-consul"}},
+		{ID: "mesos-consul:moved", Name: "web", Address: "10.0.0.9", Port: 80},
+		{ID: "mesos-consul:untagged", Name: "web", Address: "10.0.0.1", Port: 81},
+	}
+
+	web := func(id string, port int) *consulapi.AgentServiceRegistration {
+		return &consulapi.AgentServiceRegistration{ID: id, Name: "web", Address: "10.0.0.1", Port: port, Tags: []string{"a"}, Meta: map[string]string{"k": "v"}}
+	}
+	m := &Mesos{
+		Registry: r,
+		config:   config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:kept", localDatacenter}:     {service: web("mesos-consul:kept", 80), agent: "10.0.0.1"},
+			{"mesos-consul:moved", localDatacenter}:    {service: web("mesos-consul:moved", 80), agent: "10.0.0.1"},
+			{"mesos-consul:untagged", localDatacenter}: {service: web("mesos-consul:untagged", 81), agent: "10.0.0.1"},
+			{"mesos-consul:lost", localDatacenter}:     {service: web("mesos-consul:lost", 82), agent: "10.0.0.1"},
+			{"mesos-consul:leaving", localDatacenter}:  {service: web("mesos-consul:leaving", 83), missed: 1},
+		},
+	}
+
+	// The changes of a sync just ended are its own, and a stopped
+	// watcher repairs nothing
+	m.syncEnded = time.Now()
+	m.repairServices(context.Background())
+	m.syncEnded = time.Time{}
+	stopped, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.repairServices(stopped)
+	if len(r.registered) != 0 {
+		t.Fatalf("expected no repairs right after a sync or once stopped, got %v", r.registered)
+	}
+
+	m.repairServices(context.Background())
+
+	for _, id := range []string{"mesos-consul:moved", "mesos-consul:untagged", "mesos-consul:lost"} {
+		if _, ok := r.registered[id]; !ok {
+			t.Errorf("expected %s to be registered again, got %v", id, r.registered)
+		}
+	}
+	if len(r.registered) != 3 {
+		t.Errorf("expected only the lost and modified services to be registered again, got %v", r.registered)
+	}
+	if len(r.deregistered) != 0 {
+		t.Errorf("expected no deregistrations, got %v", r.deregistered)
+	}
+	if m.health.driftTotal != 3 {
+		t.Errorf("expected 3 drift repairs, got %d", m.health.driftTotal)
+	}
+}
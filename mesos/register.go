@@ -1,80 +1,150 @@
 package mesos
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"strings"
+	"reflect"
 
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/registry"
 	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
 )
 
-// Query the consul agent on the Mesos Master
-// to initialize the cache.
+// Query the registry to initialize the cache
+// when none is persisted in the KV store, or to add
+// the services of unreadable entries.
 //
 // All services created by mesos-consul are prefixed
-// with `mesos-consul:`
+// with `mesos-consul:`. Those marked as owned by another
+// instance are left to it.
 //
 func (m *Mesos) LoadCache() error {
-	log.Print("[DEBUG] Populating cache from Consul")
+	hclog.L().Debug("Populating cache from the registry")
 
-	host, _ := m.getLeader()
-	
-	client := m.Consul.Client(host).Catalog()
-
-	serviceList, _, err := client.Services(nil)
+	services, err := m.Registry.Services(m.inScopes(m.loadCtx()), "mesos-consul:")
 	if err != nil {
 		return err
 	}
 
-	for service, _ := range serviceList {
-		catalogServices, _, err := client.Service(service, "", nil)
-		if err != nil {
-			return err
-		}
-
-		for _, s := range catalogServices {
-			if strings.HasPrefix(s.ServiceID, "mesos-consul:")  {
-				log.Printf("[DEBUG] Found '%s' with ID '%s'", s.ServiceName, s.ServiceID)
-				m.ServiceCache[s.ServiceID] = &CacheEntry{
-					service:	&consulapi.AgentServiceRegistration{
-							ID:		s.ServiceID,
-							Name:		s.ServiceName,
-							Port:		s.ServicePort,
-							Address:	s.ServiceAddress,
-							Tags:		s.ServiceTags,
-							},
-					isRegistered:	false,
-				}
-			}
+	for _, s := range services {
+		if !registry.OwnedBy(s.AgentServiceRegistration, m.config.InstanceID) {
+			continue
+		}
+
+		key := ServiceKey{s.ID, localDatacenter}
+		if _, ok := m.ServiceCache[key]; ok {
+			continue
+		}
+
+		hclog.L().Debug("Found service", "service_id", s.ID, "service", s.Name)
+		m.ServiceCache[key] = &CacheEntry{
+			service:	s.AgentServiceRegistration,
+			isRegistered:	false,
+			agent:		s.Agent,
 		}
 	}
 
 	return nil
 }
 
+// Tag and describe the services of a task from its DiscoveryInfo
+// when the framework populates one, e.g. Marathon or Aurora. The
+// environment, location and version become both tags and metadata,
+// the DiscoveryInfo labels key=value tags and metadata. The services
+// are named by taskServiceName().
+//
+func discoveryService(task *Task) ([]string, map[string]string) {
+	d := task.Discovery
+	if d == nil {
+		return nil, nil
+	}
+
+	var tags []string
+	meta := make(map[string]string)
+
+	for _, kv := range []struct{ key, value string }{
+		{ "environment", d.Environment },
+		{ "location", d.Location },
+		{ "version", d.Version },
+	} {
+		if kv.value != "" {
+			tags = append(tags, kv.value)
+			meta[kv.key] = kv.value
+		}
+	}
+
+	for _, l := range d.Labels.Labels {
+		if l.Value == "" {
+			tags = append(tags, l.Key)
+		} else {
+			tags = append(tags, fmt.Sprintf("%s=%s", l.Key, l.Value))
+		}
+		meta[l.Key] = l.Value
+	}
+
+	if len(meta) == 0 {
+		meta = nil
+	}
+
+	return tags, meta
+}
+
 func (m *Mesos) RegisterHosts(sj StateJSON) {
-	log.Print("[INFO] Running RegisterHosts")
+	hclog.L().Info("Running RegisterHosts")
+
+	for _, s := range m.hostServices(sj) {
+		m.registerHost(localDatacenter, s)
+	}
+}
 
-	// Register followers
+// Build the registrations for the followers and masters, with
+// --register-mesos-endpoints for their UI and metrics endpoints, and
+// with --register-zookeeper for the Zookeeper ensemble
+//
+func (m *Mesos) hostServices(sj StateJSON) []*consulapi.AgentServiceRegistration {
+	var services []*consulapi.AgentServiceRegistration
+
+	// Followers
 	for _, f := range sj.Followers {
-		h, p := parsePID(f.Pid)
-		host := toIP(h)
+		if !f.hasRole(m.config.FollowerRoles) {
+			hclog.L().Debug("Skipping follower of another role", "follower", f.Hostname, "roles", m.config.FollowerRoles)
+			continue
+		}
+
+		h, p, err := parsePID(f.Pid, m.config.PidParseStrict)
+		if err != nil {
+			hclog.L().Warn("Skipping follower", "follower", f.Hostname, "error", err)
+			continue
+		}
+		host := m.address(h)
 		port := toPort(p)
+		if host == "" {
+			continue
+		}
 
-		m.registerHost(&consulapi.AgentServiceRegistration{
+		// Capacity tooling finds the followers by their resources,
+		// e.g. gpus.mesos.service.consul
+		tags := m.hostTags([]string{ "follower" }, m.config.FollowerTags)
+		resources, meta := f.resourceInfo(m.config.FollowerResources)
+		tags = append(tags, resources...)
+		if m.config.FollowerCapabilities {
+			tags = append(tags, f.capabilityTags()...)
+		}
+
+		services = append(services, &consulapi.AgentServiceRegistration{
 			ID:		fmt.Sprintf("mesos-consul:mesos:%s:%s", f.Id, f.Hostname),
 			Name:		"mesos",
 			Port:		port,
 			Address:	host,
-			Tags:		[]string{ "follower" },
-			Check:		&consulapi.AgentServiceCheck{
-				HTTP:		fmt.Sprintf("http://%s:%d/slave(1)/health", host, port),
-				Interval:	"10s",
-			},
+			Tags:		tags,
+			Meta:		meta,
+			Check:		m.hostCheck(m.mesosURL(hostPort(host, port), "/slave(1)/health")),
 		})
+		services = append(services, m.endpointServices("agent", f.Id + ":" + f.Hostname, host, port, "/slave(1)/health", m.hostTags(nil, m.config.FollowerTags))...)
 	}
 
-	// Register masters
+	// Masters
 	mas := m.getMasters()
 	for _, ma := range mas {
 		var tags []string
@@ -84,33 +154,138 @@ func (m *Mesos) RegisterHosts(sj StateJSON) {
 		} else {
 			tags = []string{ "master" }
 		}
-		host := toIP(ma.host)
+		host := m.address(ma.host)
 		port := toPort(ma.port)
+		if host == "" {
+			continue
+		}
 		s := &consulapi.AgentServiceRegistration{
 			ID:		fmt.Sprintf("mesos-consul:mesos:%s:%s", ma.host, ma.port),
 			Name:		"mesos",
 			Port:		port,
 			Address:	host,
-			Tags:		tags,
-			Check:		&consulapi.AgentServiceCheck{
-				HTTP:		fmt.Sprintf("http://%s:%d/master/health", host, port),
-				Interval:	"10s",
-			},
+			Tags:		m.hostTags(tags, m.config.MasterTags),
+			Check:		m.hostCheck(m.mesosURL(hostPort(host, port), "/master/health")),
+		}
+
+		services = append(services, s)
+
+		var leader []string
+		if ma.isLeader {
+			leader = []string{ "leader" }
 		}
+		services = append(services, m.endpointServices("master", ma.host + ":" + ma.port, host, port, "/master/health", m.hostTags(leader, m.config.MasterTags))...)
+	}
+
+	if m.config.RegisterZookeeper {
+		services = append(services, m.zookeeperServices()...)
+	}
+
+	return services
+}
+
+// Build the HTTP check of a master or follower from the
+// --mesos-check-* options. A zero timeout leaves Consul's default, a
+// zero deregister-critical-after keeps dead nodes registered.
+//
+func (m *Mesos) hostCheck(url string) *consulapi.AgentServiceCheck {
+	check := m.withHostCheckOptions(&consulapi.AgentServiceCheck{
+		HTTP:		url,
+	})
+
+	if ssl := m.config.MesosSSL; ssl != nil && ssl.Enabled {
+		check.TLSSkipVerify = !ssl.Verify
+	}
 
-		m.registerHost(s)
+	return check
+}
+
+// Set the interval, timeout and deregister-critical-after of the
+// --mesos-check-* options on a check of a host
+//
+func (m *Mesos) withHostCheckOptions(check *consulapi.AgentServiceCheck) *consulapi.AgentServiceCheck {
+	check.Interval = m.config.MesosCheckInterval.String()
+
+	if m.config.MesosCheckTimeout > 0 {
+		check.Timeout = m.config.MesosCheckTimeout.String()
 	}
+	if m.config.MesosCheckDeregisterCriticalAfter > 0 {
+		check.DeregisterCriticalServiceAfter = m.config.MesosCheckDeregisterCriticalAfter.String()
+	}
+
+	return check
 }
 
-// helper function to compare service tag slices
+// Combine the built-in tags of a host with the operator's, dropping
+// the built-in ones with --no-default-tags. The result may be empty,
+// which Consul accepts.
 //
-func sliceEq(a, b []string) bool {
+func (m *Mesos) hostTags(defaults []string, extra []string) []string {
+	var tags []string
+
+	if !m.config.NoDefaultTags {
+		tags = append(tags, defaults...)
+	}
+
+	return append(tags, extra...)
+}
+
+// Tell whether a new registration of a cached service differs in
+// anything Consul would serve differently: its name, its tags in any
+// order, its address and port, its tagged addresses, its meta, its
+// weights, tag override and Connect settings, or its check. The
+// status and notes of TTL checks are left out, reportTTL() pushes
+// those.
+//
+func serviceChanged(cached, s *consulapi.AgentServiceRegistration) bool {
+	return cached.Name != s.Name ||
+		!sameTags(cached.Tags, s.Tags) ||
+		cached.Address != s.Address ||
+		cached.Port != s.Port ||
+		!sameTaggedAddresses(cached.TaggedAddresses, s.TaggedAddresses) ||
+		!sameMeta(cached.Meta, s.Meta) ||
+		!reflect.DeepEqual(cached.Weights, s.Weights) ||
+		cached.EnableTagOverride != s.EnableTagOverride ||
+		!reflect.DeepEqual(cached.Connect, s.Connect) ||
+		!sameCheck(cached.Check, s.Check)
+}
+
+// Compare two sets of tagged addresses, none being the same as empty
+//
+func sameTaggedAddresses(a, b map[string]consulapi.ServiceAddress) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare two sets of tags, whatever their order
+//
+func sameTags(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, tag := range a {
+		set[tag] = true
+	}
+
+	seen := make(map[string]bool, len(b))
+	for _, tag := range b {
+		if !set[tag] {
+			return false
+		}
+		seen[tag] = true
+	}
+
+	return len(seen) == len(set)
+}
+
+func sameMeta(a, b map[string]string) bool {
 	if len(a) != len(b) {
 		return false
 	}
 
-	for i := range a{
-		if a[i] != b[i] {
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
 			return false
 		}
 	}
@@ -118,67 +293,401 @@ func sliceEq(a, b []string) bool {
 	return true
 }
 
-func (m *Mesos) registerHost(s *consulapi.AgentServiceRegistration) {
+func sameCheck(a, b *consulapi.AgentServiceCheck) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	// The fixed status of a check without TTL, e.g. of a mirror, is
+	// only set by registering it
+	ca, cb := *a, *b
+	if ca.TTL != "" || cb.TTL != "" {
+		ca.Status, cb.Status = "", ""
+	}
+	ca.Notes, cb.Notes = "", ""
+
+	return reflect.DeepEqual(ca, cb)
+}
+
+func (m *Mesos) registerHost(dc string, s *consulapi.AgentServiceRegistration) {
+	key := ServiceKey{s.ID, dc}
+	agent := s.Address
+	reason := reasonNew
+	m.scope(s)
+	s = m.withTranslatedAddress(s)
+	if !m.validRegistration(dc, s) {
+		return
+	}
 
-	if _, ok := m.ServiceCache[s.ID]; ok {
-		log.Printf("[INFO] Host found. Comparing tags: (%v, %v)", m.ServiceCache[s.ID].service.Tags, s.Tags)
+	if b, ok := m.ServiceCache[key]; ok {
+		hclog.L().Info("Host found. Comparing tags", "service_id", s.ID, "cached", b.service.Tags, "tags", s.Tags)
 
-		if sliceEq(s.Tags, m.ServiceCache[s.ID].service.Tags) {
-			m.ServiceCache[s.ID].isRegistered = true
+		change := m.differ().Diff(Registration{dc, b.agent, b.service}, Registration{dc, agent, s})
+		switch change.Action {
+		case ChangeKeep:
+			m.ServiceCache[key].isRegistered = true
+			m.summary.keep()
 
-			// Tags are the same. Return
+			// Nothing changed. Return
 			return
+		case ChangeMove:
+			hclog.L().Info("Service moved. Re-registering", "service_id", s.ID, "reason", change.Reason, "from", b.agent, "to", agent)
+
+			old := *b
+			m.write(func(ctx context.Context) error { return m.applier().Deregister(ctx, Registration{dc, old.agent, old.service}) }, func() {})
 		}
 
-		log.Println("[INFO] Tags changed. Re-registering")
+		hclog.L().Info("Host changed. Re-registering", "service_id", s.ID, "reason", change.Reason)
+		reason = change.Reason
 
 		// Delete cache entry. It will be re-created below
-		delete(m.ServiceCache, s.ID)
+		delete(m.ServiceCache, key)
 	}
 
-	m.ServiceCache[s.ID] = &CacheEntry{
+	if m.cacheFull(s.ID) {
+		return
+	}
+
+	entry := &CacheEntry{
 		service:		s,
 		isRegistered:		true,
+		agent:			agent,
 	}
+	m.ServiceCache[key] = entry
 
+	m.write(func(ctx context.Context) error {
+		err := m.applier().Register(ctx, Registration{dc, agent, s})
+		if err != nil {
+			m.registrationFailed(key, entry)
+		}
+		return err
+	}, func() {
+		m.applied(eventRegister, reason, agent, s)
+	})
+}
 
-	err := m.Consul.Register(s)
-	if err != nil {
-		log.Print("[ERROR] ", err)
-	}
+// Register a service with the agent on its address
+//
+func (m *Mesos) register(dc string, s *consulapi.AgentServiceRegistration) {
+	m.registerAt(dc, s.Address, s)
 }
 
-func (m *Mesos) register(s *consulapi.AgentServiceRegistration) {
-	if _, ok := m.ServiceCache[s.ID]; ok {
-		log.Printf("[INFO] Service found. Not registering: %s", s.ID)
-		m.ServiceCache[s.ID].isRegistered = true
+// Register a service with the given agent, moving it there when it is
+// cached as registered with another one
+//
+func (m *Mesos) registerAt(dc string, agent string, s *consulapi.AgentServiceRegistration) {
+	key := ServiceKey{s.ID, dc}
+	reason := reasonNew
+	m.scope(s)
+	s = m.withTranslatedAddress(s)
+	if !m.validRegistration(dc, s) {
+		return
+	}
+
+	if b, ok := m.ServiceCache[key]; ok {
+		change := m.differ().Diff(Registration{dc, b.agent, b.service}, Registration{dc, agent, s})
+		switch {
+		case change.Action == ChangeKeep:
+			hclog.L().Info("Service found. Not registering", "service_id", s.ID)
+			b.isRegistered = true
+			m.summary.keep()
+			return
+		case change.Action == ChangeMove:
+			hclog.L().Info("Agent, namespace or partition changed. Moving service", "service_id", s.ID, "from", b.agent, "to", agent)
+
+			old := *b
+			m.write(func(ctx context.Context) error { return m.applier().Deregister(ctx, Registration{dc, old.agent, old.service}) }, func() {})
+		case change.Reason == reasonRelaunched:
+			hclog.L().Info("Task relaunched. Re-registering", "service_id", s.ID, "launch", s.Meta[launchMeta])
+		default:
+			hclog.L().Info("Service changed. Re-registering", "service_id", s.ID, "reason", change.Reason)
+		}
+		reason = change.Reason
+
+		// Delete cache entry. It will be re-created below
+		delete(m.ServiceCache, key)
+	}
+
+	if m.cacheFull(s.ID) {
 		return
 	}
 
-	log.Print("[INFO] Registering ", s.ID)
+	hclog.L().Info("Registering", "service_id", s.ID)
 
-	m.ServiceCache[s.ID] = &CacheEntry{
+	entry := &CacheEntry{
 		service:		s,
 		isRegistered:		true,
+		agent:			agent,
 	}
+	m.ServiceCache[key] = entry
+
+	// A failing framework, e.g. with names Consul rejects, only
+	// fails its own registrations
+	reg := m.withExternalTags(s)
+	framework := s.Meta[frameworkMeta]
+	m.write(func(ctx context.Context) error {
+		err := m.applier().Register(ctx, Registration{dc, agent, reg})
+		m.health.frameworkRegistration(framework, err)
+		if err != nil {
+			m.summary.failed(framework)
+			m.registrationFailed(key, entry)
+		}
+		return err
+	}, func() {
+		m.applied(eventRegister, reason, agent, s)
+	})
+}
 
-	err := m.Consul.Register(s)
-	if err != nil {
-		log.Print("[ERROR] ", err)
+// Remember the cache entry of a failed registration, see
+// forgetFailed()
+func (m *Mesos) registrationFailed(key ServiceKey, entry *CacheEntry) {
+	m.failedLock.Lock()
+	defer m.failedLock.Unlock()
+
+	if m.failed == nil {
+		m.failed = make(map[ServiceKey]*CacheEntry)
+	}
+	m.failed[key] = entry
+}
+
+// Drop the cache entries of the registrations of the sync that failed
+// once its writes are done, so they are retried instead of found
+// cached, see queuePending()
+func (m *Mesos) forgetFailed() {
+	m.dropFailed(nil)
+}
+
+// Drop the cache entries of the failed registrations of attempted, or
+// of all of them for a sync
+func (m *Mesos) dropFailed(attempted map[ServiceKey]bool) {
+	m.failedLock.Lock()
+	defer m.failedLock.Unlock()
+
+	dropped := make(map[ServiceKey]*CacheEntry)
+	for key, entry := range m.failed {
+		if m.ServiceCache[key] == entry {
+			delete(m.ServiceCache, key)
+			dropped[key] = entry
+		}
+	}
+	m.failed = nil
+	m.queuePending(dropped, attempted)
+
+	for key, h := range m.failedHolds {
+		if m.ServiceCache[key] == h.entry {
+			h.entry.service = h.original
+		}
 	}
+	m.failedHolds = nil
+}
+
+// Check whether the cache has room for a new entry. Once it holds
+// --max-cache-entries new services are dropped instead of letting
+// a runaway cluster grow the cache without bound, unless
+// --cache-eviction=lru makes room.
+//
+func (m *Mesos) cacheFull(id string) bool {
+	max := m.config.MaxCacheEntries
+	if max <= 0 || len(m.ServiceCache) < max {
+		return false
+	}
+
+	if m.config.CacheEviction == config.CacheEvictionLRU && m.evict() {
+		return false
+	}
+
+	hclog.L().Warn("Cache is full. Dropping service", "service_id", id, "entries", max)
+	m.health.cacheOverflow(false)
+	return true
+}
+
+// Deregister and forget the least recently seen service among those
+// gone from the Mesos state, e.g. waiting out --deregister-delay or a
+// drain, returning false when there is none. Services still in the
+// state are never evicted, so a full cache does not churn them.
+//
+func (m *Mesos) evict() bool {
+	var victim ServiceKey
+	var b *CacheEntry
+	for key, e := range m.ServiceCache {
+		if e.missed == 0 {
+			continue
+		}
+		// The same one on every sync, whatever the map order
+		if b == nil || e.missed > b.missed || e.missed == b.missed && keyLess(key, victim) {
+			victim, b = key, e
+		}
+	}
+	if b == nil {
+		return false
+	}
+
+	hclog.L().Warn("Cache is full. Evicting the least recently seen service", "service_id", victim.ID, "missed", b.missed)
+	m.health.cacheOverflow(true)
+	delete(m.ServiceCache, victim)
+
+	old := *b
+	dc := victim.Datacenter
+	m.write(func(ctx context.Context) error { return m.applier().Deregister(ctx, Registration{dc, old.agent, old.service}) }, func() {
+		m.applied(eventDeregister, reasonEvicted, old.agent, old.service)
+	})
+
+	return true
+}
+
+func keyLess(a, b ServiceKey) bool {
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	return a.Datacenter < b.Datacenter
 }
 
 // deregister items that have gone away
 //
 func (m *Mesos) deregister() {
-	for s, b := range m.ServiceCache {
-		if !b.isRegistered {
-			log.Print("[INFO] Deregistering ", s)
-			m.Consul.Deregister(b.service)
+	defer m.beginSpan("deregister")(nil)
+
+	for _, b := range m.ServiceCache {
+		switch {
+		case b.isRegistered:
+			b.missed = 0
+		case m.finished(b) && b.missed < m.config.DeregisterDelay:
+			b.missed = m.config.DeregisterDelay
+		default:
+			b.missed++
+		}
+	}
 
-			delete(m.ServiceCache, s)
-		} else {
-			m.ServiceCache[s].isRegistered = false
+	confirmed := m.confirmDeregister()
+	drainable := m.drainable()
+
+	var removals []ServiceKey
+	for key, b := range m.ServiceCache {
+		if m.gone(b) && drainable(b) && confirmed(key) {
+			removals = append(removals, key)
+		}
+		b.isRegistered = false
+	}
+
+	if m.tooManyRemovals(len(removals)) {
+		return
+	}
+
+	for _, key := range removals {
+		old := *m.ServiceCache[key]
+		dc := key.Datacenter
+		end := m.terminations[old.service.Meta[taskIDMeta]]
+		hclog.L().Info("Deregistering", append([]interface{}{"service_id", key.ID}, end.logFields()...)...)
+		m.write(func(ctx context.Context) error { return m.applier().Deregister(ctx, Registration{dc, old.agent, old.service}) }, func() {
+			if old.service.Meta[taskIDMeta] != "" {
+				m.health.taskDeregistered(end.reason())
+			}
+			m.appliedEnd(eventDeregister, reasonGone, old.agent, old.service, end)
+		})
+
+		delete(m.ServiceCache, key)
+	}
+}
+
+// With --max-deregister-percent, tell whether removing n services
+// would take more than that share of the cache, e.g. because a master
+// failing over answered with a partial state, and alert instead
+//
+func (m *Mesos) tooManyRemovals(n int) bool {
+	max := m.config.MaxDeregisterPercent
+	if max <= 0 || n == 0 {
+		return false
+	}
+
+	// Orphans of an empty cache are all it would remove
+	percent := 100
+	if len(m.ServiceCache) > 0 {
+		percent = 100 * n / len(m.ServiceCache)
+	}
+	if percent <= max {
+		return false
+	}
+
+	hclog.L().Error("Refusing to deregister more services than allowed. Keeping all of them",
+		"services", n, "cached", len(m.ServiceCache), "percent", percent, "max_percent", max)
+	return true
+}
+
+// Tell whether a service has been missing from --deregister-delay
+// consecutive syncs
+//
+func (m *Mesos) gone(b *CacheEntry) bool {
+	return !b.isRegistered && b.missed >= m.config.DeregisterDelay
+}
+
+// Deregister every cached service and persist the now empty cache,
+// e.g. when shutting down with --deregister-on-shutdown
+//
+func (m *Mesos) DeregisterAll() {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.cacheLock.Lock()
+	defer m.unlockCache()
+
+	m.beginSummary()
+	errors := 0
+	for key, b := range m.ServiceCache {
+		hclog.L().Info("Deregistering", "service_id", key.ID)
+		err := m.applier().Deregister(context.Background(), Registration{key.Datacenter, b.agent, b.service})
+		m.health.consulResult(err)
+		if err != nil {
+			hclog.L().Error("Unable to deregister", "service_id", key.ID, "error", err)
+			errors++
+			continue
+		}
+
+		m.applied(eventDeregister, reasonShutdown, b.agent, b.service)
+		delete(m.ServiceCache, key)
+	}
+	m.clearPending()
+
+	m.saveCache()
+	m.endSummary(nil, errors)
+	m.serviceFiles.Reload()
+}
+
+// With --confirm-deregister, re-fetch the state before removing
+// anything and only confirm the candidates that are still gone from
+// it. Without it, every candidate is confirmed.
+//
+func (m *Mesos) confirmDeregister() func(ServiceKey) bool {
+	all := func(ServiceKey) bool { return true }
+	if !m.config.ConfirmDeregister {
+		return all
+	}
+
+	candidates := 0
+	for _, b := range m.ServiceCache {
+		if m.gone(b) {
+			candidates++
+		}
+	}
+	if candidates == 0 {
+		return all
+	}
+
+	hclog.L().Info("Confirming deregistrations against a fresh state", "services", candidates)
+	sj, err := m.loadState()
+	if err == nil && sj.Leader == "" {
+		err = fmt.Errorf("Empty master")
+	}
+	if err != nil {
+		hclog.L().Warn("Unable to confirm deregistrations. Keeping services", "error", err)
+		return func(ServiceKey) bool { return false }
+	}
+
+	running := m.stateServiceKeys(m.filterState(sj))
+	return func(key ServiceKey) bool {
+		if running[key] {
+			hclog.L().Warn("Service is back in the state. Not deregistering", "service_id", key.ID)
+			return false
 		}
+		return true
 	}
 }
@@ -0,0 +1,588 @@
+package mesos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/consul"
+	"github.com/CiscoCloud/mesos-consul/registry"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestHostServicesFollowerRoles(t *testing.T) {
+	c := config.DefaultConfig()
+	c.FollowerRoles = []string{"web"}
+
+	m := &Mesos{
+		config:  c,
+		Masters: &[]MesosHost{},
+	}
+
+	sj := StateJSON{
+		Followers: Followers{
+			{Id: "1", Hostname: "10.0.0.1", Pid: "slave(1)@10.0.0.1:5051", Attributes: map[string]interface{}{"role": "web"}},
+			{Id: "2", Hostname: "10.0.0.2", Pid: "slave(1)@10.0.0.2:5051", Attributes: map[string]interface{}{"role": "db"}},
+			{Id: "3", Hostname: "10.0.0.3", Pid: "slave(1)@10.0.0.3:5051"},
+		},
+	}
+
+	services := m.hostServices(sj)
+	if len(services) != 1 {
+		t.Fatalf("expected 1 follower, got %d", len(services))
+	}
+
+	if id := services[0].ID; id != "mesos-consul:mesos:1:10.0.0.1" {
+		t.Errorf("unexpected follower registered: %s", id)
+	}
+
+	c.FollowerRoles = nil
+	if services := m.hostServices(sj); len(services) != 3 {
+		t.Errorf("expected all 3 followers without a filter, got %d", len(services))
+	}
+}
+
+func TestHostServicesResources(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c, Masters: &[]MesosHost{}}
+
+	sj := StateJSON{
+		Followers: Followers{{
+			Id: "1", Hostname: "10.0.0.1", Pid: "slave(1)@10.0.0.1:5051",
+			Resources:    map[string]interface{}{"cpus": 8.0, "gpus": 2.0, "fpgas": 0.0, "ports": "[31000-32000]"},
+			Capabilities: []string{"MULTI_ROLE", "RESERVATION_REFINEMENT"},
+		}},
+	}
+
+	if s := m.hostServices(sj)[0]; len(s.Tags) != 1 || s.Meta != nil {
+		t.Errorf("expected no resources by default, got %v %v", s.Tags, s.Meta)
+	}
+
+	c.FollowerResources = []string{"gpus", "fpgas", "ports", "disk"}
+	c.FollowerCapabilities = true
+	s := m.hostServices(sj)[0]
+	if want := []string{"follower", "gpus", "capability-multi-role", "capability-reservation-refinement"}; !reflect.DeepEqual(s.Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, s.Tags)
+	}
+	if want := map[string]string{"resource-gpus": "2", "resource-fpgas": "0"}; !reflect.DeepEqual(s.Meta, want) {
+		t.Errorf("expected meta %v, got %v", want, s.Meta)
+	}
+
+	c.FollowerResources = []string{"*"}
+	c.FollowerCapabilities = false
+	s = m.hostServices(sj)[0]
+	if want := []string{"follower", "cpus", "gpus"}; !reflect.DeepEqual(s.Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, s.Tags)
+	}
+	if len(s.Meta) != 3 {
+		t.Errorf("expected the scalar resources, got %v", s.Meta)
+	}
+}
+
+func TestHostServicesCheck(t *testing.T) {
+	c := config.DefaultConfig()
+
+	m := &Mesos{
+		config:  c,
+		Masters: &[]MesosHost{},
+	}
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1", Pid: "slave(1)@10.0.0.1:5051"}},
+	}
+
+	check := m.hostServices(sj)[0].Check
+	if check.Interval != "10s" || check.Timeout != "" || check.DeregisterCriticalServiceAfter != "" {
+		t.Errorf("unexpected default check: %+v", check)
+	}
+
+	c.MesosCheckInterval = 30 * time.Second
+	c.MesosCheckTimeout = 2 * time.Second
+	c.MesosCheckDeregisterCriticalAfter = 10 * time.Minute
+	check = m.hostServices(sj)[0].Check
+	if check.HTTP != "http://10.0.0.1:5051/slave(1)/health" {
+		t.Errorf("unexpected check URL: %s", check.HTTP)
+	}
+	if check.Interval != "30s" || check.Timeout != "2s" || check.DeregisterCriticalServiceAfter != "10m0s" {
+		t.Errorf("unexpected check: %+v", check)
+	}
+}
+
+func TestHostServicesEndpoints(t *testing.T) {
+	c := config.DefaultConfig()
+	c.RegisterMesosEndpoints = true
+	c.MasterTags = []string{"prod"}
+
+	m := &Mesos{
+		config:  c,
+		Masters: &[]MesosHost{{host: "10.0.0.9", port: "5050", isLeader: true}},
+	}
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1", Pid: "slave(1)@10.0.0.1:5051"}},
+	}
+
+	byName := make(map[string]*consulapi.AgentServiceRegistration)
+	for _, s := range m.hostServices(sj) {
+		byName[s.Name] = s
+	}
+	if len(byName) != 5 {
+		t.Fatalf("expected the mesos services and 4 endpoint services, got %v", byName)
+	}
+
+	ui := byName["mesos-master-ui"]
+	if ui.ID != "mesos-consul:mesos-master-ui:10.0.0.9:5050" || ui.Port != 5050 || ui.Meta["url"] != "http://10.0.0.9:5050/" {
+		t.Errorf("unexpected master UI service: %+v", ui)
+	}
+	if want := []string{"leader", "prod"}; !reflect.DeepEqual(ui.Tags, want) || ui.Check.HTTP != "http://10.0.0.9:5050/master/health" {
+		t.Errorf("expected the leader tagged %v and checked on its health, got %v %s", want, ui.Tags, ui.Check.HTTP)
+	}
+
+	metrics := byName["mesos-agent-metrics"]
+	if metrics.ID != "mesos-consul:mesos-agent-metrics:1:10.0.0.1" || metrics.Port != 5051 || metrics.Meta["metrics_path"] != "/metrics/snapshot" {
+		t.Errorf("unexpected agent metrics service: %+v", metrics)
+	}
+	if metrics.Check.HTTP != "http://10.0.0.1:5051/metrics/snapshot" || metrics.Check.Interval != "10s" {
+		t.Errorf("expected the metrics checked with the mesos-check options, got %+v", metrics.Check)
+	}
+
+	c.RegisterMesosEndpoints = false
+	if services := m.hostServices(sj); len(services) != 2 {
+		t.Errorf("expected only the mesos services by default, got %d", len(services))
+	}
+}
+
+func TestRegisterCacheFull(t *testing.T) {
+	c := config.DefaultConfig()
+	c.MaxCacheEntries = 1
+
+	m := &Mesos{
+		config: c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web"}},
+		},
+	}
+
+	// A cached service is still marked while the cache is full
+	m.register(localDatacenter, &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web"})
+	if !m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}].isRegistered {
+		t.Error("expected cached service to be marked registered")
+	}
+
+	// A new one is dropped without reaching Consul
+	m.register(localDatacenter, &consulapi.AgentServiceRegistration{ID: "mesos-consul:b", Name: "web"})
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:b", localDatacenter}]; ok {
+		t.Error("expected new service to be dropped from a full cache")
+	}
+}
+
+func TestRegisterCacheEviction(t *testing.T) {
+	r := newFakeRegistry()
+	c := config.DefaultConfig()
+	c.MaxCacheEntries = 3
+	c.CacheEviction = config.CacheEvictionLRU
+
+	entry := func(id string, missed int) *CacheEntry {
+		return &CacheEntry{service: &consulapi.AgentServiceRegistration{ID: id}, missed: missed}
+	}
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:running", localDatacenter}: entry("mesos-consul:running", 0),
+			{"mesos-consul:recent", localDatacenter}:  entry("mesos-consul:recent", 1),
+			{"mesos-consul:old", localDatacenter}:     entry("mesos-consul:old", 2),
+		},
+	}
+
+	m.register(localDatacenter, &consulapi.AgentServiceRegistration{ID: "mesos-consul:b", Name: "web"})
+	_, deregistered := r.deregistered["mesos-consul:old"]
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:old", localDatacenter}]; ok || !deregistered || len(r.deregistered) != 1 {
+		t.Errorf("expected the least recently seen service to be evicted, got %v", r.deregistered)
+	}
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:b", localDatacenter}]; !ok {
+		t.Error("expected the new service to be cached")
+	}
+
+	m.register(localDatacenter, &consulapi.AgentServiceRegistration{ID: "mesos-consul:c", Name: "web"})
+	m.register(localDatacenter, &consulapi.AgentServiceRegistration{ID: "mesos-consul:d", Name: "web"})
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:d", localDatacenter}]; ok || len(m.ServiceCache) != 3 {
+		t.Errorf("expected running services never to be evicted, got %v", m.ServiceCache)
+	}
+	if m.health.evictionsTotal != 2 || m.health.dropsTotal != 1 {
+		t.Errorf("expected 2 evictions and 1 drop, got %d and %d", m.health.evictionsTotal, m.health.dropsTotal)
+	}
+}
+
+func TestMarkAndSweepPerDatacenter(t *testing.T) {
+	c := config.DefaultConfig()
+
+	// Nothing listens on the registry port, so deregistrations fail
+	// fast and are only logged
+	c.RegistryPort = "1"
+
+	service := func() *consulapi.AgentServiceRegistration {
+		return &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web", Address: "127.0.0.1"}
+	}
+
+	local := ServiceKey{"mesos-consul:a", localDatacenter}
+	dc2 := ServiceKey{"mesos-consul:a", "dc2"}
+
+	m := &Mesos{
+		Registry: consul.NewConsul(c),
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			local: {service: service(), agent: "127.0.0.1"},
+			dc2:   {service: service(), agent: "127.0.0.1"},
+		},
+	}
+
+	// Marking the service in one datacenter leaves the other alone
+	m.register(localDatacenter, service())
+	if !m.ServiceCache[local].isRegistered {
+		t.Error("expected the local entry to be marked")
+	}
+	if m.ServiceCache[dc2].isRegistered {
+		t.Error("expected the dc2 entry to stay unmarked")
+	}
+
+	m.deregister()
+
+	if _, ok := m.ServiceCache[local]; !ok {
+		t.Error("expected the marked local entry to survive the sweep")
+	}
+	if _, ok := m.ServiceCache[dc2]; ok {
+		t.Error("expected the unmarked dc2 entry to be swept")
+	}
+	if m.ServiceCache[local].isRegistered {
+		t.Error("expected the sweep to reset marks")
+	}
+}
+
+func TestHostTags(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	if tags := m.hostTags([]string{"follower"}, []string{"rack-a"}); !reflect.DeepEqual(tags, []string{"follower", "rack-a"}) {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+
+	c.NoDefaultTags = true
+	if tags := m.hostTags([]string{"follower"}, []string{"rack-a"}); !reflect.DeepEqual(tags, []string{"rack-a"}) {
+		t.Errorf("unexpected tags without defaults: %v", tags)
+	}
+
+	if tags := m.hostTags([]string{"follower"}, nil); len(tags) != 0 {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+}
+
+// A registry recording the agents services are registered with
+type fakeRegistry struct {
+	registered   map[string]string
+	deregistered map[string]string
+	services     []*consulapi.AgentServiceRegistration
+	nodes        map[string]string
+	maintenance  map[string]bool
+	queries      []*consulapi.PreparedQueryDefinition
+	checks       []*consulapi.HealthCheck
+	// The index WaitServices returns
+	index uint64
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{registered: map[string]string{}, deregistered: map[string]string{}}
+}
+
+func (r *fakeRegistry) Register(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	r.registered[s.ID] = agent
+	return nil
+}
+
+func (r *fakeRegistry) Deregister(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	r.deregistered[s.ID] = agent
+	return nil
+}
+
+// The services, held by the agent of nodes or else the one on their
+// address
+func (r *fakeRegistry) Services(context.Context, string) ([]*registry.Service, error) {
+	var services []*registry.Service
+	for _, s := range r.services {
+		agent, ok := r.nodes[s.ID]
+		if !ok {
+			agent = s.Address
+		}
+		services = append(services, &registry.Service{AgentServiceRegistration: s, Agent: agent})
+	}
+	return services, nil
+}
+func (r *fakeRegistry) WaitServices(context.Context, uint64, time.Duration) (uint64, error) {
+	return r.index, nil
+}
+func (r *fakeRegistry) Checks(context.Context, string) ([]*consulapi.HealthCheck, error) {
+	return r.checks, nil
+}
+func (r *fakeRegistry) Get(context.Context, string, uint64, time.Duration) ([]byte, uint64, error) {
+	return nil, 0, nil
+}
+func (r *fakeRegistry) Put(context.Context, string, []byte) error { return nil }
+func (r *fakeRegistry) List(context.Context, string, uint64, time.Duration) (map[string][]byte, uint64, error) {
+	return nil, 0, nil
+}
+func (r *fakeRegistry) Txn(context.Context, map[string][]byte, []string) error        { return nil }
+func (r *fakeRegistry) CheckRegistration(ctx context.Context) error                   { return nil }
+func (r *fakeRegistry) UpdateTTL(context.Context, string, string, bool, string) error { return nil }
+func (r *fakeRegistry) Maintenance(ctx context.Context, agent string, enable bool, reason string) error {
+	if r.maintenance == nil {
+		r.maintenance = map[string]bool{}
+	}
+	r.maintenance[agent] = enable
+	return nil
+}
+func (r *fakeRegistry) FireEvent(context.Context, string, string, *consulapi.AgentServiceRegistration) error {
+	return nil
+}
+func (r *fakeRegistry) Queries(context.Context) ([]*consulapi.PreparedQueryDefinition, error) {
+	return append([]*consulapi.PreparedQueryDefinition(nil), r.queries...), nil
+}
+func (r *fakeRegistry) SetQuery(ctx context.Context, q *consulapi.PreparedQueryDefinition) error {
+	for i, old := range r.queries {
+		if old.ID == q.ID {
+			r.queries[i] = q
+			return nil
+		}
+	}
+	q.ID = "q-" + q.Name
+	r.queries = append(r.queries, q)
+	return nil
+}
+func (r *fakeRegistry) DeleteQuery(ctx context.Context, id string) error {
+	for i, q := range r.queries {
+		if q.ID == id {
+			r.queries = append(r.queries[:i], r.queries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestRegisterAtMovesService(t *testing.T) {
+	r := newFakeRegistry()
+	key := ServiceKey{"mesos-consul:a", localDatacenter}
+	service := &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web", Address: "172.17.0.2"}
+
+	m := &Mesos{
+		Registry: r,
+		config:   config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			key: {service: service, agent: "172.17.0.2"},
+		},
+	}
+
+	m.registerAt(localDatacenter, "172.17.0.2", service)
+	if len(r.registered) != 0 || !m.ServiceCache[key].isRegistered {
+		t.Errorf("expected the cached service to be kept, got %v", r.registered)
+	}
+
+	m.registerAt(localDatacenter, "10.0.0.1", service)
+	if r.deregistered["mesos-consul:a"] != "172.17.0.2" || r.registered["mesos-consul:a"] != "10.0.0.1" {
+		t.Errorf("expected the service to move agents, got %v and %v", r.deregistered, r.registered)
+	}
+	if m.ServiceCache[key].agent != "10.0.0.1" {
+		t.Errorf("expected the new agent to be cached, got %s", m.ServiceCache[key].agent)
+	}
+}
+
+func TestDeregisterAll(t *testing.T) {
+	r := newFakeRegistry()
+
+	m := &Mesos{
+		Registry: r,
+		config:   config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			{"mesos-consul:a", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}, agent: "10.0.0.1", isRegistered: true},
+			{"mesos-consul:b", localDatacenter}: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:b"}},
+		},
+	}
+
+	m.DeregisterAll()
+
+	if len(m.ServiceCache) != 0 {
+		t.Errorf("expected an empty cache, got %d entries", len(m.ServiceCache))
+	}
+	if len(r.deregistered) != 2 || r.deregistered["mesos-consul:a"] != "10.0.0.1" {
+		t.Errorf("expected both services deregistered from their agents, got %v", r.deregistered)
+	}
+}
+
+func TestDeregisterDelay(t *testing.T) {
+	r := newFakeRegistry()
+	c := config.DefaultConfig()
+	c.DeregisterDelay = 2
+
+	key := ServiceKey{"mesos-consul:a", localDatacenter}
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			key: {service: &consulapi.AgentServiceRegistration{ID: "mesos-consul:a"}, agent: "10.0.0.1"},
+		},
+	}
+
+	m.deregister()
+	if _, ok := m.ServiceCache[key]; !ok || len(r.deregistered) != 0 {
+		t.Fatal("expected the service to survive its first missed sync")
+	}
+
+	// Coming back resets the count
+	m.ServiceCache[key].isRegistered = true
+	m.deregister()
+	m.deregister()
+	if _, ok := m.ServiceCache[key]; !ok {
+		t.Fatal("expected the count to restart once the service is seen again")
+	}
+
+	m.deregister()
+	if _, ok := m.ServiceCache[key]; ok || r.deregistered["mesos-consul:a"] != "10.0.0.1" {
+		t.Errorf("expected the service deregistered after 2 missed syncs, got %v", r.deregistered)
+	}
+}
+
+func TestMaxDeregisterPercent(t *testing.T) {
+	r := newFakeRegistry()
+	c := config.DefaultConfig()
+	c.MaxDeregisterPercent = 50
+
+	m := &Mesos{
+		Registry:     r,
+		config:       c,
+		ServiceCache: make(map[ServiceKey]*CacheEntry),
+	}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		id = "mesos-consul:" + id
+		m.ServiceCache[ServiceKey{id, localDatacenter}] = &CacheEntry{service: &consulapi.AgentServiceRegistration{ID: id}}
+	}
+
+	// Three of four gone is over the threshold
+	m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}].isRegistered = true
+	m.deregister()
+	if len(m.ServiceCache) != 4 || len(r.deregistered) != 0 {
+		t.Fatalf("expected no deregistrations, got %v", r.deregistered)
+	}
+
+	// Two of four is not
+	m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}].isRegistered = true
+	m.ServiceCache[ServiceKey{"mesos-consul:b", localDatacenter}].isRegistered = true
+	m.deregister()
+	if len(m.ServiceCache) != 2 || len(r.deregistered) != 2 {
+		t.Errorf("expected 2 deregistrations, got %v", r.deregistered)
+	}
+}
+
+func TestServiceChanged(t *testing.T) {
+	cached := &consulapi.AgentServiceRegistration{
+		ID:      "mesos-consul:web",
+		Tags:    []string{"a", "b"},
+		Address: "10.0.0.1",
+		Port:    31000,
+		Meta:    map[string]string{"version": "1"},
+		Check:   &consulapi.AgentServiceCheck{TTL: "180s", Status: "passing", Notes: "healthy"},
+	}
+
+	same := *cached
+	same.Tags = []string{"b", "a"}
+	same.Meta = map[string]string{"version": "1"}
+	same.Check = &consulapi.AgentServiceCheck{TTL: "180s", Status: "critical", Notes: "unhealthy"}
+	same.TaggedAddresses = map[string]consulapi.ServiceAddress{}
+	if serviceChanged(cached, &same) {
+		t.Error("expected reordered tags, a new TTL status and no tagged addresses to be no change")
+	}
+
+	changes := []func(s *consulapi.AgentServiceRegistration){
+		func(s *consulapi.AgentServiceRegistration) { s.Tags = []string{"a"} },
+		func(s *consulapi.AgentServiceRegistration) { s.Tags = []string{"a", "c"} },
+		func(s *consulapi.AgentServiceRegistration) { s.Address = "10.0.0.2" },
+		func(s *consulapi.AgentServiceRegistration) { s.Port = 31001 },
+		func(s *consulapi.AgentServiceRegistration) { s.Meta = map[string]string{"version": "2"} },
+		func(s *consulapi.AgentServiceRegistration) { s.Check = &consulapi.AgentServiceCheck{TTL: "60s"} },
+		func(s *consulapi.AgentServiceRegistration) { s.Check = nil },
+		func(s *consulapi.AgentServiceRegistration) { s.Name = "api" },
+		func(s *consulapi.AgentServiceRegistration) {
+			s.Weights = &consulapi.AgentWeights{Passing: 3, Warning: 1}
+		},
+		func(s *consulapi.AgentServiceRegistration) { s.EnableTagOverride = true },
+		func(s *consulapi.AgentServiceRegistration) {
+			s.TaggedAddresses = map[string]consulapi.ServiceAddress{"wan": {Address: "1.2.3.4", Port: 31000}}
+		},
+		func(s *consulapi.AgentServiceRegistration) { s.Connect = &consulapi.AgentServiceConnect{Native: true} },
+	}
+	for i, change := range changes {
+		s := same
+		change(&s)
+		if !serviceChanged(cached, &s) {
+			t.Errorf("change %d: expected the service to have changed", i)
+		}
+	}
+}
+
+// A fakeRegistry rejecting the registrations of the services named in
+// reject
+type rejectRegistry struct {
+	fakeRegistry
+	reject map[string]bool
+}
+
+func (r *rejectRegistry) Register(ctx context.Context, agent string, s *consulapi.AgentServiceRegistration) error {
+	if r.reject[s.Name] {
+		return errors.New("invalid service name")
+	}
+
+	return r.fakeRegistry.Register(ctx, agent, s)
+}
+
+func TestFrameworkRegistrationFailures(t *testing.T) {
+	r := &rejectRegistry{fakeRegistry: *newFakeRegistry(), reject: map[string]bool{"rejected": true}}
+	m := &Mesos{Registry: r, config: config.DefaultConfig(), ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+	m.health.begin()
+	m.beginSummary()
+	summary := m.summary
+	m.registerAt(localDatacenter, "10.0.0.1", &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "rejected", Meta: map[string]string{frameworkMeta: "chronos"}})
+	m.registerAt(localDatacenter, "10.0.0.1", &consulapi.AgentServiceRegistration{ID: "mesos-consul:b", Name: "web", Meta: map[string]string{frameworkMeta: "marathon"}})
+	m.forgetFailed()
+	m.endSummary(nil, m.health.syncErrors())
+	m.health.end(nil, nil)
+
+	if len(r.registered) != 1 || r.registered["mesos-consul:b"] == "" {
+		t.Errorf("expected the other framework to be registered, got %v", r.registered)
+	}
+	if _, ok := m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}]; ok || len(m.ServiceCache) != 1 {
+		t.Errorf("expected the failed registration to be left out of the cache for a retry, got %v", m.ServiceCache)
+	}
+	if !reflect.DeepEqual(summary.FrameworkErrors, map[string]int{"chronos": 1}) {
+		t.Errorf("expected one chronos failure, got %v", summary.FrameworkErrors)
+	}
+	if f := m.health.lastFrameworks; f["chronos"].failed != 1 || f["marathon"].registered != 1 {
+		t.Errorf("unexpected framework registrations %+v %+v", f["chronos"], f["marathon"])
+	}
+}
+
+func TestLoadCacheAgent(t *testing.T) {
+	r := newFakeRegistry()
+	r.services = []*consulapi.AgentServiceRegistration{{ID: "mesos-consul:web", Address: "172.17.0.2"}}
+	r.nodes = map[string]string{"mesos-consul:web": "10.0.0.1"}
+
+	m := &Mesos{Registry: r, config: config.DefaultConfig(), ServiceCache: map[ServiceKey]*CacheEntry{}}
+	if err := m.LoadCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := m.ServiceCache[ServiceKey{"mesos-consul:web", localDatacenter}]; e == nil || e.agent != "10.0.0.1" {
+		t.Errorf("expected the agent of the service's node rather than its address, got %+v", e)
+	}
+}
@@ -0,0 +1,143 @@
+package mesos
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The meta key of the URL of the sandbox of a task in the Mesos UI,
+// see --sandbox-url
+const sandboxMeta = "sandbox_url"
+
+// The part of an agent's /flags the sandboxes of its tasks are found
+// from
+type agentFlags struct {
+	Flags struct {
+		WorkDir string `json:"work_dir"`
+	} `json:"flags"`
+}
+
+// With --sandbox-url, read the work_dir of the agents running tasks,
+// those not read yet, --agent-concurrency at a time. The work
+// directory of an agent does not change while it keeps its ID, so it
+// is read once. Agents that cannot be read are tried again on the next
+// sync, their tasks registered without a sandbox URL meanwhile.
+func (m *Mesos) loadWorkDirs(sj StateJSON) {
+	if m.config.SandboxURL == "" {
+		return
+	}
+
+	running := make(map[string]bool)
+	for _, fw := range sj.Frameworks {
+		for _, task := range fw.Tasks {
+			if task.State == "TASK_RUNNING" {
+				running[task.FollowerId] = true
+			}
+		}
+	}
+
+	workDirs := make(map[string]string, len(running))
+	var missing []*follower
+	for id := range running {
+		if dir, ok := m.workDirs[id]; ok {
+			workDirs[id] = dir
+		} else if f, err := sj.Followers.byId(id); err == nil {
+			missing = append(missing, f)
+		}
+	}
+
+	start := time.Now()
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	n := m.config.AgentConcurrency
+	if n < 1 {
+		n = 1
+	}
+	slots := make(chan struct{}, n)
+	for _, f := range missing {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(f *follower) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			dir, err := m.loadWorkDir(f)
+			if err != nil {
+				hclog.L().Warn("Unable to read the agent flags", "agent", f.Id, "hostname", f.Hostname, "error", err)
+				return
+			}
+
+			lock.Lock()
+			workDirs[f.Id] = dir
+			lock.Unlock()
+		}(f)
+	}
+	wg.Wait()
+	if len(missing) > 0 {
+		hclog.L().Debug("Read the agent flags", "agents", len(missing), "duration", time.Since(start))
+	}
+
+	// Agents gone from the state are forgotten
+	m.workDirs = workDirs
+}
+
+// Read the work_dir flag of an agent
+func (m *Mesos) loadWorkDir(f *follower) (string, error) {
+	host, port, err := parsePID(f.Pid, m.config.PidParseStrict)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := m.agentTimeout()
+	defer cancel()
+
+	var flags agentFlags
+	if err := m.requestJSONContext(ctx, "GET", m.mesosURL(hostPort(host, port), "/flags"), "", &flags); err != nil {
+		return "", err
+	}
+
+	return flags.Flags.WorkDir, nil
+}
+
+// The URL of the sandbox of task in the Mesos UI of --sandbox-url,
+// e.g. http://mesos.example.com/#/agents/<agent>/browse?path=<sandbox>,
+// or empty when the work_dir of its agent is unknown. The sandbox is
+// <work_dir>/slaves/<agent>/frameworks/<framework>/executors/<executor>/runs/<container>,
+// the latest run when the container is unknown, and the tasks of a
+// pod have theirs under the one of the executor of the pod.
+func (m *Mesos) sandboxURL(task *Task) string {
+	dir := m.workDirs[task.FollowerId]
+	if m.config.SandboxURL == "" || dir == "" {
+		return ""
+	}
+
+	executor := task.ExecutorId
+	if executor == "" {
+		// The command executor is named after its task
+		executor = task.Id
+	}
+
+	run := []string{"latest"}
+	for i := len(task.Statuses) - 1; i >= 0; i-- {
+		id := task.Statuses[i].ContainerStatus.ContainerID
+		if id.Value == "" {
+			continue
+		}
+		run = []string{id.Value}
+		if id.Parent != nil {
+			run = []string{id.Parent.Value, "tasks", task.Id}
+		}
+		break
+	}
+
+	sandbox := path.Join(append([]string{dir, "slaves", task.FollowerId, "frameworks", task.FrameworkId, "executors", executor, "runs"}, run...)...)
+
+	return strings.TrimRight(m.config.SandboxURL, "/") + "/#/agents/" + url.PathEscape(task.FollowerId) + "/browse?path=" + url.QueryEscape(sandbox)
+}
@@ -0,0 +1,52 @@
+package mesos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestSandboxURL(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/flags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"flags":{"work_dir":"/var/lib/mesos"}}`))
+	}))
+	defer agent.Close()
+
+	c := config.DefaultConfig()
+	c.SandboxURL = "http://mesos.example.com:5050/"
+	m := &Mesos{config: c}
+
+	web := Task{Id: "web.1", FrameworkId: "fw-1", FollowerId: "a-1", State: "TASK_RUNNING",
+		Statuses: []Status{{ContainerStatus: ContainerStatus{ContainerID: ContainerID{Value: "c-1"}}}}}
+	nested := Task{Id: "db.1", FrameworkId: "fw-1", FollowerId: "a-1", ExecutorId: "instance-db", State: "TASK_RUNNING",
+		Statuses: []Status{{ContainerStatus: ContainerStatus{ContainerID: ContainerID{Value: "c-3", Parent: &ContainerID{Value: "c-2"}}}}}}
+	other := Task{Id: "cron.1", FrameworkId: "fw-1", FollowerId: "a-2", State: "TASK_RUNNING"}
+
+	sj := StateJSON{
+		Frameworks: Frameworks{{Tasks: Tasks{web, nested, other}}},
+		Followers:  Followers{{Id: "a-1", Pid: "slave(1)@" + strings.TrimPrefix(agent.URL, "http://")}, {Id: "a-2", Pid: "slave(1)@127.0.0.1:1"}},
+	}
+	m.loadWorkDirs(sj)
+
+	want := "http://mesos.example.com:5050/#/agents/a-1/browse?path=%2Fvar%2Flib%2Fmesos%2Fslaves%2Fa-1%2Fframeworks%2Ffw-1%2Fexecutors%2Fweb.1%2Fruns%2Fc-1"
+	if got := m.sandboxURL(&web); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if got := m.sandboxURL(&nested); !strings.HasSuffix(got, "executors%2Finstance-db%2Fruns%2Fc-2%2Ftasks%2Fdb.1") {
+		t.Errorf("expected the sandbox of the pod task under its executor, got %s", got)
+	}
+	if got := m.sandboxURL(&other); got != "" {
+		t.Errorf("expected no URL for an agent that cannot be read, got %s", got)
+	}
+	web.Statuses = nil
+	if got := m.sandboxURL(&web); !strings.HasSuffix(got, "runs%2Flatest") {
+		t.Errorf("expected the latest run without a container, got %s", got)
+	}
+}
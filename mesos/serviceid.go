@@ -0,0 +1,81 @@
+package mesos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The attributes of a task service available to --service-id-template,
+// on top of those of --service-tags-template
+type idData struct {
+	tagData
+
+	Name      string
+	Address   string
+	Port      int
+	Instance  int
+	PortIndex int
+}
+
+// The template of --service-id-scheme=hash: IDs that stay the same
+// when the task is relaunched on another host or port, as long as it
+// keeps its instance index
+const HashIDTemplate = `{{hash .FrameworkName .TaskName .Instance .PortIndex}}`
+
+// ServiceIDFuncs are the functions of --service-id-template on top of
+// the builtin ones:
+//
+//	hash  the first 16 hex digits of the SHA-256 of its arguments
+var ServiceIDFuncs = template.FuncMap{
+	"hash": hashID,
+}
+
+func hashID(values ...interface{}) string {
+	h := sha256.New()
+	for _, v := range values {
+		fmt.Fprint(h, v)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Whether --service-id-template renders the instance index of tasks,
+// which are then numbered even without --instance-tags
+func (m *Mesos) idInstances() bool {
+	return m.idTemplate != nil && strings.Contains(m.config.ServiceIDTemplate, ".Instance")
+}
+
+// Characters replaced in rendered service IDs, which end up in the
+// paths of the Consul agent API
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9_.:-]+`)
+
+// The ID of a task service: --service-id-template rendered for it and
+// prefixed with mesos-consul:, which marks the services of
+// mesos-consul, or id without a template or when it renders nothing
+func (m *Mesos) taskServiceID(d idData, id string) string {
+	if m.idTemplate == nil {
+		return id
+	}
+
+	var buf bytes.Buffer
+	if err := m.idTemplate.Execute(&buf, d); err != nil {
+		hclog.L().Warn("Unable to render the service ID template", append(d.task.logFields(), "error", err)...)
+		return id
+	}
+
+	rendered := invalidIDChars.ReplaceAllString(strings.TrimSpace(buf.String()), "-")
+	if rendered == "" {
+		hclog.L().Warn("Empty service ID rendered. Using the default one", d.task.logFields()...)
+		return id
+	}
+
+	return "mesos-consul:" + rendered
+}
@@ -0,0 +1,83 @@
+package mesos
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestServiceIDTemplate(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+	m.idTemplate = template.Must(template.New("service-id").Parse(`{{.FrameworkName}}:{{.TaskId}}`))
+
+	sj := StateJSON{
+		Followers: Followers{{Id: "1", Hostname: "10.0.0.1"}},
+		Frameworks: Frameworks{
+			{Name: "my framework", Tasks: Tasks{
+				{
+					Id:         "web.1",
+					Name:       "web",
+					FollowerId: "1",
+					State:      "TASK_RUNNING",
+					Resources:  Resources{Ports: "[31000-31002]"},
+				},
+			}},
+		},
+	}
+
+	services, _ := m.taskServices(sj)
+	if len(services) != 3 {
+		t.Fatalf("expected 3 services, got %d", len(services))
+	}
+
+	want := []string{"mesos-consul:my-framework:web.1", "mesos-consul:my-framework:web.1:31001", "mesos-consul:my-framework:web.1:31002"}
+	for i, s := range services {
+		if s.ID != want[i] {
+			t.Errorf("expected ID %s, got %s", want[i], s.ID)
+		}
+	}
+
+	m.idTemplate = template.Must(template.New("service-id").Parse(`{{.Label "missing"}}`))
+	if services, _ := m.taskServices(sj); services[0].ID != "mesos-consul:10.0.0.1:web:31000" {
+		t.Errorf("expected the default ID of an empty rendering, got %s", services[0].ID)
+	}
+}
+
+func TestHashServiceIDs(t *testing.T) {
+	c := config.DefaultConfig()
+	c.ServiceIDTemplate = HashIDTemplate
+	m := &Mesos{config: c, ServiceCache: map[ServiceKey]*CacheEntry{}}
+	m.idTemplate = template.Must(template.New("service-id").Funcs(ServiceIDFuncs).Parse(HashIDTemplate))
+
+	state := func(host string, id string, ports string) StateJSON {
+		return StateJSON{
+			Followers: Followers{{Id: "1", Hostname: host}},
+			Frameworks: Frameworks{
+				{Name: "marathon", Tasks: Tasks{
+					{Id: id, Name: "web", FollowerId: "1", State: "TASK_RUNNING", Resources: Resources{Ports: ports}},
+				}},
+			},
+		}
+	}
+
+	before, _ := m.taskServices(state("10.0.0.1", "web.1", "[31000-31001]"))
+	if len(before) != 2 || before[0].ID == before[1].ID {
+		t.Fatalf("expected 2 services with their own IDs, got %v", before)
+	}
+	if want := "mesos-consul:" + hashID("marathon", "web", 0, 0); before[0].ID != want {
+		t.Errorf("expected ID %s, got %s", want, before[0].ID)
+	}
+	if len(before[0].Tags) != 0 || before[0].Meta[instanceMeta] != "0" {
+		t.Errorf("expected the instance in the meta only, got %v, %v", before[0].Tags, before[0].Meta)
+	}
+
+	// The task replacing it elsewhere takes over its IDs
+	after, _ := m.taskServices(state("10.0.0.2", "web.2", "[31500-31501]"))
+	for i := range after {
+		if after[i].ID != before[i].ID {
+			t.Errorf("expected ID %s to survive the move, got %s", before[i].ID, after[i].ID)
+		}
+	}
+}
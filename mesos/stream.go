@@ -0,0 +1,140 @@
+package mesos
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The operator API events that change what is registered
+var streamEvents = map[string]bool{
+	"SUBSCRIBED":        true,
+	"TASK_ADDED":        true,
+	"TASK_UPDATED":      true,
+	"AGENT_ADDED":       true,
+	"AGENT_REMOVED":     true,
+	"FRAMEWORK_ADDED":   true,
+	"FRAMEWORK_UPDATED": true,
+	"FRAMEWORK_REMOVED": true,
+}
+
+// WatchEvents subscribes to the leader's v1 operator API event stream
+// and signals on the returned channel whenever tasks, agents or
+// frameworks change, so the caller can sync right away instead of
+// waiting for the next poll. Bursts of events are coalesced into one
+// signal. When the stream drops it is re-established with a backoff;
+// polling carries on in the meantime.
+func (m *Mesos) WatchEvents() <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		backoff := time.Second
+		for {
+			start := time.Now()
+			err := m.subscribe(changes)
+			hclog.L().Warn("Mesos event stream dropped. Falling back to polling", "error", err)
+
+			if time.Since(start) > time.Minute {
+				backoff = time.Second
+			}
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}()
+
+	return changes
+}
+
+// Subscribe to the leader's event stream until it drops
+func (m *Mesos) subscribe(changes chan<- struct{}) error {
+	ip, port := m.getLeader()
+	if ip == "" {
+		return fmt.Errorf("No master in zookeeper")
+	}
+
+	url := m.mesosURL(hostPort(ip, port), "/api/v1")
+	req, err := http.NewRequest("POST", url, strings.NewReader(`{"type":"SUBSCRIBE"}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	m.authenticate(req)
+
+	resp, err := m.mesosClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	hclog.L().Info("Subscribed to the Mesos event stream", "master", ip)
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		record, err := readRecord(r)
+		if err != nil {
+			return err
+		}
+
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(record, &event); err != nil {
+			return err
+		}
+
+		if !streamEvents[event.Type] {
+			continue
+		}
+
+		hclog.L().Debug("Mesos event", "type", event.Type)
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Read one RecordIO record: its length in bytes, a newline and the
+// record itself
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid RecordIO header: %q", header)
+	}
+
+	record := make([]byte, n)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(record), nil
+}
+
+// ExpireState forces the next Refresh to fetch a fresh state even
+// with --state-refresh
+func (m *Mesos) ExpireState() {
+	m.syncLock.Lock()
+	defer m.syncLock.Unlock()
+
+	m.stateFetched = time.Time{}
+}
@@ -0,0 +1,31 @@
+package mesos
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadRecord(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("20\n{\"type\":\"HEARTBEAT\"}16\n{\"type\":\"TASK_\"}x\n"))
+
+	record, err := readRecord(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(record) != `{"type":"HEARTBEAT"}` {
+		t.Errorf("unexpected record: %s", record)
+	}
+
+	record, err = readRecord(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(record) != `{"type":"TASK_"}` {
+		t.Errorf("unexpected record: %s", record)
+	}
+
+	if _, err := readRecord(r); err == nil {
+		t.Error("expected an error on an invalid header")
+	}
+}
@@ -0,0 +1,252 @@
+package mesos
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Why a service was registered or deregistered, see syncChange
+const (
+	reasonNew         = "new"
+	reasonChanged     = "changed"
+	reasonRelaunched  = "relaunched"
+	reasonRetried     = "retried"
+	reasonMoved       = "moved"
+	reasonGone        = "gone"
+	reasonLost        = "lost"
+	reasonOrphaned    = "orphaned"
+	reasonShutdown    = "shutdown"
+	reasonCleanup     = "cleanup"
+	reasonUnreachable = "unreachable"
+	reasonEvicted     = "evicted"
+	reasonDisabled    = "disabled"
+)
+
+// A registration or deregistration made by a sync
+type syncChange struct {
+	Action  string `json:"action"`
+	Reason  string `json:"reason"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Agent   string `json:"agent"`
+
+	// How the task of a deregistered task service ended, when Mesos
+	// still knows it
+	TaskState   string `json:"task_state,omitempty"`
+	TaskReason  string `json:"task_reason,omitempty"`
+	TaskMessage string `json:"task_message,omitempty"`
+}
+
+// The outcome of a sync, logged once it ends and recorded to the
+// --change-log and --change-log-kv when it changed the registry or a
+// registry call failed
+type syncSummary struct {
+	Time            string       `json:"time"`
+	Instance        string       `json:"instance,omitempty"`
+	Cluster         string       `json:"cluster,omitempty"`
+	Leader          string       `json:"leader,omitempty"`
+	DryRun          bool         `json:"dry_run,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Registered      int          `json:"registered"`
+	Deregistered    int          `json:"deregistered"`
+	Unchanged       int          `json:"unchanged"`
+	Frozen          bool         `json:"frozen,omitempty"`
+	Errors          int          `json:"errors"`
+	Error           string       `json:"error,omitempty"`
+	Changes         []syncChange `json:"changes,omitempty"`
+
+	// Failed registrations of task services by framework
+	FrameworkErrors map[string]int `json:"framework_errors,omitempty"`
+
+	// The changes are added by the registry writes in flight
+	lock  sync.Mutex
+	start time.Time
+}
+
+// Count a service left as registered
+func (s *syncSummary) keep() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.Unchanged++
+}
+
+// Count a failed registration of a task service of framework
+func (s *syncSummary) failed(framework string) {
+	if s == nil || framework == "" {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.FrameworkErrors == nil {
+		s.FrameworkErrors = make(map[string]int)
+	}
+	s.FrameworkErrors[framework]++
+}
+
+func (s *syncSummary) add(c syncChange) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if c.Action == eventDeregister {
+		s.Deregistered++
+	} else {
+		s.Registered++
+	}
+	s.Changes = append(s.Changes, c)
+}
+
+// SetChangeLog records the summary of every sync that changed the
+// registry to w, one JSON object per line, see --change-log
+func (m *Mesos) SetChangeLog(w io.Writer) {
+	m.changeLog = json.NewEncoder(w)
+}
+
+// Start summing up a sync, see endSummary()
+func (m *Mesos) beginSummary() {
+	m.summary = &syncSummary{start: time.Now()}
+}
+
+// Count a successful registration or deregistration of s with agent,
+// and notify the events and hooks of it
+func (m *Mesos) applied(action string, reason string, agent string, s *consulapi.AgentServiceRegistration) {
+	m.appliedEnd(action, reason, agent, s, termination{})
+}
+
+// Record a change like applied(), for the service of a task that
+// ended as end
+func (m *Mesos) appliedEnd(action string, reason string, agent string, s *consulapi.AgentServiceRegistration, end termination) {
+	m.health.registered(action == eventDeregister)
+	m.recordFlap(action, reason, s, time.Now())
+	m.summary.add(syncChange{
+		Action:      action,
+		Reason:      reason,
+		ID:          s.ID,
+		Name:        s.Name,
+		Address:     s.Address,
+		Port:        s.Port,
+		Agent:       agent,
+		TaskState:   end.State,
+		TaskReason:  end.Reason,
+		TaskMessage: end.Message,
+	})
+	m.emitEvent(m.syncCtx(), action, s, end)
+}
+
+// Log the summary of the sync ending with err, after errors failed
+// registry calls, and record it when it changed anything
+func (m *Mesos) endSummary(err error, errors int) {
+	s := m.summary
+	m.summary = nil
+	if s == nil {
+		return
+	}
+
+	end := time.Now()
+	s.Time = end.UTC().Format(time.RFC3339Nano)
+	s.DurationSeconds = end.Sub(s.start).Seconds()
+	s.Instance = m.config.InstanceID
+	s.DryRun = m.config.DryRun
+	s.Errors = errors
+	if m.config.Cluster != nil {
+		s.Cluster = m.config.Cluster.Name
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	logger := hclog.L().Info
+	if err != nil || errors > 0 {
+		logger = hclog.L().Warn
+	}
+	logger("Sync summary", "registered", s.Registered, "deregistered", s.Deregistered,
+		"unchanged", s.Unchanged, "errors", s.Errors, "duration", end.Sub(s.start))
+
+	frameworks := make([]string, 0, len(s.FrameworkErrors))
+	for framework := range s.FrameworkErrors {
+		frameworks = append(frameworks, framework)
+	}
+	sort.Strings(frameworks)
+	for _, framework := range frameworks {
+		hclog.L().Warn("Registrations failed for framework", "framework", framework, "failed", s.FrameworkErrors[framework])
+	}
+
+	if len(s.Changes) == 0 && s.Errors == 0 {
+		return
+	}
+
+	if m.changeLog != nil {
+		if werr := m.changeLog.Encode(s); werr != nil {
+			hclog.L().Error("Unable to write the change log", "error", werr)
+		}
+	}
+
+	if m.config.ChangeLogKV > 0 {
+		m.recordChangeKV(s, end)
+	}
+}
+
+// The KV prefix the --change-log-kv records are kept under
+func (m *Mesos) changesKey() string {
+	return m.config.KVPrefix + "/changes/"
+}
+
+// Write s under <kv-prefix>/changes/<time> and delete the oldest
+// records beyond --change-log-kv
+func (m *Mesos) recordChangeKV(s *syncSummary, end time.Time) {
+	value, err := json.Marshal(s)
+	if err != nil {
+		hclog.L().Error("Unable to encode the change record", "error", err)
+		return
+	}
+
+	ctx := m.syncCtx()
+	prefix := m.changesKey()
+	key := prefix + end.UTC().Format("20060102T150405.000000000Z")
+
+	existing, _, err := m.Registry.List(ctx, prefix, 0, 0)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to list the change records", "prefix", prefix, "error", err)
+		return
+	}
+
+	// The keys sort by time
+	var keys []string
+	for k := range existing {
+		if k != key && !strings.Contains(k[len(prefix):], "/") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var deletes []string
+	if extra := len(keys) + 1 - m.config.ChangeLogKV; extra > 0 {
+		deletes = keys[:extra]
+	}
+
+	err = m.Registry.Txn(ctx, map[string][]byte{key: value}, deletes)
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to record the changes", "key", key, "error", err)
+	}
+}
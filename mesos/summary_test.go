@@ -0,0 +1,93 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A txnRegistry listing the keys of a KV store
+type changesRegistry struct {
+	txnRegistry
+	keys map[string][]byte
+}
+
+func (r *changesRegistry) List(context.Context, string, uint64, time.Duration) (map[string][]byte, uint64, error) {
+	return r.keys, 0, nil
+}
+
+func TestSyncSummary(t *testing.T) {
+	r := newFakeRegistry()
+	c := config.DefaultConfig()
+	c.InstanceID = "mesos-consul-1"
+	key := ServiceKey{"mesos-consul:a", localDatacenter}
+	service := &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "a", Address: "10.0.0.1", Port: 31000}
+
+	var log bytes.Buffer
+	m := &Mesos{
+		Registry: r,
+		config:   c,
+		ServiceCache: map[ServiceKey]*CacheEntry{
+			key: {service: service, agent: "10.0.0.1"},
+		},
+	}
+	m.SetChangeLog(&log)
+
+	m.beginSummary()
+	m.registerAt(localDatacenter, "10.0.0.1", service)
+	m.endSummary(nil, 0)
+	if log.Len() != 0 {
+		t.Errorf("expected a sync without changes not to be recorded, got %s", log.String())
+	}
+
+	m.beginSummary()
+	m.registerAt(localDatacenter, "10.0.0.2", service)
+	m.registerAt(localDatacenter, "10.0.0.3", &consulapi.AgentServiceRegistration{ID: "mesos-consul:b", Name: "b", Address: "10.0.0.3"})
+	m.endSummary(nil, 1)
+
+	var s syncSummary
+	if err := json.Unmarshal(log.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Instance != "mesos-consul-1" || s.Registered != 2 || s.Deregistered != 0 || s.Errors != 1 {
+		t.Errorf("unexpected summary %s", log.String())
+	}
+	want := []syncChange{
+		{Action: eventRegister, Reason: reasonMoved, ID: "mesos-consul:a", Name: "a", Address: "10.0.0.1", Port: 31000, Agent: "10.0.0.2"},
+		{Action: eventRegister, Reason: reasonNew, ID: "mesos-consul:b", Name: "b", Address: "10.0.0.3", Agent: "10.0.0.3"},
+	}
+	if !reflect.DeepEqual(s.Changes, want) {
+		t.Errorf("expected changes %+v, got %+v", want, s.Changes)
+	}
+}
+
+func TestRecordChangeKV(t *testing.T) {
+	r := &changesRegistry{
+		txnRegistry: txnRegistry{fakeRegistry: *newFakeRegistry()},
+		keys: map[string][]byte{
+			"mesos-consul/changes/20261014T090000.000000000Z": nil,
+			"mesos-consul/changes/20261014T080000.000000000Z": nil,
+			"mesos-consul/changes/20261014T100000.000000000Z": nil,
+		},
+	}
+	c := config.DefaultConfig()
+	c.ChangeLogKV = 2
+	m := &Mesos{Registry: r, config: c}
+
+	end := time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC)
+	m.recordChangeKV(&syncSummary{Registered: 1}, end)
+
+	if _, ok := r.puts["mesos-consul/changes/20261014T110000.000000000Z"]; !ok || len(r.puts) != 1 {
+		t.Errorf("expected the record to be written under its time, got %v", r.puts)
+	}
+	want := []string{"mesos-consul/changes/20261014T080000.000000000Z", "mesos-consul/changes/20261014T090000.000000000Z"}
+	if !reflect.DeepEqual(r.deletes, want) {
+		t.Errorf("expected the oldest records %v to be deleted, got %v", want, r.deletes)
+	}
+}
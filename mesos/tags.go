@@ -0,0 +1,171 @@
+package mesos
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The task attributes available to --service-tags-template
+type tagData struct {
+	FrameworkName string
+	TaskName      string
+	TaskId        string
+	Hostname      string
+	DockerImage   string
+
+	task     *Task
+	follower *follower
+}
+
+// The value of a task label
+func (d tagData) Label(key string) string {
+	return d.task.label(key)
+}
+
+// The value of an attribute of the Mesos agent running the task
+func (d tagData) Attribute(key string) string {
+	v, ok := d.follower.Attributes[key]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprint(v)
+}
+
+func newTagData(framework string, task *Task, f *follower) tagData {
+	d := tagData{
+		FrameworkName: framework,
+		TaskName:      task.Name,
+		TaskId:        task.Id,
+		Hostname:      f.Hostname,
+		task:          task,
+		follower:      f,
+	}
+	if task.Container != nil && task.Container.Docker != nil {
+		d.DockerImage = task.Container.Docker.Image
+	}
+
+	return d
+}
+
+// Render --service-tags-template for a task and split the result on
+// commas into tags. Empty tags are dropped so missing labels or
+// attributes don't produce any.
+func (m *Mesos) templateTags(framework string, task *Task, f *follower) []string {
+	if m.tagsTemplate == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := m.tagsTemplate.Execute(&buf, newTagData(framework, task, f)); err != nil {
+		hclog.L().Warn("Unable to render the service tags template", append(task.logFields(), "error", err)...)
+		return nil
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(buf.String(), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// With --tag-label-prefix, the tags of a task's own choosing: every
+// label whose key starts with the prefix, e.g. consul.tag.env=prod,
+// becomes a tag named after the rest of the key with the value
+// appended, env=prod. Labels without a value give the bare name.
+func (m *Mesos) labelTags(task *Task) []string {
+	prefix := m.config.TagLabelPrefix
+	if prefix == "" {
+		return nil
+	}
+
+	var tags []string
+	for _, l := range task.Labels {
+		name := strings.TrimPrefix(l.Key, prefix)
+		if name == l.Key || name == "" {
+			continue
+		}
+
+		if l.Value != "" {
+			name += "=" + l.Value
+		}
+		tags = append(tags, name)
+	}
+
+	return tags
+}
+
+// With --preserve-tags, read the tags starting with its prefix that
+// other tooling added to the registered services, so registering them
+// again keeps those tags. The services are only listed again once
+// their index moved, so steady syncs only read the index. A failed
+// read keeps the tags of the last one.
+func (m *Mesos) loadExternalTags() {
+	prefix := m.config.PreserveTags
+	if prefix == "" {
+		m.externalTags = nil
+		m.externalIndex = 0
+		return
+	}
+
+	// An index of 0 is unknown, e.g. when reading it failed
+	index, _ := m.Registry.WaitServices(m.syncCtx(), 0, 0)
+	if index != 0 && index == m.externalIndex && prefix == m.externalPrefix {
+		return
+	}
+
+	services, err := m.Registry.Services(m.inScopes(m.syncCtx()), "mesos-consul:")
+	m.health.consulResult(err)
+	if err != nil {
+		hclog.L().Warn("Unable to read the registered tags", "error", err)
+		return
+	}
+	m.externalIndex, m.externalPrefix = index, prefix
+
+	m.externalTags = make(map[string][]string)
+	for _, s := range services {
+		for _, tag := range s.Tags {
+			if strings.HasPrefix(tag, prefix) {
+				m.externalTags[s.ID] = append(m.externalTags[s.ID], tag)
+			}
+		}
+	}
+}
+
+// The registration of a service merged with the tags other tooling
+// added to it, see loadExternalTags(). The service itself is left
+// untouched so the cache keeps the tags mesos-consul owns. The
+// external tags that would take it over Consul's limit on tags are
+// left out, counted as a violation of it.
+func (m *Mesos) withExternalTags(s *consulapi.AgentServiceRegistration) *consulapi.AgentServiceRegistration {
+	external := m.externalTags[s.ID]
+	if len(external) == 0 {
+		return s
+	}
+
+	reg := *s
+	reg.Tags = append([]string{}, s.Tags...)
+	dropped := 0
+	for _, tag := range external {
+		switch {
+		case contains(reg.Tags, tag):
+		case len(reg.Tags) >= maxTags:
+			dropped++
+		default:
+			reg.Tags = append(reg.Tags, tag)
+		}
+	}
+	if dropped > 0 {
+		hclog.L().Debug("External tags over Consul's limits. Leaving them out", "service_id", s.ID, "tags_dropped", dropped)
+		m.health.limitViolation(limitTags)
+	}
+
+	return &reg
+}
@@ -0,0 +1,101 @@
+package mesos
+
+import (
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestTemplateTags(t *testing.T) {
+	m := &Mesos{
+		config:       config.DefaultConfig(),
+		tagsTemplate: template.Must(template.New("service-tags").Parse(`{{.FrameworkName}},{{.Label "env"}},{{.Attribute "rack"}},{{.DockerImage}}, {{.Label "missing"}}`)),
+	}
+
+	task := &Task{
+		Labels:    []Label{{Key: "env", Value: "prod"}},
+		Container: &ContainerInfo{Type: "DOCKER", Docker: &DockerInfo{Image: "nginx:1.9"}},
+	}
+	f := &follower{Hostname: "10.0.0.1", Attributes: map[string]interface{}{"rack": "r1"}}
+
+	want := []string{"marathon", "prod", "r1", "nginx:1.9"}
+	if tags := m.templateTags("marathon", task, f); !reflect.DeepEqual(tags, want) {
+		t.Errorf("expected %v, got %v", want, tags)
+	}
+
+	if tags := (&Mesos{}).templateTags("marathon", task, f); tags != nil {
+		t.Errorf("expected no tags without a template, got %v", tags)
+	}
+}
+
+func TestLabelTags(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	task := &Task{Labels: []Label{
+		{Key: "consul.tag.env", Value: "prod"},
+		{Key: "consul.tag.canary"},
+		{Key: "consul.tag."},
+		{Key: "team", Value: "a"},
+	}}
+
+	if tags := m.labelTags(task); tags != nil {
+		t.Errorf("expected no tags without --tag-label-prefix, got %v", tags)
+	}
+
+	c.TagLabelPrefix = "consul.tag."
+	want := []string{"env=prod", "canary"}
+	if tags := m.labelTags(task); !reflect.DeepEqual(tags, want) {
+		t.Errorf("expected %v, got %v", want, tags)
+	}
+}
+
+func TestExternalTags(t *testing.T) {
+	r := newFakeRegistry()
+	r.services = []*consulapi.AgentServiceRegistration{
+		{ID: "mesos-consul:web", Tags: []string{"v1", "team-a", "lb-public", "lb-weight=10"}},
+	}
+
+	c := config.DefaultConfig()
+	m := &Mesos{Registry: r, config: c}
+
+	s := &consulapi.AgentServiceRegistration{ID: "mesos-consul:web", Tags: []string{"v2", "lb-public"}}
+
+	m.loadExternalTags()
+	if reg := m.withExternalTags(s); reg != s {
+		t.Errorf("expected the registration unchanged without --preserve-tags, got %v", reg.Tags)
+	}
+
+	c.PreserveTags = "lb-"
+	m.loadExternalTags()
+	reg := m.withExternalTags(s)
+	if expected := []string{"v2", "lb-public", "lb-weight=10"}; !reflect.DeepEqual(reg.Tags, expected) {
+		t.Errorf("expected %v, got %v", expected, reg.Tags)
+	}
+	if len(s.Tags) != 2 {
+		t.Errorf("expected the service to be left untouched, got %v", s.Tags)
+	}
+
+	other := &consulapi.AgentServiceRegistration{ID: "mesos-consul:db", Tags: []string{"v1"}}
+	if reg := m.withExternalTags(other); reg != other {
+		t.Errorf("expected services without external tags unchanged, got %v", reg.Tags)
+	}
+
+	// The services are listed again only once their index moves
+	r.index = 10
+	m.loadExternalTags()
+	r.services[0].Tags = []string{"v1", "lb-private"}
+	m.loadExternalTags()
+	if reg := m.withExternalTags(s); !reflect.DeepEqual(reg.Tags, []string{"v2", "lb-public", "lb-weight=10"}) {
+		t.Errorf("expected the tags read at index 10, got %v", reg.Tags)
+	}
+
+	r.index = 11
+	m.loadExternalTags()
+	if reg := m.withExternalTags(s); !reflect.DeepEqual(reg.Tags, []string{"v2", "lb-public", "lb-private"}) {
+		t.Errorf("expected the tags read at index 11, got %v", reg.Tags)
+	}
+}
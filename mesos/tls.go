@@ -0,0 +1,110 @@
+package mesos
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+// The client of the requests to the Mesos masters, trusting
+// --mesos-ssl-cacert and presenting --mesos-ssl-cert with --mesos-ssl.
+// The files are read again for every connection, so that those
+// rewritten, e.g. renewed by --vault-mesos-ssl, apply to the next
+// connections; they are checked once here.
+func newMesosClient(ssl *config.SSL) (*http.Client, error) {
+	if ssl == nil || !ssl.Enabled {
+		return http.DefaultClient, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: !ssl.Verify}
+
+	if caCert := ssl.CaCert; caCert != "" {
+		if _, err := loadCertPool(caCert); err != nil {
+			return nil, err
+		}
+
+		// Verified by VerifyConnection against the CA file as it
+		// is at the time of the connection
+		if ssl.Verify {
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+				return verifyConnection(cs, caCert)
+			}
+		}
+	}
+
+	if certFile, keyFile := ssl.Cert, ssl.Key; certFile != "" {
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			return &cert, err
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// The certificates of the PEM file at path
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificate found", path)
+	}
+
+	return pool, nil
+}
+
+// Verify the certificate chain and name of the server of cs, as the
+// TLS handshake does, trusting the CAs in caCert
+func verifyConnection(cs tls.ConnectionState, caCert string) error {
+	roots, err := loadCertPool(caCert)
+	if err != nil {
+		return err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no server certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// The client of the requests to Mesos, see newMesosClient()
+func (m *Mesos) mesosClient() *http.Client {
+	if m.httpClient == nil {
+		return http.DefaultClient
+	}
+	return m.httpClient
+}
+
+// The URL of path on the Mesos master or agent at addr, given as
+// host:port
+func (m *Mesos) mesosURL(addr string, path string) string {
+	if ssl := m.currentConfig().MesosSSL; ssl != nil && ssl.Enabled {
+		return "https://" + addr + path
+	}
+	return "http://" + addr + path
+}
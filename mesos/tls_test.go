@@ -0,0 +1,81 @@
+package mesos
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestLoadFromMasterSSL(t *testing.T) {
+	master := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"leader":"master@10.0.0.1:5050"}`)
+	}))
+	defer master.Close()
+
+	host, port, _ := net.SplitHostPort(master.Listener.Addr().String())
+
+	c := config.DefaultConfig()
+	c.MesosStateAPI = config.MesosStateJSON
+	c.MesosSSL.Enabled = true
+
+	load := func() error {
+		client, err := newMesosClient(c.MesosSSL)
+		if err != nil {
+			return err
+		}
+
+		m := &Mesos{config: c, httpClient: client}
+		_, err = m.loadFromMaster(host, port)
+		return err
+	}
+
+	if err := load(); err == nil {
+		t.Error("expected the unknown certificate to be rejected")
+	}
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: master.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c.MesosSSL.CaCert = ca
+	if err := load(); err != nil {
+		t.Errorf("expected the certificate to be trusted, got %v", err)
+	}
+
+	// A rewritten CA file applies to the next connections of the client
+	client, err := newMesosClient(c.MesosSSL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Mesos{config: c, httpClient: client}
+	if _, err := m.loadFromMaster(host, port); err != nil {
+		t.Errorf("expected the certificate to be trusted, got %v", err)
+	}
+	if err := ioutil.WriteFile(ca, []byte("renewed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client.CloseIdleConnections()
+	if _, err := m.loadFromMaster(host, port); err == nil {
+		t.Error("expected the rewritten CA file to be read again")
+	}
+
+	c.MesosSSL.CaCert = ""
+	c.MesosSSL.Verify = false
+	if err := load(); err != nil {
+		t.Errorf("expected the certificate not to be verified, got %v", err)
+	}
+
+	m = &Mesos{config: c}
+	if check := m.hostCheck(m.mesosURL("10.0.0.1:5050", "/master/health")); check.HTTP != "https://10.0.0.1:5050/master/health" || !check.TLSSkipVerify {
+		t.Errorf("expected an unverified HTTPS check, got %+v", check)
+	}
+}
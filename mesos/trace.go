@@ -0,0 +1,38 @@
+package mesos
+
+import (
+	"github.com/CiscoCloud/mesos-consul/tracing"
+)
+
+// SetTracer traces every sync with t from the next one on: a sync span
+// with the Mesos fetch, the processing of every framework, the
+// registration and deregistration passes and every Mesos request and
+// Consul call as its descendants, see --otlp-endpoint
+func (m *Mesos) SetTracer(t *tracing.Tracer) {
+	m.tracer = t
+}
+
+// Run the next calls of a sync under a span named name, ended, as
+// failed with err when it is not nil, by the returned func
+func (m *Mesos) beginSpan(name string) func(err error) {
+	parent := m.ctx
+	ctx, span := tracing.Begin(m.syncCtx(), name)
+	m.ctx = ctx
+
+	return func(err error) {
+		span.Fail(err)
+		span.End()
+		m.ctx = parent
+	}
+}
+
+// The attributes of the sync spans, telling the clusters and
+// instances apart
+func (m *Mesos) traceAttrs() []interface{} {
+	attrs := []interface{}{"instance", m.config.InstanceID}
+	if m.config.Cluster != nil {
+		attrs = append(attrs, "cluster", m.config.Cluster.Name)
+	}
+
+	return attrs
+}
@@ -0,0 +1,107 @@
+package mesos
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/hook"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// How long an --address-translator run may take
+const translatorTimeout = 10 * time.Second
+
+// Read an --address-map-file: one "<address> <registered address>"
+// pair per line, e.g. a private agent IP and its elastic IP. Blank
+// lines and lines starting with # are skipped.
+func loadAddressMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	addresses := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected an address and the one to register, got %q", path, n, line)
+		}
+		addresses[fields[0]] = fields[1]
+	}
+
+	return addresses, scanner.Err()
+}
+
+// The address to register s under in place of the Mesos one: its
+// --address-map-file entry, or else the output of the
+// --address-translator command. The command runs once per address and
+// sync, with the address in MESOS_CONSUL_ADDRESS, and keeps it when it
+// fails or prints nothing.
+func (m *Mesos) translateAddress(address string) string {
+	if address == "" {
+		return address
+	}
+
+	if a, ok := m.addressMap[address]; ok {
+		return a
+	}
+
+	command := m.config.AddressTranslator
+	if command == "" {
+		return address
+	}
+
+	if a, ok := m.translated[address]; ok {
+		return a
+	}
+
+	ctx, cancel := context.WithTimeout(m.syncCtx(), translatorTimeout)
+	defer cancel()
+
+	cmd := hook.Shell(ctx, command)
+	cmd.Env = append(os.Environ(), "MESOS_CONSUL_ADDRESS="+address)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	translated := address
+	out, err := cmd.Output()
+	if err != nil {
+		hclog.L().Warn("Address translator failed. Keeping the address", "address", address, "error", err, "stderr", strings.TrimSpace(stderr.String()))
+	} else if a := strings.TrimSpace(string(out)); a != "" {
+		translated = a
+	}
+
+	if m.translated == nil {
+		m.translated = make(map[string]string)
+	}
+	m.translated[address] = translated
+
+	return translated
+}
+
+// s under its translated address, see translateAddress(). The agent
+// it is registered with stays the one of the Mesos address.
+func (m *Mesos) withTranslatedAddress(s *consulapi.AgentServiceRegistration) *consulapi.AgentServiceRegistration {
+	address := m.translateAddress(s.Address)
+	if address == s.Address {
+		return s
+	}
+
+	reg := *s
+	reg.Address = address
+
+	return &reg
+}
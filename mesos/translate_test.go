@@ -0,0 +1,73 @@
+package mesos
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestLoadAddressMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addresses")
+	os.WriteFile(path, []byte("# agents\n10.0.1.12  52.16.4.20\n\n10.0.1.13\t52.16.4.21\n"), 0644)
+
+	addresses, err := loadAddressMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"10.0.1.12": "52.16.4.20", "10.0.1.13": "52.16.4.21"}
+	if !reflect.DeepEqual(addresses, want) {
+		t.Errorf("expected %v, got %v", want, addresses)
+	}
+
+	os.WriteFile(path, []byte("10.0.1.12\n"), 0644)
+	if _, err := loadAddressMap(path); err == nil {
+		t.Error("expected a line without a registered address to fail")
+	}
+}
+
+func TestTranslateAddress(t *testing.T) {
+	c := config.DefaultConfig()
+	c.AddressTranslator = `[ "$MESOS_CONSUL_ADDRESS" = 10.0.1.14 ] && echo 52.16.4.22`
+	m := &Mesos{config: c, addressMap: map[string]string{"10.0.1.12": "52.16.4.20"}}
+
+	for address, want := range map[string]string{
+		"10.0.1.12": "52.16.4.20",
+		"10.0.1.14": "52.16.4.22",
+		"10.0.1.15": "10.0.1.15",
+		"":          "",
+	} {
+		if a := m.translateAddress(address); a != want {
+			t.Errorf("translateAddress(%q) = %q, want %q", address, a, want)
+		}
+	}
+
+	c.AddressTranslator = "exit 1"
+	if a := m.translateAddress("10.0.1.14"); a != "52.16.4.22" {
+		t.Errorf("expected the translation to be reused within a sync, got %q", a)
+	}
+}
+
+func TestRegisterHostTranslated(t *testing.T) {
+	r := newFakeRegistry()
+	m := &Mesos{
+		Registry:     r,
+		config:       config.DefaultConfig(),
+		ServiceCache: map[ServiceKey]*CacheEntry{},
+		addressMap:   map[string]string{"10.0.1.12": "52.16.4.20"},
+	}
+
+	s := &consulapi.AgentServiceRegistration{ID: "mesos-consul:web", Name: "web", Address: "10.0.1.12"}
+	m.registerHost(localDatacenter, s)
+
+	e := m.ServiceCache[ServiceKey{s.ID, localDatacenter}]
+	if e.service.Address != "52.16.4.20" || e.agent != "10.0.1.12" {
+		t.Errorf("expected the translated address on the agent of the Mesos one, got %s on %s", e.service.Address, e.agent)
+	}
+	if r.registered[s.ID] != "10.0.1.12" {
+		t.Errorf("expected the service to be registered with 10.0.1.12, got %v", r.registered)
+	}
+}
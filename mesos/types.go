@@ -4,32 +4,132 @@ type follower struct {
 	Id		string	`json:"id"`
 	Hostname	string	`json:"hostname"`
 	Pid		string	`json:"pid"`
+	Attributes	map[string]interface{}	`json:"attributes"`
+	Resources	map[string]interface{}	`json:"resources"`
+	Capabilities	[]string	`json:"capabilities"`
 }
 
 type Followers []follower
 
 type Resources struct {
+	Cpus		float64	`json:"cpus"`
+	Mem		float64	`json:"mem"`
 	Ports		string	`json:"ports"`
 }
 
-type Tasks []struct {
+type Label struct {
+	Key		string	`json:"key"`
+	Value		string	`json:"value"`
+}
+
+type IPAddress struct {
+	IPAddress	string	`json:"ip_address"`
+}
+
+// A host port forwarded to a port of a container
+type PortMapping struct {
+	HostPort	int	`json:"host_port"`
+	ContainerPort	int	`json:"container_port"`
+	Protocol	string	`json:"protocol"`
+}
+
+type NetworkInfo struct {
+	IPAddress	string		`json:"ip_address"`
+	IPAddresses	[]IPAddress	`json:"ip_addresses"`
+	PortMappings	[]PortMapping	`json:"port_mappings"`
+}
+
+type ContainerID struct {
+	Value		string	`json:"value"`
+	Parent		*ContainerID	`json:"parent"`
+}
+
+type ContainerStatus struct {
+	ContainerID	ContainerID	`json:"container_id"`
+	NetworkInfos	[]NetworkInfo	`json:"network_infos"`
+}
+
+type Status struct {
+	State		string		`json:"state"`
+	Timestamp	float64		`json:"timestamp"`
+	Healthy		*bool		`json:"healthy"`
+	Labels		[]Label		`json:"labels"`
+	ContainerStatus	ContainerStatus	`json:"container_status"`
+	Reason		string		`json:"reason"`
+	Message		string		`json:"message"`
+}
+
+type DiscoveryLabels struct {
+	Labels		[]Label	`json:"labels"`
+}
+
+type DiscoveryPort struct {
+	Number		int		`json:"number"`
+	Name		string		`json:"name"`
+	Protocol	string		`json:"protocol"`
+	Labels		DiscoveryLabels	`json:"labels"`
+}
+
+type DiscoveryPorts struct {
+	Ports		[]DiscoveryPort	`json:"ports"`
+}
+
+type DiscoveryInfo struct {
+	Visibility	string		`json:"visibility"`
+	Name		string		`json:"name"`
+	Environment	string		`json:"environment"`
+	Location	string		`json:"location"`
+	Version		string		`json:"version"`
+	Ports		DiscoveryPorts	`json:"ports"`
+	Labels		DiscoveryLabels	`json:"labels"`
+}
+
+type DockerInfo struct {
+	Image		string		`json:"image"`
+	Network		string		`json:"network"`
+}
+
+type ContainerInfo struct {
+	Type		string		`json:"type"`
+	Docker		*DockerInfo	`json:"docker"`
+}
+
+type Task struct {
 	FrameworkId	string	`json:"framework_id"`
 	Id		string	`json:"id"`
 	Name		string	`json:"name"`
 	FollowerId	string	`json:"slave_id"`
+	ExecutorId	string	`json:"executor_id"`
 	State		string	`json:"state"`
+	Role		string	`json:"role"`
 	Resources		`json:"resources"`
+	Labels		[]Label		`json:"labels"`
+	Statuses	[]Status	`json:"statuses"`
+	Discovery	*DiscoveryInfo	`json:"discovery"`
+	Container	*ContainerInfo	`json:"container"`
+
+	// The port mappings of the container, see --agent-containers
+	portMappings	[]PortMapping
 }
 
+type Tasks []Task
+
 type Frameworks []struct {
 	Tasks			`json:"tasks"`
+	UnreachableTasks	Tasks	`json:"unreachable_tasks"`
+	CompletedTasks		Tasks	`json:"completed_tasks"`
+	Id		string	`json:"id"`
 	Name		string	`json:"name"`
+	Role		string	`json:"role"`
+	Roles		[]string	`json:"roles"`
+	WebuiURL	string	`json:"webui_url"`
 }
 
 type StateJSON struct {
 	Frameworks		`json:"frameworks"`
 	Followers		`json:"slaves"`
 	Leader		string	`json:"leader"`
+	Cluster		string	`json:"cluster"`
 }
 
 type MesosHost struct {
@@ -1,17 +1,20 @@
 package mesos
 
 import (
-	"log"
+	"fmt"
 	"net"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	hclog "github.com/hashicorp/go-hclog"
 )
 
 func cleanName(name string) string {
 	reg, err := regexp.Compile("[^\\w-.\\.]")
 	if err != nil {
-		log.Print("[WARN] ", err)
+		hclog.L().Warn("Unable to clean up the name", "name", name, "error", err)
 		return name
 	}
 
@@ -22,42 +25,121 @@ func cleanName(name string) string {
 
 // The PID has a specific format:
 // type@host:port
-func parsePID(pid string) (string, string) {
-	host := strings.Split(strings.Split(pid, ":")[0], "@")[1]
-	port := strings.Split(pid, ":")[1]
+//
+// Some Mesos versions decorate it with a scheme prefix or a trailing
+// path/zone (type@host:port/zone). By default these are stripped to
+// get a clean host and port. In strict mode anything other than the
+// plain format is an error so the caller can skip the PID instead of
+// guessing.
+func parsePID(pid string, strict bool) (string, string, error) {
+	s := pid
+
+	if i := strings.Index(s, "://"); i != -1 {
+		if strict {
+			return "", "", fmt.Errorf("unexpected scheme in PID: %s", pid)
+		}
+		s = s[i+3:]
+	}
+
+	at := strings.Index(s, "@")
+	if at == -1 {
+		return "", "", fmt.Errorf("no host in PID: %s", pid)
+	}
+	s = s[at+1:]
+
+	if i := strings.Index(s, "/"); i != -1 {
+		if strict {
+			return "", "", fmt.Errorf("unexpected path in PID: %s", pid)
+		}
+		s = s[:i]
+	}
 
-	return toIP(host), port
+	i := strings.LastIndex(s, ":")
+	if i == -1 {
+		return "", "", fmt.Errorf("no port in PID: %s", pid)
+	}
+
+	host, port := s[:i], s[i+1:]
+	if host == "" {
+		return "", "", fmt.Errorf("no host in PID: %s", pid)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", "", fmt.Errorf("invalid port in PID: %s", pid)
+	}
+
+	return toIP(host), port, nil
 }
-	
+
 func leaderIP(leader string) string {
 	host := strings.Split(leader, "@")[1]
-	host = strings.Split(host, ":")[0]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
 
 	return toIP(host)
 }
 
+// Resolve host to an IPv4 or IPv6 address, returning it unchanged when
+// it is an address already or does not resolve. The brackets of an
+// IPv6 literal, e.g. [::1] from a PID, are dropped.
 func toIP(host string) string {
+	if ip, ok := lookupIP(host); ok {
+		return ip
+	}
+
+	return strings.Trim(host, "[]")
+}
+
+// Resolve host to an IPv4 or IPv6 address
+func lookupIP(host string) (string, bool) {
+	host = strings.Trim(host, "[]")
+
 	// Check if host string is already an IP address
-	ip := net.ParseIP(host)
-	if ip != nil {
-		return host
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), true
 	}
 
-	// Try to resolve host
 	ips, err := net.LookupIP(host)
-	if err != nil {
-		// Return the hostname if unable to resolve
-		return host
+	if err != nil || len(ips) == 0 {
+		return "", false
+	}
+
+	return ips[0].String(), true
+}
+
+// The address of host to register services under, as selected by
+// --consul-address-mode. Empty when the mode is ip and host does not
+// resolve.
+func (m *Mesos) address(host string) string {
+	switch m.config.ConsulAddressMode {
+	case config.AddressModeHostname:
+		return strings.Trim(host, "[]")
+	case config.AddressModeIP:
+		ip, ok := lookupIP(host)
+		if !ok {
+			hclog.L().Warn("Unable to resolve the host", "host", host)
+		}
+		return ip
 	}
 
-	return ips[0].String()
+	return toIP(host)
+}
+
+// Join host and port into a URL host, bracketing IPv6 addresses
+func hostPort(host string, port interface{}) string {
+	return net.JoinHostPort(host, fmt.Sprint(port))
 }
 
 func toPort(p string) int {
 	ps, err := strconv.Atoi(p)
 	if err != nil {
-		log.Printf("[ERROR] Invalid port number: %s", p)
+		hclog.L().Error("Invalid port number", "port", p)
 	}
 
 	return ps
 }
+
+// The structured log fields identifying a task
+func (t *Task) logFields() []interface{} {
+	return []interface{}{"task_id", t.Id, "framework_id", t.FrameworkId}
+}
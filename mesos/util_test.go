@@ -2,6 +2,8 @@ package mesos
 
 import (
 	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
 )
 
 func TestLeaderIP(t *testing.T) {
@@ -15,8 +17,81 @@ func TestLeaderIP(t *testing.T) {
 func TestParsePID(t *testing.T) {
 	l := "slave(1)@127.0.0.1:5051"
 
-	host, port := parsePID(l)
+	host, port, err := parsePID(l, false)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	t.Log("host: ", host)
 	t.Log("port: ", string(port))
 }
+
+func TestParsePIDFormats(t *testing.T) {
+	tests := []struct {
+		pid    string
+		strict bool
+		host   string
+		port   string
+		ok     bool
+	}{
+		{"slave(1)@127.0.0.1:5051", false, "127.0.0.1", "5051", true},
+		{"slave(1)@127.0.0.1:5051", true, "127.0.0.1", "5051", true},
+		{"slave(1)@127.0.0.1:5051/zone-a", false, "127.0.0.1", "5051", true},
+		{"slave(1)@127.0.0.1:5051/zone-a", true, "", "", false},
+		{"http://slave(1)@127.0.0.1:5051", false, "127.0.0.1", "5051", true},
+		{"http://slave(1)@127.0.0.1:5051", true, "", "", false},
+		{"127.0.0.1:5051", false, "", "", false},
+		{"slave(1)@127.0.0.1", false, "", "", false},
+		{"slave(1)@127.0.0.1:port", false, "", "", false},
+		{"slave(1)@:5051", false, "", "", false},
+		{"slave(1)@[2001:db8::1]:5051", true, "2001:db8::1", "5051", true},
+	}
+
+	for _, tt := range tests {
+		host, port, err := parsePID(tt.pid, tt.strict)
+		if tt.ok != (err == nil) {
+			t.Errorf("parsePID(%q, %v): unexpected error state: %v", tt.pid, tt.strict, err)
+			continue
+		}
+
+		if host != tt.host || port != tt.port {
+			t.Errorf("parsePID(%q, %v) = %s, %s; want %s, %s", tt.pid, tt.strict, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestLeaderIPv6(t *testing.T) {
+	if ip := leaderIP("master@[2001:db8::1]:5050"); ip != "2001:db8::1" {
+		t.Errorf("expected 2001:db8::1, got %s", ip)
+	}
+}
+
+func TestAddressMode(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	tests := []struct {
+		mode    string
+		host    string
+		address string
+	}{
+		{config.AddressModeAuto, "10.0.0.1", "10.0.0.1"},
+		{config.AddressModeAuto, "[2001:db8::1]", "2001:db8::1"},
+		{config.AddressModeAuto, "unresolvable.invalid", "unresolvable.invalid"},
+		{config.AddressModeIP, "10.0.0.1", "10.0.0.1"},
+		{config.AddressModeIP, "unresolvable.invalid", ""},
+		{config.AddressModeHostname, "localhost", "localhost"},
+		{config.AddressModeHostname, "10.0.0.1", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		c.ConsulAddressMode = tt.mode
+		if address := m.address(tt.host); address != tt.address {
+			t.Errorf("address(%q) with %s = %q; want %q", tt.host, tt.mode, address, tt.address)
+		}
+	}
+
+	if hp := hostPort("2001:db8::1", 5051); hp != "[2001:db8::1]:5051" {
+		t.Errorf("unexpected host and port %s", hp)
+	}
+}
@@ -0,0 +1,163 @@
+package mesos
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// A label of a service or host name, as DNS allows them. Underscores
+// are not valid in host names, but resolvers accept them and
+// --service-name-separator may put them in service names.
+var dnsLabel = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?$`)
+
+// The longest tag a registration may carry, that of a DNS name
+const maxTagLength = 255
+
+// Trim the tags of a registration and keep it within Consul's limits,
+// see limitRegistration(), then tell whether Consul would take it, so a
+// registration it would reject with an opaque 400 error is refused
+// with a reason instead. Counted and logged, the refused registrations
+// are tried again on every sync.
+func (m *Mesos) validRegistration(dc string, s *consulapi.AgentServiceRegistration) bool {
+	sanitizeTags(s)
+	m.dnsTags(s)
+
+	err := m.limitRegistration(dc, s)
+	if err == nil {
+		err = validateRegistration(s)
+	}
+	if err == nil {
+		return true
+	}
+
+	hclog.L().Warn("Invalid registration. Not registering", "service_id", s.ID, "service", s.Name, "error", err)
+	m.health.invalidRegistration()
+	return false
+}
+
+// Trim the tags of s and drop the empty ones. The services of a task
+// share their tags, so s gets a copy.
+func sanitizeTags(s *consulapi.AgentServiceRegistration) {
+	var tags []string
+	changed := false
+	for _, t := range s.Tags {
+		trimmed := strings.TrimSpace(t)
+		changed = changed || trimmed != t
+		if trimmed != "" {
+			tags = append(tags, trimmed)
+		}
+	}
+
+	if changed {
+		s.Tags = tags
+	}
+}
+
+func validateRegistration(s *consulapi.AgentServiceRegistration) error {
+	if s.ID == "" {
+		return errors.New("empty ID")
+	}
+
+	if err := validateName(s.Name); err != nil {
+		return err
+	}
+
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("port %d out of range", s.Port)
+	}
+
+	if s.Address == "" {
+		if s.Port != 0 {
+			return fmt.Errorf("port %d without an address", s.Port)
+		}
+	} else if !validHost(s.Address) {
+		return fmt.Errorf("invalid address %q", s.Address)
+	}
+
+	for _, t := range s.Tags {
+		if len(t) > maxTagLength {
+			return fmt.Errorf("tag %.32q... longer than %d characters", t, maxTagLength)
+		}
+	}
+
+	checks := s.Checks
+	if s.Check != nil {
+		checks = append(consulapi.AgentServiceChecks{s.Check}, checks...)
+	}
+	for _, c := range checks {
+		if err := validateCheck(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateName(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if !dnsLabel.MatchString(label) {
+			return fmt.Errorf("name %q is not a valid DNS name", name)
+		}
+	}
+
+	return nil
+}
+
+// Tell whether host is an IP address or a host name
+func validHost(host string) bool {
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return true
+	}
+
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !dnsLabel.MatchString(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks connecting to the task must target a port
+func validateCheck(c *consulapi.AgentServiceCheck) error {
+	targets := map[string]string{"TCP": c.TCP, "gRPC": c.GRPC}
+	for kind, target := range targets {
+		if target == "" {
+			continue
+		}
+		_, port, err := net.SplitHostPort(strings.SplitN(target, "/", 2)[0])
+		if err != nil {
+			return fmt.Errorf("%s check of %q: %s", kind, target, err)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("%s check of %q: invalid port", kind, target)
+		}
+	}
+
+	if c.HTTP != "" {
+		u, err := url.Parse(c.HTTP)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("HTTP check of %q: invalid URL", c.HTTP)
+		}
+		if u.Port() == "0" {
+			return fmt.Errorf("HTTP check of %q: invalid port", c.HTTP)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package mesos
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestValidateRegistration(t *testing.T) {
+	valid := func() *consulapi.AgentServiceRegistration {
+		return &consulapi.AgentServiceRegistration{
+			ID:      "mesos-consul:10.0.0.1:web:31000",
+			Name:    "web.backend",
+			Address: "10.0.0.1",
+			Port:    31000,
+			Check:   &consulapi.AgentServiceCheck{TCP: "10.0.0.1:31000"},
+		}
+	}
+	if err := validateRegistration(valid()); err != nil {
+		t.Errorf("expected a valid registration, got %s", err)
+	}
+
+	aggregate := &consulapi.AgentServiceRegistration{ID: "mesos-consul:aggregate:web", Name: "web-aggregate"}
+	if err := validateRegistration(aggregate); err != nil {
+		t.Errorf("expected a service without address nor port to be valid, got %s", err)
+	}
+
+	cases := map[string]func(s *consulapi.AgentServiceRegistration){
+		"empty name":     func(s *consulapi.AgentServiceRegistration) { s.Name = "" },
+		"invalid label":  func(s *consulapi.AgentServiceRegistration) { s.Name = "web/backend" },
+		"long label":     func(s *consulapi.AgentServiceRegistration) { s.Name = strings.Repeat("a", 64) },
+		"leading dash":   func(s *consulapi.AgentServiceRegistration) { s.Name = "-web" },
+		"no address":     func(s *consulapi.AgentServiceRegistration) { s.Address = "" },
+		"address a URL":  func(s *consulapi.AgentServiceRegistration) { s.Address = "http://10.0.0.1" },
+		"port range":     func(s *consulapi.AgentServiceRegistration) { s.Port = 70000 },
+		"long tag":       func(s *consulapi.AgentServiceRegistration) { s.Tags = []string{strings.Repeat("t", 256)} },
+		"TCP check port": func(s *consulapi.AgentServiceRegistration) { s.Check.TCP = "10.0.0.1:0" },
+		"HTTP check port": func(s *consulapi.AgentServiceRegistration) {
+			s.Check = &consulapi.AgentServiceCheck{HTTP: "http://10.0.0.1:0/health"}
+		},
+	}
+	for name, invalidate := range cases {
+		s := valid()
+		invalidate(s)
+		if err := validateRegistration(s); err == nil {
+			t.Errorf("%s: expected an invalid registration", name)
+		}
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	r := newFakeRegistry()
+	m := &Mesos{Registry: r, config: config.DefaultConfig(), ServiceCache: map[ServiceKey]*CacheEntry{}}
+
+	tags := []string{" canary", "", "v1"}
+	m.registerAt(localDatacenter, "10.0.0.1", &consulapi.AgentServiceRegistration{ID: "mesos-consul:a", Name: "web", Address: "10.0.0.1", Tags: tags})
+	m.registerAt(localDatacenter, "10.0.0.1", &consulapi.AgentServiceRegistration{ID: "mesos-consul:b", Name: "web app", Address: "10.0.0.1"})
+
+	if len(r.registered) != 1 || m.health.invalidTotal != 1 {
+		t.Errorf("expected the invalid registration to be refused, got %v", r.registered)
+	}
+	if b := m.ServiceCache[ServiceKey{"mesos-consul:a", localDatacenter}]; b == nil || strings.Join(b.service.Tags, ",") != "canary,v1" || tags[0] != " canary" {
+		t.Errorf("expected sanitized tags on a copy, got %+v", b)
+	}
+}
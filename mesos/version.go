@@ -0,0 +1,82 @@
+package mesos
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The HTTP endpoints of the state APIs, see --mesos-state-api
+var stateEndpoints = map[string]string{
+	config.MesosStateJSON: "/master/state.json",
+	config.MesosStateHTTP: "/master/state",
+	config.MesosStateV1:   "/api/v1",
+}
+
+// The state API of a master, given as host:port. With
+// --mesos-state-api=auto it follows the version the master reports on
+// /version and is remembered until a fetch from the master fails, so
+// an upgraded master is detected again. Masters whose version cannot
+// be read get /master/state.json, which every supported version
+// serves.
+func (m *Mesos) stateAPI(addr string) string {
+	if m.config.MesosStateAPI != config.MesosStateAuto && m.config.MesosStateAPI != "" {
+		return m.config.MesosStateAPI
+	}
+
+	if api, ok := m.stateAPIs[addr]; ok {
+		return api
+	}
+
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := m.requestJSON("GET", m.mesosURL(addr, "/version"), "", &v); err != nil {
+		hclog.L().Debug("Unable to read the Mesos version", "master", addr, "error", err)
+		return config.MesosStateJSON
+	}
+
+	api, ok := stateAPIFor(v.Version)
+	if !ok {
+		hclog.L().Warn("Unknown Mesos version. Reading /master/state.json", "master", addr, "version", v.Version)
+		return config.MesosStateJSON
+	}
+
+	hclog.L().Info("Reading the Mesos state", "master", addr, "version", v.Version, "endpoint", stateEndpoints[api])
+	if m.stateAPIs == nil {
+		m.stateAPIs = make(map[string]string)
+	}
+	m.stateAPIs[addr] = api
+
+	return api
+}
+
+// The state API of a Mesos version: /master/state.json before 1.0,
+// /master/state for 1.0, which renamed it, and the v1 operator API
+// from 1.1 on
+func stateAPIFor(version string) (string, bool) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return "", false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", false
+	}
+
+	switch {
+	case major < 1:
+		return config.MesosStateJSON, true
+	case major == 1 && minor == 0:
+		return config.MesosStateHTTP, true
+	default:
+		return config.MesosStateV1, true
+	}
+}
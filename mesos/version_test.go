@@ -0,0 +1,91 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestStateAPIFor(t *testing.T) {
+	tests := []struct {
+		version string
+		api     string
+		ok      bool
+	}{
+		{"0.28.2", config.MesosStateJSON, true},
+		{"1.0.1", config.MesosStateHTTP, true},
+		{"1.1.0", config.MesosStateV1, true},
+		{"1.11.0-rc1", config.MesosStateV1, true},
+		{"", "", false},
+		{"dev", "", false},
+	}
+
+	for _, tt := range tests {
+		if api, ok := stateAPIFor(tt.version); api != tt.api || ok != tt.ok {
+			t.Errorf("stateAPIFor(%q) = %q, %t, want %q, %t", tt.version, api, ok, tt.api, tt.ok)
+		}
+	}
+}
+
+func TestLoadFromMasterVersions(t *testing.T) {
+	var version string
+	var paths []string
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/version":
+			fmt.Fprintf(w, `{"version":%q}`, version)
+		case "/master/state.json", "/master/state":
+			fmt.Fprint(w, `{"leader":"master@10.0.0.1:5050"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer master.Close()
+
+	host, port, _ := net.SplitHostPort(master.Listener.Addr().String())
+
+	for _, tt := range []struct {
+		version string
+		path    string
+	}{
+		{"0.28.2", "/master/state.json"},
+		{"1.0.1", "/master/state"},
+	} {
+		version, paths = tt.version, nil
+		m := &Mesos{config: config.DefaultConfig()}
+
+		for i := 0; i < 2; i++ {
+			if sj, err := m.loadFromMaster(host, port); err != nil || sj.Leader != "master@10.0.0.1:5050" {
+				t.Fatalf("Mesos %s: expected the state, got %+v, %v", tt.version, sj, err)
+			}
+		}
+
+		// The version is read once
+		want := []string{"/version", tt.path, tt.path}
+		if fmt.Sprint(paths) != fmt.Sprint(want) {
+			t.Errorf("Mesos %s: expected requests %v, got %v", tt.version, want, paths)
+		}
+	}
+
+	version, paths = "1.4.0", nil
+	m := &Mesos{config: config.DefaultConfig()}
+	if _, err := m.loadFromMaster(host, port); err == nil {
+		t.Error("expected the missing operator API to fail the fetch")
+	}
+	if _, ok := m.stateAPIs[hostPort(host, port)]; ok {
+		t.Error("expected the version to be read again after a failed fetch")
+	}
+
+	c := config.DefaultConfig()
+	c.MesosStateAPI = config.MesosStateHTTP
+	paths = nil
+	m = &Mesos{config: c}
+	if _, err := m.loadFromMaster(host, port); err != nil || fmt.Sprint(paths) != "[/master/state]" {
+		t.Errorf("expected --mesos-state-api to skip the version, got %v, %v", paths, err)
+	}
+}
@@ -0,0 +1,46 @@
+package mesos
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The task label setting the passing weight of a task's services
+const weightLabel = "consul-weight"
+
+// The Consul weights of a task's services, so load balancers send
+// each instance traffic in proportion to its size. The consul-weight
+// label sets the passing weight, otherwise --service-weights derives
+// it from the task's resources: hundredths of a CPU or megabytes of
+// memory. Warning keeps Consul's default of 1. Nil leaves both
+// weights at their default.
+func (m *Mesos) taskWeights(task *Task) *consulapi.AgentWeights {
+	passing := 0
+
+	if v := task.label(weightLabel); v != "" {
+		if w, err := strconv.Atoi(v); err == nil && w > 0 {
+			passing = w
+		} else {
+			hclog.L().Warn("Ignoring invalid label", append(task.logFields(), "label", weightLabel, "value", v)...)
+		}
+	}
+
+	if passing == 0 {
+		switch m.config.ServiceWeights {
+		case config.WeightsCpus:
+			passing = int(math.Round(task.Resources.Cpus * 100))
+		case config.WeightsMem:
+			passing = int(math.Round(task.Resources.Mem))
+		}
+	}
+
+	if passing <= 0 {
+		return nil
+	}
+
+	return &consulapi.AgentWeights{Passing: passing, Warning: 1}
+}
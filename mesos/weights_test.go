@@ -0,0 +1,41 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestTaskWeights(t *testing.T) {
+	c := config.DefaultConfig()
+	m := &Mesos{config: c}
+
+	task := &Task{Resources: Resources{Cpus: 0.5, Mem: 256}}
+	if w := m.taskWeights(task); w != nil {
+		t.Errorf("expected the default weights, got %v", w)
+	}
+
+	c.ServiceWeights = config.WeightsCpus
+	if w := m.taskWeights(task); w == nil || w.Passing != 50 || w.Warning != 1 {
+		t.Errorf("expected the weight of half a CPU, got %v", w)
+	}
+
+	c.ServiceWeights = config.WeightsMem
+	if w := m.taskWeights(task); w == nil || w.Passing != 256 {
+		t.Errorf("expected the weight of 256MB, got %v", w)
+	}
+
+	task.Labels = []Label{{Key: "consul-weight", Value: "3"}}
+	if w := m.taskWeights(task); w == nil || w.Passing != 3 {
+		t.Errorf("expected the label to set the weight, got %v", w)
+	}
+
+	task.Labels[0].Value = "heavy"
+	if w := m.taskWeights(task); w == nil || w.Passing != 256 {
+		t.Errorf("expected an invalid label to be ignored, got %v", w)
+	}
+
+	if w := m.taskWeights(&Task{}); w != nil {
+		t.Errorf("expected the default weights without resources, got %v", w)
+	}
+}
@@ -3,37 +3,39 @@ package mesos
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
 	"net"
 	"sync"
 	"time"
 
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/mesos/mesos-go/mesosproto"
 
 	zoo "github.com/CiscoCloud/mesos-consul/mesos/zkdetect"
 )
 
-func (m *Mesos) zkDetector(zkURI string) {
+func (m *Mesos) zkDetector(zkURI string) error {
 	if (zkURI == "") {
-		log.Fatal("[ERROR] Zookeeper address not provided")
+		return fmt.Errorf("Zookeeper address not provided")
 	}
 
 	dr, err := m.leaderDetect(zkURI)
 	if err != nil {
-		log.Fatal("[ERROR] ", err.Error())
+		return err
 	}
 
-	log.Print("[INFO] Waiting for initial leader information from Zookeeper")
+	hclog.L().Info("Waiting for initial leader information from Zookeeper")
 	select {
 	case <-dr:
-		log.Print("[INFO] Done waiting for initial leader information from Zookeeper")
+		hclog.L().Info("Done waiting for initial leader information from Zookeeper")
 	case <-time.After(2 * time.Minute):
-		log.Fatal("[ERROR] Timed out waiting for initial ZK detection")
+		return fmt.Errorf("Timed out waiting for initial ZK detection")
 	}
+
+	return nil
 }
 
 func (m *Mesos) leaderDetect(zkURI string) (<-chan struct{}, error) {
-	log.Print("[INFO] Starting leader detector for ZK ", zkURI)
+	hclog.L().Info("Starting leader detector", "zk", zkURI)
 	md, err := zoo.NewClusterDetector(zkURI)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create master detector: %v", err)
@@ -118,10 +120,12 @@ func (m *Mesos) hostFromMasterInfo(mi *mesosproto.MasterInfo) MesosHost {
 						break
 					}
 				}
-				// If control reaches here there are no IPv4 addresses
-				// returned by net.LookupIP. Use the hostname as ipstring
+				// If no IPv4 addresses are returned by net.LookupIP,
+				// use the hostname as ipstring
 				//
-				ipstring = host
+				if ipstring == "" {
+					ipstring = host
+				}
 			}
 		} else {
 			octets := make([]byte, 4, 4)
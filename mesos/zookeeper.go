@@ -0,0 +1,72 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// The client port of Zookeeper members given without one
+const zookeeperPort = "2181"
+
+// With --register-zookeeper, the registrations of the members of the
+// Zookeeper ensemble in --zk as zookeeper services, each with a TCP
+// check of its client port
+func (m *Mesos) zookeeperServices() []*consulapi.AgentServiceRegistration {
+	members, err := zookeeperMembers(m.config.Zk)
+	if err != nil {
+		hclog.L().Warn("Not registering Zookeeper", "error", err)
+		return nil
+	}
+
+	var services []*consulapi.AgentServiceRegistration
+	for _, member := range members {
+		h, p, err := net.SplitHostPort(member)
+		if err != nil {
+			h, p = member, zookeeperPort
+		}
+		host := m.address(h)
+		port := toPort(p)
+		if host == "" || port == 0 {
+			continue
+		}
+
+		services = append(services, &consulapi.AgentServiceRegistration{
+			ID:      fmt.Sprintf("mesos-consul:zookeeper:%s:%d", h, port),
+			Name:    "zookeeper",
+			Port:    port,
+			Address: host,
+			Check:   m.withHostCheckOptions(&consulapi.AgentServiceCheck{TCP: hostPort(host, port)}),
+		})
+	}
+
+	return services
+}
+
+// The host[:port] members of a zk://[user:password@]host[:port][,...]/path
+// address. url.Parse rejects the comma-separated hosts, so they are
+// split by hand.
+func zookeeperMembers(zk string) ([]string, error) {
+	hosts := strings.TrimPrefix(zk, "zk://")
+	if i := strings.Index(hosts, "/"); i >= 0 {
+		hosts = hosts[:i]
+	}
+	if i := strings.LastIndex(hosts, "@"); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+	if hosts == "" || !strings.HasPrefix(zk, "zk://") {
+		return nil, fmt.Errorf("invalid Zookeeper address %q", zk)
+	}
+
+	var members []string
+	for _, member := range strings.Split(hosts, ",") {
+		if member != "" {
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
@@ -0,0 +1,34 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+)
+
+func TestZookeeperServices(t *testing.T) {
+	c := config.DefaultConfig()
+	c.Zk = "zk://10.0.0.1:2181,10.0.0.2:2182,10.0.0.3/mesos"
+	m := &Mesos{config: c}
+
+	services := m.zookeeperServices()
+	if len(services) != 3 {
+		t.Fatalf("expected 3 members, got %d", len(services))
+	}
+
+	s := services[2]
+	if s.ID != "mesos-consul:zookeeper:10.0.0.3:2181" || s.Name != "zookeeper" || s.Port != 2181 {
+		t.Errorf("unexpected registration %+v", s)
+	}
+	if s.Check == nil || s.Check.TCP != "10.0.0.3:2181" || s.Check.Interval != "10s" {
+		t.Errorf("expected a TCP check of the client port, got %+v", s.Check)
+	}
+	if services[1].Check.TCP != "10.0.0.2:2182" {
+		t.Errorf("expected the given port to be checked, got %+v", services[1].Check)
+	}
+
+	c.Zk = "http://10.0.0.1:2181/mesos"
+	if services := m.zookeeperServices(); services != nil {
+		t.Errorf("expected no services of an invalid address, got %v", services)
+	}
+}
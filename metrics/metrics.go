@@ -0,0 +1,176 @@
+// Package metrics sends the metrics of mesos-consul to the monitoring
+// systems that do not scrape the Prometheus endpoint of
+// --metrics-addr, e.g. StatsD or the DogStatsD server of a Datadog
+// agent.
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kinds of metrics
+const (
+	// A total only ever growing, e.g. the syncs since the start
+	Counter = "counter"
+
+	// A value going up and down, e.g. the services in the cache
+	Gauge = "gauge"
+)
+
+// A Sample of a metric, named the Prometheus way, e.g.
+// mesos_consul_syncs_total
+type Sample struct {
+	Name   string
+	Kind   string
+	Help   string
+	Labels map[string]string
+	Value  float64
+}
+
+// A Sink receives the samples of all metrics every flush interval
+type Sink interface {
+	Emit(samples []Sample) error
+}
+
+// The largest datagram sent, fitting the usual MTU
+const maxPacket = 1432
+
+// StatsD returns a Sink sending the samples over UDP to the StatsD
+// server at addr, e.g. 127.0.0.1:8125. Counters are sent as the
+// increments since the last flush and gauges as their value. With
+// dogstatsd, in the DogStatsD format, the labels of a sample and tags, e.g. env:prod, are
+// sent as tags. Plain StatsD has no tags: the label values are
+// appended to the names, e.g. mesos_consul.framework_registrations.marathon,
+// and tags are dropped.
+func StatsD(addr string, dogstatsd bool, tags []string) (Sink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsd{
+		conn:   conn,
+		dog:    dogstatsd,
+		tags:   tags,
+		counts: make(map[string]float64),
+	}, nil
+}
+
+type statsd struct {
+	conn net.Conn
+	dog  bool
+	tags []string
+
+	// The counters last sent, by line without its value, to send the
+	// increments of
+	lock   sync.Mutex
+	counts map[string]float64
+}
+
+func (s *statsd) Emit(samples []Sample) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var packet []byte
+	var err error
+	send := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, e := s.conn.Write(packet); e != nil && err == nil {
+			err = e
+		}
+		packet = packet[:0]
+	}
+
+	for _, sample := range samples {
+		line := s.line(sample)
+		if len(packet) > 0 && len(packet)+1+len(line) > maxPacket {
+			send()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	send()
+
+	return err
+}
+
+// The StatsD line of a sample, e.g. mesos_consul.syncs_total:1|c|#cluster:east
+func (s *statsd) line(sample Sample) string {
+	keys := make([]string, 0, len(sample.Labels))
+	for k := range sample.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	name := statsdName(sample.Name)
+	var tags []string
+	for _, k := range keys {
+		if s.dog {
+			tags = append(tags, tagValue(k)+":"+tagValue(sample.Labels[k]))
+		} else {
+			name += "." + nameValue(sample.Labels[k])
+		}
+	}
+	if s.dog {
+		for _, t := range s.tags {
+			tags = append(tags, tagValue(t))
+		}
+	}
+
+	suffix := "|g"
+	value := sample.Value
+	if sample.Kind == Counter {
+		suffix = "|c"
+
+		// A counter below the last value sent started over
+		key := name + "|" + strings.Join(tags, ",")
+		if last, ok := s.counts[key]; ok && value >= last {
+			value -= last
+		}
+		s.counts[key] = sample.Value
+	}
+	if len(tags) > 0 {
+		suffix += "|#" + strings.Join(tags, ",")
+	}
+
+	return name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + suffix
+}
+
+// The StatsD name of a metric, namespaced with a dot, e.g.
+// mesos_consul.syncs_total
+func statsdName(name string) string {
+	if strings.HasPrefix(name, "mesos_consul_") {
+		return "mesos_consul." + nameValue(strings.TrimPrefix(name, "mesos_consul_"))
+	}
+
+	return nameValue(name)
+}
+
+// Replace the characters StatsD uses as separators
+func nameValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func tagValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Listen for StatsD datagrams, returning the address and a read of the
+// next datagram
+func listen(t *testing.T) (string, func() string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func() string {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+}
+
+var samples = []Sample{
+	{Name: "mesos_consul_syncs_total", Kind: Counter, Value: 3},
+	{Name: "mesos_consul_cache_entries", Kind: Gauge, Value: 12},
+	{Name: "mesos_consul_framework_registrations", Kind: Gauge, Labels: map[string]string{"framework": "marathon", "cluster": "east"}, Value: 5},
+}
+
+func TestDogStatsD(t *testing.T) {
+	addr, read := listen(t)
+	sink, err := StatsD(addr, true, []string{"env:prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Emit(samples); err != nil {
+		t.Fatal(err)
+	}
+	want := "mesos_consul.syncs_total:3|c|#env:prod\n" +
+		"mesos_consul.cache_entries:12|g|#env:prod\n" +
+		"mesos_consul.framework_registrations:5|g|#cluster:east,framework:marathon,env:prod"
+	if got := read(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// Counters are sent as increments
+	later := append([]Sample(nil), samples...)
+	later[0].Value = 5
+	sink.Emit(later)
+	if got := read(); !strings.HasPrefix(got, "mesos_consul.syncs_total:2|c") {
+		t.Errorf("expected an increment of 2, got %q", got)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	addr, read := listen(t)
+	sink, err := StatsD(addr, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink.Emit(samples[2:])
+	if got := read(); got != "mesos_consul.framework_registrations.east.marathon:5|g" {
+		t.Errorf("expected the labels in the name, got %q", got)
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	addr, read := listen(t)
+	sink, err := StatsD(addr, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	many := make([]Sample, 100)
+	for i := range many {
+		many[i] = Sample{Name: "mesos_consul_task_deregistrations_total", Kind: Counter, Labels: map[string]string{"task_reason": strings.Repeat("x", i)}, Value: 1}
+	}
+	sink.Emit(many)
+
+	lines := 0
+	for lines < len(many) {
+		packet := read()
+		if len(packet) > maxPacket {
+			t.Fatalf("expected packets of at most %d bytes, got %d", maxPacket, len(packet))
+		}
+		lines += len(strings.Split(packet, "\n"))
+	}
+	if lines != len(many) {
+		t.Errorf("expected %d lines, got %d", len(many), lines)
+	}
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry naming services with a prefix and suffix, see Affixed
+type affixed struct {
+	Registry
+
+	prefix string
+	suffix string
+}
+
+// Affixed wraps r so the names of the services and prepared queries
+// made through it get prefix and suffix, e.g. mesos-prod- for the
+// clusters sharing a datacenter. The services and queries it lists are
+// named as they were before, less the affixes.
+func Affixed(r Registry, prefix string, suffix string) Registry {
+	return &affixed{r, prefix, suffix}
+}
+
+func (a *affixed) name(name string) string {
+	return a.prefix + name + a.suffix
+}
+
+// The name given to name(), or name itself without the affixes
+func (a *affixed) unname(name string) string {
+	if len(name) < len(a.prefix)+len(a.suffix) || !strings.HasPrefix(name, a.prefix) || !strings.HasSuffix(name, a.suffix) {
+		return name
+	}
+
+	return name[len(a.prefix) : len(name)-len(a.suffix)]
+}
+
+func (a *affixed) service(service *consulapi.AgentServiceRegistration) *consulapi.AgentServiceRegistration {
+	s := *service
+	s.Name = a.name(service.Name)
+
+	return &s
+}
+
+func (a *affixed) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	return a.Registry.Register(ctx, agent, a.service(service))
+}
+
+func (a *affixed) FireEvent(ctx context.Context, name string, action string, service *consulapi.AgentServiceRegistration) error {
+	return a.Registry.FireEvent(ctx, name, action, a.service(service))
+}
+
+func (a *affixed) Services(ctx context.Context, prefix string) ([]*Service, error) {
+	services, err := a.Registry.Services(ctx, prefix)
+	for _, s := range services {
+		s.Name = a.unname(s.Name)
+	}
+
+	return services, err
+}
+
+func (a *affixed) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	q := *query
+	q.Name = a.name(query.Name)
+	q.Service.Service = a.name(query.Service.Service)
+
+	err := a.Registry.SetQuery(ctx, &q)
+	query.ID = q.ID
+
+	return err
+}
+
+func (a *affixed) Queries(ctx context.Context) ([]*consulapi.PreparedQueryDefinition, error) {
+	queries, err := a.Registry.Queries(ctx)
+	for _, q := range queries {
+		q.Name = a.unname(q.Name)
+		q.Service.Service = a.unname(q.Service.Service)
+	}
+
+	return queries, err
+}
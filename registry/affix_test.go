@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestAffixed(t *testing.T) {
+	rec := &recorder{}
+	r := Affixed(rec, "mesos-prod-", "-x")
+
+	s := &consulapi.AgentServiceRegistration{ID: "a", Name: "mesos"}
+	if err := r.Register(context.Background(), "", s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "mesos" || rec.services[0].Name != "mesos-prod-mesos-x" {
+		t.Errorf("expected a renamed copy, got %s and %s", s.Name, rec.services[0].Name)
+	}
+
+	rec.services = append(rec.services, &consulapi.AgentServiceRegistration{ID: "b", Name: "web"})
+	services, _ := r.Services(context.Background(), "")
+	if len(services) != 2 || services[0].Name != "mesos" || services[1].Name != "web" {
+		t.Errorf("expected the names without the affixes, got %v and %v", services[0], services[1])
+	}
+
+	q := &consulapi.PreparedQueryDefinition{Name: "web", Service: consulapi.ServiceQuery{Service: "web"}}
+	if err := r.SetQuery(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if q.ID != "mesos-prod-web-x" || rec.queries[0].Service.Service != "mesos-prod-web-x" {
+		t.Errorf("expected a renamed query, got %+v", rec.queries[0])
+	}
+
+	queries, _ := r.Queries(context.Background())
+	if len(queries) != 1 || queries[0].Name != "web" || queries[0].Service.Service != "web" {
+		t.Errorf("expected the query without the affixes, got %+v", queries)
+	}
+}
@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"context"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// The service meta key naming the cluster a service was registered
+// for, see Cluster
+const ClusterMeta = "mesos-cluster"
+
+// A Registry shared by several Mesos clusters, see Cluster
+type cluster struct {
+	Registry
+
+	name   string
+	prefix string
+}
+
+// Cluster wraps r for one of several Mesos clusters syncing into the
+// same Consul, e.g. with --cluster. Service names get the cluster's
+// prefix, services carry its name in their metadata and Services()
+// only lists the cluster's own, named as they were before the prefix.
+func Cluster(r Registry, name string, prefix string) Registry {
+	return &cluster{r, name, prefix}
+}
+
+// Copy service under the cluster's name and metadata
+func (c *cluster) service(service *consulapi.AgentServiceRegistration) *consulapi.AgentServiceRegistration {
+	s := *service
+	s.Name = c.prefix + service.Name
+
+	s.Meta = make(map[string]string, len(service.Meta)+1)
+	for k, v := range service.Meta {
+		s.Meta[k] = v
+	}
+	s.Meta[ClusterMeta] = c.name
+
+	return &s
+}
+
+func (c *cluster) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	return c.Registry.Register(ctx, agent, c.service(service))
+}
+
+func (c *cluster) FireEvent(ctx context.Context, name string, action string, service *consulapi.AgentServiceRegistration) error {
+	return c.Registry.FireEvent(ctx, name, action, c.service(service))
+}
+
+func (c *cluster) Services(ctx context.Context, prefix string) ([]*Service, error) {
+	services, err := c.Registry.Services(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var own []*Service
+	for _, s := range services {
+		if s.Meta[ClusterMeta] == c.name {
+			s.Name = strings.TrimPrefix(s.Name, c.prefix)
+			own = append(own, s)
+		}
+	}
+
+	return own, nil
+}
+
+// Prepared queries are named after the services they query, with the
+// cluster's prefix, and only return the cluster's services
+func (c *cluster) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	q := *query
+	q.Name = c.prefix + query.Name
+	q.Service.Service = c.prefix + query.Service.Service
+
+	q.Service.ServiceMeta = make(map[string]string, len(query.Service.ServiceMeta)+1)
+	for k, v := range query.Service.ServiceMeta {
+		q.Service.ServiceMeta[k] = v
+	}
+	q.Service.ServiceMeta[ClusterMeta] = c.name
+
+	err := c.Registry.SetQuery(ctx, &q)
+	query.ID = q.ID
+
+	return err
+}
+
+func (c *cluster) Queries(ctx context.Context) ([]*consulapi.PreparedQueryDefinition, error) {
+	queries, err := c.Registry.Queries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var own []*consulapi.PreparedQueryDefinition
+	for _, q := range queries {
+		if q.Service.ServiceMeta[ClusterMeta] == c.name {
+			q.Name = strings.TrimPrefix(q.Name, c.prefix)
+			q.Service.Service = strings.TrimPrefix(q.Service.Service, c.prefix)
+			delete(q.Service.ServiceMeta, ClusterMeta)
+			own = append(own, q)
+		}
+	}
+
+	return own, nil
+}
@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry recording registrations and listing them back
+type recorder struct {
+	Registry
+
+	services []*consulapi.AgentServiceRegistration
+	queries  []consulapi.PreparedQueryDefinition
+}
+
+func (r *recorder) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	r.services = append(r.services, service)
+	return nil
+}
+
+func (r *recorder) Services(ctx context.Context, prefix string) ([]*Service, error) {
+	var services []*Service
+	for _, s := range r.services {
+		services = append(services, &Service{AgentServiceRegistration: s})
+	}
+	return services, nil
+}
+
+func (r *recorder) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	query.ID = query.Name
+	r.queries = append(r.queries, *query)
+	return nil
+}
+
+// Copies, as Consul decodes new ones on every call
+func (r *recorder) Queries(ctx context.Context) ([]*consulapi.PreparedQueryDefinition, error) {
+	var queries []*consulapi.PreparedQueryDefinition
+	for _, saved := range r.queries {
+		q := saved
+		q.Service.ServiceMeta = make(map[string]string)
+		for k, v := range saved.Service.ServiceMeta {
+			q.Service.ServiceMeta[k] = v
+		}
+		queries = append(queries, &q)
+	}
+	return queries, nil
+}
+
+func TestClusterServices(t *testing.T) {
+	rec := &recorder{}
+	east := Cluster(rec, "east", "east-")
+	west := Cluster(rec, "west", "")
+
+	s := &consulapi.AgentServiceRegistration{ID: "a", Name: "web", Meta: map[string]string{"version": "1"}}
+	if err := east.Register(context.Background(), "", s); err != nil {
+		t.Fatal(err)
+	}
+	if err := west.Register(context.Background(), "", s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Name != "web" || len(s.Meta) != 1 {
+		t.Errorf("expected the registration to be left untouched, got %+v", s)
+	}
+	if got := rec.services[0]; got.Name != "east-web" || got.Meta[ClusterMeta] != "east" || got.Meta["version"] != "1" {
+		t.Errorf("unexpected east registration: %+v", got)
+	}
+	if got := rec.services[1]; got.Name != "web" || got.Meta[ClusterMeta] != "west" {
+		t.Errorf("unexpected west registration: %+v", got)
+	}
+
+	services, err := east.Services(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Name != "web" {
+		t.Errorf("expected only the east service, got %v", services)
+	}
+}
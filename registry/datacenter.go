@@ -0,0 +1,22 @@
+package registry
+
+import (
+	"context"
+)
+
+type datacenterKey struct{}
+
+// InDatacenter returns a copy of ctx whose Register and Deregister
+// calls go to the catalog of datacenter dc instead of an agent, e.g.
+// for the services mirrored there by --mirror-datacenter. The other
+// calls ignore it.
+func InDatacenter(ctx context.Context, dc string) context.Context {
+	return context.WithValue(ctx, datacenterKey{}, dc)
+}
+
+// Datacenter returns the datacenter of a context of InDatacenter,
+// empty for the local one
+func Datacenter(ctx context.Context) string {
+	dc, _ := ctx.Value(datacenterKey{}).(string)
+	return dc
+}
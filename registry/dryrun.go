@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"context"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// A Registry logging the writes to another instead of making them,
+// see DryRun
+type dryRun struct {
+	Registry
+}
+
+// DryRun wraps r so reads still reach it but every write is only
+// logged, e.g. to review the registrations of a new configuration
+// before applying it with --dry-run.
+func DryRun(r Registry) Registry {
+	return &dryRun{r}
+}
+
+func (d *dryRun) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	hclog.L().Info("dry-run: register", "service_id", service.ID, "service", service.Name,
+		"address", service.Address, "port", service.Port, "tags", service.Tags, "agent", agent)
+	return nil
+}
+
+func (d *dryRun) Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	hclog.L().Info("dry-run: deregister", "service_id", service.ID, "agent", agent)
+	return nil
+}
+
+func (d *dryRun) Put(ctx context.Context, key string, value []byte) error {
+	hclog.L().Debug("dry-run: put", "key", key, "bytes", len(value))
+	return nil
+}
+
+func (d *dryRun) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	hclog.L().Debug("dry-run: txn", "puts", len(puts), "deletes", len(deletes))
+	return nil
+}
+
+func (d *dryRun) UpdateTTL(ctx context.Context, agent string, checkID string, passing bool, note string) error {
+	hclog.L().Debug("dry-run: update TTL check", "check_id", checkID, "agent", agent, "passing", passing, "note", note)
+	return nil
+}
+
+func (d *dryRun) Maintenance(ctx context.Context, agent string, enable bool, reason string) error {
+	hclog.L().Info("dry-run: set maintenance", "agent", agent, "enable", enable, "reason", reason)
+	return nil
+}
+
+func (d *dryRun) FireEvent(ctx context.Context, name string, action string, service *consulapi.AgentServiceRegistration) error {
+	hclog.L().Debug("dry-run: fire event", "service_id", service.ID, "event", name, "action", action)
+	return nil
+}
+
+func (d *dryRun) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	hclog.L().Info("dry-run: set prepared query", "query", query.Name, "service", query.Service.Service, "failover", query.Service.Failover)
+	return nil
+}
+
+func (d *dryRun) DeleteQuery(ctx context.Context, id string) error {
+	hclog.L().Info("dry-run: delete prepared query", "query", id)
+	return nil
+}
+
+// Registrations are not made, so there is nothing to verify
+func (d *dryRun) CheckRegistration(ctx context.Context) error {
+	return nil
+}
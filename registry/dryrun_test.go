@@ -0,0 +1,24 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestDryRunSkipsWrites(t *testing.T) {
+	f := &flaky{fails: 1}
+	r := DryRun(f)
+
+	if err := r.Register(context.Background(), "", &consulapi.AgentServiceRegistration{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Deregister(context.Background(), "", &consulapi.AgentServiceRegistration{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.calls != 0 {
+		t.Errorf("expected no writes to reach the registry, got %d", f.calls)
+	}
+}
@@ -0,0 +1,375 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/hook"
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// How long the reload of a changed directory waits for more changes,
+// so a burst of writes outside of a sync is reloaded at once
+const reloadDelay = time.Second
+
+// The longest a reload command may run
+const reloadTimeout = time.Minute
+
+// A FileRegistry writing the services registered through it as
+// Consul service definition files, see Files
+type FileRegistry struct {
+	Registry
+
+	dir      string
+	reload   string
+	httpAddr func(agent string) string
+	only     bool
+	delay    time.Duration
+
+	lock sync.Mutex
+	// The agents whose directory changed since the last reload
+	changed map[string]bool
+	timer   *time.Timer
+}
+
+// Files wraps r so every service registered through it is also
+// written as a service definition file, {"service": {...}}, named
+// after its ID in the directory of its agent: dir itself for the
+// agent mesos-consul talks to, dir/<agent> for the others. The
+// definition is removed when the service is deregistered. After a
+// change, reload is run with the shell, see hook.Shell, with the
+// MESOS_CONSUL_AGENT and MESOS_CONSUL_SERVICE_DIR environment
+// variables naming the agent and its directory, for the agent to load
+// it, e.g. consul reload. For the other agents, CONSUL_HTTP_ADDR is
+// set to their httpAddr, so the consul CLI reaches them rather than
+// the local agent. With only, registrations are only written to
+// the files and reads still reach r. Registrations into another
+// datacenter, see InDatacenter, have no agent and always reach r.
+func Files(r Registry, dir, reload string, httpAddr func(agent string) string, only bool) *FileRegistry {
+	return &FileRegistry{Registry: r, dir: dir, reload: reload, httpAddr: httpAddr, only: only, delay: reloadDelay, changed: make(map[string]bool)}
+}
+
+func (f *FileRegistry) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	if Datacenter(ctx) != "" {
+		return f.Registry.Register(ctx, agent, service)
+	}
+
+	if err := f.write(agent, service); err != nil {
+		return err
+	}
+	if f.only {
+		return nil
+	}
+
+	return f.Registry.Register(ctx, agent, service)
+}
+
+func (f *FileRegistry) Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	if Datacenter(ctx) != "" {
+		return f.Registry.Deregister(ctx, agent, service)
+	}
+
+	err := os.Remove(f.Path(agent, service.ID))
+	switch {
+	case err == nil:
+		f.changedDir(agent)
+	case !os.IsNotExist(err):
+		return err
+	}
+	if f.only {
+		return nil
+	}
+
+	return f.Registry.Deregister(ctx, agent, service)
+}
+
+// Verify the directory takes files and, unless only the files are
+// written, that r accepts registrations
+func (f *FileRegistry) CheckRegistration(ctx context.Context) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".mesos-consul-preflight")
+	if err != nil {
+		return fmt.Errorf("cannot write service files: %v", err)
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	if f.only {
+		return nil
+	}
+
+	return f.Registry.CheckRegistration(ctx)
+}
+
+// Path returns the file the definition of the service with id
+// registered with agent is written to
+func (f *FileRegistry) Path(agent, id string) string {
+	return filepath.Join(f.agentDir(agent), fileName(id)+".json")
+}
+
+func (f *FileRegistry) agentDir(agent string) string {
+	if agent == "" {
+		return f.dir
+	}
+	return filepath.Join(f.dir, fileName(agent))
+}
+
+// Replace the characters a file name cannot hold on every platform,
+// e.g. the colons of service IDs and agent addresses
+func fileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Write the definition of service, through a temporary file so the
+// agent never loads half of it. An unchanged definition is left as is
+// and needs no reload.
+func (f *FileRegistry) write(agent string, service *consulapi.AgentServiceRegistration) error {
+	body, err := json.MarshalIndent(map[string]serviceDefinition{"service": definition(service)}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := f.Path(agent, service.ID)
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, body) {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	f.changedDir(agent)
+	return nil
+}
+
+// Schedule the reload of the directory of agent
+func (f *FileRegistry) changedDir(agent string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.changed[agent] = true
+	if f.timer == nil {
+		f.timer = time.AfterFunc(f.delay, f.Reload)
+	}
+}
+
+// Reload runs the reload command for every directory changed since the
+// last reload, e.g. at the end of a sync rather than after the delay
+// of the changes. It is a no-op on a nil FileRegistry.
+func (f *FileRegistry) Reload() {
+	if f == nil {
+		return
+	}
+
+	f.lock.Lock()
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	agents := make([]string, 0, len(f.changed))
+	for agent := range f.changed {
+		agents = append(agents, agent)
+	}
+	f.changed = make(map[string]bool)
+	f.lock.Unlock()
+
+	if f.reload == "" {
+		return
+	}
+
+	sort.Strings(agents)
+	for _, agent := range agents {
+		ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+		cmd := hook.Shell(ctx, f.reload)
+		cmd.Env = append(os.Environ(),
+			"MESOS_CONSUL_AGENT="+agent,
+			"MESOS_CONSUL_SERVICE_DIR="+f.agentDir(agent),
+		)
+		if agent != "" && f.httpAddr != nil {
+			cmd.Env = append(cmd.Env, "CONSUL_HTTP_ADDR="+f.httpAddr(agent))
+		}
+		out, err := cmd.CombinedOutput()
+		cancel()
+		if err != nil {
+			hclog.L().Warn("Unable to reload the service files", "agent", agent, "dir", f.agentDir(agent), "error", err, "output", strings.TrimSpace(string(out)))
+			continue
+		}
+		hclog.L().Debug("Reloaded the service files", "agent", agent, "dir", f.agentDir(agent))
+	}
+}
+
+// A service definition as the Consul agent reads it from its
+// configuration files, with the snake case keys of those
+type serviceDefinition struct {
+	ID                string                       `json:"id,omitempty"`
+	Name              string                       `json:"name"`
+	Tags              []string                     `json:"tags,omitempty"`
+	Address           string                       `json:"address,omitempty"`
+	TaggedAddresses   map[string]addressDefinition `json:"tagged_addresses,omitempty"`
+	Port              int                          `json:"port,omitempty"`
+	Meta              map[string]string            `json:"meta,omitempty"`
+	EnableTagOverride bool                         `json:"enable_tag_override,omitempty"`
+	Weights           *weightsDefinition           `json:"weights,omitempty"`
+	Check             *checkDefinition             `json:"check,omitempty"`
+	Checks            []*checkDefinition           `json:"checks,omitempty"`
+	Connect           *connectDefinition           `json:"connect,omitempty"`
+	Namespace         string                       `json:"namespace,omitempty"`
+	Partition         string                       `json:"partition,omitempty"`
+}
+
+type addressDefinition struct {
+	Address string `json:"address"`
+	Port    int    `json:"port,omitempty"`
+}
+
+type weightsDefinition struct {
+	Passing int `json:"passing"`
+	Warning int `json:"warning"`
+}
+
+type checkDefinition struct {
+	CheckID                        string              `json:"id,omitempty"`
+	Name                           string              `json:"name,omitempty"`
+	Notes                          string              `json:"notes,omitempty"`
+	Status                         string              `json:"status,omitempty"`
+	Args                           []string            `json:"args,omitempty"`
+	DockerContainerID              string              `json:"docker_container_id,omitempty"`
+	Shell                          string              `json:"shell,omitempty"`
+	HTTP                           string              `json:"http,omitempty"`
+	Method                         string              `json:"method,omitempty"`
+	Header                         map[string][]string `json:"header,omitempty"`
+	TLSSkipVerify                  bool                `json:"tls_skip_verify,omitempty"`
+	TCP                            string              `json:"tcp,omitempty"`
+	GRPC                           string              `json:"grpc,omitempty"`
+	GRPCUseTLS                     bool                `json:"grpc_use_tls,omitempty"`
+	TTL                            string              `json:"ttl,omitempty"`
+	Interval                       string              `json:"interval,omitempty"`
+	Timeout                        string              `json:"timeout,omitempty"`
+	DeregisterCriticalServiceAfter string              `json:"deregister_critical_service_after,omitempty"`
+}
+
+type connectDefinition struct {
+	Native         bool               `json:"native,omitempty"`
+	SidecarService *sidecarDefinition `json:"sidecar_service,omitempty"`
+}
+
+type sidecarDefinition struct {
+	Proxy *proxyDefinition `json:"proxy,omitempty"`
+}
+
+type proxyDefinition struct {
+	Upstreams []upstreamDefinition `json:"upstreams,omitempty"`
+}
+
+type upstreamDefinition struct {
+	DestinationType string `json:"destination_type,omitempty"`
+	DestinationName string `json:"destination_name"`
+	LocalBindPort   int    `json:"local_bind_port"`
+}
+
+// The definition of s, with the fields of the registrations
+// mesos-consul makes
+func definition(s *consulapi.AgentServiceRegistration) serviceDefinition {
+	d := serviceDefinition{
+		ID:                s.ID,
+		Name:              s.Name,
+		Tags:              s.Tags,
+		Address:           s.Address,
+		Port:              s.Port,
+		Meta:              s.Meta,
+		EnableTagOverride: s.EnableTagOverride,
+		Check:             checkDefinitionOf(s.Check),
+		Namespace:         s.Namespace,
+		Partition:         s.Partition,
+	}
+	if len(s.TaggedAddresses) > 0 {
+		d.TaggedAddresses = make(map[string]addressDefinition, len(s.TaggedAddresses))
+		for tag, a := range s.TaggedAddresses {
+			d.TaggedAddresses[tag] = addressDefinition{a.Address, a.Port}
+		}
+	}
+	if s.Weights != nil {
+		d.Weights = &weightsDefinition{s.Weights.Passing, s.Weights.Warning}
+	}
+	for _, c := range s.Checks {
+		d.Checks = append(d.Checks, checkDefinitionOf(c))
+	}
+	if c := s.Connect; c != nil {
+		d.Connect = &connectDefinition{Native: c.Native}
+		if c.SidecarService != nil {
+			d.Connect.SidecarService = &sidecarDefinition{}
+			if p := c.SidecarService.Proxy; p != nil {
+				d.Connect.SidecarService.Proxy = &proxyDefinition{}
+				for _, u := range p.Upstreams {
+					d.Connect.SidecarService.Proxy.Upstreams = append(d.Connect.SidecarService.Proxy.Upstreams,
+						upstreamDefinition{string(u.DestinationType), u.DestinationName, u.LocalBindPort})
+				}
+			}
+		}
+	}
+
+	return d
+}
+
+func checkDefinitionOf(c *consulapi.AgentServiceCheck) *checkDefinition {
+	if c == nil {
+		return nil
+	}
+
+	return &checkDefinition{
+		CheckID:                        c.CheckID,
+		Name:                           c.Name,
+		Notes:                          c.Notes,
+		Status:                         c.Status,
+		Args:                           c.Args,
+		DockerContainerID:              c.DockerContainerID,
+		Shell:                          c.Shell,
+		HTTP:                           c.HTTP,
+		Method:                         c.Method,
+		Header:                         c.Header,
+		TLSSkipVerify:                  c.TLSSkipVerify,
+		TCP:                            c.TCP,
+		GRPC:                           c.GRPC,
+		GRPCUseTLS:                     c.GRPCUseTLS,
+		TTL:                            c.TTL,
+		Interval:                       c.Interval,
+		Timeout:                        c.Timeout,
+		DeregisterCriticalServiceAfter: c.DeregisterCriticalServiceAfter,
+	}
+}
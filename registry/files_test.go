@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry counting the registrations and deregistrations reaching it
+type counter struct {
+	Registry
+
+	calls int
+}
+
+func (c *counter) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	c.calls++
+	return nil
+}
+
+func (c *counter) Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	c.calls++
+	return nil
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	reloads := filepath.Join(dir, "reloads")
+	f := &counter{}
+	r := Files(f, dir, `echo "$MESOS_CONSUL_AGENT $MESOS_CONSUL_SERVICE_DIR $CONSUL_HTTP_ADDR" >> `+reloads, func(agent string) string { return agent + ":8500" }, false)
+
+	s := &consulapi.AgentServiceRegistration{
+		ID:    "mesos-consul:10.0.0.1:web:31000",
+		Name:  "web",
+		Port:  31000,
+		Meta:  map[string]string{"mesos-task": "web.1"},
+		Check: &consulapi.AgentServiceCheck{HTTP: "http://10.0.0.1:31000/health", Interval: "10s"},
+	}
+	if err := r.Register(context.Background(), "10.0.0.1", s); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 1 {
+		t.Errorf("expected the registration to reach the registry too, got %d calls", f.calls)
+	}
+
+	path := filepath.Join(dir, "10.0.0.1", "mesos-consul_10.0.0.1_web_31000.json")
+	if r.Path("10.0.0.1", s.ID) != path {
+		t.Fatalf("expected the definition at %s, got %s", path, r.Path("10.0.0.1", s.ID))
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var def map[string]map[string]interface{}
+	if err := json.Unmarshal(body, &def); err != nil {
+		t.Fatal(err)
+	}
+	service := def["service"]
+	if service["id"] != s.ID || service["name"] != "web" || service["port"] != 31000.0 {
+		t.Errorf("expected the service definition, got %s", body)
+	}
+	if check, _ := service["check"].(map[string]interface{}); check["http"] != s.Check.HTTP || check["interval"] != "10s" {
+		t.Errorf("expected the check with snake case keys, got %s", body)
+	}
+
+	// The same registration again needs no reload
+	r.Reload()
+	if err := r.Register(context.Background(), "10.0.0.1", s); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Deregister(context.Background(), "", &consulapi.AgentServiceRegistration{ID: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Reload()
+
+	out, err := os.ReadFile(reloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "10.0.0.1 "+filepath.Join(dir, "10.0.0.1")+" 10.0.0.1:8500" {
+		t.Errorf("expected one reload of the directory of the agent, reaching it, got %q", got)
+	}
+
+	if err := r.Deregister(context.Background(), "10.0.0.1", s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the definition to be removed, got %v", err)
+	}
+	r.Reload()
+}
+
+func TestFilesOnly(t *testing.T) {
+	dir := t.TempDir()
+	f := &counter{}
+	r := Files(f, dir, "", nil, true)
+
+	s := &consulapi.AgentServiceRegistration{ID: "a", Name: "a"}
+	if err := r.Register(context.Background(), "", s); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckRegistration(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 0 {
+		t.Errorf("expected no registrations to reach the registry, got %d", f.calls)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.json")); err != nil {
+		t.Error(err)
+	}
+
+	// A datacenter has no agent to write the files of
+	if err := r.Register(InDatacenter(context.Background(), "west"), "", s); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 1 {
+		t.Errorf("expected the registration into west to reach the registry, got %d calls", f.calls)
+	}
+	r.Reload()
+}
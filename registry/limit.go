@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/retry"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry spreading the writes to another over time, see Limit
+type limited struct {
+	Registry
+
+	rate    float64
+	retries int
+
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Limit wraps r so Register, Deregister and Put are issued at no more
+// than rate calls per second, with bursts of up to one second of
+// calls, and failed ones are retried up to retries times with a
+// jittered exponential backoff unless the failure is permanent, see
+// retry.Do. A rate of 0 does not limit the calls.
+func Limit(r Registry, rate float64, retries int) Registry {
+	l := &limited{
+		Registry: r,
+		rate:     rate,
+		retries:  retries,
+		last:     time.Now(),
+	}
+	l.tokens = l.burst()
+
+	return l
+}
+
+func (l *limited) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	return l.do(ctx, func() error { return l.Registry.Register(ctx, agent, service) })
+}
+
+func (l *limited) Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	return l.do(ctx, func() error { return l.Registry.Deregister(ctx, agent, service) })
+}
+
+func (l *limited) Put(ctx context.Context, key string, value []byte) error {
+	return l.do(ctx, func() error { return l.Registry.Put(ctx, key, value) })
+}
+
+func (l *limited) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	return l.do(ctx, func() error { return l.Registry.Txn(ctx, puts, deletes) })
+}
+
+func (l *limited) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	return l.do(ctx, func() error { return l.Registry.SetQuery(ctx, query) })
+}
+
+func (l *limited) DeleteQuery(ctx context.Context, id string) error {
+	return l.do(ctx, func() error { return l.Registry.DeleteQuery(ctx, id) })
+}
+
+// Run a write once a token is available, retrying it on failure
+// until ctx is done
+func (l *limited) do(ctx context.Context, op func() error) error {
+	return retry.Do(l.retries, func() error {
+		if err := l.wait(ctx); err != nil {
+			return retry.Permanent(err)
+		}
+		return op()
+	})
+}
+
+// Take a token from the bucket, sleeping until it is refilled when
+// it is empty. Tokens are reserved by going negative, so concurrent
+// callers queue up rather than bursting once a token frees. Fails
+// once ctx is done.
+func (l *limited) wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if burst := l.burst(); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limited) burst() float64 {
+	if l.rate < 1 {
+		return 1
+	}
+	return l.rate
+}
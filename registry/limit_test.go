@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry failing the first fails writes
+type flaky struct {
+	Registry
+
+	fails int
+	calls int
+}
+
+func (f *flaky) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	f.calls++
+	if f.calls <= f.fails {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestLimitRetries(t *testing.T) {
+	f := &flaky{fails: 2}
+	r := Limit(f, 0, 2)
+
+	if err := r.Register(context.Background(), "", &consulapi.AgentServiceRegistration{ID: "a"}); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %s", err)
+	}
+	if f.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", f.calls)
+	}
+
+	f = &flaky{fails: 2}
+	r = Limit(f, 0, 1)
+
+	if err := r.Register(context.Background(), "", &consulapi.AgentServiceRegistration{ID: "a"}); err == nil {
+		t.Error("expected an error once the retries are exhausted")
+	}
+	if f.calls != 2 {
+		t.Errorf("expected 2 calls, got %d", f.calls)
+	}
+}
+
+func TestLimitContext(t *testing.T) {
+	f := &flaky{fails: 5}
+	r := Limit(f, 1, 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The first attempt takes the only token, the retry waits a second
+	// for the next one
+	start := time.Now()
+	if err := r.Register(ctx, "", &consulapi.AgentServiceRegistration{ID: "a"}); err == nil {
+		t.Error("expected an error once the context is done")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the retries to stop with the context, took %s", elapsed)
+	}
+	if f.calls != 1 {
+		t.Errorf("expected 1 call, got %d", f.calls)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	f := &flaky{}
+	r := Limit(f, 20, 0)
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		r.Register(context.Background(), "", &consulapi.AgentServiceRegistration{ID: "a"})
+	}
+
+	// A burst of 20, then 10 more at 20 per second
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("expected 30 calls at 20/s to take about 500ms, took %s", elapsed)
+	}
+}
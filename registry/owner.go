@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"context"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// The service meta marking the services mesos-consul registers, see
+// Owned
+const (
+	ManagedByMeta = "managed-by"
+	InstanceMeta  = "mesos-consul-instance"
+)
+
+// The service meta naming the file of the ACL token a service is
+// registered with, relative to --registry-token-dir
+const TokenPathMeta = "mesos-token-path"
+
+// The ManagedByMeta value of the services mesos-consul registers
+const ManagedBy = "mesos-consul"
+
+// A Registry marking the services registered through it, see Owned
+type owned struct {
+	Registry
+
+	instance string
+}
+
+// Owned wraps r so every service registered through it is marked as
+// managed by mesos-consul and owned by instance, e.g. for telling its
+// orphans apart from the services of other instances after losing
+// the cache.
+func Owned(r Registry, instance string) Registry {
+	return &owned{r, instance}
+}
+
+func (o *owned) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	s := *service
+
+	s.Meta = make(map[string]string, len(service.Meta)+2)
+	for k, v := range service.Meta {
+		s.Meta[k] = v
+	}
+	s.Meta[ManagedByMeta] = ManagedBy
+	s.Meta[InstanceMeta] = o.instance
+
+	return o.Registry.Register(ctx, agent, &s)
+}
+
+// Prepared queries only return the services of the instance and are
+// told apart by the same marks, see Queries
+func (o *owned) SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error {
+	q := *query
+
+	q.Service.ServiceMeta = make(map[string]string, len(query.Service.ServiceMeta)+2)
+	for k, v := range query.Service.ServiceMeta {
+		q.Service.ServiceMeta[k] = v
+	}
+	q.Service.ServiceMeta[ManagedByMeta] = ManagedBy
+	q.Service.ServiceMeta[InstanceMeta] = o.instance
+
+	err := o.Registry.SetQuery(ctx, &q)
+	query.ID = q.ID
+
+	return err
+}
+
+// Queries lists the prepared queries created through SetQuery
+func (o *owned) Queries(ctx context.Context) ([]*consulapi.PreparedQueryDefinition, error) {
+	queries, err := o.Registry.Queries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var own []*consulapi.PreparedQueryDefinition
+	for _, q := range queries {
+		meta := q.Service.ServiceMeta
+		if meta[ManagedByMeta] == ManagedBy && meta[InstanceMeta] == o.instance {
+			delete(meta, ManagedByMeta)
+			delete(meta, InstanceMeta)
+			own = append(own, q)
+		}
+	}
+
+	return own, nil
+}
+
+// OwnedBy tells whether service may belong to instance: it is marked
+// as owned by it, or carries no mark, e.g. because an older release
+// registered it
+func OwnedBy(service *consulapi.AgentServiceRegistration, instance string) bool {
+	owner, ok := service.Meta[InstanceMeta]
+	return !ok || owner == instance
+}
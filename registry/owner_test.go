@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestOwned(t *testing.T) {
+	rec := &recorder{}
+	r := Owned(rec, "mesos-consul/east")
+
+	s := &consulapi.AgentServiceRegistration{ID: "a", Meta: map[string]string{"version": "1"}}
+	if err := r.Register(context.Background(), "", s); err != nil {
+		t.Fatal(err)
+	}
+
+	got := rec.services[0]
+	if got.Meta[ManagedByMeta] != ManagedBy || got.Meta[InstanceMeta] != "mesos-consul/east" || got.Meta["version"] != "1" {
+		t.Errorf("unexpected meta %v", got.Meta)
+	}
+	if len(s.Meta) != 1 {
+		t.Errorf("expected the registration to be left untouched, got %v", s.Meta)
+	}
+
+	if !OwnedBy(got, "mesos-consul/east") || OwnedBy(got, "mesos-consul/west") {
+		t.Error("expected the service to be owned by its instance only")
+	}
+	if !OwnedBy(&consulapi.AgentServiceRegistration{ID: "b"}, "mesos-consul/west") {
+		t.Error("expected an unmarked service to be owned by any instance")
+	}
+}
+
+func TestOwnedQueries(t *testing.T) {
+	rec := &recorder{queries: []consulapi.PreparedQueryDefinition{{ID: "ops", Name: "ops"}}}
+	east := Cluster(Owned(rec, "mesos-consul"), "east", "east-")
+	west := Cluster(Owned(rec, "mesos-consul"), "west", "")
+
+	q := &consulapi.PreparedQueryDefinition{Name: "web", Service: consulapi.ServiceQuery{Service: "web"}}
+	if err := east.SetQuery(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if q.ID != "east-web" || q.Name != "web" || q.Service.ServiceMeta != nil {
+		t.Errorf("expected the query to get its ID and be left untouched, got %+v", q)
+	}
+
+	got := rec.queries[1]
+	if got.Name != "east-web" || got.Service.Service != "east-web" || got.Service.ServiceMeta[ClusterMeta] != "east" ||
+		got.Service.ServiceMeta[ManagedByMeta] != ManagedBy || got.Service.ServiceMeta[InstanceMeta] != "mesos-consul" {
+		t.Errorf("unexpected query %+v", got)
+	}
+
+	queries, err := east.Queries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0].Name != "web" || queries[0].Service.Service != "web" || len(queries[0].Service.ServiceMeta) != 0 {
+		t.Errorf("expected the east query as it was set, got %+v", queries)
+	}
+
+	if queries, _ := west.Queries(context.Background()); len(queries) != 0 {
+		t.Errorf("expected neither the east nor the operator's queries, got %+v", queries)
+	}
+}
@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry is the service store mesos-consul syncs the Mesos state
+// into. Services are described with the Consul agent registration
+// type so every backend registers the same fields; backends without
+// an equivalent for a field, e.g. checks, may ignore it. Every call
+// gives up once its ctx is done.
+type Registry interface {
+	// Register a service with agent, replacing any with the same ID.
+	// An empty agent is the one mesos-consul itself talks to.
+	Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error
+
+	// Remove a service registered with Register from agent
+	Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error
+
+	// List the registered services whose ID starts with prefix
+	Services(ctx context.Context, prefix string) ([]*Service, error)
+
+	// Block until the registered services change past waitIndex or
+	// wait elapses, returning the index to wait past next
+	WaitServices(ctx context.Context, waitIndex uint64, wait time.Duration) (uint64, error)
+
+	// List the health checks of the registered services whose ID
+	// starts with prefix
+	Checks(ctx context.Context, prefix string) ([]*consulapi.HealthCheck, error)
+
+	// Read key from the store. A missing key returns a nil value.
+	// With a non-zero waitIndex the read blocks until the key changes
+	// past waitIndex or wait elapses.
+	Get(ctx context.Context, key string, waitIndex uint64, wait time.Duration) ([]byte, uint64, error)
+
+	// Write key to the store
+	Put(ctx context.Context, key string, value []byte) error
+
+	// Read the keys of the store starting with prefix, blocking like
+	// Get() with a non-zero waitIndex
+	List(ctx context.Context, prefix string, waitIndex uint64, wait time.Duration) (map[string][]byte, uint64, error)
+
+	// Write puts and remove deletes from the store, atomically where
+	// the store allows it
+	Txn(ctx context.Context, puts map[string][]byte, deletes []string) error
+
+	// Verify the registry accepts registrations, e.g. that its
+	// credentials allow them
+	CheckRegistration(ctx context.Context) error
+
+	// Mark a TTL check as passing or failing on the agent its service
+	// was registered with
+	UpdateTTL(ctx context.Context, agent string, checkID string, passing bool, note string) error
+
+	// Put the node of agent into maintenance mode for reason, or take
+	// it out of it
+	Maintenance(ctx context.Context, agent string, enable bool, reason string) error
+
+	// Notify watchers of a change to service
+	FireEvent(ctx context.Context, name string, action string, service *consulapi.AgentServiceRegistration) error
+
+	// List the prepared queries
+	Queries(ctx context.Context) ([]*consulapi.PreparedQueryDefinition, error)
+
+	// Create a prepared query, or update it when it has an ID
+	SetQuery(ctx context.Context, query *consulapi.PreparedQueryDefinition) error
+
+	// Delete the prepared query with id
+	DeleteQuery(ctx context.Context, id string) error
+}
+
+// A Service listed by Services: its registration and the agent holding
+// it, the address of its catalog node, to deregister it from
+type Service struct {
+	*consulapi.AgentServiceRegistration
+
+	Agent string
+}
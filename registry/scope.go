@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"context"
+)
+
+// A Consul Enterprise namespace and admin partition, empty for the
+// default ones
+type Scope struct {
+	Namespace string
+	Partition string
+}
+
+type scopesKey struct{}
+
+// InScopes returns a copy of ctx whose Services calls also list the
+// services of scopes, besides those of --consul-namespace and
+// --consul-partition. The other calls ignore it.
+func InScopes(ctx context.Context, scopes []Scope) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// Scopes returns the scopes of a context of InScopes
+func Scopes(ctx context.Context) []Scope {
+	scopes, _ := ctx.Value(scopesKey{}).([]Scope)
+	return scopes
+}
@@ -0,0 +1,21 @@
+package registry
+
+import (
+	"context"
+)
+
+type staleKey struct{}
+
+// AllowStale returns a copy of ctx whose reads any Consul server can
+// answer, rather than only the leader, at the price of results
+// possibly a little behind it, e.g. for --consul-allow-stale. Writes
+// ignore it.
+func AllowStale(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleKey{}, true)
+}
+
+// Stale tells whether the reads of ctx may be stale, see AllowStale
+func Stale(ctx context.Context) bool {
+	stale, _ := ctx.Value(staleKey{}).(bool)
+	return stale
+}
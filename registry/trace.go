@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+
+	"github.com/CiscoCloud/mesos-consul/tracing"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// A Registry tracing the calls to another, see Traced
+type traced struct {
+	Registry
+}
+
+// Traced wraps r so every call made within a trace, e.g. of a sync,
+// is recorded as a span of it, see tracing.Client. Calls outside of
+// one, e.g. the blocking queries of the watchers, are not traced.
+func Traced(r Registry) Registry {
+	return &traced{r}
+}
+
+func (t *traced) Register(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	ctx, span := tracing.Client(ctx, "consul.register", "service.id", service.ID, "service.name", service.Name, "agent", agent)
+	defer span.End()
+
+	err := t.Registry.Register(ctx, agent, service)
+	span.Fail(err)
+	return err
+}
+
+func (t *traced) Deregister(ctx context.Context, agent string, service *consulapi.AgentServiceRegistration) error {
+	ctx, span := tracing.Client(ctx, "consul.deregister", "service.id", service.ID, "service.name", service.Name, "agent", agent)
+	defer span.End()
+
+	err := t.Registry.Deregister(ctx, agent, service)
+	span.Fail(err)
+	return err
+}
+
+func (t *traced) Services(ctx context.Context, prefix string) ([]*Service, error) {
+	ctx, span := tracing.Client(ctx, "consul.services", "prefix", prefix)
+	defer span.End()
+
+	services, err := t.Registry.Services(ctx, prefix)
+	span.Set("services", len(services))
+	span.Fail(err)
+	return services, err
+}
+
+func (t *traced) Checks(ctx context.Context, prefix string) ([]*consulapi.HealthCheck, error) {
+	ctx, span := tracing.Client(ctx, "consul.checks", "prefix", prefix)
+	defer span.End()
+
+	checks, err := t.Registry.Checks(ctx, prefix)
+	span.Fail(err)
+	return checks, err
+}
+
+func (t *traced) Put(ctx context.Context, key string, value []byte) error {
+	ctx, span := tracing.Client(ctx, "consul.kv.put", "key", key, "bytes", len(value))
+	defer span.End()
+
+	err := t.Registry.Put(ctx, key, value)
+	span.Fail(err)
+	return err
+}
+
+func (t *traced) Txn(ctx context.Context, puts map[string][]byte, deletes []string) error {
+	ctx, span := tracing.Client(ctx, "consul.kv.txn", "puts", len(puts), "deletes", len(deletes))
+	defer span.End()
+
+	err := t.Registry.Txn(ctx, puts, deletes)
+	span.Fail(err)
+	return err
+}
+
+func (t *traced) UpdateTTL(ctx context.Context, agent string, checkID string, passing bool, note string) error {
+	ctx, span := tracing.Client(ctx, "consul.ttl", "check.id", checkID, "agent", agent, "passing", passing)
+	defer span.End()
+
+	err := t.Registry.UpdateTTL(ctx, agent, checkID, passing, note)
+	span.Fail(err)
+	return err
+}
@@ -0,0 +1,72 @@
+// Package retry retries the calls mesos-consul makes to Consul and
+// Mesos when they fail transiently.
+package retry
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// An error retrying cannot fix, see Permanent
+type permanent struct {
+	err error
+}
+
+func (p permanent) Error() string {
+	return p.err.Error()
+}
+
+func (p permanent) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as one retrying cannot fix, e.g. a request the
+// server rejected as invalid
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanent{err}
+}
+
+// Retryable tells whether retrying may fix err. Errors marked with
+// Permanent are final, as are the Consul responses other than 429 and
+// 5xx, e.g. a 400 for an invalid registration. Everything else, e.g.
+// timeouts and refused connections, is retried.
+func Retryable(err error) bool {
+	var p permanent
+	if errors.As(err, &p) {
+		return false
+	}
+
+	var status consulapi.StatusError
+	if errors.As(err, &status) {
+		return status.Code == http.StatusTooManyRequests || status.Code >= 500
+	}
+
+	return true
+}
+
+// Do runs op until it succeeds, fails with an error that is not
+// Retryable or has been retried retries times, with a jittered
+// exponential backoff starting at 100ms between the attempts.
+func Do(retries int, op func() error) error {
+	var err error
+
+	for i := 0; ; i++ {
+		if err = op(); err == nil || i >= retries || !Retryable(err) {
+			return err
+		}
+
+		backoff := (100 * time.Millisecond) << uint(i)
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		hclog.L().Debug("Retrying", "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+	}
+}
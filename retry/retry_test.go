@@ -0,0 +1,57 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{consulapi.StatusError{Code: 500}, true},
+		{consulapi.StatusError{Code: 429}, true},
+		{fmt.Errorf("register: %w", consulapi.StatusError{Code: 503}), true},
+		{consulapi.StatusError{Code: 400}, false},
+		{consulapi.StatusError{Code: 403}, false},
+		{Permanent(errors.New("bad request")), false},
+	}
+
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	calls := 0
+	err := Do(2, func() error {
+		calls++
+		if calls < 3 {
+			return consulapi.StatusError{Code: 503}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected the third attempt to succeed, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = Do(2, func() error {
+		calls++
+		return consulapi.StatusError{Code: 400}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected a permanent error not to be retried, got %v after %d calls", err, calls)
+	}
+
+	if Permanent(nil) != nil {
+		t.Error("expected Permanent(nil) to be nil")
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Deliver the signals shutting down the daemon to shutdown, and
+// SIGHUP, reloading the configuration, to reload
+func notifySignals(shutdown chan<- os.Signal, reload chan<- os.Signal) {
+	signal.Notify(shutdown, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(reload, syscall.SIGHUP)
+}
+
+// Where the log goes
+func logOutput() io.Writer {
+	return os.Stderr
+}
+
+// Run main, which only Windows services wrap
+func runMain(main func()) {
+	main()
+}
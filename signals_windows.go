@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// The requests of the service control manager, see serviceHandler
+var (
+	serviceStop   = make(chan os.Signal, 1)
+	serviceReload = make(chan os.Signal, 1)
+)
+
+// The log of the process, opened once for every reload
+var (
+	logOnce sync.Once
+	logTo   io.Writer
+)
+
+// Deliver Ctrl+C and the stop and shutdown requests of the service
+// control manager to shutdown, and its paramchange requests, e.g. from
+// sc.exe control mesos-consul paramchange, to reload, as Windows has
+// no SIGHUP
+func notifySignals(shutdown chan<- os.Signal, reload chan<- os.Signal) {
+	signal.Notify(shutdown, os.Interrupt)
+
+	go func() {
+		for {
+			select {
+			case sig := <-serviceStop:
+				shutdown <- sig
+			case sig := <-serviceReload:
+				reload <- sig
+			}
+		}
+	}()
+}
+
+// Where the log goes: the Application event log for a service, which
+// has no console, else the console
+func logOutput() io.Writer {
+	logOnce.Do(func() {
+		logTo = os.Stderr
+		if service, err := svc.IsWindowsService(); err != nil || !service {
+			return
+		}
+		if l, err := eventlog.Open(Name); err == nil {
+			logTo = eventLogWriter{l}
+		}
+	})
+
+	return logTo
+}
+
+// Run main, as a service when the service control manager started the
+// process, e.g. once created with
+// sc.exe create mesos-consul binPath= "C:\mesos-consul\mesos-consul.exe --zk=..."
+func runMain(main func()) {
+	if service, err := svc.IsWindowsService(); err != nil || !service {
+		main()
+		return
+	}
+
+	if err := svc.Run(Name, serviceHandler{main}); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Runs main as a service until it returns or the service is stopped
+type serviceHandler struct {
+	main func()
+}
+
+func (h serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.main()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for {
+		select {
+		case <-done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				send(serviceStop, syscall.SIGTERM)
+			case svc.ParamChange:
+				send(serviceReload, syscall.SIGHUP)
+			}
+		}
+	}
+}
+
+// Send sig unless one is pending already
+func send(ch chan os.Signal, sig os.Signal) {
+	select {
+	case ch <- sig:
+	default:
+	}
+}
+
+// Writes every line of the log as an event, a warning or error one
+// for the lines of those levels
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSpace(string(p))
+
+	var err error
+	switch {
+	case strings.Contains(line, "[ERROR]"):
+		err = w.log.Error(1, line)
+	case strings.Contains(line, "[WARN]"):
+		err = w.log.Warning(1, line)
+	default:
+		err = w.log.Info(1, line)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
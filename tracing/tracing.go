@@ -0,0 +1,353 @@
+// Package tracing records the stages of the syncs of mesos-consul as
+// OpenTelemetry spans, e.g. the Mesos state fetch and every Consul
+// call, and exports them to an OTLP collector over HTTP.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// How often the ended spans are exported, besides at the end of every
+// trace
+const exportInterval = 5 * time.Second
+
+// The most ended spans waiting for their export. Those over it are
+// dropped, e.g. while the collector is down.
+const maxQueue = 65536
+
+// Kinds of spans, as numbered by OTLP
+const (
+	kindInternal = 1
+	kindClient   = 3
+)
+
+// Status codes of spans, as numbered by OTLP
+const statusError = 2
+
+// A Tracer starts the traces of the syncs and exports their spans
+type Tracer struct {
+	url     string
+	service string
+	headers map[string]string
+	client  *http.Client
+
+	lock    sync.Mutex
+	queue   []*Span
+	dropped int
+
+	flush chan struct{}
+}
+
+// New returns a Tracer exporting to the OTLP/HTTP collector at
+// endpoint, e.g. http://127.0.0.1:4318, with headers, e.g. the API key
+// of a tracing vendor, as the spans of service
+func New(endpoint string, service string, headers map[string]string) *Tracer {
+	t := &Tracer{
+		url:     strings.TrimRight(endpoint, "/") + "/v1/traces",
+		service: service,
+		headers: headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		flush:   make(chan struct{}, 1),
+	}
+	go t.run()
+
+	return t
+}
+
+// A Span of a trace, from its start to End. The methods of a nil Span,
+// as started without a trace, do nothing.
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	root    bool
+
+	name  string
+	kind  int
+	start time.Time
+	end   time.Time
+
+	lock   sync.Mutex
+	attrs  []interface{}
+	failed string
+}
+
+type spanKey struct{}
+
+// Start a trace, e.g. of a sync, with a root span named name and the
+// attributes of attrs, alternating keys and values like hclog
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: t, root: true, name: name, kind: kindInternal, start: time.Now(), attrs: attrs}
+	rand.Read(s.traceID[:])
+	rand.Read(s.id[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Begin a span of the work of mesos-consul itself, e.g. a stage of a
+// sync, as a child of the span of ctx. Without one, nothing is traced.
+func Begin(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	return begin(ctx, name, kindInternal, attrs)
+}
+
+// Client begins a span like Begin, of a call to another system, e.g.
+// to Consul or a Mesos master
+func Client(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	return begin(ctx, name, kindClient, attrs)
+}
+
+func begin(ctx context.Context, name string, kind int, attrs []interface{}) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return ctx, nil
+	}
+
+	s := &Span{
+		tracer:  parent.tracer,
+		traceID: parent.traceID,
+		parent:  parent.id,
+		name:    name,
+		kind:    kind,
+		start:   time.Now(),
+		attrs:   attrs,
+	}
+	rand.Read(s.id[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Add attributes to the span, alternating keys and values
+func (s *Span) Set(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// Mark the span as failed with err, when it is not nil
+func (s *Span) Fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failed = err.Error()
+}
+
+// End the span, queueing it for its export. Ending the root span of a
+// trace exports it without waiting for the next export interval.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.end = time.Now()
+	s.lock.Unlock()
+
+	t := s.tracer
+	t.lock.Lock()
+	if len(t.queue) < maxQueue {
+		t.queue = append(t.queue, s)
+	} else {
+		t.dropped++
+	}
+	t.lock.Unlock()
+
+	if s.root {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		}
+
+		if err := t.Flush(); err != nil {
+			hclog.L().Warn("Unable to export the traces", "error", err)
+		}
+	}
+}
+
+// Flush exports the ended spans, e.g. before exiting
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	t.lock.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.lock.Unlock()
+
+	if dropped > 0 {
+		hclog.L().Warn("Dropped spans over the export queue", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", t.url, resp.Status)
+	}
+
+	return nil
+}
+
+// The JSON encoding of an OTLP export request
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+// The value of an attribute, one of its fields set. 64-bit integers
+// are strings in the JSON encoding of OTLP.
+type attributeValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (t *Tracer) request(spans []*Span) exportRequest {
+	encoded := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		s.lock.Lock()
+		js := spanJSON{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attrs),
+		}
+		if !s.root {
+			js.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.failed != "" {
+			js.Status = &status{Code: statusError, Message: s.failed}
+		}
+		s.lock.Unlock()
+
+		encoded = append(encoded, js)
+	}
+
+	return exportRequest{[]resourceSpans{{
+		Resource:   resource{attributes([]interface{}{"service.name", t.service})},
+		ScopeSpans: []scopeSpans{{Scope: scope{"mesos-consul"}, Spans: encoded}},
+	}}}
+}
+
+// The attributes of alternating keys and values. Integers and booleans
+// keep their type, other values are formatted as strings.
+func attributes(kv []interface{}) []attribute {
+	var attrs []attribute
+	for i := 0; i+1 < len(kv); i += 2 {
+		a := attribute{Key: fmt.Sprint(kv[i])}
+		switch v := kv[i+1].(type) {
+		case int:
+			s := strconv.Itoa(v)
+			a.Value.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			a.Value.IntValue = &s
+		case bool:
+			a.Value.BoolValue = &v
+		default:
+			s := fmt.Sprint(v)
+			a.Value.StringValue = &s
+		}
+		attrs = append(attrs, a)
+	}
+
+	return attrs
+}
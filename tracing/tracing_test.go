@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Collect the export requests posted to a test collector
+func collector(t *testing.T) (string, <-chan *http.Request, <-chan exportRequest) {
+	requests := make(chan *http.Request, 10)
+	exports := make(chan exportRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var e exportRequest
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Error(err)
+		}
+		requests <- r
+		exports <- e
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, requests, exports
+}
+
+func TestExport(t *testing.T) {
+	url, requests, exports := collector(t)
+	tracer := New(url+"/", "mesos-consul", map[string]string{"X-Key": "secret"})
+
+	ctx, root := tracer.Start(context.Background(), "sync", "instance", "east")
+	fetchCtx, fetch := Begin(ctx, "mesos.fetch")
+	_, request := Client(fetchCtx, "mesos.request", "http.status_code", 503, "retried", true)
+	request.Fail(errors.New("503 Service Unavailable"))
+	request.End()
+	fetch.End()
+	root.Set("services", 3)
+	root.End()
+
+	var e exportRequest
+	select {
+	case r := <-requests:
+		if r.URL.Path != "/v1/traces" || r.Header.Get("X-Key") != "secret" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST to /v1/traces with the headers, got %s with %v", r.URL.Path, r.Header)
+		}
+		e = <-exports
+	case <-time.After(time.Second):
+		t.Fatal("expected the trace to be exported when its root span ends")
+	}
+
+	if len(e.ResourceSpans) != 1 || *e.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "mesos-consul" {
+		t.Fatalf("expected the spans of the mesos-consul service, got %+v", e)
+	}
+	spans := e.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %+v", spans)
+	}
+
+	byName := make(map[string]spanJSON)
+	for _, s := range spans {
+		byName[s.Name] = s
+		if s.TraceID != spans[0].TraceID || len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Errorf("expected the spans of one trace, got %+v", s)
+		}
+	}
+	sync, fetchSpan, req := byName["sync"], byName["mesos.fetch"], byName["mesos.request"]
+	if sync.ParentSpanID != "" || fetchSpan.ParentSpanID != sync.SpanID || req.ParentSpanID != fetchSpan.SpanID {
+		t.Errorf("expected sync > mesos.fetch > mesos.request, got %+v", spans)
+	}
+	if req.Kind != kindClient || fetchSpan.Kind != kindInternal {
+		t.Errorf("expected a client request within an internal fetch, got %d and %d", req.Kind, fetchSpan.Kind)
+	}
+	if req.Status == nil || req.Status.Code != statusError || req.Status.Message != "503 Service Unavailable" || fetchSpan.Status != nil {
+		t.Errorf("expected only the request to fail, got %+v and %+v", req.Status, fetchSpan.Status)
+	}
+	if a := req.Attributes; len(a) != 2 || *a[0].Value.IntValue != "503" || !*a[1].Value.BoolValue {
+		t.Errorf("expected typed attributes, got %+v", a)
+	}
+	if a := sync.Attributes; len(a) != 2 || a[1].Key != "services" || *a[1].Value.IntValue != "3" {
+		t.Errorf("expected the attributes set after the start, got %+v", a)
+	}
+}
+
+func TestUntraced(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "sync")
+	if span != nil {
+		t.Fatal("expected no span without a tracer")
+	}
+
+	if _, child := Begin(ctx, "register"); child != nil {
+		t.Error("expected no span outside of a trace")
+	}
+	span.Set("services", 1)
+	span.Fail(errors.New("failed"))
+	span.End()
+
+	if err := tracer.Flush(); err != nil {
+		t.Error(err)
+	}
+}
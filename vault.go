@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/CiscoCloud/mesos-consul/config"
+	"github.com/CiscoCloud/mesos-consul/vault"
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Whether any secret is read from Vault
+func usesVault(c *config.Config) bool {
+	return c.VaultRegistryToken != "" || c.VaultMesosCredential != "" ||
+		c.VaultRegistrySSL != "" || c.VaultMesosSSL != ""
+}
+
+// Read the --vault-* secrets into c, writing the TLS material to
+// private files since the clients take file names
+func readVault(c *config.Config) error {
+	if !usesVault(c) {
+		return nil
+	}
+
+	client, err := vaultClient(c)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := readSecrets(client, c)
+	if err != nil {
+		return err
+	}
+
+	if s := secrets[c.VaultRegistryToken]; s != nil {
+		if c.RegistryToken, err = s.Field("token"); err != nil {
+			return fmt.Errorf("%s: %s", c.VaultRegistryToken, err)
+		}
+	}
+
+	if s := secrets[c.VaultMesosCredential]; s != nil {
+		if c.MesosUser, err = s.Field("principal"); err != nil {
+			return fmt.Errorf("%s: %s", c.VaultMesosCredential, err)
+		}
+		if c.MesosPassword, err = s.Field("secret"); err != nil {
+			return fmt.Errorf("%s: %s", c.VaultMesosCredential, err)
+		}
+	}
+
+	if s := secrets[c.VaultRegistrySSL]; s != nil {
+		if err := writeTLS(c.RegistrySSL, "registry", s); err != nil {
+			return fmt.Errorf("%s: %s", c.VaultRegistrySSL, err)
+		}
+	}
+
+	if s := secrets[c.VaultMesosSSL]; s != nil {
+		if err := writeTLS(c.MesosSSL, "mesos", s); err != nil {
+			return fmt.Errorf("%s: %s", c.VaultMesosSSL, err)
+		}
+	}
+
+	return nil
+}
+
+func vaultClient(c *config.Config) (*vault.Client, error) {
+	if c.VaultAddr == "" {
+		return nil, fmt.Errorf("no Vault address: set --vault-addr or VAULT_ADDR")
+	}
+
+	token, err := vault.Token(c.VaultTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return vault.New(c.VaultAddr, token), nil
+}
+
+// Read the --vault-* secret paths of c, by path
+func readSecrets(client *vault.Client, c *config.Config) (map[string]*vault.Secret, error) {
+	secrets := make(map[string]*vault.Secret)
+	for _, path := range []string{c.VaultRegistryToken, c.VaultMesosCredential, c.VaultRegistrySSL, c.VaultMesosSSL} {
+		if path == "" || secrets[path] != nil {
+			continue
+		}
+
+		s, err := client.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		secrets[path] = s
+	}
+
+	return secrets, nil
+}
+
+// Write the certificate, private key and, when the secret has one,
+// issuing CA of a Vault secret, as issued by the PKI engine, to files
+// only the user of mesos-consul can read. The files keep their names
+// for the life of the process, so reloads find the same settings.
+func writeTLS(ssl *config.SSL, name string, s *vault.Secret) error {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("mesos-consul-vault-%d", os.Getpid()))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	files := []struct {
+		field string
+		path  *string
+	}{
+		{"certificate", &ssl.Cert},
+		{"private_key", &ssl.Key},
+		{"issuing_ca", &ssl.CaCert},
+	}
+	for _, f := range files {
+		value, err := s.Field(f.field)
+		if err != nil {
+			if f.field == "issuing_ca" {
+				continue
+			}
+			return err
+		}
+
+		path := filepath.Join(dir, name+"-"+f.field+".pem")
+		if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+			return err
+		}
+		*f.path = path
+	}
+
+	return nil
+}
+
+// Renew the Vault token and read the secrets again every
+// --vault-refresh, signalling changed whenever they no longer match
+// the last ones read, so that the configuration is reloaded with them.
+// The token is read again every time, e.g. for a Vault agent
+// rewriting --vault-token-file.
+func watchVault(c *config.Config, changed chan<- struct{}) {
+	var last map[string]*vault.Secret
+	if client, err := vaultClient(c); err == nil {
+		last, _ = readSecrets(client, c)
+	}
+
+	ticker := time.NewTicker(c.VaultRefresh)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		client, err := vaultClient(c)
+		if err != nil {
+			hclog.L().Warn("Unable to renew the Vault secrets", "error", err)
+			continue
+		}
+
+		if err := client.RenewSelf(); err != nil {
+			hclog.L().Warn("Unable to renew the Vault token", "error", err)
+		}
+
+		secrets, err := readSecrets(client, c)
+		if err != nil {
+			hclog.L().Warn("Unable to read the Vault secrets", "error", err)
+			continue
+		}
+
+		if !sameSecrets(last, secrets) {
+			hclog.L().Info("Vault secrets changed")
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+		last = secrets
+	}
+}
+
+func sameSecrets(a, b map[string]*vault.Secret) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, s := range a {
+		if other, ok := b[path]; !ok || !reflect.DeepEqual(s.Data, other.Data) {
+			return false
+		}
+	}
+
+	return true
+}
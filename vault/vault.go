@@ -0,0 +1,143 @@
+// Package vault reads the secrets of mesos-consul, e.g. its Consul ACL
+// token and Mesos credential, from HashiCorp Vault instead of the
+// command line.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// A Client reads secrets from the Vault server at an address with a
+// Vault token
+type Client struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// New returns a Client of the Vault server at addr, e.g.
+// https://vault.service.consul:8200, authenticating with token
+func New(addr, token string) *Client {
+	return &Client{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// A Secret read from Vault
+type Secret struct {
+	Data map[string]interface{}
+}
+
+// The JSON of the Vault API responses
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// Read the secret at path, e.g. secret/data/mesos-consul. The data of
+// the KV version 2 engine is unwrapped from its metadata.
+func (c *Client) Read(path string) (*Secret, error) {
+	var r response
+	if err := c.call("GET", path, &r); err != nil {
+		return nil, err
+	}
+	if r.Data == nil {
+		return nil, fmt.Errorf("%s: no data", path)
+	}
+
+	data := r.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	return &Secret{Data: data}, nil
+}
+
+// Field returns the string field of the secret, failing when it is
+// missing or empty
+func (s *Secret) Field(name string) (string, error) {
+	v, ok := s.Data[name].(string)
+	if !ok || v == "" {
+		return "", fmt.Errorf("no %s field", name)
+	}
+
+	return v, nil
+}
+
+// RenewSelf extends the lease of the token of the client. Tokens that
+// cannot be renewed, e.g. root tokens, are left as they are.
+func (c *Client) RenewSelf() error {
+	var r response
+	err := c.call("POST", "auth/token/renew-self", &r)
+	if err != nil && strings.Contains(err.Error(), "not renewable") {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Client) call(method, path string, v *response) error {
+	var body io.Reader
+	if method == "POST" {
+		body = bytes.NewBufferString("{}")
+	}
+
+	req, err := http.NewRequest(method, c.addr+"/v1/"+strings.TrimLeft(path, "/"), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil && resp.StatusCode/100 == 2 {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(v.Errors) > 0 {
+			return fmt.Errorf("%s: %s: %s", path, resp.Status, strings.Join(v.Errors, ", "))
+		}
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+
+	return nil
+}
+
+// Token reads the Vault token from file or, without one, from the
+// VAULT_TOKEN environment variable, out of the process arguments
+func Token(file string) (string, error) {
+	if file == "" {
+		if token := os.Getenv("VAULT_TOKEN"); token != "" {
+			return token, nil
+		}
+		return "", errors.New("no Vault token: set --vault-token-file or VAULT_TOKEN")
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", file)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	var renewed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/mesos-consul":
+			w.Write([]byte(`{"data": {"data": {"token": "acl"}, "metadata": {"version": 2}}}`))
+		case "/v1/kv/mesos":
+			w.Write([]byte(`{"data": {"principal": "mesos-consul", "secret": "pw"}, "lease_duration": 2764800}`))
+		case "/v1/auth/token/renew-self":
+			renewed = r.Method == "POST"
+			w.Write([]byte(`{"auth": {"renewable": true, "lease_duration": 3600}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/", "s.token")
+
+	s, err := c.Read("secret/data/mesos-consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := s.Field("token"); err != nil || token != "acl" {
+		t.Errorf("expected the KV version 2 data, got %q, %v", token, err)
+	}
+
+	s, err = c.Read("/kv/mesos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal, _ := s.Field("principal"); principal != "mesos-consul" {
+		t.Errorf("expected the KV version 1 data, got %v", s.Data)
+	}
+	if _, err := s.Field("token"); err == nil {
+		t.Error("expected a missing field to fail")
+	}
+
+	if _, err := c.Read("secret/missing"); err == nil {
+		t.Error("expected a missing secret to fail")
+	}
+
+	if err := c.RenewSelf(); err != nil || !renewed {
+		t.Errorf("expected the token to be renewed, got %v", err)
+	}
+
+	if _, err := New(server.URL, "s.other").Read("kv/mesos"); err == nil || err.Error() != "kv/mesos: 403 Forbidden: permission denied" {
+		t.Errorf("expected the Vault errors, got %v", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("s.file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAULT_TOKEN", "s.env")
+
+	if token, err := Token(file); err != nil || token != "s.file" {
+		t.Errorf("expected the token of the file, got %q, %v", token, err)
+	}
+	if token, err := Token(""); err != nil || token != "s.env" {
+		t.Errorf("expected the token of VAULT_TOKEN, got %q, %v", token, err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := Token(""); err == nil {
+		t.Error("expected no token to fail")
+	}
+}